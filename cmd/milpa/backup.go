@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/robrt95x/milpa-cloud/internal/infrastructure/config"
+	"github.com/robrt95x/milpa-cloud/internal/infrastructure/db"
+)
+
+const (
+	backupUsage  = "usage: milpa backup [file|dir]"
+	restoreUsage = `usage: milpa restore <file>
+
+Replaces the configured database with a backup taken at the schema version
+of this binary. Stop the core first: a running core keeps a lease on the
+database, and restore refuses to run while a lease was renewed within the
+last 30s. The lease of a core that crashed expires after that time.`
+)
+
+// runBackup implements `milpa backup`
+// Without an argument the backup is written to backup.dir and pruned to
+// backup.retention, like scheduled backups.
+func runBackup(cfg *config.Config, args []string) error {
+	if len(args) > 1 {
+		return errors.New(backupUsage)
+	}
+	if cfg.Database.Type == config.DatabaseTypeMemory {
+		return errors.New("the memory database cannot be backed up")
+	}
+
+	repo, err := db.Open(cfg)
+	if err != nil {
+		return err
+	}
+	defer repo.Close()
+
+	ctx := context.Background()
+
+	var info *db.BackupInfo
+	switch {
+	case len(args) == 0:
+		info, err = repo.BackupToDir(ctx, cfg.Backup.Dir)
+		if err == nil {
+			_, err = db.PruneBackups(cfg.Backup.Dir, cfg.Backup.Retention)
+		}
+	case isDir(args[0]):
+		info, err = repo.BackupToDir(ctx, args[0])
+	default:
+		info, err = repo.Backup(ctx, args[0])
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("backup written to %s (%s, schema %04d, %d bytes)\n",
+		info.Path, info.Format, info.SchemaVersion, info.Size)
+	return nil
+}
+
+// runRestore implements `milpa restore`
+func runRestore(cfg *config.Config, args []string) error {
+	if len(args) != 1 {
+		return errors.New(restoreUsage)
+	}
+	if args[0] == "-h" || args[0] == "--help" {
+		fmt.Println(restoreUsage)
+		return nil
+	}
+	if cfg.Database.Type == config.DatabaseTypeMemory {
+		return errors.New("the memory database cannot be restored")
+	}
+
+	info, err := db.Restore(context.Background(), cfg, args[0])
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("restored %s (%s, schema %04d)\n", info.Path, info.Format, info.SchemaVersion)
+	return nil
+}
+
+func isDir(path string) bool {
+	st, err := os.Stat(path)
+	return err == nil && st.IsDir()
+}
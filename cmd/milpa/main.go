@@ -2,18 +2,31 @@ package main
 
 import (
 	"context"
+	"fmt"
+	"io"
 	"log"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/robrt95x/milpa-cloud/internal/core"
 	"github.com/robrt95x/milpa-cloud/internal/infrastructure/config"
 	"github.com/robrt95x/milpa-cloud/internal/infrastructure/db"
+	"github.com/robrt95x/milpa-cloud/internal/infrastructure/storage"
 	"github.com/robrt95x/milpa-cloud/pkg/logger"
+	"github.com/robrt95x/milpa-cloud/pkg/tracing"
 )
 
 func main() {
+	// Deferred first so it runs last, after the other deferred cleanups
+	exitCode := 0
+	defer func() {
+		if exitCode != 0 {
+			os.Exit(exitCode)
+		}
+	}()
+
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
@@ -23,42 +36,175 @@ func main() {
 		log.Fatalf("failed to load config: %v", err)
 	}
 
-	// Initialize logger
-	log := logger.New(cfg.LogLevel)
+	// Subcommands
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "migrate":
+			if err := runMigrate(cfg, os.Args[2:]); err != nil {
+				log.Fatalf("migrate: %v", err)
+			}
+			return
+		case "backup":
+			if err := runBackup(cfg, os.Args[2:]); err != nil {
+				log.Fatalf("backup: %v", err)
+			}
+			return
+		case "restore":
+			if err := runRestore(cfg, os.Args[2:]); err != nil {
+				log.Fatalf("restore: %v", err)
+			}
+			return
+		default:
+			log.Fatalf("unknown command %q", os.Args[1])
+		}
+	}
+
+	// Initialize logger; subsystem levels can be changed at runtime through
+	// PUT /api/v1/log-level
+	coreLog, closeLog, err := newLogger(cfg)
+	if err != nil {
+		log.Fatalf("failed to initialize logger: %v", err)
+	}
+	defer closeLog()
+	logger.SetDefault(coreLog)
+	log := coreLog
+
+	// Time the HTTP server and the tracer get to finish after the drain
+	serverTimeout, err := time.ParseDuration(cfg.Shutdown.ServerTimeout)
+	if err != nil {
+		log.Error("invalid shutdown.server_timeout", "error", err)
+		exitCode = 1
+		return
+	}
 
-	// Initialize database
-	// TODO: Make database optional for development (in-memory mode)
-	repo, err := db.NewRepository(cfg)
+	// Initialize database (database.type: memory keeps state in process)
+	repo, err := storage.New(cfg)
 	if err != nil {
 		log.Error("failed to initialize database", "error", err)
-		os.Exit(1)
+		exitCode = 1
+		return
 	}
 	defer repo.Close()
+	if r, ok := repo.(*db.Repository); ok {
+		r.SetLogger(log.Subsystem(core.LogSubsystemDB))
+
+		// Marks the database as in use so `milpa restore` refuses to run
+		lease, err := r.AcquireCoreLease(log.Subsystem(core.LogSubsystemDB))
+		if err != nil {
+			log.Error("failed to initialize database", "error", err)
+			exitCode = 1
+			return
+		}
+		defer lease.Release()
+	}
+
+	// Scheduled backups
+	if cfg.Backup.Enabled {
+		if r, ok := repo.(*db.Repository); ok {
+			go r.RunScheduledBackups(ctx, cfg.Backup, log.Subsystem(core.LogSubsystemDB))
+		} else {
+			log.Warn("scheduled backups are not available for the memory database")
+		}
+	}
+
+	// Spans are exported per tracing.exporter; trace context is always propagated
+	target := cfg.Tracing.Endpoint
+	if cfg.Tracing.Exporter == "file" {
+		target = cfg.Tracing.File
+	}
+	exporter, err := tracing.NewExporter(cfg.Tracing.Exporter, target)
+	if err != nil {
+		log.Error("failed to initialize tracing", "error", err)
+		exitCode = 1
+		return
+	}
+	tracer := tracing.NewTracer(cfg.Tracing.ServiceName, exporter)
 
 	// Initialize plugin manager with persistence
-	mgr := core.NewManager(cfg, log, repo)
+	mgr := core.NewManager(cfg, log.Subsystem(core.LogSubsystemManager), repo)
+	mgr.SetTracer(tracer)
 
-	// Start plugin manager (gRPC)
+	// Start plugin manager (gRPC); listeners are bound before Start returns
 	if err := mgr.Start(ctx); err != nil {
 		log.Error("failed to start plugin manager", "error", err)
-		os.Exit(1)
+		exitCode = 1
+		return
 	}
 
 	// Start HTTP server
-	go func() {
-		httpServer := core.NewHTTPServer(cfg, log, mgr)
-		if err := httpServer.Start(); err != nil {
-			log.Error("HTTP server error", "error", err)
-		}
-	}()
+	httpServer := core.NewHTTPServer(cfg, log.Subsystem(core.LogSubsystemHTTP), mgr)
+	if err := httpServer.Start(); err != nil {
+		log.Error("failed to start HTTP server", "error", err)
+		mgr.Stop()
+		exitCode = 1
+		return
+	}
 
-	// Wait for shutdown signal
+	// Wait for a shutdown signal or for a server to fail
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 
-	<-sigChan
-	log.Info("shutting down...")
+	select {
+	case sig := <-sigChan:
+		log.Info("shutting down...", "signal", sig.String())
+	case err := <-mgr.Errors():
+		log.Error("shutting down after server failure", "error", err)
+		exitCode = 1
+	case err := <-httpServer.Errors():
+		log.Error("shutting down after server failure", "error", err)
+		exitCode = 1
+	}
 
+	// The manager drains plugins first; HTTP plugins acknowledge through
+	// the HTTP server, so it is closed last
 	mgr.Stop()
+
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), serverTimeout)
+	defer shutdownCancel()
+	if err := httpServer.Shutdown(shutdownCtx); err != nil {
+		log.Error("HTTP server shutdown error", "error", err)
+	}
+	if err := tracer.Shutdown(shutdownCtx); err != nil {
+		log.Error("failed to export remaining spans", "error", err)
+	}
 	log.Info("goodbye!")
 }
+
+// newLogger creates the core logger from the log settings
+// The returned function closes the log file, if any
+func newLogger(cfg *config.Config) (logger.Logger, func(), error) {
+	output := io.Writer(os.Stdout)
+	closeLog := func() {}
+	if cfg.Log.File != "" {
+		var maxAge time.Duration
+		if cfg.Log.MaxAge != "" {
+			d, err := time.ParseDuration(cfg.Log.MaxAge)
+			if err != nil {
+				return nil, nil, fmt.Errorf("log.max_age: %w", err)
+			}
+			maxAge = d
+		}
+		file, err := logger.OpenRotatingFile(cfg.Log.File, logger.RotateOptions{
+			MaxSize:    int64(cfg.Log.MaxSizeMB) << 20,
+			MaxAge:     maxAge,
+			MaxBackups: cfg.Log.MaxBackups,
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		output = file
+		closeLog = func() { file.Close() }
+	}
+
+	log, err := logger.NewWithOptions(logger.Options{
+		Name:   "milpa",
+		Level:  cfg.LogLevel,
+		Format: cfg.Log.Format,
+		Output: output,
+	})
+	if err != nil {
+		closeLog()
+		return nil, nil, err
+	}
+	return log, closeLog, nil
+}
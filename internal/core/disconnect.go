@@ -0,0 +1,230 @@
+package core
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/robrt95x/milpa-cloud/internal/domain/entities"
+	"github.com/robrt95x/milpa-cloud/pkg/types"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// connectionSet tracks the instances with an open connection, so that each
+// connection emits exactly one plugin_connected and one plugin_disconnected
+type connectionSet struct {
+	mu  sync.Mutex
+	ids map[string]string // instance ID -> plugin ID
+}
+
+func newConnectionSet() *connectionSet {
+	return &connectionSet{
+		ids: make(map[string]string),
+	}
+}
+
+// add marks an instance connected and reports whether it was not already
+func (c *connectionSet) add(id, pluginID string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	_, ok := c.ids[id]
+	c.ids[id] = pluginID
+	return !ok
+}
+
+// list returns the connected instance IDs
+func (c *connectionSet) list() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ids := make([]string, 0, len(c.ids))
+	for id := range c.ids {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// snapshot returns a copy of the connected instances (instance ID -> plugin ID)
+func (c *connectionSet) snapshot() map[string]string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ids := make(map[string]string, len(c.ids))
+	for id, pluginID := range c.ids {
+		ids[id] = pluginID
+	}
+	return ids
+}
+
+// remove marks an instance disconnected and returns its plugin ID if it
+// was connected
+func (c *connectionSet) remove(id string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	pluginID, ok := c.ids[id]
+	delete(c.ids, id)
+	return pluginID, ok
+}
+
+// errStillConnected aborts a disconnect whose cause no longer holds
+// (e.g. a heartbeat arrived while the timeout was being handled)
+// It wraps errNoChange so the liveness table is refreshed from the store
+var errStillConnected = fmt.Errorf("instance still connected: %w", errNoChange)
+
+// disconnectTransitionReasons are the transition reasons of each disconnect reason
+var disconnectTransitionReasons = map[string]string{
+	types.DisconnectHeartbeatTimeout: "heartbeat timeout",
+	types.DisconnectStreamClosed:     "event stream closed",
+	types.DisconnectDeregistered:     "deregistered by plugin",
+	types.DisconnectDisabled:         "disabled by admin",
+}
+
+// disconnect is the single path that ends a plugin connection: heartbeat
+// timeouts, closed event streams, deregistrations and admin disables.
+// mutate applies the status change of the reason; errNoChange keeps the
+// stored instance as is and errStillConnected aborts the disconnect.
+// The instance is unsubscribed from the event bus and, if it was connected,
+// one plugin_disconnected event carrying the reason is emitted.
+func (m *PluginManager) disconnect(id string, ifMatch int64, reason string, mutate func(*entities.PluginInstance) error) (*entities.PluginInstance, error) {
+	inst, err := m.transitionInstance(id, ifMatch, disconnectTransitionReasons[reason], mutate)
+	if errors.Is(err, errStillConnected) || (err != nil && !errors.Is(err, errNoChange)) {
+		return nil, err
+	}
+
+	if reason == types.DisconnectDisabled {
+		m.eventBus.SendDirect(id, &PluginEvent{
+			Type: EventTypeShutdown,
+			Data: "instance disabled",
+		})
+	}
+	m.eventBus.Unsubscribe(id)
+	m.drain.resolve(id)
+
+	pluginID, connected := m.conns.remove(id)
+	if !connected {
+		return inst, nil
+	}
+
+	data, _ := json.Marshal(&types.DisconnectEvent{
+		InstanceId: id,
+		PluginId:   pluginID,
+		Reason:     reason,
+	})
+	m.eventBus.SendBroadcast(&PluginEvent{
+		Type: EventTypePluginDisconnected,
+		Data: string(data),
+	})
+	m.recordEvent(EventTypePluginDisconnected, pluginID, id, string(data))
+	m.log.Info("plugin disconnected", "instance_id", id, "plugin_id", pluginID, "reason", reason)
+	return inst, nil
+}
+
+// connect marks an instance connected and emits plugin_connected
+// A handshake always emits the event; a heartbeat reviving an instance
+// only does when it was disconnected
+func (m *PluginManager) connect(inst *entities.PluginInstance, always bool) {
+	if !m.conns.add(inst.ID, inst.DefinitionID) && !always {
+		return
+	}
+	m.eventBus.SendBroadcast(&PluginEvent{
+		Type: EventTypePluginConnected,
+		Data: inst.DefinitionID,
+	})
+	m.recordEvent(EventTypePluginConnected, inst.DefinitionID, inst.ID, inst.DefinitionID)
+}
+
+// DisconnectPlugin ends the connection of an instance for the given reason
+// (types.Disconnect*). Live instances become unhealthy when their stream
+// closes and stopped when they deregister; stopping ones become stopped.
+func (m *PluginManager) DisconnectPlugin(instanceID, reason string) error {
+	_, err := m.disconnect(instanceID, 0, reason, func(inst *entities.PluginInstance) error {
+		switch {
+		case inst.Status == entities.PluginStatusStopping:
+			inst.Status = entities.PluginStatusStopped
+			return nil
+		case reason == types.DisconnectDeregistered:
+			if entities.CanTransition(inst.Status, entities.PluginStatusStopped) {
+				inst.Status = entities.PluginStatusStopped
+				return nil
+			}
+		default:
+			if entities.IsLiveStatus(inst.Status) {
+				inst.Status = entities.PluginStatusUnhealthy
+				return nil
+			}
+		}
+		return errNoChange
+	})
+	return err
+}
+
+// markStopping moves a live or unhealthy instance to stopping
+// Instances that cannot stop (e.g. quarantined ones) are left as they are.
+func (m *PluginManager) markStopping(id, reason string) error {
+	_, err := m.transitionInstance(id, 0, reason, func(inst *entities.PluginInstance) error {
+		if !entities.CanTransition(inst.Status, entities.PluginStatusStopping) {
+			return errNoChange
+		}
+		inst.Status = entities.PluginStatusStopping
+		return nil
+	})
+	if errors.Is(err, errNoChange) {
+		return nil
+	}
+	return err
+}
+
+// markStopped moves a stopping instance to stopped
+func (m *PluginManager) markStopped(id, reason string) error {
+	_, err := m.transitionInstance(id, 0, reason, func(inst *entities.PluginInstance) error {
+		if inst.Status != entities.PluginStatusStopping {
+			return errNoChange
+		}
+		inst.Status = entities.PluginStatusStopped
+		return nil
+	})
+	if errors.Is(err, errNoChange) {
+		return nil
+	}
+	return err
+}
+
+// Deregister ends a session at the plugin's request (e.g. on shutdown)
+func (m *PluginManager) Deregister(ctx context.Context, req *DeregisterRequest) (*DeregisterResponse, error) {
+	inst, err := m.repo.GetInstance(req.SessionId)
+	if err != nil || inst.Status == entities.PluginStatusRetired {
+		return &DeregisterResponse{Ok: false, Error: "session not found"},
+			status.Error(codes.NotFound, "session not found")
+	}
+	if subtle.ConstantTimeCompare([]byte(inst.AuthToken), []byte(req.AuthToken)) != 1 {
+		return &DeregisterResponse{Ok: false, Error: "invalid auth token"},
+			status.Error(codes.Unauthenticated, "invalid auth token")
+	}
+
+	m.log.Info("deregister request", "instance_id", inst.ID, "plugin_id", inst.DefinitionID, "reason", req.Reason)
+	if err := m.markStopping(inst.ID, "deregister requested"); err != nil {
+		m.log.Error("failed to deregister instance", "instance_id", inst.ID, "error", err)
+		return &DeregisterResponse{Ok: false, Error: "internal error"},
+			status.Error(codes.Internal, "failed to deregister instance")
+	}
+	if err := m.DisconnectPlugin(inst.ID, types.DisconnectDeregistered); err != nil {
+		m.log.Error("failed to deregister instance", "instance_id", inst.ID, "error", err)
+		return &DeregisterResponse{Ok: false, Error: "internal error"},
+			status.Error(codes.Internal, "failed to deregister instance")
+	}
+	return &DeregisterResponse{Ok: true}, nil
+}
+
+// DeregisterHTTP handles HTTP deregistration requests
+// Rejections are reported in the response rather than as an error
+func (m *PluginManager) DeregisterHTTP(ctx context.Context, req *types.DeregisterRequest) (*types.DeregisterResponse, error) {
+	resp, _ := m.Deregister(ctx, req)
+	return resp, nil
+}
@@ -0,0 +1,204 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/robrt95x/milpa-cloud/internal/domain/entities"
+	"github.com/robrt95x/milpa-cloud/internal/domain/repository"
+	"github.com/robrt95x/milpa-cloud/pkg/types"
+
+	"google.golang.org/grpc/metadata"
+)
+
+// disconnectReasons returns the reasons of the logged plugin_disconnected events
+func disconnectReasons(t *testing.T, repo repository.Repository, instanceID string) []string {
+	t.Helper()
+
+	events, err := repo.ListEvents(repository.EventFilter{Type: EventTypePluginDisconnected, InstanceID: instanceID})
+	if err != nil {
+		t.Fatalf("ListEvents: %v", err)
+	}
+	var reasons []string
+	for _, ev := range events {
+		var data types.DisconnectEvent
+		if err := json.Unmarshal([]byte(ev.Data), &data); err != nil {
+			t.Fatalf("Invalid event data %q: %v", ev.Data, err)
+		}
+		reasons = append(reasons, data.Reason)
+	}
+	return reasons
+}
+
+func TestHeartbeatTimeoutDisconnectsOnce(t *testing.T) {
+	_, _, mgr, repo := setupTest(t)
+	mgr.config.Security.HeartbeatTimeout = "1ms"
+	resp := handshake(t, mgr, "example")
+	mgr.SubscribePlugin(resp.SessionId)
+
+	time.Sleep(5 * time.Millisecond)
+	mgr.checkHeartbeats()
+	mgr.checkHeartbeats()
+	// A later stream close of the same connection is not a new disconnect
+	mgr.DisconnectPlugin(resp.SessionId, types.DisconnectStreamClosed)
+
+	if reasons := disconnectReasons(t, repo, resp.SessionId); !equalReasons(reasons, types.DisconnectHeartbeatTimeout) {
+		t.Errorf("Expected one heartbeat_timeout disconnect, got %v", reasons)
+	}
+	if err := mgr.SendEventToPlugin(resp.SessionId, EventTypeRestart, ""); err == nil {
+		t.Error("Expected instance to be unsubscribed from the event bus")
+	}
+
+	// A heartbeat reconnects the instance, so the next disconnect is emitted
+	mgr.HeartbeatHTTP(context.Background(), &types.HeartbeatRequest{
+		SessionId: resp.SessionId,
+		AuthToken: resp.AuthToken,
+	})
+	time.Sleep(5 * time.Millisecond)
+	mgr.checkHeartbeats()
+	reasons := disconnectReasons(t, repo, resp.SessionId)
+	if !equalReasons(reasons, types.DisconnectHeartbeatTimeout, types.DisconnectHeartbeatTimeout) {
+		t.Errorf("Expected a second disconnect after reconnecting, got %v", reasons)
+	}
+}
+
+func TestDeregister(t *testing.T) {
+	cfg, log, mgr, repo := setupTest(t)
+	server := NewHTTPServer(cfg, log, mgr)
+	resp := handshake(t, mgr, "example")
+
+	deregister := func(token string) types.DeregisterResponse {
+		t.Helper()
+		body := `{"session_id": "` + resp.SessionId + `", "auth_token": "` + token + `", "reason": "shutting down"}`
+		w := httptest.NewRecorder()
+		server.handleDeregister(w, httptest.NewRequest(http.MethodPost, "/api/v1/deregister", strings.NewReader(body)))
+		var out types.DeregisterResponse
+		json.NewDecoder(w.Body).Decode(&out)
+		return out
+	}
+
+	if out := deregister("wrong"); out.Ok {
+		t.Error("Expected deregister with a wrong token to be rejected")
+	}
+	if out := deregister(resp.AuthToken); !out.Ok {
+		t.Fatalf("Deregister rejected: %s", out.Error)
+	}
+
+	inst, _ := repo.GetInstance(resp.SessionId)
+	if inst.Status != entities.PluginStatusStopped || !inst.Enabled {
+		t.Errorf("Expected deregistered instance to be stopped and enabled, got %s", inst.Status)
+	}
+	if reasons := disconnectReasons(t, repo, resp.SessionId); !equalReasons(reasons, types.DisconnectDeregistered) {
+		t.Errorf("Expected one deregistered disconnect, got %v", reasons)
+	}
+	history, _ := repo.ListTransitions(resp.SessionId)
+	if n := len(history); n < 2 || history[n-2].To != entities.PluginStatusStopping || history[n-1].To != entities.PluginStatusStopped {
+		t.Errorf("Expected deregister to go through stopping to stopped, got %v", history)
+	}
+}
+
+func TestDisableDisconnects(t *testing.T) {
+	_, _, mgr, repo := setupTest(t)
+	resp := handshake(t, mgr, "example")
+	events := mgr.SubscribePlugin(resp.SessionId)
+
+	if _, err := mgr.SetInstanceEnabled(resp.SessionId, false, 0); err != nil {
+		t.Fatalf("SetInstanceEnabled: %v", err)
+	}
+
+	// The shutdown event is delivered before the subscription is closed
+	var got []string
+	for ev := range events {
+		got = append(got, ev.Type)
+	}
+	if len(got) == 0 || got[len(got)-1] != EventTypeShutdown {
+		t.Errorf("Expected shutdown event before unsubscribe, got %v", got)
+	}
+	if reasons := disconnectReasons(t, repo, resp.SessionId); !equalReasons(reasons, types.DisconnectDisabled) {
+		t.Errorf("Expected one disabled disconnect, got %v", reasons)
+	}
+}
+
+// fakeStream is a grpc.ServerStream whose client closes when done is closed
+type fakeStream struct {
+	ctx  context.Context
+	done chan struct{}
+	sent chan *CoreEvent
+}
+
+func (s *fakeStream) SetHeader(metadata.MD) error  { return nil }
+func (s *fakeStream) SendHeader(metadata.MD) error { return nil }
+func (s *fakeStream) SetTrailer(metadata.MD)       {}
+func (s *fakeStream) Context() context.Context     { return s.ctx }
+
+func (s *fakeStream) SendMsg(m interface{}) error {
+	s.sent <- m.(*CoreEvent)
+	return nil
+}
+
+func (s *fakeStream) RecvMsg(m interface{}) error {
+	<-s.done
+	return io.EOF
+}
+
+func TestStreamCloseDisconnects(t *testing.T) {
+	_, _, mgr, repo := setupTest(t)
+	resp := handshake(t, mgr, "example")
+
+	stream := &fakeStream{
+		ctx: metadata.NewIncomingContext(context.Background(), metadata.Pairs(
+			"session-id", resp.SessionId, "auth-token", resp.AuthToken)),
+		done: make(chan struct{}),
+		sent: make(chan *CoreEvent, 10),
+	}
+	result := make(chan error, 1)
+	go func() { result <- mgr.Stream(&pluginStreamServer{stream}) }()
+
+	// Wait for the subscription, then deliver an event over the stream
+	deadline := time.Now().Add(time.Second)
+	for mgr.SendEventToPlugin(resp.SessionId, EventTypeRestart, "now") != nil {
+		if time.Now().After(deadline) {
+			t.Fatal("Stream did not subscribe")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if ev := <-stream.sent; ev.Type != EventTypeRestart || ev.Data != "now" {
+		t.Errorf("Unexpected event %+v", ev)
+	}
+
+	close(stream.done)
+	if err := <-result; err != nil {
+		t.Errorf("Stream returned %v", err)
+	}
+	inst, _ := repo.GetInstance(resp.SessionId)
+	if inst.Status != entities.PluginStatusUnhealthy {
+		t.Errorf("Expected closed stream to mark the instance unhealthy, got %s", inst.Status)
+	}
+	if reasons := disconnectReasons(t, repo, resp.SessionId); !equalReasons(reasons, types.DisconnectStreamClosed) {
+		t.Errorf("Expected one stream_closed disconnect, got %v", reasons)
+	}
+
+	bad := &fakeStream{ctx: metadata.NewIncomingContext(context.Background(), metadata.Pairs(
+		"session-id", resp.SessionId, "auth-token", "wrong"))}
+	if err := mgr.Stream(&pluginStreamServer{bad}); err == nil {
+		t.Error("Expected stream with a wrong token to be rejected")
+	}
+}
+
+func equalReasons(got []string, want ...string) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -1,11 +1,20 @@
 package core
 
 import (
+	"sort"
 	"sync"
+	"sync/atomic"
 
+	"github.com/robrt95x/milpa-cloud/internal/infrastructure/metrics"
 	"github.com/robrt95x/milpa-cloud/pkg/logger"
 )
 
+// Event channel capacities
+const (
+	broadcastQueueSize  = 100
+	subscriberQueueSize = 50
+)
+
 // EventBus handles event distribution to plugins
 // TODO: Add message persistence for disconnected plugins
 // TODO: Add event history for debugging
@@ -14,6 +23,11 @@ type EventBus struct {
 	mu        sync.RWMutex
 	subs      map[string]chan *PluginEvent // instance ID -> event channel
 	broadcast chan *PluginEvent
+	running   atomic.Bool
+
+	// published and dropped are nil until instrument is called
+	published *metrics.CounterVec
+	dropped   *metrics.CounterVec
 }
 
 // NewEventBus creates a new event bus
@@ -21,16 +35,36 @@ func NewEventBus(log logger.Logger) *EventBus {
 	return &EventBus{
 		log:       log,
 		subs:      make(map[string]chan *PluginEvent),
-		broadcast: make(chan *PluginEvent, 100), // Buffered
+		broadcast: make(chan *PluginEvent, broadcastQueueSize),
 	}
 }
 
 // Subscribe adds a plugin to the event bus
+// A previous subscription of the same instance is closed
 func (eb *EventBus) Subscribe(instanceID string) chan *PluginEvent {
 	eb.mu.Lock()
 	defer eb.mu.Unlock()
 
-	ch := make(chan *PluginEvent, 50)
+	if old, ok := eb.subs[instanceID]; ok {
+		close(old)
+	}
+	ch := make(chan *PluginEvent, subscriberQueueSize)
+	eb.subs[instanceID] = ch
+	eb.log.Debug("plugin subscribed to events", "instance_id", instanceID)
+
+	return ch
+}
+
+// Attach returns the subscription of a plugin, subscribing it if needed
+// Unlike Subscribe it never replaces an existing subscription
+func (eb *EventBus) Attach(instanceID string) chan *PluginEvent {
+	eb.mu.Lock()
+	defer eb.mu.Unlock()
+
+	if ch, ok := eb.subs[instanceID]; ok {
+		return ch
+	}
+	ch := make(chan *PluginEvent, subscriberQueueSize)
 	eb.subs[instanceID] = ch
 	eb.log.Debug("plugin subscribed to events", "instance_id", instanceID)
 
@@ -53,11 +87,14 @@ func (eb *EventBus) Unsubscribe(instanceID string) {
 // TODO: Add timeout for send
 // TODO: Return error if plugin disconnected
 func (eb *EventBus) SendDirect(instanceID string, event *PluginEvent) error {
+	// Sends never block, so the lock is held to keep Unsubscribe from
+	// closing the channel meanwhile
 	eb.mu.RLock()
+	defer eb.mu.RUnlock()
 	ch, ok := eb.subs[instanceID]
-	eb.mu.RUnlock()
 
 	if !ok {
+		eb.countDropped(event.Type, ErrPluginNotFound)
 		return &EventBusError{
 			Code:    ErrPluginNotFound,
 			Message: "plugin not found or not subscribed",
@@ -66,10 +103,12 @@ func (eb *EventBus) SendDirect(instanceID string, event *PluginEvent) error {
 
 	select {
 	case ch <- event:
+		eb.countPublished(event.Type)
 		eb.log.Debug("event sent directly", "instance_id", instanceID, "type", event.Type)
 		return nil
 	default:
 		// Channel full, plugin might be slow
+		eb.countDropped(event.Type, ErrChannelFull)
 		eb.log.Warn("event channel full, dropping", "instance_id", instanceID)
 		return &EventBusError{
 			Code:    ErrChannelFull,
@@ -82,18 +121,16 @@ func (eb *EventBus) SendDirect(instanceID string, event *PluginEvent) error {
 // TODO: Return list of failed deliveries
 func (eb *EventBus) SendBroadcast(event *PluginEvent) {
 	eb.mu.RLock()
-	subs := make(map[string]chan *PluginEvent)
-	for k, v := range eb.subs {
-		subs[k] = v
-	}
-	eb.mu.RUnlock()
+	defer eb.mu.RUnlock()
 
 	count := 0
-	for id, ch := range subs {
+	for id, ch := range eb.subs {
 		select {
 		case ch <- event:
 			count++
+			eb.countPublished(event.Type)
 		default:
+			eb.countDropped(event.Type, ErrChannelFull)
 			eb.log.Warn("broadcast event dropped", "instance_id", id)
 		}
 	}
@@ -101,8 +138,64 @@ func (eb *EventBus) SendBroadcast(event *PluginEvent) {
 	eb.log.Debug("broadcast sent", "count", count, "type", event.Type)
 }
 
+// instrument registers the event bus metrics
+func (eb *EventBus) instrument(reg *metrics.Registry) {
+	eb.published = reg.NewCounterVec("milpa_events_published_total",
+		"Events delivered to plugin subscriptions by type.", "type")
+	eb.dropped = reg.NewCounterVec("milpa_events_dropped_total",
+		"Events that could not be delivered by type and reason.", "type", "reason")
+	reg.NewGaugeFunc("milpa_event_bus_subscribers", "Plugins subscribed to the event bus.", func() []metrics.Sample {
+		return []metrics.Sample{{Value: float64(eb.subscriberCount())}}
+	})
+	reg.NewGaugeFunc("milpa_event_bus_queue_length", "Events waiting in an event bus channel.", eb.queueSamples(false))
+	reg.NewGaugeFunc("milpa_event_bus_queue_capacity", "Capacity of an event bus channel.", eb.queueSamples(true))
+}
+
+// queueSamples returns a function reporting the length (or capacity) of
+// each subscription. Broadcasts are delivered straight to the subscriptions,
+// so they have no queue of their own.
+func (eb *EventBus) queueSamples(capacity bool) func() []metrics.Sample {
+	size := func(ch chan *PluginEvent) float64 {
+		if capacity {
+			return float64(cap(ch))
+		}
+		return float64(len(ch))
+	}
+	return func() []metrics.Sample {
+		eb.mu.RLock()
+		defer eb.mu.RUnlock()
+
+		samples := make([]metrics.Sample, 0, len(eb.subs))
+		ids := make([]string, 0, len(eb.subs))
+		for id := range eb.subs {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		for _, id := range ids {
+			samples = append(samples, metrics.Sample{
+				Labels: []metrics.Label{{Name: "queue", Value: "subscriber"}, {Name: "instance_id", Value: id}},
+				Value:  size(eb.subs[id]),
+			})
+		}
+		return samples
+	}
+}
+
+func (eb *EventBus) countPublished(eventType string) {
+	if eb.published != nil {
+		eb.published.With(eventType).Inc()
+	}
+}
+
+func (eb *EventBus) countDropped(eventType, reason string) {
+	if eb.dropped != nil {
+		eb.dropped.With(eventType, reason).Inc()
+	}
+}
+
 // Start begins the broadcast goroutine
 func (eb *EventBus) Start() {
+	eb.running.Store(true)
 	go func() {
 		for event := range eb.broadcast {
 			eb.SendBroadcast(event)
@@ -110,11 +203,24 @@ func (eb *EventBus) Start() {
 	}()
 }
 
+// subscriberCount returns the number of subscriptions
+func (eb *EventBus) subscriberCount() int {
+	eb.mu.RLock()
+	defer eb.mu.RUnlock()
+	return len(eb.subs)
+}
+
+// Running reports whether the event bus was started and not yet stopped
+func (eb *EventBus) Running() bool {
+	return eb.running.Load()
+}
+
 // Stop gracefully shuts down the event bus
 func (eb *EventBus) Stop() {
 	eb.mu.Lock()
 	defer eb.mu.Unlock()
 
+	eb.running.Store(false)
 	for id, ch := range eb.subs {
 		close(ch)
 		delete(eb.subs, id)
@@ -129,12 +235,19 @@ func (eb *EventBus) Stop() {
 // Event types
 const (
 	EventTypeShutdown       = "shutdown"
+	EventTypeShutdownAck    = "shutdown_ack"
 	EventTypeConfigUpdate  = "config_update"
 	EventTypeRestart       = "restart"
 	EventTypeLogLevel      = "log_level"
 	EventTypeStatusQuery   = "status_query"
+	EventTypeStatusReply   = "status_reply"
+	EventTypeLogs          = "logs"
 	EventTypePluginConnected    = "plugin_connected"
 	EventTypePluginDisconnected = "plugin_disconnected"
+	EventTypeInstanceRetired    = "instance_retired"
+	EventTypeInstanceStateChanged = "instance_state_changed"
+	EventTypeInstanceQuarantined = "instance_quarantined"
+	EventTypeInstanceReleased    = "instance_released"
 )
 
 // Event errors
@@ -0,0 +1,71 @@
+package core
+
+import (
+	"sync"
+	"time"
+)
+
+// flapTable tracks recent unhealthy episodes and quarantine deadlines
+// Flap history is in memory only; quarantine deadlines are also stored on
+// the instance (QuarantinedUntil) and reloaded on start
+type flapTable struct {
+	mu          sync.Mutex
+	flaps       map[string][]time.Time
+	quarantined map[string]time.Time
+}
+
+func newFlapTable() *flapTable {
+	return &flapTable{
+		flaps:       make(map[string][]time.Time),
+		quarantined: make(map[string]time.Time),
+	}
+}
+
+// record adds a flap at the given time and returns the number of flaps
+// within the window ending there
+func (t *flapTable) record(id string, at time.Time, window time.Duration) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	cutoff := at.Add(-window)
+	recent := t.flaps[id][:0]
+	for _, f := range t.flaps[id] {
+		if f.After(cutoff) {
+			recent = append(recent, f)
+		}
+	}
+	recent = append(recent, at)
+	t.flaps[id] = recent
+	return len(recent)
+}
+
+// quarantine records when an instance's quarantine ends
+func (t *flapTable) quarantine(id string, until time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.quarantined[id] = until
+}
+
+// release clears the quarantine and flap history of an instance
+func (t *flapTable) release(id string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.quarantined, id)
+	delete(t.flaps, id)
+}
+
+// due returns the quarantined instances whose cooldown ended by now
+func (t *flapTable) due(now time.Time) []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var ids []string
+	for id, until := range t.quarantined {
+		if !until.After(now) {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
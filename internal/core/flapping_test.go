@@ -0,0 +1,168 @@
+package core
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/robrt95x/milpa-cloud/internal/domain/entities"
+	"github.com/robrt95x/milpa-cloud/internal/domain/repository"
+	"github.com/robrt95x/milpa-cloud/pkg/types"
+)
+
+func TestFlapTableWindow(t *testing.T) {
+	flaps := newFlapTable()
+	now := time.Now()
+
+	flaps.record("a", now.Add(-20*time.Minute), 10*time.Minute)
+	flaps.record("a", now.Add(-5*time.Minute), 10*time.Minute)
+	if n := flaps.record("a", now, 10*time.Minute); n != 2 {
+		t.Errorf("Expected 2 flaps within the window, got %d", n)
+	}
+
+	flaps.quarantine("a", now)
+	flaps.quarantine("b", now.Add(time.Minute))
+	if due := flaps.due(now); len(due) != 1 || due[0] != "a" {
+		t.Errorf("Expected only a to be due, got %v", due)
+	}
+	flaps.release("a")
+	if n := flaps.record("a", now, 10*time.Minute); n != 1 {
+		t.Errorf("Expected release to clear flap history, got %d", n)
+	}
+}
+
+// flap makes an instance unhealthy and revives it with a heartbeat
+func flap(t *testing.T, mgr *PluginManager, resp *types.HandshakeResponse) {
+	t.Helper()
+
+	_, err := mgr.transitionInstance(resp.SessionId, 0, "heartbeat timeout", func(inst *entities.PluginInstance) error {
+		inst.Status = entities.PluginStatusUnhealthy
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("transitionInstance: %v", err)
+	}
+	mgr.HeartbeatHTTP(context.Background(), &types.HeartbeatRequest{
+		SessionId: resp.SessionId,
+		AuthToken: resp.AuthToken,
+	})
+}
+
+func TestFlappingInstanceIsQuarantined(t *testing.T) {
+	cfg, log, mgr, repo := setupTest(t)
+	mgr.config.Flapping.Threshold = 3
+	server := NewHTTPServer(cfg, log, mgr)
+
+	req := &types.HandshakeRequest{
+		PluginId:    "example",
+		Version:     "1.0.0",
+		ApiVersion:  "1.0",
+		InstanceKey: "host-a",
+	}
+	resp, _ := mgr.HandshakeHTTP(context.Background(), req)
+
+	flap(t, mgr, resp)
+	flap(t, mgr, resp)
+	inst, _ := repo.GetInstance(resp.SessionId)
+	if inst.Status != entities.PluginStatusRunning {
+		t.Fatalf("Expected instance below the threshold to recover, got %s", inst.Status)
+	}
+
+	flap(t, mgr, resp)
+	inst, _ = repo.GetInstance(resp.SessionId)
+	if inst.Status != entities.PluginStatusQuarantined || inst.QuarantinedUntil == nil {
+		t.Fatalf("Expected flapping instance to be quarantined, got %s", inst.Status)
+	}
+	events, _ := repo.ListEvents(repository.EventFilter{Type: EventTypeInstanceQuarantined})
+	if len(events) != 1 || events[0].InstanceID != resp.SessionId {
+		t.Errorf("Expected one instance_quarantined event, got %+v", events)
+	}
+
+	// Reconnects are refused while quarantined
+	if again, _ := mgr.HandshakeHTTP(context.Background(), req); again.Accepted {
+		t.Error("Expected handshake of a quarantined instance to be refused")
+	}
+
+	w := httptest.NewRecorder()
+	server.handleInstanceByID(w, httptest.NewRequest(http.MethodPost, "/api/v1/plugins/instances/"+resp.SessionId+"/release", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	inst, _ = repo.GetInstance(resp.SessionId)
+	if inst.Status != entities.PluginStatusStarting || inst.QuarantinedUntil != nil {
+		t.Errorf("Expected released instance to be starting, got %s", inst.Status)
+	}
+
+	// Releasing again conflicts
+	w = httptest.NewRecorder()
+	server.handleInstanceByID(w, httptest.NewRequest(http.MethodPost, "/api/v1/plugins/instances/"+resp.SessionId+"/release", nil))
+	if w.Code != http.StatusConflict {
+		t.Errorf("Expected status 409, got %d", w.Code)
+	}
+
+	if again, _ := mgr.HandshakeHTTP(context.Background(), req); !again.Accepted || !again.Resumed {
+		t.Errorf("Expected released instance to be resumed, got %+v", again)
+	}
+}
+
+func TestQuarantineCooldown(t *testing.T) {
+	_, _, mgr, repo := setupTest(t)
+	mgr.config.Flapping.Cooldown = "1ns"
+	resp := handshake(t, mgr, "example")
+
+	if err := mgr.quarantineInstance(resp.SessionId, "test"); err != nil {
+		t.Fatalf("quarantineInstance: %v", err)
+	}
+	time.Sleep(time.Millisecond)
+	mgr.releaseQuarantined()
+
+	inst, _ := repo.GetInstance(resp.SessionId)
+	if inst.Status != entities.PluginStatusStarting {
+		t.Errorf("Expected instance to be released after the cooldown, got %s", inst.Status)
+	}
+	history, _ := mgr.InstanceHistory(resp.SessionId)
+	if last := history[len(history)-1]; last.Reason != "quarantine cooldown elapsed" {
+		t.Errorf("Unexpected release reason %q", last.Reason)
+	}
+}
+
+func TestQuarantineBlocksNewInstances(t *testing.T) {
+	_, _, mgr, _ := setupTest(t)
+	req := &types.HandshakeRequest{
+		PluginId:    "example",
+		Version:     "1.0.0",
+		ApiVersion:  "1.0",
+		InstanceKey: "host-a",
+	}
+	resp, _ := mgr.HandshakeHTTP(context.Background(), req)
+	if err := mgr.quarantineInstance(resp.SessionId, "test"); err != nil {
+		t.Fatalf("quarantineInstance: %v", err)
+	}
+
+	// The same key is the quarantined instance
+	again, _ := mgr.HandshakeHTTP(context.Background(), req)
+	if again.Accepted || !strings.HasPrefix(again.Error, "instance quarantined") {
+		t.Errorf("Expected handshake with the quarantined key to be refused, got %+v", again)
+	}
+
+	// Keyless handshakes and other hosts start new instances
+	keyless := &types.HandshakeRequest{PluginId: "example", Version: "1.0.0", ApiVersion: "1.0"}
+	if other, _ := mgr.HandshakeHTTP(context.Background(), keyless); !other.Accepted || other.SessionId == resp.SessionId {
+		t.Errorf("Expected keyless handshake to start a new instance, got %+v", other)
+	}
+	other := *req
+	other.InstanceKey = "host-b"
+	if resp, _ := mgr.HandshakeHTTP(context.Background(), &other); !resp.Accepted {
+		t.Errorf("Expected handshake with another key to be accepted, got %+v", resp)
+	}
+
+	if _, err := mgr.ReleaseInstance(resp.SessionId, 0); err != nil {
+		t.Fatalf("ReleaseInstance: %v", err)
+	}
+	if again, _ := mgr.HandshakeHTTP(context.Background(), req); !again.Accepted || again.SessionId != resp.SessionId {
+		t.Errorf("Expected the released instance to be resumed, got %+v", again)
+	}
+}
@@ -3,6 +3,7 @@ package core
 import (
 	"context"
 
+	"github.com/robrt95x/milpa-cloud/pkg/rpc"
 	"github.com/robrt95x/milpa-cloud/pkg/types"
 
 	"google.golang.org/grpc"
@@ -13,6 +14,7 @@ type PluginServiceServer interface {
 	Handshake(context.Context, *HandshakeRequest) (*HandshakeResponse, error)
 	Heartbeat(context.Context, *HeartbeatRequest) (*HeartbeatResponse, error)
 	Configure(context.Context, *ConfigureRequest) (*ConfigureResponse, error)
+	Deregister(context.Context, *DeregisterRequest) (*DeregisterResponse, error)
 	Stream(*pluginStreamServer) error
 }
 
@@ -41,17 +43,21 @@ type (
 	HeartbeatResponse = types.HeartbeatResponse
 	ConfigureRequest  = types.ConfigureRequest
 	ConfigureResponse = types.ConfigureResponse
+	DeregisterRequest  = types.DeregisterRequest
+	DeregisterResponse = types.DeregisterResponse
 	PluginEvent      = types.PluginEvent
 	CoreEvent        = types.CoreEvent
 )
 
 // RegisterPluginServiceServer registers the service
+// Messages are plain pkg/types structs, so clients must select the JSON
+// codec registered by pkg/rpc (content-subtype "json").
 func RegisterPluginServiceServer(s *grpc.Server, srv PluginServiceServer) {
 	s.RegisterService(&_PluginService_serviceDesc, srv)
 }
 
 var _PluginService_serviceDesc = grpc.ServiceDesc{
-	ServiceName: "milpa.v1.PluginService",
+	ServiceName: rpc.ServiceName,
 	HandlerType: (*PluginServiceServer)(nil),
 	Methods: []grpc.MethodDesc{
 		{
@@ -66,6 +72,10 @@ var _PluginService_serviceDesc = grpc.ServiceDesc{
 			MethodName: "Configure",
 			Handler:    _PluginService_Configure_Handler,
 		},
+		{
+			MethodName: "Deregister",
+			Handler:    _PluginService_Deregister_Handler,
+		},
 	},
 	Streams: []grpc.StreamDesc{
 		{
@@ -75,7 +85,7 @@ var _PluginService_serviceDesc = grpc.ServiceDesc{
 			ClientStreams: true,
 		},
 	},
-	Metadata: "plugin.proto",
+	Metadata: "milpa/v1/plugin.proto",
 }
 
 func _PluginService_Handshake_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
@@ -88,7 +98,7 @@ func _PluginService_Handshake_Handler(srv interface{}, ctx context.Context, dec
 	}
 	info := &grpc.UnaryServerInfo{
 		Server:     srv,
-		FullMethod: "/milpa.v1.PluginService/Handshake",
+		FullMethod: rpc.MethodHandshake,
 	}
 	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
 		return srv.(PluginServiceServer).Handshake(ctx, req.(*HandshakeRequest))
@@ -106,7 +116,7 @@ func _PluginService_Heartbeat_Handler(srv interface{}, ctx context.Context, dec
 	}
 	info := &grpc.UnaryServerInfo{
 		Server:     srv,
-		FullMethod: "/milpa.v1.PluginService/Heartbeat",
+		FullMethod: rpc.MethodHeartbeat,
 	}
 	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
 		return srv.(PluginServiceServer).Heartbeat(ctx, req.(*HeartbeatRequest))
@@ -124,7 +134,7 @@ func _PluginService_Configure_Handler(srv interface{}, ctx context.Context, dec
 	}
 	info := &grpc.UnaryServerInfo{
 		Server:     srv,
-		FullMethod: "/milpa.v1.PluginService/Configure",
+		FullMethod: rpc.MethodConfigure,
 	}
 	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
 		return srv.(PluginServiceServer).Configure(ctx, req.(*ConfigureRequest))
@@ -132,6 +142,24 @@ func _PluginService_Configure_Handler(srv interface{}, ctx context.Context, dec
 	return interceptor(ctx, &in, info, handler)
 }
 
+func _PluginService_Deregister_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	var in DeregisterRequest
+	if err := dec(&in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PluginServiceServer).Deregister(ctx, &in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: rpc.MethodDeregister,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PluginServiceServer).Deregister(ctx, req.(*DeregisterRequest))
+	}
+	return interceptor(ctx, &in, info, handler)
+}
+
 func _PluginService_Stream_Handler(srv interface{}, stream grpc.ServerStream) error {
 	return srv.(PluginServiceServer).Stream(&pluginStreamServer{stream})
 }
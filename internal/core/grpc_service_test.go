@@ -0,0 +1,180 @@
+package core
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+
+	"github.com/robrt95x/milpa-cloud/internal/infrastructure/config"
+	"github.com/robrt95x/milpa-cloud/pkg/rpc"
+	"github.com/robrt95x/milpa-cloud/pkg/types"
+)
+
+// startGRPC starts the manager on lc and returns a client connected to it
+func startGRPC(t *testing.T, mgr *PluginManager, lc config.ListenerConfig, target func(addr string) string) (*rpc.Client, *grpc.ClientConn) {
+	t.Helper()
+
+	mgr.config.Server.GRPC = lc
+	mgr.config.Shutdown.DrainTimeout = "10ms"
+	if err := mgr.Start(context.Background()); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	t.Cleanup(func() { mgr.Stop() })
+
+	conn, err := grpc.NewClient(target(mgr.GRPCAddr()), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return rpc.NewClient(conn), conn
+}
+
+func tcpTarget(addr string) string { return addr }
+
+func TestGRPCHandshakeAndHeartbeat(t *testing.T) {
+	_, _, mgr, repo := setupTest(t)
+	client, _ := startGRPC(t, mgr, config.ListenerConfig{Address: "127.0.0.1:0"}, tcpTarget)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	resp, err := client.Handshake(ctx, &types.HandshakeRequest{
+		PluginId:     "example",
+		Version:      "1.0.0",
+		ApiVersion:   "1.0",
+		Capabilities: []string{"status"},
+		Metadata:     map[string]string{"region": "north"},
+		InstanceKey:  "host-a",
+	})
+	if err != nil {
+		t.Fatalf("Handshake failed: %v", err)
+	}
+	if !resp.Accepted || resp.SessionId == "" || resp.AuthToken == "" {
+		t.Fatalf("Expected an accepted handshake, got %+v", resp)
+	}
+	inst, err := repo.GetInstance(resp.SessionId)
+	if err != nil {
+		t.Fatalf("Expected the instance to be stored: %v", err)
+	}
+	if inst.InstanceKey != "host-a" {
+		t.Errorf("Expected instance key host-a, got %q", inst.InstanceKey)
+	}
+
+	hb, err := client.Heartbeat(ctx, &types.HeartbeatRequest{
+		SessionId: resp.SessionId,
+		AuthToken: resp.AuthToken,
+		Health:    &types.HealthReport{Status: "pass"},
+		Metrics:   []types.Metric{{Name: "jobs_total", Type: types.MetricCounter, Value: 3}},
+	})
+	if err != nil || !hb.Ok {
+		t.Fatalf("Heartbeat failed: %v %+v", err, hb)
+	}
+
+	_, err = client.Heartbeat(ctx, &types.HeartbeatRequest{SessionId: resp.SessionId, AuthToken: "wrong"})
+	if status.Code(err) != codes.Unauthenticated {
+		t.Errorf("Expected Unauthenticated for a wrong token, got %v", err)
+	}
+
+	// Rejections carry a status code
+	_, err = client.Handshake(ctx, &types.HandshakeRequest{PluginId: "old", Version: "1.0.0", ApiVersion: "0.1"})
+	if status.Code(err) == codes.OK {
+		t.Error("Expected an incompatible API version to be rejected")
+	}
+}
+
+func TestGRPCStream(t *testing.T) {
+	_, _, mgr, _ := setupTest(t)
+	client, _ := startGRPC(t, mgr, config.ListenerConfig{Address: "127.0.0.1:0"}, tcpTarget)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	resp, err := client.Handshake(ctx, &types.HandshakeRequest{PluginId: "example", Version: "1.0.0", ApiVersion: "1.0"})
+	if err != nil || !resp.Accepted {
+		t.Fatalf("Handshake failed: %v %+v", err, resp)
+	}
+
+	// The status of a rejected stream arrives with the first receive
+	rejected, err := client.Stream(ctx, resp.SessionId, "wrong")
+	if err == nil {
+		_, err = rejected.Recv()
+	}
+	if status.Code(err) != codes.Unauthenticated {
+		t.Errorf("Expected Unauthenticated for a wrong token, got %v", err)
+	}
+
+	stream, err := client.Stream(ctx, resp.SessionId, resp.AuthToken)
+	if err != nil {
+		t.Fatalf("Stream failed: %v", err)
+	}
+
+	// The subscription exists once the stream handler runs
+	deadline := time.Now().Add(2 * time.Second)
+	for mgr.SendEventToPlugin(resp.SessionId, EventTypeRestart, "now") != nil {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the stream to subscribe to events")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	ev, err := stream.Recv()
+	if err != nil {
+		t.Fatalf("Recv failed: %v", err)
+	}
+	if ev.Type != EventTypeRestart || ev.Data != "now" {
+		t.Errorf("Unexpected event %+v", ev)
+	}
+
+	// Closing the stream disconnects the plugin
+	stream.CloseSend()
+	deadline = time.Now().Add(2 * time.Second)
+	for len(mgr.conns.list()) != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the plugin to be disconnected when the stream closed")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestGRPCOverUnixSocket(t *testing.T) {
+	_, _, mgr, _ := setupTest(t)
+	path := filepath.Join(t.TempDir(), "grpc.sock")
+	client, _ := startGRPC(t, mgr, config.ListenerConfig{Network: "unix", Address: path},
+		func(addr string) string { return "unix://" + addr })
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	resp, err := client.Handshake(ctx, &types.HandshakeRequest{PluginId: "example", Version: "1.0.0", ApiVersion: "1.0"})
+	if err != nil || !resp.Accepted {
+		t.Fatalf("Handshake over the unix socket failed: %v %+v", err, resp)
+	}
+	hb, err := client.Heartbeat(ctx, &types.HeartbeatRequest{SessionId: resp.SessionId, AuthToken: resp.AuthToken})
+	if err != nil || !hb.Ok {
+		t.Fatalf("Heartbeat over the unix socket failed: %v %+v", err, hb)
+	}
+}
+
+func TestGRPCRequiresJSONCodec(t *testing.T) {
+	_, _, mgr, _ := setupTest(t)
+	_, conn := startGRPC(t, mgr, config.ListenerConfig{Address: "127.0.0.1:0"}, tcpTarget)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Without the json content-subtype the proto codec cannot encode the
+	// pkg/types structs
+	var out types.HandshakeResponse
+	err := conn.Invoke(ctx, rpc.MethodHandshake, &types.HandshakeRequest{PluginId: "example"}, &out)
+	if err == nil {
+		t.Fatal("Expected the default proto codec to fail")
+	}
+
+	err = conn.Invoke(ctx, rpc.MethodHandshake,
+		&types.HandshakeRequest{PluginId: "example", Version: "1.0.0", ApiVersion: "1.0"}, &out, rpc.CallOption())
+	if err != nil || !out.Accepted {
+		t.Fatalf("Expected the json codec to work: %v %+v", err, out)
+	}
+}
@@ -0,0 +1,135 @@
+package core
+
+import (
+	"strings"
+	"time"
+
+	"github.com/robrt95x/milpa-cloud/internal/domain/entities"
+	"github.com/robrt95x/milpa-cloud/pkg/types"
+)
+
+// Limits on the size of a stored health report
+const (
+	maxHealthChecks = 64
+	maxHealthGauges = 64
+)
+
+// newHealthReport converts the health sent with a heartbeat
+// Without a structured report the legacy Status["status"] value is used;
+// nil means the heartbeat carried no health information.
+func newHealthReport(req *types.HeartbeatRequest, at time.Time) *entities.HealthReport {
+	if req.Health == nil {
+		legacy, ok := req.Status["status"]
+		if !ok {
+			return nil
+		}
+		return &entities.HealthReport{Status: normalizeHealth(legacy), ReportedAt: at}
+	}
+
+	report := &entities.HealthReport{
+		Status:     normalizeHealth(req.Health.Status),
+		ReportedAt: at,
+	}
+	for i, c := range req.Health.Checks {
+		if i == maxHealthChecks {
+			break
+		}
+		report.Checks = append(report.Checks, entities.HealthCheck{
+			Name:    c.Name,
+			Status:  normalizeHealth(c.Status),
+			Message: c.Message,
+		})
+	}
+	for name, v := range req.Health.Gauges {
+		if len(report.Gauges) == maxHealthGauges {
+			break
+		}
+		if report.Gauges == nil {
+			report.Gauges = make(map[string]float64, len(req.Health.Gauges))
+		}
+		report.Gauges[name] = v
+	}
+
+	// An empty overall status takes the worst check
+	if req.Health.Status == "" {
+		report.Status = entities.HealthPass
+		for _, c := range report.Checks {
+			if healthRank(c.Status) > healthRank(report.Status) {
+				report.Status = c.Status
+			}
+		}
+	}
+	return report
+}
+
+// normalizeHealth maps reported statuses to pass, warn or fail
+// Unknown values are treated as warn so a typo never degrades an instance
+func normalizeHealth(status string) string {
+	switch strings.ToLower(status) {
+	case entities.HealthPass, "healthy", "ok", "":
+		return entities.HealthPass
+	case entities.HealthFail, "unhealthy", "error":
+		return entities.HealthFail
+	default:
+		return entities.HealthWarn
+	}
+}
+
+func healthRank(status string) int {
+	switch status {
+	case entities.HealthFail:
+		return 2
+	case entities.HealthWarn:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// heartbeatStatus returns the status a heartbeat moves an instance to, with
+// the reason, or "" when the status stays the same. Only live and unhealthy
+// instances react to heartbeats; stopping and stopped ones need an admin
+// action or a new handshake.
+func heartbeatStatus(status string, health *entities.HealthReport) (string, string) {
+	if !entities.IsLiveStatus(status) && status != entities.PluginStatusUnhealthy {
+		return "", ""
+	}
+
+	target, reason := entities.PluginStatusRunning, "heartbeat received"
+	switch {
+	case health != nil && health.Degraded():
+		target, reason = entities.PluginStatusDegraded, "reported status fail"
+		if failing := health.FailingChecks(); len(failing) > 0 {
+			reason = "failing checks: " + strings.Join(failing, ", ")
+		}
+	case status == entities.PluginStatusDegraded && health == nil:
+		// No report: keep the last derived status
+		return "", ""
+	case status == entities.PluginStatusDegraded:
+		reason = "checks passing"
+	}
+
+	if target == status {
+		return "", ""
+	}
+	return target, reason
+}
+
+// sameHealth reports whether two reports have the same content, ignoring
+// when they were reported
+func sameHealth(a, b *entities.HealthReport) bool {
+	if a.Status != b.Status || len(a.Checks) != len(b.Checks) || len(a.Gauges) != len(b.Gauges) {
+		return false
+	}
+	for i := range a.Checks {
+		if a.Checks[i] != b.Checks[i] {
+			return false
+		}
+	}
+	for k, v := range a.Gauges {
+		if bv, ok := b.Gauges[k]; !ok || bv != v {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,158 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/robrt95x/milpa-cloud/internal/domain/entities"
+	"github.com/robrt95x/milpa-cloud/pkg/types"
+)
+
+func TestNewHealthReport(t *testing.T) {
+	now := time.Now()
+
+	if h := newHealthReport(&types.HeartbeatRequest{}, now); h != nil {
+		t.Errorf("Expected no report without health, got %+v", h)
+	}
+
+	h := newHealthReport(&types.HeartbeatRequest{Status: map[string]string{"status": "healthy"}}, now)
+	if h == nil || h.Status != entities.HealthPass {
+		t.Errorf("Expected legacy healthy status to map to pass, got %+v", h)
+	}
+
+	h = newHealthReport(&types.HeartbeatRequest{Health: &types.HealthReport{
+		Checks: []types.HealthCheck{
+			{Name: "db", Status: "pass"},
+			{Name: "disk", Status: "WARN"},
+			{Name: "cache", Status: "bogus"},
+		},
+		Gauges: map[string]float64{"queue_depth": 3},
+	}}, now)
+	if h.Status != entities.HealthWarn {
+		t.Errorf("Expected overall status from worst check, got %s", h.Status)
+	}
+	if h.Checks[1].Status != entities.HealthWarn || h.Checks[2].Status != entities.HealthWarn {
+		t.Errorf("Expected statuses to be normalized, got %+v", h.Checks)
+	}
+	if h.Gauges["queue_depth"] != 3 || !h.ReportedAt.Equal(now) {
+		t.Errorf("Unexpected report %+v", h)
+	}
+}
+
+func TestHeartbeatStatus(t *testing.T) {
+	failing := &entities.HealthReport{Status: entities.HealthPass, Checks: []entities.HealthCheck{{Name: "db", Status: entities.HealthFail}}}
+	passing := &entities.HealthReport{Status: entities.HealthPass}
+
+	tests := []struct {
+		status     string
+		health     *entities.HealthReport
+		wantStatus string
+		wantReason string
+	}{
+		{entities.PluginStatusRunning, nil, "", ""},
+		{entities.PluginStatusRunning, failing, entities.PluginStatusDegraded, "failing checks: db"},
+		{entities.PluginStatusDegraded, nil, "", ""},
+		{entities.PluginStatusDegraded, passing, entities.PluginStatusRunning, "checks passing"},
+		{entities.PluginStatusUnhealthy, failing, entities.PluginStatusDegraded, "failing checks: db"},
+		{entities.PluginStatusUnhealthy, nil, entities.PluginStatusRunning, "heartbeat received"},
+		{entities.PluginStatusStarting, passing, entities.PluginStatusRunning, "heartbeat received"},
+		{entities.PluginStatusStopped, passing, "", ""},
+		{entities.PluginStatusStopping, failing, "", ""},
+	}
+
+	for _, tt := range tests {
+		status, reason := heartbeatStatus(tt.status, tt.health)
+		if status != tt.wantStatus || reason != tt.wantReason {
+			t.Errorf("heartbeatStatus(%s): expected %q (%q), got %q (%q)", tt.status, tt.wantStatus, tt.wantReason, status, reason)
+		}
+	}
+}
+
+func TestHeartbeatHealthReport(t *testing.T) {
+	cfg, log, mgr, repo := setupTest(t)
+	server := NewHTTPServer(cfg, log, mgr)
+	resp := handshake(t, mgr, "example")
+
+	beat := func(health *types.HealthReport) {
+		t.Helper()
+		hb, _ := mgr.HeartbeatHTTP(context.Background(), &types.HeartbeatRequest{
+			SessionId: resp.SessionId,
+			AuthToken: resp.AuthToken,
+			Health:    health,
+		})
+		if !hb.Ok {
+			t.Fatalf("Heartbeat rejected: %s", hb.Message)
+		}
+	}
+
+	beat(&types.HealthReport{
+		Status: "warn",
+		Checks: []types.HealthCheck{{Name: "db", Status: "fail", Message: "connection refused"}},
+		Gauges: map[string]float64{"queue_depth": 42},
+	})
+
+	inst, _ := repo.GetInstance(resp.SessionId)
+	if inst.Status != entities.PluginStatusDegraded {
+		t.Fatalf("Expected failing check to degrade the instance, got %s", inst.Status)
+	}
+	// The report that changed the status is written with it
+	if inst.Health == nil || inst.Health.Status != entities.HealthWarn {
+		t.Errorf("Expected health report to be stored with the status change, got %+v", inst.Health)
+	}
+
+	// The API shows the in-memory report
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/plugins/instances/"+resp.SessionId, nil)
+	w := httptest.NewRecorder()
+	server.handleInstanceByID(w, req)
+	var body InstanceResponse
+	json.NewDecoder(w.Body).Decode(&body)
+	if body.Health == nil || len(body.Health.Checks) != 1 || body.Health.Checks[0].Message != "connection refused" {
+		t.Errorf("Expected health report in response, got %+v", body.Health)
+	}
+
+	mgr.flushHeartbeats()
+	inst, _ = repo.GetInstance(resp.SessionId)
+	if inst.Health == nil || inst.Health.Gauges["queue_depth"] != 42 {
+		t.Errorf("Expected health report to be flushed, got %+v", inst.Health)
+	}
+
+	// Without a report the derived status is kept
+	beat(nil)
+	if inst, _ := repo.GetInstance(resp.SessionId); inst.Status != entities.PluginStatusDegraded {
+		t.Errorf("Expected instance to stay degraded, got %s", inst.Status)
+	}
+
+	beat(&types.HealthReport{Status: "pass"})
+	if inst, _ := repo.GetInstance(resp.SessionId); inst.Status != entities.PluginStatusRunning {
+		t.Errorf("Expected passing checks to restore running, got %s", inst.Status)
+	}
+
+	history, _ := mgr.InstanceHistory(resp.SessionId)
+	if len(history) != 3 || history[1].Reason != "failing checks: db" || history[2].Reason != "checks passing" {
+		t.Errorf("Unexpected history %+v", history)
+	}
+}
+
+func TestLivenessHealthOnlyDirtyOnChange(t *testing.T) {
+	table := newLivenessTable()
+	table.sync(&entities.PluginInstance{ID: "inst-1", Status: entities.PluginStatusRunning})
+
+	table.report("inst-1", &entities.HealthReport{Status: entities.HealthPass, ReportedAt: time.Now()})
+	if n := len(table.drainHealth()); n != 1 {
+		t.Fatalf("Expected 1 dirty report, got %d", n)
+	}
+
+	table.report("inst-1", &entities.HealthReport{Status: entities.HealthPass, ReportedAt: time.Now()})
+	if n := len(table.drainHealth()); n != 0 {
+		t.Errorf("Expected unchanged report not to be flushed, got %d", n)
+	}
+
+	table.report("inst-1", &entities.HealthReport{Status: entities.HealthWarn, ReportedAt: time.Now()})
+	if n := len(table.drainHealth()); n != 1 {
+		t.Errorf("Expected changed report to be flushed, got %d", n)
+	}
+}
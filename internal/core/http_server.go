@@ -1,52 +1,301 @@
 package core
 
 import (
-	
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/robrt95x/milpa-cloud/internal/domain/entities"
+	"github.com/robrt95x/milpa-cloud/internal/domain/repository"
 	"github.com/robrt95x/milpa-cloud/internal/infrastructure/config"
+	"github.com/robrt95x/milpa-cloud/internal/infrastructure/metrics"
 	"github.com/robrt95x/milpa-cloud/pkg/logger"
+	"github.com/robrt95x/milpa-cloud/pkg/tracing"
 	"github.com/robrt95x/milpa-cloud/pkg/types"
 )
 
 // HTTPServer handles REST API requests
+// The plugin API and the admin API share one listener unless
+// server.admin is set.
 type HTTPServer struct {
 	config *config.Config
 	log    logger.Logger
 	mgr    *PluginManager
+	server *http.Server
+	// admin serves the admin API on its own listener; nil when shared
+	admin *http.Server
+	// requests and duration are the per-route request metrics
+	requests *metrics.CounterVec
+	duration *metrics.HistogramVec
+	// closing is closed on shutdown to end streaming responses
+	closing   chan struct{}
+	closeOnce sync.Once
+	// addr and adminAddr are the bound addresses, set by Start
+	addr      string
+	adminAddr string
+	errs      serverErrors
 }
 
 // NewHTTPServer creates a new HTTP server
 func NewHTTPServer(cfg *config.Config, log logger.Logger, mgr *PluginManager) *HTTPServer {
-	return &HTTPServer{
-		config: cfg,
-		log:    log,
-		mgr:    mgr,
+	s := &HTTPServer{
+		config:  cfg,
+		log:     log,
+		mgr:     mgr,
+		closing: make(chan struct{}),
+		errs:    newServerErrors(),
 	}
+	reg := mgr.Metrics()
+	s.requests = reg.NewCounterVec("milpa_http_requests_total",
+		"HTTP requests by route, method and status code.", "route", "method", "code")
+	s.duration = reg.NewHistogramVec("milpa_http_request_duration_seconds",
+		"HTTP request latency by route and method.", metrics.DefaultBuckets, "route", "method")
+	if _, separate := cfg.Server.AdminListener(); separate {
+		s.server = &http.Server{Handler: s.handler(true, false)}
+		s.admin = &http.Server{Handler: s.handler(false, true)}
+		s.admin.RegisterOnShutdown(s.closeStreams)
+	} else {
+		s.server = &http.Server{Handler: s.routes()}
+	}
+	s.server.RegisterOnShutdown(s.closeStreams)
+	return s
+}
+
+// closeStreams ends the streaming responses of both listeners
+func (s *HTTPServer) closeStreams() {
+	s.closeOnce.Do(func() { close(s.closing) })
+}
+
+// routes returns the handler serving every API endpoint
+func (s *HTTPServer) routes() http.Handler {
+	return s.handler(true, true)
 }
 
-// Start begins the HTTP server
+// handler returns the handler serving the plugin API, the admin API or
+// both; probes are served by every listener
+func (s *HTTPServer) handler(plugin, admin bool) http.Handler {
+	mux := http.NewServeMux()
+
+	if admin {
+		// Plugin Definition endpoints
+		mux.HandleFunc("/api/v1/plugins", s.handleDefinitions)
+		mux.HandleFunc("/api/v1/plugins/", s.handleDefinitionByID)
+
+		// Plugin Instance endpoints
+		mux.HandleFunc("/api/v1/plugins/instances", s.handleInstances)
+		mux.HandleFunc("/api/v1/plugins/instances/", s.handleInstanceByID)
+	}
+
+	if plugin {
+		// Plugin communication endpoints (HTTP fallback for gRPC)
+		mux.HandleFunc("/api/v1/handshake", s.handleHandshake)
+		mux.HandleFunc("/api/v1/heartbeat", s.handleHeartbeat)
+		mux.HandleFunc("/api/v1/configure", s.handleConfigure)
+		mux.HandleFunc("/api/v1/deregister", s.handleDeregister)
+		mux.HandleFunc("/api/v1/events/poll", s.handlePollEvents)
+		mux.HandleFunc("/api/v1/events", s.handlePluginEvent)
+		mux.HandleFunc("/api/v1/logs", s.handleLogs)
+	}
+
+	if admin {
+		// Admin endpoints
+		mux.HandleFunc("/api/v1/log-level", s.handleLogLevel)
+		mux.Handle("/metrics", s.mgr.Metrics().Handler())
+	}
+
+	// Probes
+	mux.HandleFunc("/healthz", s.handleHealthz)
+	mux.HandleFunc("/readyz", s.handleReadyz)
+
+	return s.logRequests(s.instrumentRequests(mux))
+}
+
+// statusRecorder captures the status code written by a handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Flush supports streaming responses
+func (r *statusRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// logRequests tags every request with a request ID, taken from the
+// X-Request-ID header or generated, and logs it at debug level
+func (s *HTTPServer) logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := r.Header.Get("X-Request-ID")
+		if requestID == "" {
+			requestID = generateRequestID()
+		}
+		w.Header().Set("X-Request-ID", requestID)
+		r = r.WithContext(logger.ContextWith(r.Context(), logger.FieldRequestID, requestID))
+
+		if !s.log.IsDebug() {
+			next.ServeHTTP(w, r)
+			return
+		}
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+		s.log.WithContext(r.Context()).Debug("http request", "method", r.Method, "path", r.URL.Path,
+			"status", rec.status, "elapsed", time.Since(start))
+	})
+}
+
+// instrumentRequests records the count and latency of every request and
+// traces it, continuing the trace of the caller's traceparent header
+func (s *HTTPServer) instrumentRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ctx, span := s.mgr.Tracer().Start(tracing.Extract(r.Context(), r.Header), "HTTP "+r.Method, tracing.SpanKindServer,
+			"http.request.method", r.Method, "url.path", r.URL.Path)
+		defer span.End()
+		r = r.WithContext(ctx)
+
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		// The mux sets r.Pattern on the request it was given
+		route := metricRoute(r.Pattern, r.URL.Path, rec.status)
+		method := metricMethod(r.Method)
+		s.requests.With(route, method, strconv.Itoa(rec.status)).Inc()
+		s.duration.With(route, method).Observe(time.Since(start).Seconds())
+
+		span.SetName("HTTP " + r.Method + " " + route)
+		span.SetAttributes("http.route", route, "http.response.status_code", rec.status)
+		if rec.status >= http.StatusInternalServerError {
+			span.SetStatus(tracing.StatusError, http.StatusText(rec.status))
+		}
+	})
+}
+
+// metricRoute returns the route label of a request: its mux pattern, with
+// the ID of subtree patterns replaced by :id. The segment after the ID is
+// kept only when the handler recognized it, so labels stay bounded.
+func metricRoute(pattern, path string, status int) string {
+	if pattern == "" {
+		return "unmatched"
+	}
+	if !strings.HasSuffix(pattern, "/") {
+		return pattern
+	}
+	rest := strings.TrimPrefix(path, pattern)
+	if rest == "" {
+		return pattern
+	}
+	id, sub, _ := strings.Cut(rest, "/")
+	if id == "" {
+		return pattern + "*"
+	}
+	switch {
+	case sub == "":
+		return pattern + ":id"
+	case strings.Contains(sub, "/") || status == http.StatusNotFound || status == http.StatusMethodNotAllowed:
+		return pattern + ":id/*"
+	}
+	return pattern + ":id/" + sub
+}
+
+// metricMethod returns the method label of a request
+func metricMethod(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut,
+		http.MethodPatch, http.MethodDelete, http.MethodOptions:
+		return method
+	}
+	return "other"
+}
+
+// generateRequestID returns a random request ID
+func generateRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Start binds the HTTP listeners and serves in the background
+// A *ListenError reports that an address could not be bound; failures
+// after that are sent on Errors.
 func (s *HTTPServer) Start() error {
-	addr := fmt.Sprintf("%s:%d", s.config.Server.Host, s.config.Server.HTTPPort)
+	lis, err := listen(ServerHTTP, s.config.Server.HTTPListener())
+	if err != nil {
+		return err
+	}
+	var adminLis net.Listener
+	if s.admin != nil {
+		lc, _ := s.config.Server.AdminListener()
+		if adminLis, err = listen(ServerAdmin, lc); err != nil {
+			lis.Close()
+			return err
+		}
+	}
 
-	// Plugin Definition endpoints
-	http.HandleFunc("/api/v1/plugins", s.handleDefinitions)
-	http.HandleFunc("/api/v1/plugins/", s.handleDefinitionByID)
+	s.addr = lis.Addr().String()
+	go s.serve(ServerHTTP, s.server, lis)
+	if adminLis != nil {
+		s.adminAddr = adminLis.Addr().String()
+		go s.serve(ServerAdmin, s.admin, adminLis)
+	}
+	return nil
+}
 
-	// Plugin Instance endpoints
-	http.HandleFunc("/api/v1/plugins/instances", s.handleInstances)
-	http.HandleFunc("/api/v1/plugins/instances/", s.handleInstanceByID)
+// serve runs srv on lis until Shutdown, reporting an unexpected end on Errors
+func (s *HTTPServer) serve(name string, srv *http.Server, lis net.Listener) {
+	addr := lis.Addr().String()
+	s.log.Info("HTTP server listening", "server", name, "network", lis.Addr().Network(), "address", addr)
+	if err := srv.Serve(lis); !errors.Is(err, http.ErrServerClosed) {
+		s.log.Error("HTTP server failed", "server", name, "address", addr, "error", err)
+		s.errs.report(&ServeError{Server: name, Address: addr, Err: err})
+	}
+}
+
+// Addr returns the address the plugin API is bound to, once started
+// It is host:port for tcp and the socket path for unix listeners.
+func (s *HTTPServer) Addr() string {
+	return s.addr
+}
+
+// AdminAddr returns the address of the separate admin API listener, if any
+func (s *HTTPServer) AdminAddr() string {
+	return s.adminAddr
+}
 
-	// Plugin communication endpoints (HTTP fallback for gRPC)
-	http.HandleFunc("/api/v1/handshake", s.handleHandshake)
-	http.HandleFunc("/api/v1/heartbeat", s.handleHeartbeat)
-	http.HandleFunc("/api/v1/configure", s.handleConfigure)
+// Errors reports a server that stopped serving before Shutdown
+func (s *HTTPServer) Errors() <-chan error {
+	return s.errs
+}
 
-	s.log.Info("HTTP server listening", "address", addr)
-	return http.ListenAndServe(addr, nil)
+// Shutdown stops accepting connections and waits for active requests
+// to finish or ctx to expire
+func (s *HTTPServer) Shutdown(ctx context.Context) error {
+	s.log.Info("stopping HTTP server")
+	err := s.server.Shutdown(ctx)
+	if s.admin != nil {
+		if adminErr := s.admin.Shutdown(ctx); err == nil {
+			err = adminErr
+		}
+	}
+	return err
 }
 
 // ============ Plugin Communication Handlers ============
@@ -70,7 +319,7 @@ func (s *HTTPServer) handleHandshake(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Call manager's handshake (need to make it public)
-	resp, err := s.mgr.HandshakeHTTP(r.Context(), &req)
+	resp, err := s.mgr.HandshakeHTTP(withPeerAddr(r.Context(), r.RemoteAddr), &req)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		json.NewEncoder(w).Encode(resp)
@@ -139,6 +388,74 @@ func (s *HTTPServer) handleConfigure(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(resp)
 }
 
+func (s *HTTPServer) handleDeregister(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req types.DeregisterRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	resp, _ := s.mgr.DeregisterHTTP(r.Context(), &req)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (s *HTTPServer) handleLogs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req types.LogBatchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	resp := s.mgr.ForwardLogs(&req)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (s *HTTPServer) handlePollEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req types.EventPollRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	resp := s.mgr.PollEvents(r.Context(), &req)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (s *HTTPServer) handlePluginEvent(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req types.PluginEventRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	resp := s.mgr.SendPluginEvent(r.Context(), &req)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
 // ============ Definition Handlers ============
 
 func (s *HTTPServer) handleDefinitions(w http.ResponseWriter, r *http.Request) {
@@ -151,37 +468,87 @@ func (s *HTTPServer) handleDefinitions(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *HTTPServer) handleDefinitionByID(w http.ResponseWriter, r *http.Request) {
-	id := r.URL.Path[len("/api/v1/plugins/"):]
+	id, sub, _ := strings.Cut(r.URL.Path[len("/api/v1/plugins/"):], "/")
 	if id == "" {
 		http.Error(w, "Plugin ID required", http.StatusBadRequest)
 		return
 	}
 
-	switch r.Method {
-	case http.MethodGet:
+	switch {
+	case sub == "" && r.Method == http.MethodGet:
 		s.getDefinition(w, r, id)
-	case http.MethodPut:
+	case sub == "" && r.Method == http.MethodPut:
 		s.updateDefinition(w, r, id)
+	case sub == "log-level" && r.Method == http.MethodPut:
+		s.setPluginLogLevel(w, r, id)
+	case sub != "" && sub != "log-level":
+		http.Error(w, "Not found", http.StatusNotFound)
 	default:
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 	}
 }
 
+// setPluginLogLevel sends a log_level event to the connected instances of
+// a plugin
+func (s *HTTPServer) setPluginLogLevel(w http.ResponseWriter, r *http.Request, id string) {
+	var req LogLevelRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if err := logger.ValidateLevel(req.Level); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	resp, err := s.mgr.SetPluginLogLevel(r.Context(), id, req.Level)
+	if err != nil {
+		writeRepositoryError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
 func (s *HTTPServer) listDefinitions(w http.ResponseWriter, r *http.Request) {
-	defs := s.mgr.ListDefinitions()
+	params := r.URL.Query()
+	q := repository.DefinitionQuery{
+		Capability: params.Get("capability"),
+		Sort:       params.Get("sort"),
+		Cursor:     params.Get("cursor"),
+	}
+	var err error
+	if q.Limit, err = parseLimit(params); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if q.Enabled, err = parseBoolParam(params, "enabled"); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	page, err := s.mgr.QueryDefinitions(q)
+	if err != nil {
+		writeRepositoryError(w, err)
+		return
+	}
 
 	response := DefinitionListResponse{
-		Plugins: make([]DefinitionResponse, 0, len(defs)),
-		Total:   len(defs),
+		Plugins:    make([]DefinitionResponse, 0, len(page.Items)),
+		Total:      int(page.Total),
+		NextCursor: page.NextCursor,
 	}
 
-	for _, def := range defs {
+	for _, def := range page.Items {
 		response.Plugins = append(response.Plugins, DefinitionResponse{
 			ID:           def.ID,
 			Version:      def.Version,
 			APIVersion:   def.APIVersion,
 			DependsOn:    def.DependsOn,
 			Capabilities: def.Capabilities,
+			Enabled:      def.Enabled,
+			Revision:     def.Revision,
 		})
 	}
 
@@ -202,8 +569,11 @@ func (s *HTTPServer) getDefinition(w http.ResponseWriter, r *http.Request, id st
 		APIVersion:   def.APIVersion,
 		DependsOn:    def.DependsOn,
 		Capabilities: def.Capabilities,
+		Enabled:      def.Enabled,
+		Revision:     def.Revision,
 	}
 
+	setETag(w, def.Revision)
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
 }
@@ -215,11 +585,19 @@ func (s *HTTPServer) updateDefinition(w http.ResponseWriter, r *http.Request, id
 		return
 	}
 
-	if err := s.mgr.SetDefinitionEnabled(id, req.Enabled); err != nil {
-		http.Error(w, err.Error(), http.StatusNotFound)
+	ifMatch, err := parseIfMatch(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	def, err := s.mgr.SetDefinitionEnabled(id, req.Enabled, ifMatch)
+	if err != nil {
+		writeRepositoryError(w, err)
 		return
 	}
 
+	setETag(w, def.Revision)
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
 }
@@ -237,39 +615,78 @@ func (s *HTTPServer) handleInstances(w http.ResponseWriter, r *http.Request) {
 
 func (s *HTTPServer) handleInstanceByID(w http.ResponseWriter, r *http.Request) {
 	path := r.URL.Path
-	id := path[len("/api/v1/plugins/instances/"):]
+	id, sub, _ := strings.Cut(path[len("/api/v1/plugins/instances/"):], "/")
 	if id == "" {
 		http.Error(w, "Instance ID required", http.StatusBadRequest)
 		return
 	}
 
-	switch r.Method {
-	case http.MethodGet:
+	switch {
+	case id == "status-query" && sub == "" && r.Method == http.MethodPost:
+		// Broadcast form: /api/v1/plugins/instances/status-query
+		s.queryStatus(w, r, "")
+	case sub == "" && r.Method == http.MethodGet:
 		s.getInstance(w, r, id)
-	case http.MethodPut:
+	case sub == "" && r.Method == http.MethodPut:
 		s.updateInstance(w, r, id)
+	case sub == "history" && r.Method == http.MethodGet:
+		s.getInstanceHistory(w, r, id)
+	case sub == "release" && r.Method == http.MethodPost:
+		s.releaseInstance(w, r, id)
+	case sub == "status-query" && r.Method == http.MethodPost:
+		s.queryStatus(w, r, id)
+	case sub == "logs" && r.Method == http.MethodGet:
+		s.getInstanceLogs(w, r, id)
+	case sub != "" && sub != "history" && sub != "release" && sub != "status-query" && sub != "logs":
+		http.Error(w, "Not found", http.StatusNotFound)
 	default:
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 	}
 }
 
 func (s *HTTPServer) listInstances(w http.ResponseWriter, r *http.Request) {
-	instances := s.mgr.ListInstances()
+	params := r.URL.Query()
+	q := repository.InstanceQuery{
+		DefinitionID: params.Get("definition_id"),
+		Capability:   params.Get("capability"),
+		Sort:         params.Get("sort"),
+		Cursor:       params.Get("cursor"),
+	}
+	if status := params.Get("status"); status != "" {
+		q.Status = strings.Split(status, ",")
+	}
+	var err error
+	if q.Limit, err = parseLimit(params); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if q.Enabled, err = parseBoolParam(params, "enabled"); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if q.HeartbeatBefore, err = parseTimeParam(params, "heartbeat_before"); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if q.HeartbeatAfter, err = parseTimeParam(params, "heartbeat_after"); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	page, err := s.mgr.QueryInstances(q)
+	if err != nil {
+		writeRepositoryError(w, err)
+		return
+	}
 
 	response := InstanceListResponse{
-		Instances: make([]InstanceResponse, 0, len(instances)),
-		Total:     len(instances),
-	}
-
-	for _, inst := range instances {
-		response.Instances = append(response.Instances, InstanceResponse{
-			ID:             inst.ID,
-			PluginID:       inst.DefinitionID,
-			Status:         inst.Status,
-			Enabled:        inst.Enabled,
-			StartedAt:      inst.StartedAt,
-			LastHeartbeat:  inst.LastHeartbeat,
-		})
+		Instances:  make([]InstanceResponse, 0, len(page.Items)),
+		Total:      int(page.Total),
+		NextCursor: page.NextCursor,
+	}
+
+	for _, inst := range page.Items {
+		response.Instances = append(response.Instances, s.instanceResponse(inst))
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -283,19 +700,111 @@ func (s *HTTPServer) getInstance(w http.ResponseWriter, r *http.Request, id stri
 		return
 	}
 
-	response := InstanceResponse{
-		ID:             inst.ID,
-		PluginID:       inst.DefinitionID,
-		Status:         inst.Status,
-		Enabled:        inst.Enabled,
-		StartedAt:      inst.StartedAt,
-		LastHeartbeat:  inst.LastHeartbeat,
+	response := s.instanceResponse(inst)
+
+	setETag(w, inst.Revision)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+func (s *HTTPServer) getInstanceHistory(w http.ResponseWriter, r *http.Request, id string) {
+	transitions, err := s.mgr.InstanceHistory(id)
+	if err != nil {
+		writeRepositoryError(w, err)
+		return
 	}
 
+	response := InstanceHistoryResponse{
+		InstanceID:  id,
+		Transitions: transitions,
+	}
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
 }
 
+// getInstanceLogs returns the logs forwarded by an instance
+// Query parameters: level (minimum), since, until (RFC 3339) and limit (most
+// recent records, default 100). With follow=true the response streams
+// newline-delimited records, first the matching ones, then new ones as they
+// arrive, until the client disconnects.
+func (s *HTTPServer) getInstanceLogs(w http.ResponseWriter, r *http.Request, id string) {
+	params := r.URL.Query()
+	q := LogQuery{Level: params.Get("level"), Limit: defaultLogQueryLimit}
+	if q.Level != "" && !validLogLevel(q.Level) {
+		http.Error(w, "invalid level "+strconv.Quote(q.Level), http.StatusBadRequest)
+		return
+	}
+	var err error
+	if limit, err := parseLimit(params); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	} else if limit > 0 {
+		q.Limit = limit
+	}
+	if q.Since, err = parseTimeParam(params, "since"); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if q.Until, err = parseTimeParam(params, "until"); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	follow, err := parseBoolParam(params, "follow")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if follow == nil || !*follow {
+		records, err := s.mgr.InstanceLogs(id, q)
+		if err != nil {
+			writeRepositoryError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(InstanceLogsResponse{InstanceID: id, Records: records})
+		return
+	}
+
+	// Watch before reading so no record falls between the two
+	notify, stop := s.mgr.WatchInstanceLogs(id)
+	defer stop()
+	records, err := s.mgr.InstanceLogs(id, q)
+	if err != nil {
+		writeRepositoryError(w, err)
+		return
+	}
+
+	flusher, _ := w.(http.Flusher)
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	enc := json.NewEncoder(w)
+	q.Limit = 0
+	for {
+		for _, rec := range records {
+			if err := enc.Encode(rec); err != nil {
+				return
+			}
+			q.AfterSeq = rec.Seq
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+
+		select {
+		case <-notify:
+		case <-r.Context().Done():
+			return
+		case <-s.closing:
+			return
+		}
+		if records, err = s.mgr.InstanceLogs(id, q); err != nil {
+			// Instance deleted while following
+			return
+		}
+	}
+}
+
 func (s *HTTPServer) updateInstance(w http.ResponseWriter, r *http.Request, id string) {
 	var req UpdatePluginRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -303,20 +812,222 @@ func (s *HTTPServer) updateInstance(w http.ResponseWriter, r *http.Request, id s
 		return
 	}
 
-	if err := s.mgr.SetInstanceEnabled(id, req.Enabled); err != nil {
-		http.Error(w, err.Error(), http.StatusNotFound)
+	ifMatch, err := parseIfMatch(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	inst, err := s.mgr.SetInstanceEnabled(id, req.Enabled, ifMatch)
+	if err != nil {
+		writeRepositoryError(w, err)
 		return
 	}
 
+	setETag(w, inst.Revision)
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
 }
 
+func (s *HTTPServer) releaseInstance(w http.ResponseWriter, r *http.Request, id string) {
+	ifMatch, err := parseIfMatch(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	inst, err := s.mgr.ReleaseInstance(id, ifMatch)
+	if errors.Is(err, errNotQuarantined) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		writeRepositoryError(w, err)
+		return
+	}
+
+	setETag(w, inst.Revision)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.instanceResponse(inst))
+}
+
+// Status query timeouts
+const (
+	defaultStatusQueryTimeout = 5 * time.Second
+	maxStatusQueryTimeout     = 30 * time.Second
+)
+
+// queryStatus asks one instance, or all connected ones when id is empty,
+// for their current status
+// Query parameters: timeout (Go duration, default 5s, max 30s) and, for the
+// broadcast form, definition_id
+func (s *HTTPServer) queryStatus(w http.ResponseWriter, r *http.Request, id string) {
+	params := r.URL.Query()
+	timeout := defaultStatusQueryTimeout
+	if v := params.Get("timeout"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 || d > maxStatusQueryTimeout {
+			http.Error(w, "invalid timeout (max "+maxStatusQueryTimeout.String()+")", http.StatusBadRequest)
+			return
+		}
+		timeout = d
+	}
+
+	var resp *StatusQueryResponse
+	if id == "" {
+		resp = s.mgr.QueryStatus(r.Context(), params.Get("definition_id"), timeout)
+	} else {
+		var err error
+		if resp, err = s.mgr.QueryInstanceStatus(r.Context(), id, timeout); err != nil {
+			writeRepositoryError(w, err)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// ============ Admin Handlers ============
+
+// handleLogLevel reads (GET) or changes (PUT) the core's log levels
+func (s *HTTPServer) handleLogLevel(w http.ResponseWriter, r *http.Request) {
+	var resp *LogLevelConfig
+	switch r.Method {
+	case http.MethodGet:
+		resp = s.mgr.LogLevels()
+	case http.MethodPut:
+		var req LogLevelConfig
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		var err error
+		if resp, err = s.mgr.SetLogLevels(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// ============ Probes ============
+
+// handleHealthz reports that the process is alive
+func (s *HTTPServer) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+}
+
+// handleReadyz reports whether the core can serve plugins, with the status
+// of each component; it answers 503 when any of them fails
+func (s *HTTPServer) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	report := s.mgr.Readiness(r.Context())
+	w.Header().Set("Content-Type", "application/json")
+	if !report.Ready() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(report)
+}
+
+// ============ Helpers ============
+
+// setETag exposes a record revision as a strong ETag
+func setETag(w http.ResponseWriter, revision int64) {
+	w.Header().Set("ETag", strconv.Quote(strconv.FormatInt(revision, 10)))
+}
+
+// parseIfMatch returns the revision required by an If-Match header
+// A missing header or "*" returns 0 (no precondition)
+func parseIfMatch(r *http.Request) (int64, error) {
+	v := strings.TrimSpace(r.Header.Get("If-Match"))
+	if v == "" || v == "*" {
+		return 0, nil
+	}
+
+	v = strings.TrimPrefix(v, "W/")
+	v = strings.Trim(v, `"`)
+	rev, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || rev <= 0 {
+		return 0, fmt.Errorf("invalid If-Match header")
+	}
+	return rev, nil
+}
+
+// parseLimit reads the page size (repository.MaxPageLimit at most)
+func parseLimit(params url.Values) (int, error) {
+	v := params.Get("limit")
+	if v == "" {
+		return 0, nil
+	}
+	limit, err := strconv.Atoi(v)
+	if err != nil || limit < 1 {
+		return 0, fmt.Errorf("invalid limit %q", v)
+	}
+	return limit, nil
+}
+
+// parseBoolParam reads an optional true/false query parameter
+func parseBoolParam(params url.Values, name string) (*bool, error) {
+	v := params.Get(name)
+	if v == "" {
+		return nil, nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s %q", name, v)
+	}
+	return &b, nil
+}
+
+// parseTimeParam reads an optional RFC 3339 timestamp query parameter
+func parseTimeParam(params url.Values, name string) (time.Time, error) {
+	v := params.Get(name)
+	if v == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid %s %q (RFC 3339 expected)", name, v)
+	}
+	return t, nil
+}
+
+// writeRepositoryError maps repository errors to HTTP status codes
+func writeRepositoryError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, repository.ErrInvalidQuery):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, repository.ErrNotFound):
+		http.Error(w, "Not found", http.StatusNotFound)
+	case errors.Is(err, repository.ErrConflict):
+		http.Error(w, "Resource was modified, reload and retry", http.StatusConflict)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
 // ============ Types ============
 
 type DefinitionListResponse struct {
-	Plugins []DefinitionResponse `json:"plugins"`
-	Total   int                 `json:"total"`
+	Plugins    []DefinitionResponse `json:"plugins"`
+	Total      int                  `json:"total"`
+	NextCursor string               `json:"next_cursor,omitempty"`
 }
 
 type DefinitionResponse struct {
@@ -325,11 +1036,14 @@ type DefinitionResponse struct {
 	APIVersion   string   `json:"api_version"`
 	DependsOn    []string `json:"depends_on"`
 	Capabilities []string `json:"capabilities"`
+	Enabled      bool     `json:"enabled"`
+	Revision     int64    `json:"revision"`
 }
 
 type InstanceListResponse struct {
-	Instances []InstanceResponse `json:"instances"`
-	Total     int                `json:"total"`
+	Instances  []InstanceResponse `json:"instances"`
+	Total      int                `json:"total"`
+	NextCursor string             `json:"next_cursor,omitempty"`
 }
 
 type InstanceResponse struct {
@@ -339,8 +1053,53 @@ type InstanceResponse struct {
 	Enabled        bool        `json:"enabled"`
 	StartedAt      interface{} `json:"started_at"`
 	LastHeartbeat  interface{} `json:"last_heartbeat"`
+	Revision       int64       `json:"revision"`
+	InstanceKey    string      `json:"instance_key,omitempty"`
+	RetiredAt      *time.Time  `json:"retired_at,omitempty"`
+	// Health is the latest report sent with heartbeats
+	Health *entities.HealthReport `json:"health,omitempty"`
+	Host   string                 `json:"host,omitempty"`
+	Port   int                    `json:"port,omitempty"`
+	// Probe is the state of the active health probe, if one is registered
+	Probe *ProbeStatus `json:"probe,omitempty"`
+	// QuarantinedUntil is when a quarantined instance is released
+	QuarantinedUntil *time.Time `json:"quarantined_until,omitempty"`
+}
+
+func (s *HTTPServer) instanceResponse(inst *entities.PluginInstance) InstanceResponse {
+	probe, _ := s.mgr.ProbeStatus(inst.ID)
+	return InstanceResponse{
+		ID:               inst.ID,
+		PluginID:         inst.DefinitionID,
+		Status:           inst.Status,
+		Enabled:          inst.Enabled,
+		StartedAt:        inst.StartedAt,
+		LastHeartbeat:    inst.LastHeartbeat,
+		Revision:         inst.Revision,
+		InstanceKey:      inst.InstanceKey,
+		RetiredAt:        inst.RetiredAt,
+		Health:           inst.Health,
+		Host:             inst.Host,
+		Port:             inst.Port,
+		Probe:            probe,
+		QuarantinedUntil: inst.QuarantinedUntil,
+	}
+}
+
+type InstanceHistoryResponse struct {
+	InstanceID  string                         `json:"instance_id"`
+	Transitions []*entities.InstanceTransition `json:"transitions"`
+}
+
+type InstanceLogsResponse struct {
+	InstanceID string     `json:"instance_id"`
+	Records    []LogEntry `json:"records"`
 }
 
 type UpdatePluginRequest struct {
 	Enabled bool `json:"enabled"`
 }
+
+type LogLevelRequest struct {
+	Level string `json:"level"`
+}
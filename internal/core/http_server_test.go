@@ -1,31 +1,29 @@
 package core
 
 import (
+	"context"
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
-	"os"
+	"strings"
 	"testing"
 
+	"github.com/robrt95x/milpa-cloud/internal/domain/repository"
 	"github.com/robrt95x/milpa-cloud/internal/infrastructure/config"
 	"github.com/robrt95x/milpa-cloud/internal/infrastructure/db"
+	"github.com/robrt95x/milpa-cloud/internal/infrastructure/db/dbtest"
 	"github.com/robrt95x/milpa-cloud/pkg/logger"
+	"github.com/robrt95x/milpa-cloud/pkg/types"
 )
 
-func setupTest(t *testing.T) (*config.Config, logger.Logger, *PluginManager, *db.Repository) {
-	tmpFile, _ := os.CreateTemp("", "milpa-*.db")
-	os.Remove(tmpFile.Name())
-
+func setupTest(t *testing.T) (*config.Config, logger.Logger, *PluginManager, repository.Repository) {
 	cfg := &config.Config{
 		Server: config.ServerConfig{
 			Host:     "0.0.0.0",
 			Port:     8081,
 			HTTPPort: 8080,
 		},
-		Database: config.DatabaseConfig{
-			Type: "sqlite",
-			Path: tmpFile.Name(),
-		},
+		Database: dbtest.Database(t),
 	}
 
 	log := logger.New("debug")
@@ -33,6 +31,7 @@ func setupTest(t *testing.T) (*config.Config, logger.Logger, *PluginManager, *db
 	if err != nil {
 		t.Fatalf("Failed to create repo: %v", err)
 	}
+	t.Cleanup(func() { repo.Close() })
 
 	mgr := NewManager(cfg, log, repo)
 
@@ -41,7 +40,6 @@ func setupTest(t *testing.T) (*config.Config, logger.Logger, *PluginManager, *db
 
 func TestListDefinitions(t *testing.T) {
 	cfg, log, mgr, _ := setupTest(t)
-	defer os.Remove(cfg.Database.Path)
 
 	server := NewHTTPServer(cfg, log, mgr)
 
@@ -66,7 +64,6 @@ func TestListDefinitions(t *testing.T) {
 
 func TestListInstances(t *testing.T) {
 	cfg, log, mgr, _ := setupTest(t)
-	defer os.Remove(cfg.Database.Path)
 
 	server := NewHTTPServer(cfg, log, mgr)
 
@@ -91,7 +88,6 @@ func TestListInstances(t *testing.T) {
 
 func TestGetInstanceNotFound(t *testing.T) {
 	cfg, log, mgr, _ := setupTest(t)
-	defer os.Remove(cfg.Database.Path)
 
 	server := NewHTTPServer(cfg, log, mgr)
 
@@ -107,7 +103,6 @@ func TestGetInstanceNotFound(t *testing.T) {
 
 func TestMethodNotAllowed(t *testing.T) {
 	cfg, log, mgr, _ := setupTest(t)
-	defer os.Remove(cfg.Database.Path)
 
 	server := NewHTTPServer(cfg, log, mgr)
 
@@ -120,3 +115,173 @@ func TestMethodNotAllowed(t *testing.T) {
 		t.Errorf("Expected status 405, got %d", w.Code)
 	}
 }
+
+func TestUpdateInstanceIfMatch(t *testing.T) {
+	cfg, log, mgr, _ := setupTest(t)
+	server := NewHTTPServer(cfg, log, mgr)
+
+	resp, _ := mgr.HandshakeHTTP(context.Background(), &types.HandshakeRequest{
+		PluginId:   "example",
+		Version:    "1.0.0",
+		ApiVersion: "1.0",
+	})
+	path := "/api/v1/plugins/instances/" + resp.SessionId
+
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	w := httptest.NewRecorder()
+	server.handleInstanceByID(w, req)
+	etag := w.Header().Get("ETag")
+	if etag != `"1"` {
+		t.Fatalf("Expected ETag \"1\", got %q", etag)
+	}
+
+	req = httptest.NewRequest(http.MethodPut, path, strings.NewReader(`{"enabled": false}`))
+	req.Header.Set("If-Match", etag)
+	w = httptest.NewRecorder()
+	server.handleInstanceByID(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if w.Header().Get("ETag") != `"2"` {
+		t.Errorf("Expected new ETag \"2\", got %q", w.Header().Get("ETag"))
+	}
+
+	// Same If-Match again is now stale
+	req = httptest.NewRequest(http.MethodPut, path, strings.NewReader(`{"enabled": true}`))
+	req.Header.Set("If-Match", etag)
+	w = httptest.NewRecorder()
+	server.handleInstanceByID(w, req)
+	if w.Code != http.StatusConflict {
+		t.Errorf("Expected status 409, got %d", w.Code)
+	}
+}
+
+func TestUpdateDefinitionNotFound(t *testing.T) {
+	cfg, log, mgr, _ := setupTest(t)
+	server := NewHTTPServer(cfg, log, mgr)
+
+	req := httptest.NewRequest(http.MethodPut, "/api/v1/plugins/missing", strings.NewReader(`{"enabled": false}`))
+	w := httptest.NewRecorder()
+	server.handleDefinitionByID(w, req)
+
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404, got %d", w.Code)
+	}
+}
+
+func TestListInstancesPagination(t *testing.T) {
+	cfg, log, mgr, _ := setupTest(t)
+	server := NewHTTPServer(cfg, log, mgr)
+
+	for i := 0; i < 5; i++ {
+		handshake(t, mgr, "example")
+	}
+	other := handshake(t, mgr, "other")
+
+	var ids []string
+	path := "/api/v1/plugins/instances?definition_id=example&limit=2&sort=-started_at"
+	for pages := 0; ; pages++ {
+		if pages > 5 {
+			t.Fatal("Pagination did not terminate")
+		}
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		w := httptest.NewRecorder()
+		server.handleInstances(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+		}
+
+		var resp InstanceListResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("Failed to parse response: %v", err)
+		}
+		if resp.Total != 5 {
+			t.Errorf("Expected total 5, got %d", resp.Total)
+		}
+		for _, inst := range resp.Instances {
+			ids = append(ids, inst.ID.(string))
+		}
+		if resp.NextCursor == "" {
+			break
+		}
+		path = "/api/v1/plugins/instances?definition_id=example&limit=2&sort=-started_at&cursor=" + resp.NextCursor
+	}
+
+	if len(ids) != 5 {
+		t.Errorf("Expected 5 instances across pages, got %v", ids)
+	}
+	for _, id := range ids {
+		if id == other.SessionId {
+			t.Errorf("Expected definition filter to exclude %s", id)
+		}
+	}
+}
+
+func TestListInstancesInvalidQuery(t *testing.T) {
+	cfg, log, mgr, _ := setupTest(t)
+	server := NewHTTPServer(cfg, log, mgr)
+
+	for _, query := range []string{"limit=0", "enabled=maybe", "heartbeat_before=yesterday", "sort=auth_token", "cursor=xyz"} {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/plugins/instances?"+query, nil)
+		w := httptest.NewRecorder()
+		server.handleInstances(w, req)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected status 400, got %d", query, w.Code)
+		}
+	}
+}
+
+func TestListDefinitionsFilter(t *testing.T) {
+	cfg, log, mgr, _ := setupTest(t)
+	server := NewHTTPServer(cfg, log, mgr)
+
+	handshake(t, mgr, "alpha")
+	handshake(t, mgr, "beta")
+	mgr.SetDefinitionEnabled("beta", false, 0)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/plugins?enabled=false", nil)
+	w := httptest.NewRecorder()
+	server.handleDefinitions(w, req)
+
+	var resp DefinitionListResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+	if resp.Total != 1 || len(resp.Plugins) != 1 || resp.Plugins[0].ID != "beta" {
+		t.Errorf("Expected only beta, got %+v", resp)
+	}
+}
+
+func TestGetInstanceHistory(t *testing.T) {
+	cfg, log, mgr, _ := setupTest(t)
+	server := NewHTTPServer(cfg, log, mgr)
+
+	resp := handshake(t, mgr, "example")
+	if _, err := mgr.SetInstanceEnabled(resp.SessionId, false, 0); err != nil {
+		t.Fatalf("SetInstanceEnabled: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/plugins/instances/"+resp.SessionId+"/history", nil)
+	w := httptest.NewRecorder()
+	server.handleInstanceByID(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var history InstanceHistoryResponse
+	json.NewDecoder(w.Body).Decode(&history)
+	if len(history.Transitions) != 2 {
+		t.Fatalf("Expected 2 transitions, got %d", len(history.Transitions))
+	}
+	last := history.Transitions[1]
+	if last.From != "running" || last.To != "stopped" || last.Reason != "disabled by admin" {
+		t.Errorf("Unexpected transition %+v", last)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/v1/plugins/instances/missing/history", nil)
+	w = httptest.NewRecorder()
+	server.handleInstanceByID(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404, got %d", w.Code)
+	}
+}
@@ -0,0 +1,164 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"sync"
+
+	"github.com/robrt95x/milpa-cloud/internal/infrastructure/config"
+)
+
+// Servers named in listener errors
+const (
+	ServerGRPC  = "grpc"
+	ServerHTTP  = "http"
+	ServerAdmin = "admin"
+)
+
+// ListenError is returned by Start when a server cannot bind its address
+type ListenError struct {
+	Server  string
+	Address string
+	Err     error
+}
+
+func (e *ListenError) Error() string {
+	return fmt.Sprintf("%s server: listen on %s: %v", e.Server, e.Address, e.Err)
+}
+
+func (e *ListenError) Unwrap() error {
+	return e.Err
+}
+
+// ServeError is reported on Errors when a server stops serving while the
+// core is still running
+type ServeError struct {
+	Server  string
+	Address string
+	Err     error
+}
+
+func (e *ServeError) Error() string {
+	return fmt.Sprintf("%s server on %s stopped: %v", e.Server, e.Address, e.Err)
+}
+
+func (e *ServeError) Unwrap() error {
+	return e.Err
+}
+
+// listen binds the listener of a server
+// A stale unix socket left by a previous run is removed first; any other
+// file at the socket path is an error.
+func listen(server string, lc config.ListenerConfig) (net.Listener, error) {
+	fail := func(err error) (net.Listener, error) {
+		return nil, &ListenError{Server: server, Address: lc.Address, Err: err}
+	}
+	if err := lc.Validate(); err != nil {
+		return fail(err)
+	}
+
+	network := lc.NetworkOrDefault()
+	if network == config.NetworkUnix {
+		if err := removeStaleSocket(lc.Address); err != nil {
+			return fail(err)
+		}
+	}
+
+	if network == config.NetworkUnix && lc.Mode != "" {
+		// Created owner-only, then opened up to the configured mode
+		lis, err := listenPrivateUnix(lc.Address)
+		if err != nil {
+			return fail(err)
+		}
+		mode, _ := lc.FileMode()
+		if err := os.Chmod(lc.Address, mode); err != nil {
+			lis.Close()
+			return fail(err)
+		}
+		return lis, nil
+	}
+
+	lis, err := net.Listen(network, lc.Address)
+	if err != nil {
+		return fail(err)
+	}
+	return lis, nil
+}
+
+// removeStaleSocket removes the socket at path, if any
+func removeStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.Mode()&fs.ModeSocket == 0 {
+		return fmt.Errorf("%s exists and is not a socket", path)
+	}
+	// A socket someone still accepts on is in use, not stale
+	if conn, err := net.Dial(config.NetworkUnix, path); err == nil {
+		conn.Close()
+		return fmt.Errorf("%s is in use", path)
+	}
+	return os.Remove(path)
+}
+
+// serverErrors carries the runtime failures of a server to its owner
+// Only the first failure is kept; later ones are dropped since the owner
+// shuts down on the first.
+type serverErrors chan error
+
+func newServerErrors() serverErrors {
+	return make(serverErrors, 1)
+}
+
+// report queues err unless a failure is already pending
+func (c serverErrors) report(err error) {
+	select {
+	case c <- err:
+	default:
+	}
+}
+
+// listenerState tracks whether a listener is bound
+type listenerState struct {
+	mu      sync.RWMutex
+	network string
+	addr    string
+	bound   bool
+	err     error
+}
+
+// set records the outcome of binding addr
+func (l *listenerState) set(network, addr string, err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.network = network
+	l.addr = addr
+	l.bound = err == nil
+	l.err = err
+}
+
+// closed records that the listener stopped serving
+func (l *listenerState) closed(err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.bound = false
+	l.err = err
+}
+
+// get returns the listener network and address, whether it is bound and
+// the last error
+func (l *listenerState) get() (string, string, bool, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	return l.network, l.addr, l.bound, l.err
+}
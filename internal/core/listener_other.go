@@ -0,0 +1,15 @@
+//go:build !unix
+
+package core
+
+import (
+	"net"
+
+	"github.com/robrt95x/milpa-cloud/internal/infrastructure/config"
+)
+
+// listenPrivateUnix binds a unix socket; without a umask the mode is only
+// restricted once the configured one is applied
+func listenPrivateUnix(path string) (net.Listener, error) {
+	return net.Listen(config.NetworkUnix, path)
+}
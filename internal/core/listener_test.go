@@ -0,0 +1,226 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/robrt95x/milpa-cloud/internal/infrastructure/config"
+)
+
+// busyPort returns a TCP port held by a listener until the test ends
+func busyPort(t *testing.T) int {
+	t.Helper()
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { lis.Close() })
+	return lis.Addr().(*net.TCPAddr).Port
+}
+
+func TestStartReportsListenError(t *testing.T) {
+	_, _, mgr, _ := setupTest(t)
+	mgr.config.Server.Host = "127.0.0.1"
+	mgr.config.Server.Port = busyPort(t)
+
+	err := mgr.Start(context.Background())
+	var lerr *ListenError
+	if !errors.As(err, &lerr) {
+		t.Fatalf("Expected a *ListenError, got %v", err)
+	}
+	if lerr.Server != ServerGRPC || !strings.HasPrefix(lerr.Address, "127.0.0.1:") {
+		t.Errorf("Unexpected listen error %+v", lerr)
+	}
+	if mgr.eventBus.Running() {
+		t.Error("Expected the event bus not to start when the listener fails")
+	}
+}
+
+func TestServeErrorReported(t *testing.T) {
+	_, _, mgr, _ := setupTest(t)
+	mgr.config.Server.GRPC = config.ListenerConfig{Address: "127.0.0.1:0"}
+	mgr.config.Shutdown.DrainTimeout = "10ms"
+
+	if err := mgr.Start(context.Background()); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer mgr.Stop()
+	if mgr.GRPCAddr() == "" {
+		t.Fatal("Expected the gRPC address once started")
+	}
+
+	// The server dies without the manager stopping
+	mgr.grpcServer.Stop()
+
+	select {
+	case err := <-mgr.Errors():
+		var serr *ServeError
+		if !errors.As(err, &serr) || serr.Server != ServerGRPC {
+			t.Errorf("Expected a gRPC *ServeError, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected the server failure on Errors")
+	}
+}
+
+func TestStopDoesNotReportServeError(t *testing.T) {
+	_, _, mgr, _ := setupTest(t)
+	mgr.config.Server.GRPC = config.ListenerConfig{Address: "127.0.0.1:0"}
+	mgr.config.Shutdown.DrainTimeout = "10ms"
+
+	if err := mgr.Start(context.Background()); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	mgr.Stop()
+
+	select {
+	case err := <-mgr.Errors():
+		t.Errorf("Expected no error after Stop, got %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestHTTPServerStart(t *testing.T) {
+	cfg, log, mgr, _ := setupTest(t)
+	cfg.Server.Host = "127.0.0.1"
+	cfg.Server.HTTPPort = busyPort(t)
+
+	err := NewHTTPServer(cfg, log, mgr).Start()
+	var lerr *ListenError
+	if !errors.As(err, &lerr) || lerr.Server != ServerHTTP {
+		t.Fatalf("Expected an HTTP *ListenError, got %v", err)
+	}
+
+	cfg.Server.HTTPPort = 0
+	server := NewHTTPServer(cfg, log, mgr)
+	if err := server.Start(); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	if !strings.HasPrefix(server.Addr(), "127.0.0.1:") || server.Addr() == "127.0.0.1:0" {
+		t.Errorf("Expected the bound address, got %q", server.Addr())
+	}
+	if err := server.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown failed: %v", err)
+	}
+	select {
+	case err := <-server.Errors():
+		t.Errorf("Expected no error after Shutdown, got %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestUnixSocketListeners(t *testing.T) {
+	cfg, log, mgr, _ := setupTest(t)
+	dir := t.TempDir()
+	cfg.Server.GRPC = config.ListenerConfig{Network: "unix", Address: filepath.Join(dir, "grpc.sock"), Mode: "0600"}
+	cfg.Server.HTTP = config.ListenerConfig{Network: "unix", Address: filepath.Join(dir, "http.sock"), Mode: "0660"}
+	cfg.Shutdown.DrainTimeout = "10ms"
+
+	// A socket left behind by a previous run is replaced
+	stale, err := net.Listen("unix", cfg.Server.GRPC.Address)
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	if err := mgr.Start(context.Background()); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer mgr.Stop()
+	server := NewHTTPServer(cfg, log, mgr)
+	if err := server.Start(); err != nil {
+		t.Fatalf("HTTP Start failed: %v", err)
+	}
+	defer server.Shutdown(context.Background())
+
+	for path, want := range map[string]os.FileMode{
+		cfg.Server.GRPC.Address: 0o600,
+		cfg.Server.HTTP.Address: 0o660,
+	} {
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatalf("Expected socket %s: %v", path, err)
+		}
+		if info.Mode().Perm() != want {
+			t.Errorf("Expected %s to have mode %v, got %v", path, want, info.Mode().Perm())
+		}
+	}
+	if mgr.GRPCAddr() != cfg.Server.GRPC.Address {
+		t.Errorf("Expected gRPC on %s, got %s", cfg.Server.GRPC.Address, mgr.GRPCAddr())
+	}
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", cfg.Server.HTTP.Address)
+		},
+	}}
+	resp, err := client.Get("http://unix/healthz")
+	if err != nil {
+		t.Fatalf("Request over the unix socket failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected 200 from /healthz, got %d", resp.StatusCode)
+	}
+
+	// A second core cannot take over a socket in use
+	_, _, other, _ := setupTest(t)
+	other.config.Server.GRPC = cfg.Server.GRPC
+	var lerr *ListenError
+	if err := other.Start(context.Background()); !errors.As(err, &lerr) {
+		t.Errorf("Expected a *ListenError for a socket in use, got %v", err)
+	}
+}
+
+func TestSeparateAdminListener(t *testing.T) {
+	cfg, log, mgr, _ := setupTest(t)
+	cfg.Server.HTTP = config.ListenerConfig{Address: "127.0.0.1:0"}
+	cfg.Server.Admin = config.ListenerConfig{Address: "127.0.0.1:0"}
+
+	server := NewHTTPServer(cfg, log, mgr)
+	if err := server.Start(); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer server.Shutdown(context.Background())
+	if server.AdminAddr() == "" || server.AdminAddr() == server.Addr() {
+		t.Fatalf("Expected a separate admin address, got %q and %q", server.Addr(), server.AdminAddr())
+	}
+
+	status := func(addr, path string) int {
+		t.Helper()
+		resp, err := http.Get("http://" + addr + path)
+		if err != nil {
+			t.Fatalf("GET %s failed: %v", path, err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	tests := []struct {
+		addr string
+		path string
+		want int
+	}{
+		{server.Addr(), "/api/v1/plugins", http.StatusNotFound},
+		{server.Addr(), "/metrics", http.StatusNotFound},
+		{server.Addr(), "/api/v1/handshake", http.StatusMethodNotAllowed},
+		{server.Addr(), "/healthz", http.StatusOK},
+		{server.AdminAddr(), "/api/v1/plugins", http.StatusOK},
+		{server.AdminAddr(), "/metrics", http.StatusOK},
+		{server.AdminAddr(), "/api/v1/handshake", http.StatusNotFound},
+		{server.AdminAddr(), "/healthz", http.StatusOK},
+	}
+	for _, tt := range tests {
+		if got := status(tt.addr, tt.path); got != tt.want {
+			t.Errorf("GET %s on %s: expected %d, got %d", tt.path, tt.addr, tt.want, got)
+		}
+	}
+}
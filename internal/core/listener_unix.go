@@ -0,0 +1,25 @@
+//go:build unix
+
+package core
+
+import (
+	"net"
+	"sync"
+	"syscall"
+
+	"github.com/robrt95x/milpa-cloud/internal/infrastructure/config"
+)
+
+// umaskMu serializes umask changes, which affect the whole process
+var umaskMu sync.Mutex
+
+// listenPrivateUnix binds a unix socket only its owner can connect to, so
+// it is not reachable with a wider mode before the configured one is applied
+func listenPrivateUnix(path string) (net.Listener, error) {
+	umaskMu.Lock()
+	defer umaskMu.Unlock()
+
+	old := syscall.Umask(0o177)
+	defer syscall.Umask(old)
+	return net.Listen(config.NetworkUnix, path)
+}
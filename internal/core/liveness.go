@@ -0,0 +1,242 @@
+package core
+
+import (
+	"sync"
+	"time"
+
+	"github.com/robrt95x/milpa-cloud/internal/domain/entities"
+)
+
+// livenessTable is the authoritative record of plugin heartbeats
+// Heartbeats only touch memory; dirty timestamps are flushed to the
+// repository in batches by the manager (see flushHeartbeats)
+type livenessTable struct {
+	mu      sync.RWMutex
+	entries map[string]*livenessEntry
+}
+
+// livenessEntry mirrors the instance fields needed to process a heartbeat
+type livenessEntry struct {
+	PluginID      string
+	AuthToken     string
+	Status        string
+	Enabled       bool
+	LastHeartbeat time.Time
+	Health        *entities.HealthReport
+	dirty         bool // LastHeartbeat not yet written to the repository
+	healthDirty   bool // Health not yet written to the repository
+}
+
+func newLivenessTable() *livenessTable {
+	return &livenessTable{
+		entries: make(map[string]*livenessEntry),
+	}
+}
+
+// sync records the stored state of an instance
+// A newer in-memory heartbeat is kept; an equal or older one is marked clean
+func (t *livenessTable) sync(inst *entities.PluginInstance) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	e, ok := t.entries[inst.ID]
+	if !ok {
+		e = &livenessEntry{}
+		t.entries[inst.ID] = e
+	}
+	e.PluginID = inst.DefinitionID
+	e.AuthToken = inst.AuthToken
+	e.Status = inst.Status
+	e.Enabled = inst.Enabled
+
+	if inst.LastHeartbeat != nil && !e.LastHeartbeat.After(*inst.LastHeartbeat) {
+		e.LastHeartbeat = *inst.LastHeartbeat
+		e.dirty = false
+	}
+	if inst.Health != nil && (e.Health == nil || !e.Health.ReportedAt.After(inst.Health.ReportedAt)) {
+		e.Health = inst.Health
+		e.healthDirty = false
+	}
+}
+
+// get returns a copy of the entry for an instance
+func (t *livenessTable) get(id string) (livenessEntry, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	e, ok := t.entries[id]
+	if !ok {
+		return livenessEntry{}, false
+	}
+	return *e, true
+}
+
+// beat records a heartbeat and returns the updated entry
+func (t *livenessTable) beat(id string, at time.Time) (livenessEntry, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	e, ok := t.entries[id]
+	if !ok {
+		return livenessEntry{}, false
+	}
+	if at.After(e.LastHeartbeat) {
+		e.LastHeartbeat = at
+		e.dirty = true
+	}
+	return *e, true
+}
+
+// report records the latest health report of an instance
+// Reports are only flushed when their content changes, so a steady plugin
+// costs no extra writes
+func (t *livenessTable) report(id string, h *entities.HealthReport) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if e, ok := t.entries[id]; ok {
+		if e.Health == nil || !sameHealth(e.Health, h) {
+			e.healthDirty = true
+		}
+		e.Health = h
+	}
+}
+
+// lastHeartbeat returns the latest known heartbeat for an instance
+func (t *livenessTable) lastHeartbeat(id string) (time.Time, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	e, ok := t.entries[id]
+	if !ok || e.LastHeartbeat.IsZero() {
+		return time.Time{}, false
+	}
+	return e.LastHeartbeat, true
+}
+
+// overlay replaces a stored heartbeat with a newer in-memory one
+func (t *livenessTable) overlay(inst *entities.PluginInstance) {
+	hb, ok := t.lastHeartbeat(inst.ID)
+	if !ok {
+		return
+	}
+	if inst.LastHeartbeat == nil || hb.After(*inst.LastHeartbeat) {
+		inst.LastHeartbeat = &hb
+	}
+
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if e, ok := t.entries[inst.ID]; ok && e.Health != nil &&
+		(inst.Health == nil || e.Health.ReportedAt.After(inst.Health.ReportedAt)) {
+		inst.Health = e.Health
+	}
+}
+
+// expired returns live instances whose last heartbeat is before cutoff
+func (t *livenessTable) expired(cutoff time.Time) []string {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	var ids []string
+	for id, e := range t.entries {
+		if entities.IsLiveStatus(e.Status) && e.LastHeartbeat.Before(cutoff) {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// drainDirty returns unflushed heartbeats and marks them clean
+func (t *livenessTable) drainDirty() map[string]time.Time {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	beats := make(map[string]time.Time)
+	for id, e := range t.entries {
+		if e.dirty {
+			beats[id] = e.LastHeartbeat
+			e.dirty = false
+		}
+	}
+	return beats
+}
+
+// drainHealth returns unflushed health reports and marks them clean
+func (t *livenessTable) drainHealth() map[string]*entities.HealthReport {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	reports := make(map[string]*entities.HealthReport)
+	for id, e := range t.entries {
+		if e.healthDirty {
+			reports[id] = e.Health
+			e.healthDirty = false
+		}
+	}
+	return reports
+}
+
+// requeueHealth marks health reports dirty again after a failed flush
+func (t *livenessTable) requeueHealth(reports map[string]*entities.HealthReport) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for id, h := range reports {
+		if e, ok := t.entries[id]; ok && e.Health == h {
+			e.healthDirty = true
+		}
+	}
+}
+
+// requeue marks heartbeats dirty again after a failed flush
+func (t *livenessTable) requeue(beats map[string]time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for id, at := range beats {
+		if e, ok := t.entries[id]; ok && !e.LastHeartbeat.After(at) {
+			e.dirty = true
+		}
+	}
+}
+
+// forget removes an instance from the table
+func (t *livenessTable) forget(id string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.entries, id)
+}
+
+// len returns the number of tracked instances
+func (t *livenessTable) len() int {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	return len(t.entries)
+}
+
+// hasPlugin reports whether any tracked instance belongs to the plugin
+func (t *livenessTable) hasPlugin(pluginID string) bool {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	for _, e := range t.entries {
+		if e.PluginID == pluginID {
+			return true
+		}
+	}
+	return false
+}
+
+// statusCounts returns the number of known instances per status
+func (t *livenessTable) statusCounts() map[string]int {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	counts := make(map[string]int)
+	for _, e := range t.entries {
+		counts[e.Status]++
+	}
+	return counts
+}
@@ -0,0 +1,216 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/robrt95x/milpa-cloud/internal/domain/entities"
+	"github.com/robrt95x/milpa-cloud/internal/infrastructure/config"
+	"github.com/robrt95x/milpa-cloud/internal/infrastructure/db"
+	"github.com/robrt95x/milpa-cloud/internal/infrastructure/db/dbtest"
+	"github.com/robrt95x/milpa-cloud/pkg/logger"
+	"github.com/robrt95x/milpa-cloud/pkg/types"
+)
+
+func handshake(t testing.TB, mgr *PluginManager, pluginID string) *types.HandshakeResponse {
+	t.Helper()
+
+	resp, err := mgr.HandshakeHTTP(context.Background(), &types.HandshakeRequest{
+		PluginId:   pluginID,
+		Version:    "1.0.0",
+		ApiVersion: "1.0",
+	})
+	if err != nil || !resp.Accepted {
+		t.Fatalf("Handshake failed: %v %+v", err, resp)
+	}
+	return resp
+}
+
+func TestHeartbeatIsWrittenBehind(t *testing.T) {
+	_, _, mgr, repo := setupTest(t)
+	resp := handshake(t, mgr, "example")
+
+	before, _ := repo.GetInstance(resp.SessionId)
+
+	time.Sleep(5 * time.Millisecond)
+	hb, _ := mgr.HeartbeatHTTP(context.Background(), &types.HeartbeatRequest{
+		SessionId: resp.SessionId,
+		AuthToken: resp.AuthToken,
+	})
+	if !hb.Ok {
+		t.Fatalf("Heartbeat rejected: %s", hb.Message)
+	}
+
+	// Nothing written yet
+	stored, _ := repo.GetInstance(resp.SessionId)
+	if !stored.LastHeartbeat.Equal(*before.LastHeartbeat) || stored.Revision != before.Revision {
+		t.Errorf("Expected heartbeat not to be written before flush")
+	}
+
+	// The API already sees the in-memory heartbeat
+	inst, _ := mgr.GetInstance(resp.SessionId)
+	if !inst.LastHeartbeat.After(*before.LastHeartbeat) {
+		t.Errorf("Expected API to return in-memory heartbeat")
+	}
+
+	mgr.flushHeartbeats()
+
+	stored, _ = repo.GetInstance(resp.SessionId)
+	if !stored.LastHeartbeat.After(*before.LastHeartbeat) {
+		t.Errorf("Expected flush to write heartbeat, got %v", stored.LastHeartbeat)
+	}
+	if stored.Revision != before.Revision {
+		t.Errorf("Expected flush not to bump revision, got %d", stored.Revision)
+	}
+}
+
+func TestHeartbeatUnknownSessionAndBadToken(t *testing.T) {
+	_, _, mgr, _ := setupTest(t)
+	resp := handshake(t, mgr, "example")
+
+	hb, _ := mgr.HeartbeatHTTP(context.Background(), &types.HeartbeatRequest{SessionId: "inst-missing"})
+	if hb.Ok || hb.Message != "session not found" {
+		t.Errorf("Expected session not found, got %+v", hb)
+	}
+
+	hb, _ = mgr.HeartbeatHTTP(context.Background(), &types.HeartbeatRequest{SessionId: resp.SessionId, AuthToken: "wrong"})
+	if hb.Ok || hb.Message != "invalid auth token" {
+		t.Errorf("Expected invalid auth token, got %+v", hb)
+	}
+}
+
+func TestCheckHeartbeatsUsesLiveness(t *testing.T) {
+	cfg, _, mgr, repo := setupTest(t)
+	cfg.Security.HeartbeatTimeout = "50ms"
+
+	stale := handshake(t, mgr, "stale")
+	fresh := handshake(t, mgr, "fresh")
+
+	time.Sleep(80 * time.Millisecond)
+	mgr.HeartbeatHTTP(context.Background(), &types.HeartbeatRequest{
+		SessionId: fresh.SessionId,
+		AuthToken: fresh.AuthToken,
+	})
+
+	mgr.checkHeartbeats()
+
+	got, _ := repo.GetInstance(stale.SessionId)
+	if got.Status != entities.PluginStatusUnhealthy {
+		t.Errorf("Expected stale instance unhealthy, got %s", got.Status)
+	}
+	got, _ = repo.GetInstance(fresh.SessionId)
+	if got.Status != entities.PluginStatusRunning {
+		t.Errorf("Expected fresh instance running (heartbeat not yet flushed), got %s", got.Status)
+	}
+
+	// A heartbeat brings the unhealthy instance back immediately
+	mgr.HeartbeatHTTP(context.Background(), &types.HeartbeatRequest{
+		SessionId: stale.SessionId,
+		AuthToken: stale.AuthToken,
+	})
+	got, _ = repo.GetInstance(stale.SessionId)
+	if got.Status != entities.PluginStatusRunning {
+		t.Errorf("Expected recovered instance running, got %s", got.Status)
+	}
+}
+
+func TestLoadInstancesSeedsLiveness(t *testing.T) {
+	cfg, log, mgr, repo := setupTest(t)
+	resp := handshake(t, mgr, "example")
+
+	// A new manager on the same repository, as after a core restart
+	restarted := NewManager(cfg, log, repo)
+	if err := restarted.loadInstances(); err != nil {
+		t.Fatalf("loadInstances: %v", err)
+	}
+	if _, ok := restarted.liveness.get(resp.SessionId); !ok {
+		t.Error("Expected instance to be tracked after restart")
+	}
+}
+
+func TestLivenessTableRequeue(t *testing.T) {
+	lt := newLivenessTable()
+	lt.sync(&entities.PluginInstance{ID: "a", Status: entities.PluginStatusRunning, Enabled: true})
+
+	now := time.Now()
+	lt.beat("a", now)
+
+	beats := lt.drainDirty()
+	if len(beats) != 1 {
+		t.Fatalf("Expected 1 dirty heartbeat, got %d", len(beats))
+	}
+	if len(lt.drainDirty()) != 0 {
+		t.Error("Expected drain to clear dirty flags")
+	}
+
+	lt.requeue(beats)
+	if len(lt.drainDirty()) != 1 {
+		t.Error("Expected requeue to mark heartbeat dirty again")
+	}
+}
+
+// BenchmarkHeartbeat measures heartbeat throughput against a SQLite-backed
+// manager with thousands of connected instances, plus the periodic flush and
+// liveness check that replace per-heartbeat database writes.
+//
+//	go test -run '^$' -bench Heartbeat ./internal/core/
+func BenchmarkHeartbeat(b *testing.B) {
+	for _, n := range []int{1000, 5000} {
+		mgr, sessions := benchmarkManager(b, n)
+
+		b.Run(fmt.Sprintf("instances=%d/heartbeat", n), func(b *testing.B) {
+			var next atomic.Int64
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					s := sessions[int(next.Add(1))%len(sessions)]
+					mgr.HeartbeatHTTP(context.Background(), &s)
+				}
+			})
+			b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "heartbeats/s")
+		})
+
+		b.Run(fmt.Sprintf("instances=%d/flush", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				for _, s := range sessions {
+					mgr.liveness.beat(s.SessionId, time.Now())
+				}
+				b.StartTimer()
+				mgr.flushHeartbeats()
+			}
+		})
+
+		b.Run(fmt.Sprintf("instances=%d/check", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				mgr.checkHeartbeats()
+			}
+		})
+	}
+}
+
+func benchmarkManager(b *testing.B, n int) (*PluginManager, []types.HeartbeatRequest) {
+	b.Helper()
+
+	cfg := &config.Config{Database: dbtest.Database(b)}
+	cfg.Security.HeartbeatTimeout = "1h"
+	repo, err := db.NewRepository(cfg)
+	if err != nil {
+		b.Fatalf("Failed to create repo: %v", err)
+	}
+	b.Cleanup(func() { repo.Close() })
+
+	mgr := NewManager(cfg, logger.New("error"), repo)
+	sessions := make([]types.HeartbeatRequest, 0, n)
+	for i := 0; i < n; i++ {
+		resp := handshake(b, mgr, fmt.Sprintf("plugin-%d", i%50))
+		sessions = append(sessions, types.HeartbeatRequest{
+			SessionId: resp.SessionId,
+			AuthToken: resp.AuthToken,
+		})
+	}
+	return mgr, sessions
+}
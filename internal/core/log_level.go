@@ -0,0 +1,123 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/robrt95x/milpa-cloud/internal/domain/repository"
+	"github.com/robrt95x/milpa-cloud/pkg/logger"
+	"github.com/robrt95x/milpa-cloud/pkg/types"
+)
+
+// Core subsystems whose log level can be overridden at runtime
+const (
+	LogSubsystemManager  = "manager"
+	LogSubsystemEventBus = "eventbus"
+	LogSubsystemHTTP     = "http"
+	LogSubsystemDB       = "db"
+)
+
+// LogSubsystems lists the subsystems accepted by the log-level endpoint
+var LogSubsystems = []string{LogSubsystemManager, LogSubsystemEventBus, LogSubsystemHTTP, LogSubsystemDB}
+
+// LogLevelConfig is the core's root log level and its subsystem overrides
+// In updates an empty level removes a subsystem override
+type LogLevelConfig struct {
+	Level      string            `json:"level,omitempty"`
+	Subsystems map[string]string `json:"subsystems,omitempty"`
+}
+
+// PluginLogLevelResponse lists the instances a log_level event was sent to
+type PluginLogLevelResponse struct {
+	PluginID    string   `json:"plugin_id"`
+	Level       string   `json:"level"`
+	Sent        []string `json:"sent"`
+	Unreachable []string `json:"unreachable"`
+}
+
+// LogLevels returns the core's current log levels
+func (m *PluginManager) LogLevels() *LogLevelConfig {
+	levels := m.log.Levels()
+	return &LogLevelConfig{
+		Level:      levels.Level(),
+		Subsystems: levels.Overrides(),
+	}
+}
+
+// SetLogLevels changes the core's root level and subsystem overrides
+// Everything is validated before any level changes
+func (m *PluginManager) SetLogLevels(update *LogLevelConfig) (*LogLevelConfig, error) {
+	if update.Level != "" {
+		if err := logger.ValidateLevel(update.Level); err != nil {
+			return nil, err
+		}
+	}
+	for name, level := range update.Subsystems {
+		if !isLogSubsystem(name) {
+			return nil, fmt.Errorf("unknown subsystem %q (valid: %v)", name, LogSubsystems)
+		}
+		if level != "" {
+			if err := logger.ValidateLevel(level); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	levels := m.log.Levels()
+	if update.Level != "" {
+		levels.SetLevel(update.Level)
+	}
+	for name, level := range update.Subsystems {
+		levels.SetOverride(name, level)
+	}
+
+	current := m.LogLevels()
+	m.log.Info("log level changed", "level", current.Level, "overrides", current.Subsystems)
+	return current, nil
+}
+
+func isLogSubsystem(name string) bool {
+	for _, s := range LogSubsystems {
+		if s == name {
+			return true
+		}
+	}
+	return false
+}
+
+// SetPluginLogLevel sends a log_level event to every connected instance of
+// a plugin
+func (m *PluginManager) SetPluginLogLevel(ctx context.Context, pluginID, level string) (*PluginLogLevelResponse, error) {
+	if err := logger.ValidateLevel(level); err != nil {
+		return nil, err
+	}
+	if _, ok := m.GetDefinition(pluginID); !ok {
+		return nil, repository.ErrNotFound
+	}
+
+	data, _ := json.Marshal(&types.LogLevelEvent{Level: level})
+	resp := &PluginLogLevelResponse{
+		PluginID:    pluginID,
+		Level:       level,
+		Sent:        []string{},
+		Unreachable: []string{},
+	}
+	for id, p := range m.conns.snapshot() {
+		if p != pluginID {
+			continue
+		}
+		if err := m.sendEvent(ctx, id, EventTypeLogLevel, string(data)); err != nil {
+			resp.Unreachable = append(resp.Unreachable, id)
+			continue
+		}
+		resp.Sent = append(resp.Sent, id)
+	}
+	sort.Strings(resp.Sent)
+	sort.Strings(resp.Unreachable)
+
+	m.log.Info("plugin log level changed", "plugin_id", pluginID, "level", level,
+		"sent", len(resp.Sent), "unreachable", len(resp.Unreachable))
+	return resp, nil
+}
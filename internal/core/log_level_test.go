@@ -0,0 +1,95 @@
+package core
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/robrt95x/milpa-cloud/pkg/types"
+)
+
+func TestLogLevelHTTP(t *testing.T) {
+	cfg, log, mgr, _ := setupTest(t)
+	server := NewHTTPServer(cfg, log, mgr)
+	eventBus := log.Subsystem(LogSubsystemEventBus)
+
+	put := func(body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		server.routes().ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/api/v1/log-level", strings.NewReader(body)))
+		return w
+	}
+
+	w := put(`{"level": "warn", "subsystems": {"eventbus": "debug"}}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var out LogLevelConfig
+	json.NewDecoder(w.Body).Decode(&out)
+	if out.Level != "warn" || out.Subsystems["eventbus"] != "debug" {
+		t.Errorf("Unexpected levels %+v", out)
+	}
+	if !eventBus.IsDebug() || log.IsDebug() {
+		t.Error("Expected only the eventbus subsystem to log at debug")
+	}
+
+	// Invalid updates change nothing
+	for _, body := range []string{
+		`{"level": "loud"}`,
+		`{"subsystems": {"grpc": "debug"}}`,
+		`{"level": "info", "subsystems": {"db": "loud"}}`,
+	} {
+		if w := put(body); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", body, w.Code)
+		}
+	}
+
+	put(`{"subsystems": {"eventbus": ""}}`)
+	w = httptest.NewRecorder()
+	server.routes().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/log-level", nil))
+	out = LogLevelConfig{}
+	json.NewDecoder(w.Body).Decode(&out)
+	if out.Level != "warn" || len(out.Subsystems) != 0 {
+		t.Errorf("Expected the override to be removed, got %+v", out)
+	}
+}
+
+func TestPluginLogLevelHTTP(t *testing.T) {
+	cfg, log, mgr, _ := setupTest(t)
+	server := NewHTTPServer(cfg, log, mgr)
+	a := handshake(t, mgr, "example")
+	b := handshake(t, mgr, "example")
+	handshake(t, mgr, "other")
+	events := mgr.SubscribePlugin(a.SessionId)
+
+	put := func(path, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		server.routes().ServeHTTP(w, httptest.NewRequest(http.MethodPut, path, strings.NewReader(body)))
+		return w
+	}
+
+	w := put("/api/v1/plugins/example/log-level", `{"level": "debug"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var out PluginLogLevelResponse
+	json.NewDecoder(w.Body).Decode(&out)
+	if len(out.Sent) != 1 || out.Sent[0] != a.SessionId || len(out.Unreachable) != 1 || out.Unreachable[0] != b.SessionId {
+		t.Errorf("Expected the event to reach the subscribed instance only, got %+v", out)
+	}
+
+	ev := <-events
+	var data types.LogLevelEvent
+	json.Unmarshal([]byte(ev.Data), &data)
+	if ev.Type != EventTypeLogLevel || data.Level != "debug" {
+		t.Errorf("Unexpected event %+v", ev)
+	}
+
+	if w := put("/api/v1/plugins/example/log-level", `{"level": "loud"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an invalid level, got %d", w.Code)
+	}
+	if w := put("/api/v1/plugins/missing/log-level", `{"level": "info"}`); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unknown plugin, got %d", w.Code)
+	}
+}
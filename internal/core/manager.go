@@ -3,7 +3,10 @@ package core
 import (
 	"context"
 	"crypto/rand"
+	"crypto/subtle"
 	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net"
 	"strings"
@@ -11,93 +14,205 @@ import (
 	"time"
 
 	"github.com/robrt95x/milpa-cloud/internal/domain/entities"
+	"github.com/robrt95x/milpa-cloud/internal/domain/repository"
 	"github.com/robrt95x/milpa-cloud/internal/infrastructure/config"
-	"github.com/robrt95x/milpa-cloud/internal/infrastructure/db"
+	"github.com/robrt95x/milpa-cloud/internal/infrastructure/metrics"
 	"github.com/robrt95x/milpa-cloud/pkg/logger"
+	"github.com/robrt95x/milpa-cloud/pkg/rpc"
+	"github.com/robrt95x/milpa-cloud/pkg/tracing"
 	"github.com/robrt95x/milpa-cloud/pkg/types"
 
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
 )
 
 // PluginManager handles plugin connections and lifecycle
-// TODO: Add graceful shutdown with timeout
-// TODO: Add metrics/observability (Prometheus, tracing)
 type PluginManager struct {
 	config    *config.Config
 	log       logger.Logger
-	repo      *db.Repository
+	repo      repository.Repository
 	eventBus  *EventBus
-
-	grpcServer *grpc.Server
-	mu         sync.RWMutex
-	stopped    chan struct{}
+	liveness  *livenessTable
+	probes    *probeTable
+	flaps     *flapTable
+	conns     *connectionSet
+	drain     *drainTracker
+	queries   *statusQueries
+	logs      *logStore
+
+	registry      *metrics.Registry
+	metrics       *managerMetrics
+	pluginMetrics *pluginMetricStore
+	tracer        *tracing.Tracer
+
+	grpcServer   *grpc.Server
+	grpcListener listenerState
+	errs         serverErrors
+	mu           sync.RWMutex
+	stopped      chan struct{}
+	stopOnce     sync.Once
 }
 
 // NewManager creates a new PluginManager
-// TODO: Accept interface instead of concrete type for testing
-func NewManager(cfg *config.Config, log logger.Logger, repo *db.Repository) *PluginManager {
-	return &PluginManager{
-		config:    cfg,
-		log:       log,
-		repo:      repo,
-		eventBus:  NewEventBus(log),
-		stopped:   make(chan struct{}),
+func NewManager(cfg *config.Config, log logger.Logger, repo repository.Repository) *PluginManager {
+	m := &PluginManager{
+		config:        cfg,
+		log:           log,
+		repo:          repo,
+		eventBus:      NewEventBus(log.Subsystem(LogSubsystemEventBus)),
+		liveness:      newLivenessTable(),
+		probes:        newProbeTable(),
+		flaps:         newFlapTable(),
+		conns:         newConnectionSet(),
+		drain:         newDrainTracker(),
+		queries:       newStatusQueries(),
+		logs:          newLogStore(cfg.PluginLogs.PerInstance),
+		registry:      metrics.NewRegistry(),
+		pluginMetrics: newPluginMetricStore(),
+		tracer:        tracing.NewTracer("milpa-core", nil),
+		stopped:       make(chan struct{}),
+		errs:          newServerErrors(),
 	}
+	m.instrument(m.registry)
+	return m
+}
+
+// SetTracer replaces the tracer recording the manager's spans
+// It must be called before Start.
+func (m *PluginManager) SetTracer(t *tracing.Tracer) {
+	m.tracer = t
+}
+
+// Tracer returns the tracer recording the manager's spans
+func (m *PluginManager) Tracer() *tracing.Tracer {
+	return m.tracer
 }
 
 // Start begins the plugin manager services
-// TODO: Return detailed error types
+// The gRPC listener is bound before Start returns; a *ListenError reports
+// that it could not be. Failures after that are sent on Errors.
 func (m *PluginManager) Start(ctx context.Context) error {
 	m.log.Info("starting plugin manager")
 	
+	// Seed the liveness table so instances from a previous run expire normally
+	if err := m.loadInstances(); err != nil {
+		return fmt.Errorf("failed to load instances: %w", err)
+	}
+	
+	lis, err := m.listenGRPC()
+	if err != nil {
+		return err
+	}
+	
 	// Start event bus
 	m.eventBus.Start()
 	
-	// TODO: Load existing definitions and instances from database
-	
-	go m.startGRPCServer()
+	go m.serveGRPC(lis)
 	go m.heartbeatMonitor()
+	go m.heartbeatFlusher()
+	go m.instanceJanitor()
+	go m.healthProber()
 	
 	m.log.Info("plugin manager started")
 	return nil
 }
 
-// Stop gracefully shuts down the plugin manager
-// TODO: Implement graceful shutdown (wait for active connections)
+// Stop gracefully shuts down the plugin manager in phases:
+//  1. new handshakes are refused
+//  2. shutdown is broadcast to plugins (and returned on heartbeats) and
+//     connected instances become stopping
+//  3. connected plugins get shutdown.drain_timeout to acknowledge or disconnect
+//  4. instances still stopping become stopped
+//  5. background work stops, state is flushed and the gRPC server is closed
+//
+// The HTTP server is owned by the caller and should be shut down afterwards,
+// since HTTP plugins acknowledge through it.
 func (m *PluginManager) Stop() error {
+	m.stopOnce.Do(m.stop)
+	return nil
+}
+
+func (m *PluginManager) stop() {
 	m.log.Info("stopping plugin manager")
-	
-	// Send shutdown event to all plugins
+
+	ids := m.conns.list()
+	drained := m.drain.start(ids)
 	m.eventBus.SendBroadcast(&PluginEvent{
 		Type: EventTypeShutdown,
 		Data: "system shutting down",
 	})
-	
-	// Stop event bus
+	for _, id := range ids {
+		if err := m.markStopping(id, "core shutting down"); err != nil {
+			m.log.Error("failed to mark instance stopping", "instance_id", id, "error", err)
+		}
+	}
+
+	timeout := parseDuration(m.config.Shutdown.DrainTimeout, 10*time.Second)
+	select {
+	case <-drained:
+		m.log.Info("all plugins acknowledged shutdown")
+	case <-time.After(timeout):
+		m.log.Warn("shutdown drain timeout elapsed", "timeout", timeout, "pending", m.drain.remaining())
+	}
+	for _, id := range ids {
+		if err := m.markStopped(id, "core shut down"); err != nil {
+			m.log.Error("failed to mark instance stopped", "instance_id", id, "error", err)
+		}
+	}
+
+	close(m.stopped)
+
+	// Write pending heartbeats and health reports before exiting
+	m.flushHeartbeats()
+
+	// Closing the subscriptions ends the event streams, so GracefulStop returns
 	m.eventBus.Stop()
-	
 	if m.grpcServer != nil {
 		m.grpcServer.GracefulStop()
 	}
-	
-	// TODO: Save state to database before stopping
-	
-	close(m.stopped)
-	return nil
+	m.log.Info("plugin manager stopped")
+}
+
+// acknowledgeShutdown records that a plugin received the shutdown event
+func (m *PluginManager) acknowledgeShutdown(id string) {
+	if !m.drain.draining() {
+		return
+	}
+	m.log.Debug("shutdown acknowledged", "instance_id", id)
+	m.drain.resolve(id)
 }
 
 // Handshake processes a plugin connection request
 // TODO: Add rate limiting
 // TODO: Add more detailed validation
-// TODO: Add metrics for handshake attempts
 func (m *PluginManager) Handshake(ctx context.Context, req *HandshakeRequest) (*HandshakeResponse, error) {
-	m.log.Info("handshake request", "plugin_id", req.PluginId, "version", req.Version)
+	return m.handshake(ctx, req, "grpc")
+}
+
+// handshake validates a connection request and creates or resumes an instance
+// Rejections return a gRPC status error alongside the response
+func (m *PluginManager) handshake(ctx context.Context, req *HandshakeRequest, transport string) (*HandshakeResponse, error) {
+	log := m.log.WithContext(ctx).With(logger.FieldPluginID, req.PluginId, "transport", transport)
+	log.Info("handshake request", "version", req.Version, "instance_key", req.InstanceKey)
+
+	outcome := HandshakeError
+	defer func() {
+		m.observeHandshake(transport, outcome)
+		tracing.SpanFromContext(ctx).SetAttributes("milpa.plugin_id", req.PluginId, "milpa.handshake.outcome", outcome)
+	}()
+
+	if m.drain.draining() {
+		outcome = HandshakeShuttingDown
+		return &HandshakeResponse{Accepted: false, Error: "core shutting down"},
+			status.Error(codes.Unavailable, "core shutting down")
+	}
 
 	// Security: validate token
 	if m.config.Security.Enabled {
 		if req.Token != m.config.Security.PluginToken {
+			outcome = HandshakeInvalidToken
 			return &HandshakeResponse{Accepted: false, Error: "invalid token"},
 				status.Error(codes.Unauthenticated, "invalid token")
 		}
@@ -111,6 +226,7 @@ func (m *PluginManager) Handshake(ctx context.Context, req *HandshakeRequest) (*
 			}
 		}
 		if !allowed {
+			outcome = HandshakeNotAllowed
 			return &HandshakeResponse{Accepted: false, Error: "plugin not allowed"},
 				status.Error(codes.PermissionDenied, "plugin not allowed")
 		}
@@ -119,14 +235,17 @@ func (m *PluginManager) Handshake(ctx context.Context, req *HandshakeRequest) (*
 	// Check API version compatibility
 	// TODO: Make API version configurable
 	if !isAPIVersionCompatible(req.ApiVersion, "1.0") {
+		outcome = HandshakeIncompatible
 		return &HandshakeResponse{Accepted: false, Error: "incompatible API version"},
 			status.Error(codes.FailedPrecondition, "incompatible API version")
 	}
 
-	// Generate session
-	sessionID := generateUUID()
-	authToken := generateToken()
-	now := time.Now()
+	host, port, probe, err := probeEndpoint(ctx, req.HealthEndpoint, m.config.Probes.AllowedHosts)
+	if err != nil {
+		outcome = HandshakeInvalidRequest
+		return &HandshakeResponse{Accepted: false, Error: err.Error()},
+			status.Error(codes.InvalidArgument, err.Error())
+	}
 
 	// Create or update definition in database
 	def := &entities.PluginDefinition{
@@ -140,109 +259,374 @@ func (m *PluginManager) Handshake(ctx context.Context, req *HandshakeRequest) (*
 
 	// TODO: Handle database errors properly
 	if err := m.repo.UpsertDefinition(def); err != nil {
-		m.log.Error("failed to upsert definition", "error", err)
+		log.Error("failed to upsert definition", "error", err)
 		// Continue anyway - instance can still be created
 	}
 
-	// Create instance
-	instance := &entities.PluginInstance{
-		ID:            sessionID,
-		DefinitionID: req.PluginId,
-		Status:        entities.PluginStatusRunning,
-		Enabled:       true,
-		AuthToken:     authToken,
-		LastHeartbeat: &now,
-		StartedAt:     now,
-		Metadata:      req.Metadata,
-	}
-
-	// Store in database
-	// TODO: Handle duplicate ID errors (retry with new UUID)
-	if err := m.repo.CreateInstance(instance); err != nil {
-		m.log.Error("failed to create instance", "error", err)
+	instance, resumed, err := m.resumeInstance(req, host, port, probe)
+	if errors.Is(err, errInstanceQuarantined) {
+		outcome = HandshakeQuarantined
+		return &HandshakeResponse{Accepted: false, Error: err.Error()},
+			status.Error(codes.FailedPrecondition, err.Error())
+	}
+	if errors.Is(err, errInstanceDisabled) {
+		outcome = HandshakeDisabled
+		return &HandshakeResponse{Accepted: false, Error: "instance disabled"},
+			status.Error(codes.FailedPrecondition, "instance disabled")
+	}
+	if errors.Is(err, errIllegalTransition) {
+		// e.g. the instance is still stopping; the plugin retries later
+		outcome = HandshakeNotResumable
+		return &HandshakeResponse{Accepted: false, Error: "instance cannot be resumed"},
+			status.Error(codes.FailedPrecondition, err.Error())
+	}
+	if err != nil {
+		log.Error("failed to resume instance", "error", err)
 		return &HandshakeResponse{Accepted: false, Error: "internal error"},
-			status.Error(codes.Internal, "failed to create instance")
+			status.Error(codes.Internal, "failed to resume instance")
 	}
 
-	// Emit connected event (broadcast)
-	m.eventBus.SendBroadcast(&PluginEvent{
-		Type: EventTypePluginConnected,
-		Data: req.PluginId,
-	})
+	if instance == nil {
+		now := time.Now()
+		instance = &entities.PluginInstance{
+			ID:            generateUUID(),
+			DefinitionID:  req.PluginId,
+			InstanceKey:   req.InstanceKey,
+			Status:        entities.PluginStatusRunning,
+			Enabled:       true,
+			AuthToken:     generateToken(),
+			LastHeartbeat: &now,
+			StartedAt:     now,
+			Metadata:      req.Metadata,
+			Host:          host,
+			Port:          port,
+			Probe:         probe,
+		}
 
-	m.log.Info("handshake accepted", "plugin_id", req.PluginId, "session_id", sessionID)
+		// Store in database
+		// TODO: Handle duplicate ID errors (retry with new UUID)
+		if err := m.repo.CreateInstance(instance); err != nil {
+			log.Error("failed to create instance", "error", err)
+			return &HandshakeResponse{Accepted: false, Error: "internal error"},
+				status.Error(codes.Internal, "failed to create instance")
+		}
+		m.liveness.sync(instance)
+		m.recordTransition(&entities.InstanceTransition{
+			InstanceID: instance.ID,
+			PluginID:   instance.DefinitionID,
+			To:         instance.Status,
+			Reason:     "handshake accepted",
+		})
+	}
+
+	m.probes.register(instance)
+	m.connect(instance, true)
+
+	log.Info("handshake accepted", "session_id", instance.ID, "resumed", resumed)
+	outcome = HandshakeAccepted
+	if resumed {
+		outcome = HandshakeResumed
+	}
 
 	return &HandshakeResponse{
 		Accepted:    true,
-		SessionId:   sessionID,
+		SessionId:   instance.ID,
 		CoreVersion: "1.0.0", // TODO: Get from build info
-		AuthToken:   authToken,
-		Config:      map[string]string{},
+		AuthToken:   instance.AuthToken,
+		Config:      m.pluginConfig(req.PluginId),
+		Resumed:     resumed,
 	}, nil
 }
 
-// Heartbeat processes periodic health checks from plugins
-// TODO: Add metrics for heartbeat latency
-func (m *PluginManager) Heartbeat(ctx context.Context, req *HeartbeatRequest) (*HeartbeatResponse, error) {
-	// TODO: Add caching to reduce DB load
-	
-	instance, err := m.repo.GetInstance(req.SessionId)
+// findResumable returns the instance a handshake may resume, or nil
+func (m *PluginManager) findResumable(req *HandshakeRequest) (*entities.PluginInstance, error) {
+	if req.ResumeSessionId != "" {
+		inst, err := m.repo.GetInstance(req.ResumeSessionId)
+		switch {
+		case err == nil && inst.DefinitionID == req.PluginId &&
+			subtle.ConstantTimeCompare([]byte(inst.AuthToken), []byte(req.ResumeAuthToken)) == 1:
+			return inst, nil
+		case err != nil && !errors.Is(err, repository.ErrNotFound):
+			return nil, err
+		}
+		m.log.Debug("session not resumable", "plugin_id", req.PluginId, "session_id", req.ResumeSessionId)
+	}
+
+	if req.InstanceKey == "" {
+		return nil, nil
+	}
+	inst, err := m.repo.FindInstanceByKey(req.PluginId, req.InstanceKey)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, nil
+	}
 	if err != nil {
-		return &HeartbeatResponse{Ok: false, Message: "session not found"},
-			status.Error(codes.NotFound, "session not found")
+		return nil, err
 	}
+	// Replicas on one host share the default key; a live session is only
+	// taken over by its own auth token, so the newcomer gets its own instance
+	if m.hasLiveSession(inst) {
+		m.log.Debug("instance key in use by a live session", "plugin_id", req.PluginId,
+			"instance_key", req.InstanceKey, "session_id", inst.ID)
+		return nil, nil
+	}
+	return inst, nil
+}
 
-	if instance.AuthToken != req.AuthToken {
-		return &HeartbeatResponse{Ok: false, Message: "invalid auth token"},
-			status.Error(codes.Unauthenticated, "invalid auth token")
+// hasLiveSession reports whether an instance is running and has
+// heartbeated within the heartbeat timeout
+func (m *PluginManager) hasLiveSession(inst *entities.PluginInstance) bool {
+	if inst.Status != entities.PluginStatusRunning && inst.Status != entities.PluginStatusDegraded {
+		return false
+	}
+	last := inst.LastHeartbeat
+	if hb, ok := m.liveness.lastHeartbeat(inst.ID); ok && (last == nil || hb.After(*last)) {
+		last = &hb
+	}
+	return last != nil && last.After(time.Now().Add(-m.heartbeatTimeout()))
+}
+
+// heartbeatTimeout is how long an instance may go without a heartbeat
+func (m *PluginManager) heartbeatTimeout() time.Duration {
+	return parseDuration(m.config.Security.HeartbeatTimeout, 30*time.Second)
+}
+
+// errInstanceDisabled rejects a reconnect to an instance disabled by an admin
+var errInstanceDisabled = errors.New("instance disabled")
+
+// errInstanceQuarantined rejects a reconnect to a quarantined instance
+var errInstanceQuarantined = errors.New("instance quarantined")
+
+// resumeInstance reattaches a handshake to an existing instance: the
+// session named by ResumeSessionId when its auth token matches, otherwise
+// the latest non-retired instance with the same plugin ID and instance key
+// unless that instance still has a live session.
+// It returns nil when there is nothing to resume.
+// The resumed instance keeps its ID and gets a fresh auth token.
+func (m *PluginManager) resumeInstance(req *HandshakeRequest, host string, port int, probe *entities.ProbeSpec) (*entities.PluginInstance, bool, error) {
+	existing, err := m.findResumable(req)
+	if err != nil {
+		return nil, false, err
+	}
+	if existing == nil {
+		return nil, false, m.checkQuarantined(req)
 	}
 
 	now := time.Now()
-	instance.LastHeartbeat = &now
-	instance.Status = entities.PluginStatusRunning
+	inst, err := m.transitionInstance(existing.ID, 0, "resumed by handshake", func(inst *entities.PluginInstance) error {
+		if inst.Status == entities.PluginStatusRetired {
+			return repository.ErrNotFound
+		}
+		if !inst.Enabled {
+			return errInstanceDisabled
+		}
+		if inst.Status == entities.PluginStatusQuarantined {
+			return quarantineError(inst)
+		}
+		inst.Status = entities.PluginStatusRunning
+		inst.AuthToken = generateToken()
+		inst.LastHeartbeat = &now
+		inst.StartedAt = now
+		inst.Metadata = req.Metadata
+		if req.InstanceKey != "" {
+			inst.InstanceKey = req.InstanceKey
+		}
+		inst.Host, inst.Port, inst.Probe = host, port, probe
+		return nil
+	})
+	// Retired or deleted since the lookup: start a new instance
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, false, m.checkQuarantined(req)
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return inst, true, nil
+}
+
+// checkQuarantined rejects a handshake that would start a new instance
+// while the plugin has a quarantined instance with the same instance key
+// Keyless handshakes always start a new instance.
+func (m *PluginManager) checkQuarantined(req *HandshakeRequest) error {
+	if req.InstanceKey == "" {
+		return nil
+	}
+	page, err := m.repo.QueryInstances(repository.InstanceQuery{
+		DefinitionID: req.PluginId,
+		Status:       []string{entities.PluginStatusQuarantined},
+		Limit:        repository.MaxPageLimit,
+	})
+	if err != nil {
+		return err
+	}
+	for _, inst := range page.Items {
+		if inst.InstanceKey == req.InstanceKey {
+			return quarantineError(inst)
+		}
+	}
+	return nil
+}
 
-	// TODO: Handle update errors
-	if err := m.repo.UpdateInstance(instance); err != nil {
+// quarantineError returns errInstanceQuarantined with the end of the
+// quarantine, when known
+func quarantineError(inst *entities.PluginInstance) error {
+	if inst.QuarantinedUntil != nil {
+		return fmt.Errorf("%w until %s", errInstanceQuarantined, inst.QuarantinedUntil.Format(time.RFC3339))
+	}
+	return errInstanceQuarantined
+}
+
+// Heartbeat processes periodic health checks from plugins
+func (m *PluginManager) Heartbeat(ctx context.Context, req *HeartbeatRequest) (*HeartbeatResponse, error) {
+	start := time.Now()
+	err := m.recordHeartbeat(req)
+	m.observeHeartbeat("grpc", start, err)
+	switch {
+	case errors.Is(err, errSessionNotFound):
+		return &HeartbeatResponse{Ok: false, Message: "session not found"},
+			status.Error(codes.NotFound, "session not found")
+	case errors.Is(err, errInvalidAuthToken):
+		return &HeartbeatResponse{Ok: false, Message: "invalid auth token"},
+			status.Error(codes.Unauthenticated, "invalid auth token")
+	case err != nil:
 		m.log.Error("failed to update instance", "error", err)
 	}
 
-	return &HeartbeatResponse{Ok: true, Message: "ok"}, nil
+	return &HeartbeatResponse{Ok: true, Message: "ok", Shutdown: m.drain.draining()}, nil
 }
 
-// Configure handles dynamic configuration updates
-// TODO: Implement configuration management
+// Configure stores configuration values reported by a plugin
 func (m *PluginManager) Configure(ctx context.Context, req *ConfigureRequest) (*ConfigureResponse, error) {
+	instance, err := m.repo.GetInstance(req.SessionId)
+	if err != nil {
+		return &ConfigureResponse{Ok: false, Error: "session not found"},
+			status.Error(codes.NotFound, "session not found")
+	}
+
+	if subtle.ConstantTimeCompare([]byte(instance.AuthToken), []byte(req.AuthToken)) != 1 {
+		return &ConfigureResponse{Ok: false, Error: "invalid auth token"},
+			status.Error(codes.Unauthenticated, "invalid auth token")
+	}
+
+	if err := m.repo.SetConfig(instance.DefinitionID, req.Config); err != nil {
+		m.log.Error("failed to store config", "plugin_id", instance.DefinitionID, "error", err)
+		return &ConfigureResponse{Ok: false, Error: "internal error"},
+			status.Error(codes.Internal, "failed to store config")
+	}
+
 	return &ConfigureResponse{Ok: true}, nil
 }
 
-// Stream handles bidirectional streaming for events
-// TODO: Implement event system
+// Stream delivers core events to a plugin until either side closes it
+// The plugin authenticates with session-id and auth-token metadata. When
+// the plugin closes the stream it is disconnected (types.DisconnectStreamClosed).
 func (m *PluginManager) Stream(srv *pluginStreamServer) error {
-	return nil
+	ctx := srv.Context()
+	md, _ := metadata.FromIncomingContext(ctx)
+	sessionID, authToken := firstValue(md.Get(rpc.MetadataSessionID)), firstValue(md.Get(rpc.MetadataAuthToken))
+
+	entry, ok := m.liveness.get(sessionID)
+	if !ok || subtle.ConstantTimeCompare([]byte(entry.AuthToken), []byte(authToken)) != 1 {
+		return status.Error(codes.Unauthenticated, "invalid session")
+	}
+	events := m.eventBus.Subscribe(sessionID)
+
+	// Plugins send acknowledgements and replies; a receive error means
+	// the plugin closed the stream
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			var ev PluginEvent
+			if err := srv.RecvMsg(&ev); err != nil {
+				return
+			}
+			m.handlePluginEvent(ctx, sessionID, &ev)
+		}
+	}()
+
+	for {
+		select {
+		case ev, ok := <-events:
+			if !ok {
+				// Unsubscribed: already disconnected, or replaced by a new stream
+				return nil
+			}
+			if err := srv.SendMsg(&CoreEvent{Type: ev.Type, Data: ev.Data, TraceParent: ev.TraceParent}); err != nil {
+				m.DisconnectPlugin(sessionID, types.DisconnectStreamClosed)
+				return err
+			}
+		case <-closed:
+			m.DisconnectPlugin(sessionID, types.DisconnectStreamClosed)
+			return nil
+		}
+	}
+}
+
+// firstValue returns the first metadata value, or ""
+func firstValue(values []string) string {
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
 }
 
 // Internal
 
-func (m *PluginManager) startGRPCServer() {
-	addr := fmt.Sprintf("%s:%d", m.config.Server.Host, m.config.Server.Port+1)
+// Errors reports servers that stop serving while the manager is running
+// The caller should shut down when it receives an error.
+func (m *PluginManager) Errors() <-chan error {
+	return m.errs
+}
+
+// GRPCAddr returns the address the gRPC server is bound to, if any
+// It is host:port for tcp and the socket path for unix listeners.
+func (m *PluginManager) GRPCAddr() string {
+	_, addr, _, _ := m.grpcListener.get()
+	return addr
+}
+
+// listenGRPC binds the gRPC listener and creates the server
+func (m *PluginManager) listenGRPC() (net.Listener, error) {
+	lc := m.config.Server.GRPCListener()
 	// TODO: Add TLS support
 	// TODO: Add connection limits
-	lis, err := net.Listen("tcp", addr)
+	lis, err := listen(ServerGRPC, lc)
 	if err != nil {
-		m.log.Error("failed to listen", "address", addr, "error", err)
-		return
+		m.grpcListener.set(lc.NetworkOrDefault(), lc.Address, err)
+		return nil, err
 	}
+	m.grpcListener.set(lis.Addr().Network(), lis.Addr().String(), nil)
 
-	m.grpcServer = grpc.NewServer()
+	m.grpcServer = grpc.NewServer(
+		grpc.ChainUnaryInterceptor(tracing.UnaryServerInterceptor(m.tracer)),
+		grpc.ChainStreamInterceptor(tracing.StreamServerInterceptor(m.tracer)),
+	)
 	RegisterPluginServiceServer(m.grpcServer, m)
+	return lis, nil
+}
+
+// serveGRPC serves gRPC until the manager stops, reporting an unexpected
+// end on Errors
+func (m *PluginManager) serveGRPC(lis net.Listener) {
+	addr := lis.Addr().String()
+	m.log.Info("gRPC server listening", "network", lis.Addr().Network(), "address", addr)
+	err := m.grpcServer.Serve(lis)
+	m.grpcListener.closed(err)
 
-	m.log.Info("gRPC server listening", "address", addr)
-	// TODO: Handle server errors
-	m.grpcServer.Serve(lis)
+	select {
+	case <-m.stopped:
+		return
+	default:
+	}
+	if err == nil {
+		err = errors.New("server stopped")
+	}
+	m.log.Error("gRPC server failed", "address", addr, "error", err)
+	m.errs.report(&ServeError{Server: ServerGRPC, Address: addr, Err: err})
 }
 
 func (m *PluginManager) heartbeatMonitor() {
-	ticker := time.NewTicker(10 * time.Second)
+	ticker := time.NewTicker(parseDuration(m.config.Heartbeat.CheckInterval, 10*time.Second))
 	defer ticker.Stop()
 
 	for {
@@ -251,37 +635,573 @@ func (m *PluginManager) heartbeatMonitor() {
 			return
 		case <-ticker.C:
 			m.checkHeartbeats()
+			m.releaseQuarantined()
 		}
 	}
 }
 
+// checkHeartbeats marks instances unhealthy using the in-memory liveness table
+// Only instances that actually change status are read from and written to the DB
 func (m *PluginManager) checkHeartbeats() {
-	timeout := m.config.Security.HeartbeatTimeout
-	if timeout == "" {
-		timeout = "30s"
+	cutoff := time.Now().Add(-m.heartbeatTimeout())
+
+	for _, id := range m.liveness.expired(cutoff) {
+		_, err := m.disconnect(id, 0, types.DisconnectHeartbeatTimeout, func(inst *entities.PluginInstance) error {
+			if !isHeartbeatExpired(inst, cutoff) || !entities.IsLiveStatus(inst.Status) {
+				return errStillConnected
+			}
+			inst.Status = entities.PluginStatusUnhealthy
+			return nil
+		})
+		if errors.Is(err, errStillConnected) {
+			continue
+		}
+		if errors.Is(err, repository.ErrNotFound) {
+			m.liveness.forget(id)
+			continue
+		}
+		if err != nil {
+			m.log.Error("failed to update instance status", "error", err)
+			continue
+		}
+		m.log.Warn("plugin unhealthy", "session_id", id)
 	}
+}
 
-	// TODO: Use database query instead of loading all instances
-	instances, err := m.repo.ListInstances()
+func (m *PluginManager) heartbeatFlusher() {
+	ticker := time.NewTicker(parseDuration(m.config.Heartbeat.FlushInterval, 5*time.Second))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-m.stopped:
+			return
+		case <-ticker.C:
+			m.flushHeartbeats()
+		}
+	}
+}
+
+// flushHeartbeats writes dirty heartbeat timestamps and health reports to the
+// repository, one batch each
+func (m *PluginManager) flushHeartbeats() {
+	if beats := m.liveness.drainDirty(); len(beats) > 0 {
+		if err := m.repo.TouchHeartbeats(beats); err != nil {
+			m.log.Error("failed to flush heartbeats", "count", len(beats), "error", err)
+			m.liveness.requeue(beats)
+		} else {
+			m.log.Debug("heartbeats flushed", "count", len(beats))
+		}
+	}
+
+	if reports := m.liveness.drainHealth(); len(reports) > 0 {
+		if err := m.repo.SaveHealth(reports); err != nil {
+			m.log.Error("failed to flush health reports", "count", len(reports), "error", err)
+			m.liveness.requeueHealth(reports)
+		}
+	}
+}
+
+func (m *PluginManager) instanceJanitor() {
+	ticker := time.NewTicker(parseDuration(m.config.Instances.GCInterval, 10*time.Minute))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-m.stopped:
+			return
+		case <-ticker.C:
+			m.collectInstances()
+		}
+	}
+}
+
+// collectInstances retires instances that stayed unhealthy, stopping or stopped past
+// instances.retire_after, and deletes those retired before instances.retention
+func (m *PluginManager) collectInstances() {
+	now := time.Now()
+	retireAfter := parseDuration(m.config.Instances.RetireAfter, time.Hour)
+	retention := parseDuration(m.config.Instances.Retention, 7*24*time.Hour)
+
+	// Pending heartbeats must reach the database before staleness is judged
+	m.flushHeartbeats()
+
+	retired, err := m.repo.RetireInstances(now.Add(-retireAfter), "no heartbeat for "+retireAfter.String())
 	if err != nil {
-		m.log.Error("failed to list instances", "error", err)
+		m.log.Error("failed to retire instances", "error", err)
+	}
+	for _, t := range retired {
+		m.liveness.forget(t.InstanceID)
+		m.probes.forget(t.InstanceID)
+		m.flaps.release(t.InstanceID)
+		m.logs.forget(t.InstanceID)
+		m.pluginMetrics.forget(t.InstanceID)
+		m.eventBus.Unsubscribe(t.InstanceID)
+		m.emitTransition(t)
+		m.recordEvent(EventTypeInstanceRetired, t.PluginID, t.InstanceID, t.Reason)
+		m.log.Info("instance retired", "instance_id", t.InstanceID, "plugin_id", t.PluginID)
+	}
+	// The heartbeat interval is per plugin; drop it with its last instance
+	for _, t := range retired {
+		if !m.liveness.hasPlugin(t.PluginID) {
+			m.metrics.heartbeatInterval.Delete(t.PluginID)
+		}
+	}
+
+	deleted, err := m.repo.DeleteRetiredInstances(now.Add(-retention))
+	if err != nil {
+		m.log.Error("failed to delete retired instances", "error", err)
+		return
+	}
+	if deleted > 0 {
+		m.log.Info("retired instances deleted", "count", deleted)
+	}
+}
+
+func (m *PluginManager) healthProber() {
+	ticker := time.NewTicker(parseDuration(m.config.Probes.Interval, 15*time.Second))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-m.stopped:
+			return
+		case <-ticker.C:
+			m.runProbes()
+		}
+	}
+}
+
+// runProbes checks the health endpoint of every live or unhealthy instance
+// An instance whose probe fails probes.failure_threshold times in a row is
+// marked unhealthy, even if its heartbeats keep arriving
+func (m *PluginManager) runProbes() {
+	timeout := parseDuration(m.config.Probes.Timeout, 2*time.Second)
+	sem := make(chan struct{}, maxConcurrentProbes)
+	var wg sync.WaitGroup
+
+	for id, target := range m.probes.snapshot() {
+		entry, ok := m.liveness.get(id)
+		if !ok || !entry.Enabled ||
+			(!entities.IsLiveStatus(entry.Status) && entry.Status != entities.PluginStatusUnhealthy) {
+			continue
+		}
+
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(id string, target probeTarget) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			err := probe(ctx, target)
+			cancel()
+			m.recordProbe(id, err)
+		}(id, target)
+	}
+	wg.Wait()
+}
+
+// recordProbe stores a probe result and marks the instance unhealthy once
+// the failure threshold is reached
+func (m *PluginManager) recordProbe(id string, err error) {
+	failures := m.probes.record(id, err, time.Now())
+	if err == nil {
+		return
+	}
+	m.log.Debug("health probe failed", "instance_id", id, "failures", failures, "error", err)
+	if failures < m.probeThreshold() {
+		return
+	}
+
+	reason := fmt.Sprintf("health probe failed %d times: %v", failures, err)
+	_, err = m.transitionInstance(id, 0, reason, func(inst *entities.PluginInstance) error {
+		if !entities.IsLiveStatus(inst.Status) {
+			return errNoChange
+		}
+		inst.Status = entities.PluginStatusUnhealthy
+		return nil
+	})
+	if err != nil && !errors.Is(err, errNoChange) {
+		m.log.Error("failed to update instance status", "instance_id", id, "error", err)
 		return
 	}
+	if err == nil {
+		m.log.Warn("plugin unhealthy", "session_id", id, "reason", reason)
+	}
+}
+
+// probeThreshold returns the configured failure threshold (default 3)
+func (m *PluginManager) probeThreshold() int {
+	if m.config.Probes.FailureThreshold <= 0 {
+		return 3
+	}
+	return m.config.Probes.FailureThreshold
+}
 
-	d, _ := time.ParseDuration(timeout)
-	cutoff := time.Now().Add(-d)
+// probeFailing reports whether an instance's health probe is failing
+func (m *PluginManager) probeFailing(id string) bool {
+	return m.probes.failing(id, m.probeThreshold())
+}
 
+// ProbeStatus returns the health probe state of an instance, if it has one
+func (m *PluginManager) ProbeStatus(id string) (*ProbeStatus, bool) {
+	return m.probes.status(id)
+}
+
+// loadInstances seeds the liveness table from the repository
+func (m *PluginManager) loadInstances() error {
+	instances, err := m.repo.ListInstances()
+	if err != nil {
+		return err
+	}
+	count := 0
 	for _, inst := range instances {
-		if inst.Status != entities.PluginStatusRunning {
+		if inst.Status == entities.PluginStatusRetired {
 			continue
 		}
-		if inst.LastHeartbeat == nil || inst.LastHeartbeat.Before(cutoff) {
-			inst.Status = entities.PluginStatusUnhealthy
-			if err := m.repo.UpdateInstance(inst); err != nil {
-				m.log.Error("failed to update instance status", "error", err)
+		if inst.Status == entities.PluginStatusStopping {
+			// Left over by a core that exited while draining
+			if err := m.markStopped(inst.ID, "core restarted"); err != nil {
+				return err
 			}
-			m.log.Warn("plugin unhealthy", "session_id", inst.ID)
+			inst.Status = entities.PluginStatusStopped
+		}
+		m.liveness.sync(inst)
+		m.probes.register(inst)
+		if entities.IsLiveStatus(inst.Status) {
+			// Expected to keep heartbeating after the restart
+			m.conns.add(inst.ID, inst.DefinitionID)
 		}
+		if inst.Status == entities.PluginStatusQuarantined {
+			until := time.Now()
+			if inst.QuarantinedUntil != nil {
+				until = *inst.QuarantinedUntil
+			}
+			m.flaps.quarantine(inst.ID, until)
+		}
+		count++
+	}
+	m.log.Info("instances loaded", "count", count)
+	return nil
+}
+
+// parseDuration parses a config duration, falling back to def when empty or invalid
+func parseDuration(value string, def time.Duration) time.Duration {
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		return def
+	}
+	return d
+}
+
+func isHeartbeatExpired(inst *entities.PluginInstance, cutoff time.Time) bool {
+	if !entities.IsLiveStatus(inst.Status) {
+		return false
+	}
+	return inst.LastHeartbeat == nil || inst.LastHeartbeat.Before(cutoff)
+}
+
+// maxUpdateRetries bounds read-modify-write retries on concurrent modification
+const maxUpdateRetries = 5
+
+// errNoChange aborts an update without writing
+var errNoChange = errors.New("no change")
+
+// updateInstance applies mutate to the latest stored instance and saves it
+// with a conditional write, retrying when another writer got there first.
+// A non-zero ifMatch pins the expected revision and disables retries.
+func (m *PluginManager) updateInstance(id string, ifMatch int64, mutate func(*entities.PluginInstance) error) (*entities.PluginInstance, error) {
+	for attempt := 0; ; attempt++ {
+		inst, err := m.repo.GetInstance(id)
+		if err != nil {
+			return nil, err
+		}
+		if ifMatch != 0 && inst.Revision != ifMatch {
+			return nil, repository.ErrConflict
+		}
+
+		// The liveness table is authoritative for heartbeat timestamps
+		m.liveness.overlay(inst)
+		if err := mutate(inst); err != nil {
+			if errors.Is(err, errNoChange) {
+				m.liveness.sync(inst)
+			}
+			return nil, err
+		}
+
+		err = m.repo.UpdateInstance(inst)
+		if errors.Is(err, repository.ErrConflict) && ifMatch == 0 && attempt < maxUpdateRetries {
+			m.log.Debug("instance modified concurrently, retrying", "instance_id", id, "attempt", attempt+1)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		m.liveness.sync(inst)
+		return inst, nil
+	}
+}
+
+// updateDefinition is the definition counterpart of updateInstance
+func (m *PluginManager) updateDefinition(id string, ifMatch int64, mutate func(*entities.PluginDefinition) error) (*entities.PluginDefinition, error) {
+	for attempt := 0; ; attempt++ {
+		def, err := m.repo.GetDefinition(id)
+		if err != nil {
+			return nil, err
+		}
+		if ifMatch != 0 && def.Revision != ifMatch {
+			return nil, repository.ErrConflict
+		}
+		if err := mutate(def); err != nil {
+			return nil, err
+		}
+
+		err = m.repo.UpdateDefinition(def)
+		if errors.Is(err, repository.ErrConflict) && ifMatch == 0 && attempt < maxUpdateRetries {
+			m.log.Debug("definition modified concurrently, retrying", "plugin_id", id, "attempt", attempt+1)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return def, nil
+	}
+}
+
+// errIllegalTransition rejects a status change not allowed by entities.CanTransition
+var errIllegalTransition = errors.New("illegal status transition")
+
+// transitionInstance is updateInstance for writes that may change the status
+// An illegal status change aborts the write with errIllegalTransition; a
+// legal one is recorded with reason and emitted as an event.
+func (m *PluginManager) transitionInstance(id string, ifMatch int64, reason string, mutate func(*entities.PluginInstance) error) (*entities.PluginInstance, error) {
+	var from string
+	inst, err := m.updateInstance(id, ifMatch, func(inst *entities.PluginInstance) error {
+		from = inst.Status
+		if err := mutate(inst); err != nil {
+			return err
+		}
+		if inst.Status != from && !entities.CanTransition(from, inst.Status) {
+			return fmt.Errorf("%w from %s to %s", errIllegalTransition, from, inst.Status)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if inst.Status != from {
+		m.recordTransition(&entities.InstanceTransition{
+			InstanceID: inst.ID,
+			PluginID:   inst.DefinitionID,
+			From:       from,
+			To:         inst.Status,
+			Reason:     reason,
+		})
+		if inst.Status == entities.PluginStatusUnhealthy && entities.IsLiveStatus(from) {
+			m.checkFlapping(inst.ID)
+		}
+	}
+	return inst, nil
+}
+
+// checkFlapping counts an unhealthy episode and quarantines the instance
+// once it flapped flapping.threshold times within flapping.window
+func (m *PluginManager) checkFlapping(id string) {
+	window := parseDuration(m.config.Flapping.Window, 10*time.Minute)
+	threshold := m.config.Flapping.Threshold
+	if threshold <= 0 {
+		threshold = 3
+	}
+
+	flaps := m.flaps.record(id, time.Now(), window)
+	if flaps < threshold {
+		return
+	}
+	reason := fmt.Sprintf("became unhealthy %d times in %s", flaps, window)
+	if err := m.quarantineInstance(id, reason); err != nil && !errors.Is(err, errNoChange) {
+		m.log.Error("failed to quarantine instance", "instance_id", id, "error", err)
+	}
+}
+
+// quarantineInstance moves an instance to quarantined for flapping.cooldown
+// Handshakes resuming it are refused until it is released
+func (m *PluginManager) quarantineInstance(id, reason string) error {
+	until := time.Now().Add(parseDuration(m.config.Flapping.Cooldown, 30*time.Minute))
+	inst, err := m.transitionInstance(id, 0, reason, func(inst *entities.PluginInstance) error {
+		if !entities.CanTransition(inst.Status, entities.PluginStatusQuarantined) {
+			return errNoChange
+		}
+		inst.Status = entities.PluginStatusQuarantined
+		inst.QuarantinedUntil = &until
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	m.flaps.quarantine(id, until)
+	m.recordEvent(EventTypeInstanceQuarantined, inst.DefinitionID, id, reason)
+	m.log.Warn("instance quarantined", "instance_id", id, "plugin_id", inst.DefinitionID,
+		"until", until, "reason", reason)
+	return nil
+}
+
+// errNotQuarantined rejects releasing an instance that is not quarantined
+var errNotQuarantined = errors.New("instance is not quarantined")
+
+// ReleaseInstance ends the quarantine of an instance early
+// The instance moves to starting and runs again on its next heartbeat or handshake
+func (m *PluginManager) ReleaseInstance(id string, ifMatch int64) (*entities.PluginInstance, error) {
+	return m.releaseInstance(id, ifMatch, "released by admin")
+}
+
+func (m *PluginManager) releaseInstance(id string, ifMatch int64, reason string) (*entities.PluginInstance, error) {
+	inst, err := m.transitionInstance(id, ifMatch, reason, func(inst *entities.PluginInstance) error {
+		if inst.Status != entities.PluginStatusQuarantined {
+			return errNotQuarantined
+		}
+		inst.Status = entities.PluginStatusStarting
+		inst.QuarantinedUntil = nil
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	m.flaps.release(id)
+	m.recordEvent(EventTypeInstanceReleased, inst.DefinitionID, id, reason)
+	m.log.Info("instance released", "instance_id", id, "plugin_id", inst.DefinitionID, "reason", reason)
+	return inst, nil
+}
+
+// releaseQuarantined releases instances whose quarantine cooldown elapsed
+func (m *PluginManager) releaseQuarantined() {
+	for _, id := range m.flaps.due(time.Now()) {
+		_, err := m.releaseInstance(id, 0, "quarantine cooldown elapsed")
+		switch {
+		case errors.Is(err, errNotQuarantined), errors.Is(err, repository.ErrNotFound):
+			// Stopped or deleted by an admin meanwhile
+			m.flaps.release(id)
+		case err != nil:
+			m.log.Error("failed to release instance", "instance_id", id, "error", err)
+		}
+	}
+}
+
+// recordTransition stores a status change and emits it
+func (m *PluginManager) recordTransition(t *entities.InstanceTransition) {
+	if err := m.repo.AppendTransition(t); err != nil {
+		m.log.Error("failed to record transition", "instance_id", t.InstanceID, "error", err)
+	}
+	m.emitTransition(t)
+}
+
+// emitTransition broadcasts an instance_state_changed event and logs it
+func (m *PluginManager) emitTransition(t *entities.InstanceTransition) {
+	data, _ := json.Marshal(t)
+	m.eventBus.SendBroadcast(&PluginEvent{
+		Type: EventTypeInstanceStateChanged,
+		Data: string(data),
+	})
+	m.recordEvent(EventTypeInstanceStateChanged, t.PluginID, t.InstanceID, string(data))
+	m.log.Debug("instance state changed", "instance_id", t.InstanceID, "from", t.From, "to", t.To, "reason", t.Reason)
+}
+
+// Heartbeat errors
+var (
+	errSessionNotFound  = errors.New("session not found")
+	errInvalidAuthToken = errors.New("invalid auth token")
+)
+
+// recordHeartbeat stores a heartbeat and its health report in the liveness table
+// The database is only written immediately when the status changes
+// (see heartbeatStatus); timestamps and reports are written in batches by
+// flushHeartbeats
+func (m *PluginManager) recordHeartbeat(req *types.HeartbeatRequest) error {
+	sessionID := req.SessionId
+	entry, ok := m.liveness.get(sessionID)
+	if !ok {
+		inst, err := m.repo.GetInstance(sessionID)
+		if err != nil || inst.Status == entities.PluginStatusRetired {
+			return errSessionNotFound
+		}
+		m.liveness.sync(inst)
+		entry, _ = m.liveness.get(sessionID)
+	}
+
+	// Retired instances cannot be resumed by heartbeats, only by a handshake
+	if entry.Status == entities.PluginStatusRetired {
+		return errSessionNotFound
+	}
+	if subtle.ConstantTimeCompare([]byte(entry.AuthToken), []byte(req.AuthToken)) != 1 {
+		return errInvalidAuthToken
+	}
+	if req.ShutdownAck {
+		m.acknowledgeShutdown(sessionID)
+	}
+
+	now := time.Now()
+	health := newHealthReport(req, now)
+	if prev := entry.LastHeartbeat; !prev.IsZero() && now.After(prev) {
+		m.metrics.heartbeatInterval.With(entry.PluginID).Observe(now.Sub(prev).Seconds())
+	}
+	entry, _ = m.liveness.beat(sessionID, now)
+	if health != nil {
+		m.liveness.report(sessionID, health)
+	}
+	if req.Metrics != nil {
+		if dropped := m.pluginMetrics.set(sessionID, entry.PluginID, req.Metrics); dropped > 0 {
+			m.log.Debug("dropped plugin metrics", "instance_id", sessionID, "count", dropped)
+		}
+	}
+
+	// A disabled instance keeps its stopped status, and an instance whose
+	// health probe keeps failing stays unhealthy despite heartbeats
+	target, reason := heartbeatStatus(entry.Status, health)
+	if !entry.Enabled || target == "" || m.probeFailing(sessionID) {
+		return nil
+	}
+
+	inst, err := m.transitionInstance(sessionID, 0, reason, func(inst *entities.PluginInstance) error {
+		if t, _ := heartbeatStatus(inst.Status, health); !inst.Enabled || t != target {
+			return errNoChange
+		}
+		inst.Status = target
+		return nil
+	})
+	if errors.Is(err, errNoChange) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	// A revived instance is connected again
+	m.connect(inst, false)
+	return nil
+}
+
+// pluginConfig returns the stored configuration for a plugin (empty on error)
+func (m *PluginManager) pluginConfig(pluginID string) map[string]string {
+	values, err := m.repo.GetConfig(pluginID)
+	if err != nil {
+		m.log.Error("failed to load plugin config", "plugin_id", pluginID, "error", err)
+		return map[string]string{}
+	}
+	return values
+}
+
+// recordEvent appends a lifecycle event to the repository event log
+func (m *PluginManager) recordEvent(eventType, pluginID, instanceID, data string) {
+	err := m.repo.AppendEvent(&entities.EventRecord{
+		Type:       eventType,
+		PluginID:   pluginID,
+		InstanceID: instanceID,
+		Data:       data,
+	})
+	if err != nil {
+		m.log.Error("failed to record event", "type", eventType, "error", err)
 	}
 }
 
@@ -315,6 +1235,11 @@ func (m *PluginManager) ListDefinitions() []*entities.PluginDefinition {
 	return defs
 }
 
+// QueryDefinitions returns one page of filtered, sorted plugin definitions
+func (m *PluginManager) QueryDefinitions(q repository.DefinitionQuery) (*repository.DefinitionPage, error) {
+	return m.repo.QueryDefinitions(q)
+}
+
 // GetDefinition returns a specific plugin definition by ID
 func (m *PluginManager) GetDefinition(id string) (*entities.PluginDefinition, bool) {
 	def, err := m.repo.GetDefinition(id)
@@ -325,8 +1250,12 @@ func (m *PluginManager) GetDefinition(id string) (*entities.PluginDefinition, bo
 }
 
 // SetDefinitionEnabled enables or disables a plugin definition
-func (m *PluginManager) SetDefinitionEnabled(id string, enabled bool) error {
-	return m.repo.SetDefinitionEnabled(id, enabled)
+// A non-zero ifMatch must equal the stored revision (repository.ErrConflict otherwise)
+func (m *PluginManager) SetDefinitionEnabled(id string, enabled bool, ifMatch int64) (*entities.PluginDefinition, error) {
+	return m.updateDefinition(id, ifMatch, func(def *entities.PluginDefinition) error {
+		def.Enabled = enabled
+		return nil
+	})
 }
 
 // ListInstances returns all registered plugin instances
@@ -336,44 +1265,83 @@ func (m *PluginManager) ListInstances() []*entities.PluginInstance {
 		m.log.Error("failed to list instances", "error", err)
 		return []*entities.PluginInstance{}
 	}
+	for _, inst := range instances {
+		m.liveness.overlay(inst)
+	}
 	return instances
 }
 
+// QueryInstances returns one page of filtered, sorted plugin instances
+// Heartbeat filters apply to the last flushed heartbeat; the returned
+// instances carry the latest in-memory one.
+func (m *PluginManager) QueryInstances(q repository.InstanceQuery) (*repository.InstancePage, error) {
+	page, err := m.repo.QueryInstances(q)
+	if err != nil {
+		return nil, err
+	}
+	for _, inst := range page.Items {
+		m.liveness.overlay(inst)
+	}
+	return page, nil
+}
+
 // GetInstance returns a specific plugin instance by ID
 func (m *PluginManager) GetInstance(id string) (*entities.PluginInstance, bool) {
 	inst, err := m.repo.GetInstance(id)
 	if err != nil {
 		return nil, false
 	}
+	m.liveness.overlay(inst)
 	return inst, true
 }
 
-// SetInstanceEnabled enables or disables a plugin instance
-func (m *PluginManager) SetInstanceEnabled(id string, enabled bool) error {
-	inst, err := m.repo.GetInstance(id)
-	if err != nil {
-		return err
+// InstanceHistory returns the status transitions of an instance, oldest first
+func (m *PluginManager) InstanceHistory(id string) ([]*entities.InstanceTransition, error) {
+	if _, err := m.repo.GetInstance(id); err != nil {
+		return nil, err
 	}
+	return m.repo.ListTransitions(id)
+}
 
-	inst.Enabled = enabled
+// SetInstanceEnabled enables or disables a plugin instance
+// A non-zero ifMatch must equal the stored revision (repository.ErrConflict otherwise)
+// Disabling goes through the disconnect path, which also sends the plugin
+// a shutdown event
+func (m *PluginManager) SetInstanceEnabled(id string, enabled bool, ifMatch int64) (*entities.PluginInstance, error) {
 	if !enabled {
-		inst.Status = entities.PluginStatusStopped
-		// Send stop event to the plugin
-		m.eventBus.SendDirect(id, &PluginEvent{
-			Type: EventTypeShutdown,
-			Data: "instance disabled",
+		return m.disconnect(id, ifMatch, types.DisconnectDisabled, func(inst *entities.PluginInstance) error {
+			inst.Enabled = false
+			if entities.CanTransition(inst.Status, entities.PluginStatusStopped) {
+				inst.Status = entities.PluginStatusStopped
+				inst.QuarantinedUntil = nil
+			}
+			return nil
 		})
 	}
 
-	return m.repo.UpdateInstance(inst)
+	return m.transitionInstance(id, ifMatch, "enabled by admin", func(inst *entities.PluginInstance) error {
+		inst.Enabled = true
+		if inst.Status == entities.PluginStatusStopped {
+			// Running again once the plugin heartbeats
+			inst.Status = entities.PluginStatusStarting
+		}
+		return nil
+	})
 }
 
 // SendEventToPlugin sends an event to a specific plugin instance
 // TODO: Add timeout and error handling
 func (m *PluginManager) SendEventToPlugin(instanceID string, eventType string, data string) error {
+	return m.sendEvent(context.Background(), instanceID, eventType, data)
+}
+
+// sendEvent sends an event carrying the trace context of ctx, so the
+// plugin's handling continues the trace
+func (m *PluginManager) sendEvent(ctx context.Context, instanceID, eventType, data string) error {
 	event := &PluginEvent{
-		Type: eventType,
-		Data: data,
+		Type:        eventType,
+		Data:        data,
+		TraceParent: tracing.TraceParentFromContext(ctx),
 	}
 	return m.eventBus.SendDirect(instanceID, event)
 }
@@ -397,145 +1365,47 @@ func (m *PluginManager) UnsubscribePlugin(instanceID string) {
 	m.eventBus.Unsubscribe(instanceID)
 }
 
-// DisconnectPlugin handles plugin disconnection and emits event
-// TODO: Call this when a plugin disconnects (gRPC stream closes, heartbeat fails, etc.)
-func (m *PluginManager) DisconnectPlugin(instanceID string) {
-	// Get instance info before removing
-	inst, err := m.repo.GetInstance(instanceID)
-	pluginID := ""
-	if err == nil && inst != nil {
-		pluginID = inst.DefinitionID
-	}
-
-	// Emit disconnected event (broadcast)
-	m.eventBus.SendBroadcast(&PluginEvent{
-		Type: EventTypePluginDisconnected,
-		Data: pluginID,
-	})
-
-	// Unsubscribe from event bus
-	m.eventBus.Unsubscribe(instanceID)
-
-	m.log.Info("plugin disconnected", "instance_id", instanceID, "plugin_id", pluginID)
-}
-
 // ============ HTTP Handlers (for plugin communication) ============
 
 // HandshakeHTTP handles HTTP handshake requests
+// Rejections are reported in the response rather than as an error
 func (m *PluginManager) HandshakeHTTP(ctx context.Context, req *types.HandshakeRequest) (*types.HandshakeResponse, error) {
-	m.log.Info("http handshake request", "plugin_id", req.PluginId, "version", req.Version)
-
-	// Security: validate token
-	if m.config.Security.Enabled {
-		if req.Token != m.config.Security.PluginToken {
-			return &types.HandshakeResponse{
-				Accepted: false,
-				Error:    "invalid token",
-			}, nil
-		}
-
-		allowed := false
-		for _, p := range m.config.Security.AllowedPlugins {
-			if p == req.PluginId {
-				allowed = true
-				break
-			}
-		}
-		if !allowed {
-			return &types.HandshakeResponse{
-				Accepted: false,
-				Error:    "plugin not allowed",
-			}, nil
-		}
-	}
-
-	// Check API version compatibility
-	if !isAPIVersionCompatible(req.ApiVersion, "1.0") {
-		return &types.HandshakeResponse{
-			Accepted: false,
-			Error:    "incompatible API version",
-		}, nil
-	}
-
-	// Generate session
-	sessionID := generateUUID()
-	authToken := generateToken()
-	now := time.Now()
-
-	// Create or update definition in database
-	def := &entities.PluginDefinition{
-		ID:           req.PluginId,
-		Version:      req.Version,
-		APIVersion:   req.ApiVersion,
-		DependsOn:    []string{},
-		Capabilities: req.Capabilities,
-		Enabled:      true,
-	}
-
-	if err := m.repo.UpsertDefinition(def); err != nil {
-		m.log.Error("failed to upsert definition", "error", err)
-	}
-
-	// Create instance
-	instance := &entities.PluginInstance{
-		ID:            sessionID,
-		DefinitionID: req.PluginId,
-		Status:        entities.PluginStatusRunning,
-		Enabled:       true,
-		AuthToken:     authToken,
-		LastHeartbeat: &now,
-		StartedAt:     now,
-		Metadata:      req.Metadata,
-	}
-
-	if err := m.repo.CreateInstance(instance); err != nil {
-		m.log.Error("failed to create instance", "error", err)
-		return &types.HandshakeResponse{
-			Accepted: false,
-			Error:    "internal error",
-		}, nil
-	}
-
-	// Emit connected event
-	m.eventBus.SendBroadcast(&PluginEvent{
-		Type: EventTypePluginConnected,
-		Data: req.PluginId,
-	})
-
-	m.log.Info("handshake accepted (HTTP)", "plugin_id", req.PluginId, "session_id", sessionID)
-
-	return &types.HandshakeResponse{
-		Accepted:    true,
-		SessionId:   sessionID,
-		CoreVersion: "1.0.0",
-		AuthToken:   authToken,
-		Config:      map[string]string{},
-	}, nil
+	resp, _ := m.handshake(ctx, req, "http")
+	return resp, nil
 }
 
 // HeartbeatHTTP handles HTTP heartbeat requests
 func (m *PluginManager) HeartbeatHTTP(ctx context.Context, req *types.HeartbeatRequest) (*types.HeartbeatResponse, error) {
-	instance, err := m.repo.GetInstance(req.SessionId)
-	if err != nil {
+	start := time.Now()
+	err := m.recordHeartbeat(req)
+	m.observeHeartbeat("http", start, err)
+	switch {
+	case errors.Is(err, errSessionNotFound):
 		return &types.HeartbeatResponse{Ok: false, Message: "session not found"}, nil
-	}
-
-	if instance.AuthToken != req.AuthToken {
+	case errors.Is(err, errInvalidAuthToken):
 		return &types.HeartbeatResponse{Ok: false, Message: "invalid auth token"}, nil
-	}
-
-	now := time.Now()
-	instance.LastHeartbeat = &now
-	instance.Status = entities.PluginStatusRunning
-
-	if err := m.repo.UpdateInstance(instance); err != nil {
+	case err != nil:
 		m.log.Error("failed to update instance", "error", err)
 	}
 
-	return &types.HeartbeatResponse{Ok: true, Message: "ok"}, nil
+	return &types.HeartbeatResponse{Ok: true, Message: "ok", Shutdown: m.drain.draining()}, nil
 }
 
 // ConfigureHTTP handles HTTP configure requests
 func (m *PluginManager) ConfigureHTTP(ctx context.Context, req *types.ConfigureRequest) (*types.ConfigureResponse, error) {
+	instance, err := m.repo.GetInstance(req.SessionId)
+	if err != nil {
+		return &types.ConfigureResponse{Ok: false, Error: "session not found"}, nil
+	}
+
+	if subtle.ConstantTimeCompare([]byte(instance.AuthToken), []byte(req.AuthToken)) != 1 {
+		return &types.ConfigureResponse{Ok: false, Error: "invalid auth token"}, nil
+	}
+
+	if err := m.repo.SetConfig(instance.DefinitionID, req.Config); err != nil {
+		m.log.Error("failed to store config", "plugin_id", instance.DefinitionID, "error", err)
+		return &types.ConfigureResponse{Ok: false, Error: "internal error"}, nil
+	}
+
 	return &types.ConfigureResponse{Ok: true}, nil
 }
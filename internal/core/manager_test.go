@@ -1,7 +1,16 @@
 package core
 
 import (
+	"context"
+	"errors"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/robrt95x/milpa-cloud/internal/domain/entities"
+	"github.com/robrt95x/milpa-cloud/internal/domain/repository"
+	"github.com/robrt95x/milpa-cloud/internal/infrastructure/config"
+	"github.com/robrt95x/milpa-cloud/pkg/types"
 )
 
 func TestIsAPIVersionCompatible(t *testing.T) {
@@ -56,3 +65,387 @@ func TestGenerateUUID(t *testing.T) {
 		t.Error("Expected different UUIDs")
 	}
 }
+
+func TestHeartbeatDoesNotRevertDisabledInstance(t *testing.T) {
+	_, _, mgr, repo := setupTest(t)
+
+	resp, err := mgr.HandshakeHTTP(context.Background(), &types.HandshakeRequest{
+		PluginId:   "example",
+		Version:    "1.0.0",
+		ApiVersion: "1.0",
+	})
+	if err != nil || !resp.Accepted {
+		t.Fatalf("Handshake failed: %v %+v", err, resp)
+	}
+
+	if _, err := mgr.SetInstanceEnabled(resp.SessionId, false, 0); err != nil {
+		t.Fatalf("SetInstanceEnabled: %v", err)
+	}
+
+	hb, _ := mgr.HeartbeatHTTP(context.Background(), &types.HeartbeatRequest{
+		SessionId: resp.SessionId,
+		AuthToken: resp.AuthToken,
+	})
+	if !hb.Ok {
+		t.Fatalf("Heartbeat rejected: %s", hb.Message)
+	}
+
+	inst, _ := repo.GetInstance(resp.SessionId)
+	if inst.Status != entities.PluginStatusStopped {
+		t.Errorf("Expected disabled instance to stay stopped, got %s", inst.Status)
+	}
+	if inst.LastHeartbeat == nil {
+		t.Error("Expected heartbeat timestamp to be recorded")
+	}
+}
+
+func TestConcurrentHeartbeatsAndDisable(t *testing.T) {
+	_, _, mgr, repo := setupTest(t)
+
+	resp, _ := mgr.HandshakeHTTP(context.Background(), &types.HandshakeRequest{
+		PluginId:   "example",
+		Version:    "1.0.0",
+		ApiVersion: "1.0",
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			mgr.HeartbeatHTTP(context.Background(), &types.HeartbeatRequest{
+				SessionId: resp.SessionId,
+				AuthToken: resp.AuthToken,
+			})
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if _, err := mgr.SetInstanceEnabled(resp.SessionId, false, 0); err != nil {
+			t.Errorf("SetInstanceEnabled: %v", err)
+		}
+	}()
+	wg.Wait()
+
+	inst, _ := repo.GetInstance(resp.SessionId)
+	if inst.Enabled || inst.Status != entities.PluginStatusStopped {
+		t.Errorf("Expected admin disable to win, got enabled=%v status=%s", inst.Enabled, inst.Status)
+	}
+}
+
+func TestSetInstanceEnabledStaleRevision(t *testing.T) {
+	_, _, mgr, _ := setupTest(t)
+
+	resp, _ := mgr.HandshakeHTTP(context.Background(), &types.HandshakeRequest{
+		PluginId:   "example",
+		Version:    "1.0.0",
+		ApiVersion: "1.0",
+	})
+
+	inst, err := mgr.SetInstanceEnabled(resp.SessionId, false, 1)
+	if err != nil {
+		t.Fatalf("SetInstanceEnabled: %v", err)
+	}
+	if inst.Revision != 2 {
+		t.Errorf("Expected revision 2, got %d", inst.Revision)
+	}
+
+	if _, err := mgr.SetInstanceEnabled(resp.SessionId, true, 1); !errors.Is(err, repository.ErrConflict) {
+		t.Errorf("Expected ErrConflict, got %v", err)
+	}
+}
+
+func TestHandshakeResumesInstanceByKey(t *testing.T) {
+	_, _, mgr, repo := setupTest(t)
+
+	req := &types.HandshakeRequest{
+		PluginId:    "example",
+		Version:     "1.0.0",
+		ApiVersion:  "1.0",
+		InstanceKey: "host-a",
+	}
+	first, _ := mgr.HandshakeHTTP(context.Background(), req)
+	if !first.Accepted || first.Resumed {
+		t.Fatalf("Expected new instance, got %+v", first)
+	}
+
+	// The instance went unhealthy while the plugin was away
+	if _, err := mgr.updateInstance(first.SessionId, 0, func(inst *entities.PluginInstance) error {
+		inst.Status = entities.PluginStatusUnhealthy
+		return nil
+	}); err != nil {
+		t.Fatalf("updateInstance: %v", err)
+	}
+
+	second, _ := mgr.HandshakeHTTP(context.Background(), req)
+	if !second.Accepted || !second.Resumed {
+		t.Fatalf("Expected resumed instance, got %+v", second)
+	}
+	if second.SessionId != first.SessionId {
+		t.Errorf("Expected session %s to be resumed, got %s", first.SessionId, second.SessionId)
+	}
+	if second.AuthToken == first.AuthToken {
+		t.Error("Expected auth token to be rotated")
+	}
+
+	inst, _ := repo.GetInstance(first.SessionId)
+	if inst.Status != entities.PluginStatusRunning {
+		t.Errorf("Expected resumed instance to be running, got %s", inst.Status)
+	}
+	if instances, _ := repo.ListInstances(); len(instances) != 1 {
+		t.Errorf("Expected 1 instance, got %d", len(instances))
+	}
+
+	// The old token no longer works
+	hb, _ := mgr.HeartbeatHTTP(context.Background(), &types.HeartbeatRequest{
+		SessionId: first.SessionId,
+		AuthToken: first.AuthToken,
+	})
+	if hb.Ok {
+		t.Error("Expected heartbeat with old token to be rejected")
+	}
+
+	// A different key gets its own instance
+	req.InstanceKey = "host-b"
+	third, _ := mgr.HandshakeHTTP(context.Background(), req)
+	if third.Resumed || third.SessionId == first.SessionId {
+		t.Errorf("Expected new instance for a different key, got %+v", third)
+	}
+}
+
+func TestHandshakeDoesNotTakeOverLiveInstance(t *testing.T) {
+	_, _, mgr, repo := setupTest(t)
+
+	// Two replicas on one host default to the same key
+	req := &types.HandshakeRequest{
+		PluginId:    "example",
+		Version:     "1.0.0",
+		ApiVersion:  "1.0",
+		InstanceKey: "host-a",
+	}
+	first, _ := mgr.HandshakeHTTP(context.Background(), req)
+	second, _ := mgr.HandshakeHTTP(context.Background(), req)
+	if !first.Accepted || !second.Accepted {
+		t.Fatalf("Expected both handshakes to be accepted, got %+v and %+v", first, second)
+	}
+	if second.Resumed || second.SessionId == first.SessionId {
+		t.Fatalf("Expected a new instance for the second replica, got %+v", second)
+	}
+
+	for i := 0; i < 3; i++ {
+		for _, resp := range []*types.HandshakeResponse{first, second} {
+			hb, _ := mgr.HeartbeatHTTP(context.Background(), &types.HeartbeatRequest{
+				SessionId: resp.SessionId,
+				AuthToken: resp.AuthToken,
+			})
+			if !hb.Ok {
+				t.Fatalf("Expected heartbeat of %s to be accepted, got %+v", resp.SessionId, hb)
+			}
+		}
+	}
+	if instances, _ := repo.ListInstances(); len(instances) != 2 {
+		t.Errorf("Expected 2 instances, got %d", len(instances))
+	}
+}
+
+func TestHandshakeRejectsDisabledInstance(t *testing.T) {
+	_, _, mgr, _ := setupTest(t)
+
+	req := &types.HandshakeRequest{
+		PluginId:    "example",
+		Version:     "1.0.0",
+		ApiVersion:  "1.0",
+		InstanceKey: "host-a",
+	}
+	resp, _ := mgr.HandshakeHTTP(context.Background(), req)
+	if _, err := mgr.SetInstanceEnabled(resp.SessionId, false, 0); err != nil {
+		t.Fatalf("SetInstanceEnabled: %v", err)
+	}
+
+	resp, err := mgr.HandshakeHTTP(context.Background(), req)
+	if err != nil || resp.Accepted || resp.Error != "instance disabled" {
+		t.Errorf("Expected disabled instance to be rejected, got %+v %v", resp, err)
+	}
+}
+
+func TestCollectInstances(t *testing.T) {
+	cfg, _, mgr, repo := setupTest(t)
+	cfg.Instances = config.InstancesConfig{RetireAfter: "1h", Retention: "24h"}
+
+	req := &types.HandshakeRequest{
+		PluginId:    "example",
+		Version:     "1.0.0",
+		ApiVersion:  "1.0",
+		InstanceKey: "host-a",
+	}
+	resp, _ := mgr.HandshakeHTTP(context.Background(), req)
+	live := handshake(t, mgr, "example")
+
+	old := time.Now().Add(-2 * time.Hour)
+	if _, err := mgr.updateInstance(resp.SessionId, 0, func(inst *entities.PluginInstance) error {
+		inst.Status = entities.PluginStatusUnhealthy
+		inst.LastHeartbeat = &old
+		return nil
+	}); err != nil {
+		t.Fatalf("updateInstance: %v", err)
+	}
+
+	mgr.collectInstances()
+
+	inst, _ := repo.GetInstance(resp.SessionId)
+	if inst.Status != entities.PluginStatusRetired {
+		t.Fatalf("Expected stale instance to be retired, got %s", inst.Status)
+	}
+	if inst, _ := repo.GetInstance(live.SessionId); inst.Status != entities.PluginStatusRunning {
+		t.Errorf("Expected live instance to keep running, got %s", inst.Status)
+	}
+	events, _ := repo.ListEvents(repository.EventFilter{Type: EventTypeInstanceRetired})
+	if len(events) != 1 || events[0].InstanceID != resp.SessionId {
+		t.Errorf("Expected one instance_retired event, got %d", len(events))
+	}
+
+	// Retired instances accept neither heartbeats nor resumption
+	hb, _ := mgr.HeartbeatHTTP(context.Background(), &types.HeartbeatRequest{
+		SessionId: resp.SessionId,
+		AuthToken: resp.AuthToken,
+	})
+	if hb.Ok {
+		t.Error("Expected heartbeat for retired instance to be rejected")
+	}
+	again, _ := mgr.HandshakeHTTP(context.Background(), req)
+	if again.Resumed || again.SessionId == resp.SessionId {
+		t.Errorf("Expected retired instance not to be resumed, got %+v", again)
+	}
+
+	// Deleted once the retention period has passed
+	cfg.Instances.Retention = "1ns"
+	mgr.collectInstances()
+	if _, err := repo.GetInstance(resp.SessionId); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("Expected retired instance to be deleted, got %v", err)
+	}
+}
+
+func TestSessionSurvivesRestart(t *testing.T) {
+	cfg, log, mgr, repo := setupTest(t)
+	resp := handshake(t, mgr, "example")
+	mgr.flushHeartbeats()
+
+	// A new manager over the same repository stands in for a restarted core
+	restarted := NewManager(cfg, log, repo)
+	if err := restarted.loadInstances(); err != nil {
+		t.Fatalf("loadInstances: %v", err)
+	}
+	hb, _ := restarted.HeartbeatHTTP(context.Background(), &types.HeartbeatRequest{
+		SessionId: resp.SessionId,
+		AuthToken: resp.AuthToken,
+	})
+	if !hb.Ok {
+		t.Errorf("Expected session to survive restart, got %s", hb.Message)
+	}
+}
+
+func TestHandshakeResumesSession(t *testing.T) {
+	_, _, mgr, repo := setupTest(t)
+	first := handshake(t, mgr, "example")
+
+	req := &types.HandshakeRequest{
+		PluginId:        "example",
+		Version:         "1.0.0",
+		ApiVersion:      "1.0",
+		ResumeSessionId: first.SessionId,
+		ResumeAuthToken: "wrong",
+	}
+	resp, _ := mgr.HandshakeHTTP(context.Background(), req)
+	if resp.Resumed || resp.SessionId == first.SessionId {
+		t.Errorf("Expected a wrong token not to resume the session, got %+v", resp)
+	}
+
+	req.ResumeAuthToken = first.AuthToken
+	resp, _ = mgr.HandshakeHTTP(context.Background(), req)
+	if !resp.Resumed || resp.SessionId != first.SessionId {
+		t.Errorf("Expected session %s to be resumed, got %+v", first.SessionId, resp)
+	}
+
+	// A session belongs to its plugin
+	req.PluginId = "other"
+	req.ResumeAuthToken = resp.AuthToken
+	other, _ := mgr.HandshakeHTTP(context.Background(), req)
+	if other.Resumed {
+		t.Error("Expected another plugin not to resume the session")
+	}
+
+	if instances, _ := repo.ListInstances(); len(instances) != 3 {
+		t.Errorf("Expected 3 instances, got %d", len(instances))
+	}
+}
+
+func TestInstanceStateMachine(t *testing.T) {
+	cfg, _, mgr, repo := setupTest(t)
+	cfg.Security.HeartbeatTimeout = "1ms"
+
+	resp := handshake(t, mgr, "example")
+	beat := func() {
+		t.Helper()
+		hb, _ := mgr.HeartbeatHTTP(context.Background(), &types.HeartbeatRequest{
+			SessionId: resp.SessionId,
+			AuthToken: resp.AuthToken,
+		})
+		if !hb.Ok {
+			t.Fatalf("Heartbeat rejected: %s", hb.Message)
+		}
+	}
+
+	time.Sleep(5 * time.Millisecond)
+	mgr.checkHeartbeats()
+	beat()
+
+	mgr.SetInstanceEnabled(resp.SessionId, false, 0)
+	// A heartbeat does not bring a stopped instance back
+	beat()
+	mgr.SetInstanceEnabled(resp.SessionId, true, 0)
+	beat()
+
+	history, err := mgr.InstanceHistory(resp.SessionId)
+	if err != nil {
+		t.Fatalf("InstanceHistory: %v", err)
+	}
+	want := []struct{ from, to, reason string }{
+		{"", entities.PluginStatusRunning, "handshake accepted"},
+		{entities.PluginStatusRunning, entities.PluginStatusUnhealthy, "heartbeat timeout"},
+		{entities.PluginStatusUnhealthy, entities.PluginStatusRunning, "heartbeat received"},
+		{entities.PluginStatusRunning, entities.PluginStatusStopped, "disabled by admin"},
+		{entities.PluginStatusStopped, entities.PluginStatusStarting, "enabled by admin"},
+		{entities.PluginStatusStarting, entities.PluginStatusRunning, "heartbeat received"},
+	}
+	if len(history) != len(want) {
+		t.Fatalf("Expected %d transitions, got %d", len(want), len(history))
+	}
+	for i, w := range want {
+		if history[i].From != w.from || history[i].To != w.to || history[i].Reason != w.reason {
+			t.Errorf("Transition %d: expected %s -> %s (%s), got %s -> %s (%s)", i,
+				w.from, w.to, w.reason, history[i].From, history[i].To, history[i].Reason)
+		}
+	}
+
+	events, _ := repo.ListEvents(repository.EventFilter{Type: EventTypeInstanceStateChanged, InstanceID: resp.SessionId})
+	if len(events) != len(want) {
+		t.Errorf("Expected %d state change events, got %d", len(want), len(events))
+	}
+}
+
+func TestTransitionInstanceRejectsIllegalChange(t *testing.T) {
+	_, _, mgr, repo := setupTest(t)
+	resp := handshake(t, mgr, "example")
+
+	_, err := mgr.transitionInstance(resp.SessionId, 0, "test", func(inst *entities.PluginInstance) error {
+		inst.Status = entities.PluginStatusRetired
+		return nil
+	})
+	if !errors.Is(err, errIllegalTransition) {
+		t.Errorf("Expected errIllegalTransition, got %v", err)
+	}
+	if inst, _ := repo.GetInstance(resp.SessionId); inst.Status != entities.PluginStatusRunning {
+		t.Errorf("Expected status to be unchanged, got %s", inst.Status)
+	}
+}
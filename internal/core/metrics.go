@@ -0,0 +1,271 @@
+package core
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/robrt95x/milpa-cloud/internal/domain/entities"
+	"github.com/robrt95x/milpa-cloud/internal/infrastructure/metrics"
+	"github.com/robrt95x/milpa-cloud/pkg/types"
+)
+
+// Handshake outcomes other than rejections
+const (
+	HandshakeAccepted = "accepted"
+	HandshakeResumed  = "resumed"
+)
+
+// Handshake rejection reasons
+const (
+	HandshakeShuttingDown   = "shutting_down"
+	HandshakeInvalidToken   = "invalid_token"
+	HandshakeNotAllowed     = "not_allowed"
+	HandshakeIncompatible   = "incompatible_version"
+	HandshakeInvalidRequest = "invalid_request"
+	HandshakeQuarantined    = "quarantined"
+	HandshakeDisabled       = "disabled"
+	HandshakeNotResumable   = "not_resumable"
+	HandshakeError          = "error"
+)
+
+// Plugin metrics are exposed under this prefix
+const pluginMetricPrefix = "milpa_plugin_"
+
+// Plugin metric limits
+const (
+	maxPluginMetrics      = 1000
+	maxPluginMetricLabels = 16
+)
+
+// heartbeatIntervalBuckets are the buckets of the heartbeat interval histogram
+var heartbeatIntervalBuckets = []float64{1, 2, 5, 10, 15, 20, 30, 45, 60, 120}
+
+// metricStatuses are the instance statuses always exposed, even when zero
+var metricStatuses = []string{
+	entities.PluginStatusStarting,
+	entities.PluginStatusRunning,
+	entities.PluginStatusDegraded,
+	entities.PluginStatusUnhealthy,
+	entities.PluginStatusStopping,
+	entities.PluginStatusStopped,
+	entities.PluginStatusQuarantined,
+}
+
+// managerMetrics are the metrics updated by the plugin manager
+type managerMetrics struct {
+	handshakes        *metrics.CounterVec
+	heartbeats        *metrics.CounterVec
+	heartbeatDuration *metrics.HistogramVec
+	heartbeatInterval *metrics.HistogramVec
+}
+
+// instrument registers the manager metrics, including those of its event
+// bus and the custom metrics pushed by plugins
+func (m *PluginManager) instrument(reg *metrics.Registry) {
+	m.metrics = &managerMetrics{
+		handshakes: reg.NewCounterVec("milpa_handshakes_total",
+			"Plugin handshake attempts by outcome.", "transport", "outcome"),
+		heartbeats: reg.NewCounterVec("milpa_heartbeats_total",
+			"Plugin heartbeats received by result.", "transport", "result"),
+		heartbeatDuration: reg.NewHistogramVec("milpa_heartbeat_duration_seconds",
+			"Time spent processing a heartbeat.", metrics.DefaultBuckets, "transport"),
+		heartbeatInterval: reg.NewHistogramVec("milpa_heartbeat_interval_seconds",
+			"Time between consecutive heartbeats of an instance.", heartbeatIntervalBuckets, "plugin_id"),
+	}
+	reg.NewGaugeFunc("milpa_instances", "Plugin instances by status.", m.instanceSamples)
+	reg.NewGaugeFunc("milpa_connected_instances", "Plugin instances currently connected.", func() []metrics.Sample {
+		return []metrics.Sample{{Value: float64(len(m.conns.list()))}}
+	})
+	m.eventBus.instrument(reg)
+	reg.Register(m.pluginMetrics)
+}
+
+// Metrics returns the registry served on /metrics
+func (m *PluginManager) Metrics() *metrics.Registry {
+	return m.registry
+}
+
+// instanceSamples counts the known instances per status
+func (m *PluginManager) instanceSamples() []metrics.Sample {
+	counts := m.liveness.statusCounts()
+	samples := make([]metrics.Sample, 0, len(metricStatuses))
+	for _, s := range metricStatuses {
+		samples = append(samples, metrics.Sample{
+			Labels: []metrics.Label{{Name: "status", Value: s}},
+			Value:  float64(counts[s]),
+		})
+	}
+	return samples
+}
+
+// observeHandshake counts a handshake attempt
+func (m *PluginManager) observeHandshake(transport, outcome string) {
+	m.metrics.handshakes.With(transport, outcome).Inc()
+}
+
+// observeHeartbeat records the processing time and result of a heartbeat
+func (m *PluginManager) observeHeartbeat(transport string, start time.Time, err error) {
+	result := "ok"
+	switch {
+	case err == errSessionNotFound:
+		result = "session_not_found"
+	case err == errInvalidAuthToken:
+		result = "invalid_auth_token"
+	case err != nil:
+		result = "error"
+	}
+	m.metrics.heartbeats.With(transport, result).Inc()
+	m.metrics.heartbeatDuration.With(transport).Observe(time.Since(start).Seconds())
+}
+
+// pluginMetricSet is the latest set of custom metrics of one instance
+type pluginMetricSet struct {
+	pluginID string
+	metrics  []types.Metric
+}
+
+// pluginMetricStore keeps the custom metrics pushed by plugins with their
+// heartbeats; each heartbeat replaces the instance's previous set
+type pluginMetricStore struct {
+	mu        sync.RWMutex
+	instances map[string]*pluginMetricSet
+}
+
+func newPluginMetricStore() *pluginMetricStore {
+	return &pluginMetricStore{
+		instances: make(map[string]*pluginMetricSet),
+	}
+}
+
+// set replaces the metrics of an instance, dropping invalid ones, repeats
+// of a name and label set, and those beyond maxPluginMetrics
+// It returns the number of metrics dropped.
+func (s *pluginMetricStore) set(instanceID, pluginID string, in []types.Metric) int {
+	valid := make([]types.Metric, 0, min(len(in), maxPluginMetrics))
+	seen := make(map[string]bool, len(in))
+	for _, mt := range in {
+		if len(valid) == maxPluginMetrics {
+			break
+		}
+		if !validPluginMetric(mt) {
+			continue
+		}
+		key := pluginMetricKey(mt)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		valid = append(valid, mt)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.instances[instanceID] = &pluginMetricSet{pluginID: pluginID, metrics: valid}
+	return len(in) - len(valid)
+}
+
+// forget drops the metrics of an instance
+func (s *pluginMetricStore) forget(instanceID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.instances, instanceID)
+}
+
+// validPluginMetric reports whether a plugin metric can be exposed
+func validPluginMetric(mt types.Metric) bool {
+	if mt.Type != types.MetricCounter && mt.Type != types.MetricGauge {
+		return false
+	}
+	if len(mt.Labels) > maxPluginMetricLabels {
+		return false
+	}
+	if !metrics.ValidMetricName(pluginMetricPrefix + mt.Name) {
+		return false
+	}
+	for name := range mt.Labels {
+		if !metrics.ValidLabelName(name) || name == "plugin_id" || name == "instance_id" {
+			return false
+		}
+	}
+	return true
+}
+
+// pluginMetricKey identifies the series of a plugin metric
+func pluginMetricKey(mt types.Metric) string {
+	names := make([]string, 0, len(mt.Labels))
+	for name := range mt.Labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	b.WriteString(mt.Name)
+	for _, name := range names {
+		fmt.Fprintf(&b, ",%s=%q", name, mt.Labels[name])
+	}
+	return b.String()
+}
+
+// Collect exposes the plugin metrics, grouped by name
+// A name reported with different types by different instances keeps the
+// type seen first.
+func (s *pluginMetricStore) Collect() []metrics.Family {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	ids := make([]string, 0, len(s.instances))
+	for id := range s.instances {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	families := make(map[string]*metrics.Family)
+	var order []string
+	for _, id := range ids {
+		set := s.instances[id]
+		for _, mt := range set.metrics {
+			name := pluginMetricPrefix + mt.Name
+			f, ok := families[name]
+			if !ok {
+				f = &metrics.Family{Name: name, Help: mt.Help, Type: mt.Type}
+				families[name] = f
+				order = append(order, name)
+			}
+			if f.Type != mt.Type {
+				continue
+			}
+			f.Samples = append(f.Samples, metrics.Sample{
+				Labels: pluginMetricLabels(set.pluginID, id, mt.Labels),
+				Value:  mt.Value,
+			})
+		}
+	}
+
+	out := make([]metrics.Family, 0, len(order))
+	for _, name := range order {
+		out = append(out, *families[name])
+	}
+	return out
+}
+
+// pluginMetricLabels returns the labels of a plugin metric sample
+func pluginMetricLabels(pluginID, instanceID string, labels map[string]string) []metrics.Label {
+	out := []metrics.Label{
+		{Name: "plugin_id", Value: pluginID},
+		{Name: "instance_id", Value: instanceID},
+	}
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		out = append(out, metrics.Label{Name: name, Value: labels[name]})
+	}
+	return out
+}
@@ -0,0 +1,205 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/robrt95x/milpa-cloud/internal/domain/entities"
+	"github.com/robrt95x/milpa-cloud/internal/infrastructure/config"
+	"github.com/robrt95x/milpa-cloud/pkg/types"
+)
+
+// scrape returns the /metrics output of a server
+func scrape(t *testing.T, server *HTTPServer) string {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	server.routes().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200 from /metrics, got %d", w.Code)
+	}
+	return w.Body.String()
+}
+
+func expectMetrics(t *testing.T, body string, lines ...string) {
+	t.Helper()
+
+	for _, line := range lines {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("Expected %q in metrics output:\n%s", line, body)
+		}
+	}
+}
+
+func TestMetricsEndpoint(t *testing.T) {
+	cfg, log, mgr, _ := setupTest(t)
+	server := NewHTTPServer(cfg, log, mgr)
+
+	resp := handshake(t, mgr, "example")
+	mgr.HandshakeHTTP(context.Background(), &types.HandshakeRequest{PluginId: "old", ApiVersion: "0.1"})
+	mgr.SubscribePlugin(resp.SessionId)
+	mgr.SendEventToPlugin(resp.SessionId, EventTypeRestart, "")
+	mgr.SendEventToPlugin("missing", EventTypeRestart, "")
+
+	for i := 0; i < 2; i++ {
+		mgr.HeartbeatHTTP(context.Background(), &types.HeartbeatRequest{
+			SessionId: resp.SessionId,
+			AuthToken: resp.AuthToken,
+		})
+	}
+	mgr.HeartbeatHTTP(context.Background(), &types.HeartbeatRequest{SessionId: resp.SessionId, AuthToken: "wrong"})
+
+	w := httptest.NewRecorder()
+	server.routes().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/plugins/instances/"+resp.SessionId+"/history", nil))
+
+	body := scrape(t, server)
+	expectMetrics(t, body,
+		`milpa_handshakes_total{transport="http",outcome="accepted"} 1`,
+		`milpa_handshakes_total{transport="http",outcome="incompatible_version"} 1`,
+		`milpa_heartbeats_total{transport="http",result="ok"} 2`,
+		`milpa_heartbeats_total{transport="http",result="invalid_auth_token"} 1`,
+		`milpa_heartbeat_duration_seconds_count{transport="http"} 3`,
+		// The handshake counts as the first heartbeat
+		`milpa_heartbeat_interval_seconds_count{plugin_id="example"} 2`,
+		`milpa_instances{status="running"} 1`,
+		`milpa_instances{status="stopped"} 0`,
+		`milpa_events_published_total{type="restart"} 1`,
+		`milpa_events_dropped_total{type="restart",reason="plugin_not_found"} 1`,
+		`milpa_event_bus_queue_length{queue="subscriber",instance_id="`+resp.SessionId+`"} 1`,
+		`milpa_event_bus_queue_capacity{queue="subscriber",instance_id="`+resp.SessionId+`"} 50`,
+		`milpa_http_requests_total{route="/api/v1/plugins/instances/:id/history",method="GET",code="200"} 1`,
+	)
+	if strings.Contains(body, `queue="broadcast"`) {
+		t.Error("Expected no broadcast queue series")
+	}
+}
+
+func TestHeartbeatIntervalForgottenWithPlugin(t *testing.T) {
+	cfg, log, mgr, _ := setupTest(t)
+	cfg.Instances = config.InstancesConfig{RetireAfter: "1ns", Retention: "24h"}
+	server := NewHTTPServer(cfg, log, mgr)
+	resp := handshake(t, mgr, "example")
+	mgr.HeartbeatHTTP(context.Background(), &types.HeartbeatRequest{SessionId: resp.SessionId, AuthToken: resp.AuthToken})
+
+	expectMetrics(t, scrape(t, server), `milpa_heartbeat_interval_seconds_count{plugin_id="example"} 1`)
+
+	if _, err := mgr.updateInstance(resp.SessionId, 0, func(inst *entities.PluginInstance) error {
+		inst.Status = entities.PluginStatusUnhealthy
+		return nil
+	}); err != nil {
+		t.Fatalf("updateInstance: %v", err)
+	}
+	time.Sleep(time.Millisecond)
+	mgr.collectInstances()
+
+	if body := scrape(t, server); strings.Contains(body, `plugin_id="example"`) {
+		t.Errorf("Expected the series of a plugin without instances to be dropped:\n%s", body)
+	}
+}
+
+func TestPluginMetrics(t *testing.T) {
+	cfg, log, mgr, _ := setupTest(t)
+	server := NewHTTPServer(cfg, log, mgr)
+	resp := handshake(t, mgr, "example")
+
+	heartbeat := func(metrics ...types.Metric) {
+		t.Helper()
+		hb, _ := mgr.HeartbeatHTTP(context.Background(), &types.HeartbeatRequest{
+			SessionId: resp.SessionId,
+			AuthToken: resp.AuthToken,
+			Metrics:   metrics,
+		})
+		if !hb.Ok {
+			t.Fatalf("Heartbeat rejected: %s", hb.Message)
+		}
+	}
+	heartbeat(
+		types.Metric{Name: "jobs_total", Type: types.MetricCounter, Help: "Jobs run.", Value: 3,
+			Labels: map[string]string{"queue": "default"}},
+		types.Metric{Name: "bad-name", Type: types.MetricGauge, Value: 1},
+		types.Metric{Name: "spoofed", Type: types.MetricGauge, Labels: map[string]string{"plugin_id": "other"}},
+		types.Metric{Name: "summary", Type: "summary", Value: 1},
+	)
+
+	body := scrape(t, server)
+	expectMetrics(t, body,
+		"# HELP milpa_plugin_jobs_total Jobs run.",
+		"# TYPE milpa_plugin_jobs_total counter",
+		`milpa_plugin_jobs_total{plugin_id="example",instance_id="`+resp.SessionId+`",queue="default"} 3`,
+	)
+	for _, name := range []string{"bad", "spoofed", "summary"} {
+		if strings.Contains(body, "milpa_plugin_"+name) {
+			t.Errorf("Expected invalid metric %s to be dropped", name)
+		}
+	}
+
+	// A heartbeat replaces the previous set; one without metrics keeps it
+	heartbeat()
+	heartbeat(types.Metric{Name: "queue_depth", Type: types.MetricGauge, Value: 7})
+	body = scrape(t, server)
+	if strings.Contains(body, "milpa_plugin_jobs_total") {
+		t.Error("Expected metrics missing from the latest heartbeat to be dropped")
+	}
+	expectMetrics(t, body, `milpa_plugin_queue_depth{plugin_id="example",instance_id="`+resp.SessionId+`"} 7`)
+}
+
+func TestPluginMetricLimits(t *testing.T) {
+	store := newPluginMetricStore()
+
+	// Repeats of a name and label set are dropped, whatever the label order
+	dropped := store.set("inst-1", "example",
+		[]types.Metric{
+			{Name: "jobs_total", Type: types.MetricCounter, Value: 1, Labels: map[string]string{"queue": "a", "kind": "x"}},
+			{Name: "jobs_total", Type: types.MetricCounter, Value: 2, Labels: map[string]string{"kind": "x", "queue": "a"}},
+			{Name: "jobs_total", Type: types.MetricCounter, Value: 3, Labels: map[string]string{"queue": "b", "kind": "x"}},
+		})
+	families := store.Collect()
+	if dropped != 1 || len(families) != 1 || len(families[0].Samples) != 2 {
+		t.Fatalf("Expected the duplicate series to be dropped, got %d dropped and %+v", dropped, families)
+	}
+	if families[0].Samples[0].Value != 1 {
+		t.Errorf("Expected the first of the duplicates to be kept, got %v", families[0].Samples[0].Value)
+	}
+
+	// Series beyond the cap and metrics with too many labels are dropped
+	in := make([]types.Metric, 0, maxPluginMetrics+10)
+	labels := make(map[string]string, maxPluginMetricLabels+1)
+	for i := 0; i <= maxPluginMetricLabels; i++ {
+		labels[fmt.Sprintf("l%d", i)] = "v"
+	}
+	in = append(in, types.Metric{Name: "wide", Type: types.MetricGauge, Labels: labels})
+	for i := 0; i < maxPluginMetrics+9; i++ {
+		in = append(in, types.Metric{Name: "queue_depth", Type: types.MetricGauge,
+			Labels: map[string]string{"queue": fmt.Sprint(i)}})
+	}
+	dropped = store.set("inst-1", "example", in)
+	families = store.Collect()
+	if dropped != 10 || len(families) != 1 || len(families[0].Samples) != maxPluginMetrics {
+		t.Errorf("Expected %d series and 10 dropped, got %d dropped", maxPluginMetrics, dropped)
+	}
+}
+
+func TestMetricRoute(t *testing.T) {
+	tests := []struct {
+		pattern, path string
+		status        int
+		want          string
+	}{
+		{"/api/v1/heartbeat", "/api/v1/heartbeat", 200, "/api/v1/heartbeat"},
+		{"/api/v1/plugins/", "/api/v1/plugins/example", 200, "/api/v1/plugins/:id"},
+		{"/api/v1/plugins/", "/api/v1/plugins/example/enabled", 200, "/api/v1/plugins/:id/enabled"},
+		{"/api/v1/plugins/", "/api/v1/plugins/example/random", 404, "/api/v1/plugins/:id/*"},
+		{"/api/v1/plugins/", "/api/v1/plugins/a/b/c", 200, "/api/v1/plugins/:id/*"},
+		{"", "/nope", 404, "unmatched"},
+	}
+	for _, tt := range tests {
+		if got := metricRoute(tt.pattern, tt.path, tt.status); got != tt.want {
+			t.Errorf("metricRoute(%q, %q, %d) = %q, want %q", tt.pattern, tt.path, tt.status, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package core
+
+import (
+	"context"
+	"crypto/subtle"
+	"time"
+
+	"github.com/robrt95x/milpa-cloud/internal/domain/entities"
+	"github.com/robrt95x/milpa-cloud/pkg/tracing"
+	"github.com/robrt95x/milpa-cloud/pkg/types"
+)
+
+// Event polling limits
+const (
+	defaultPollWait = 25 * time.Second
+	maxPollWait     = 30 * time.Second
+	maxPollEvents   = 50
+)
+
+// authenticate checks a session ID and auth token against the liveness table
+func (m *PluginManager) authenticate(sessionID, authToken string) error {
+	entry, ok := m.liveness.get(sessionID)
+	if !ok {
+		inst, err := m.repo.GetInstance(sessionID)
+		if err != nil || inst.Status == entities.PluginStatusRetired {
+			return errSessionNotFound
+		}
+		m.liveness.sync(inst)
+		entry, _ = m.liveness.get(sessionID)
+	}
+	if entry.Status == entities.PluginStatusRetired {
+		return errSessionNotFound
+	}
+	if subtle.ConstantTimeCompare([]byte(entry.AuthToken), []byte(authToken)) != 1 {
+		return errInvalidAuthToken
+	}
+	return nil
+}
+
+// PollEvents waits up to WaitMs for events addressed to a plugin and returns
+// them with any others already queued. It is the HTTP counterpart of the
+// gRPC event stream; both read the instance's event bus subscription.
+func (m *PluginManager) PollEvents(ctx context.Context, req *types.EventPollRequest) *types.EventPollResponse {
+	if err := m.authenticate(req.SessionId, req.AuthToken); err != nil {
+		return &types.EventPollResponse{Ok: false, Error: err.Error()}
+	}
+
+	wait := time.Duration(req.WaitMs) * time.Millisecond
+	if wait <= 0 {
+		wait = defaultPollWait
+	}
+	if wait > maxPollWait {
+		wait = maxPollWait
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	events := m.eventBus.Attach(req.SessionId)
+	resp := &types.EventPollResponse{Ok: true, Events: []types.CoreEvent{}}
+	select {
+	case ev, ok := <-events:
+		if !ok {
+			return resp
+		}
+		resp.Events = append(resp.Events, types.CoreEvent{Type: ev.Type, Data: ev.Data, TraceParent: ev.TraceParent})
+	case <-timer.C:
+		return resp
+	case <-ctx.Done():
+		return resp
+	}
+
+	for len(resp.Events) < maxPollEvents {
+		select {
+		case ev, ok := <-events:
+			if !ok {
+				return resp
+			}
+			resp.Events = append(resp.Events, types.CoreEvent{Type: ev.Type, Data: ev.Data, TraceParent: ev.TraceParent})
+		default:
+			return resp
+		}
+	}
+	return resp
+}
+
+// SendPluginEvent handles an event a plugin sends over HTTP
+// The event continues the trace of ctx (the request's traceparent).
+func (m *PluginManager) SendPluginEvent(ctx context.Context, req *types.PluginEventRequest) *types.PluginEventResponse {
+	if err := m.authenticate(req.SessionId, req.AuthToken); err != nil {
+		return &types.PluginEventResponse{Ok: false, Error: err.Error()}
+	}
+	m.handlePluginEvent(ctx, req.SessionId, &PluginEvent{
+		SessionId: req.SessionId,
+		Type:      req.Type,
+		Data:      req.Data,
+	})
+	return &types.PluginEventResponse{Ok: true}
+}
+
+// handlePluginEvent dispatches an event received from an authenticated
+// plugin, over HTTP or the gRPC stream
+// The event's traceparent, if set, takes precedence over the trace of ctx.
+func (m *PluginManager) handlePluginEvent(ctx context.Context, sessionID string, ev *PluginEvent) {
+	ctx = tracing.ContextWithTraceParent(ctx, ev.TraceParent)
+	_, span := m.tracer.Start(ctx, "plugin event "+ev.Type, tracing.SpanKindConsumer,
+		"milpa.instance_id", sessionID, "milpa.event.type", ev.Type)
+	defer span.End()
+
+	switch ev.Type {
+	case EventTypeShutdownAck:
+		m.acknowledgeShutdown(sessionID)
+	case EventTypeStatusReply:
+		m.statusReply(sessionID, ev.Data)
+	case EventTypeLogs:
+		m.pluginLogs(sessionID, ev.Data)
+	default:
+		m.log.Debug("ignoring plugin event", "instance_id", sessionID, "type", ev.Type)
+	}
+}
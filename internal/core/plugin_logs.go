@@ -0,0 +1,240 @@
+package core
+
+import (
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/robrt95x/milpa-cloud/pkg/types"
+)
+
+// Plugin log limits
+const (
+	defaultLogsPerInstance = 1000
+	maxLogBatch            = 1000
+	maxLogMessage          = 8 << 10
+	defaultLogQueryLimit   = 100
+)
+
+// LogEntry is a log record stored for an instance
+// Seq increases with every record the core stores, across instances
+type LogEntry struct {
+	Seq        int64  `json:"seq"`
+	InstanceID string `json:"instance_id"`
+	types.LogRecord
+}
+
+// LogQuery filters the stored records of an instance
+type LogQuery struct {
+	// Level is the minimum level (trace, debug, info, warn, error)
+	Level string
+	Since time.Time
+	Until time.Time
+	// AfterSeq skips records up to and including this sequence number
+	AfterSeq int64
+	// Limit keeps the most recent matching records (0 for all)
+	Limit int
+}
+
+// logRanks orders levels; unknown levels rank as info
+var logRanks = map[string]int{"trace": 0, "debug": 1, "info": 2, "warn": 3, "warning": 3, "error": 4}
+
+func logRank(level string) int {
+	if r, ok := logRanks[strings.ToLower(level)]; ok {
+		return r
+	}
+	return logRanks["info"]
+}
+
+// validLogLevel reports whether level can be used as a query filter
+func validLogLevel(level string) bool {
+	_, ok := logRanks[strings.ToLower(level)]
+	return ok
+}
+
+func (q *LogQuery) matches(e *LogEntry) bool {
+	if e.Seq <= q.AfterSeq {
+		return false
+	}
+	if q.Level != "" && logRank(e.Level) < logRank(q.Level) {
+		return false
+	}
+	if !q.Since.IsZero() && e.Time.Before(q.Since) {
+		return false
+	}
+	if !q.Until.IsZero() && e.Time.After(q.Until) {
+		return false
+	}
+	return true
+}
+
+// logRing keeps the most recent records of an instance
+type logRing struct {
+	entries []LogEntry
+	next    int
+	full    bool
+}
+
+func (r *logRing) add(e LogEntry) {
+	r.entries[r.next] = e
+	r.next = (r.next + 1) % len(r.entries)
+	if r.next == 0 {
+		r.full = true
+	}
+}
+
+// each calls fn for every record, oldest first
+func (r *logRing) each(fn func(*LogEntry)) {
+	if r.full {
+		for i := r.next; i < len(r.entries); i++ {
+			fn(&r.entries[i])
+		}
+	}
+	for i := 0; i < r.next; i++ {
+		fn(&r.entries[i])
+	}
+}
+
+// logStore holds the recent logs forwarded by each instance and wakes up
+// followers when new records arrive
+type logStore struct {
+	mu       sync.Mutex
+	capacity int
+	seq      int64
+	rings    map[string]*logRing
+	watchers map[string]map[chan struct{}]struct{}
+}
+
+func newLogStore(capacity int) *logStore {
+	if capacity <= 0 {
+		capacity = defaultLogsPerInstance
+	}
+	return &logStore{
+		capacity: capacity,
+		rings:    make(map[string]*logRing),
+		watchers: make(map[string]map[chan struct{}]struct{}),
+	}
+}
+
+// add stores records of an instance, dropping its oldest ones beyond capacity
+func (s *logStore) add(instanceID string, records []types.LogRecord) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ring, ok := s.rings[instanceID]
+	if !ok {
+		ring = &logRing{entries: make([]LogEntry, s.capacity)}
+		s.rings[instanceID] = ring
+	}
+	now := time.Now()
+	for _, rec := range records {
+		if rec.Time.IsZero() {
+			rec.Time = now
+		}
+		if len(rec.Message) > maxLogMessage {
+			rec.Message = rec.Message[:maxLogMessage]
+		}
+		rec.Level = strings.ToLower(rec.Level)
+		s.seq++
+		ring.add(LogEntry{Seq: s.seq, InstanceID: instanceID, LogRecord: rec})
+	}
+
+	for ch := range s.watchers[instanceID] {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// query returns the matching records of an instance, oldest first
+func (s *logStore) query(instanceID string, q LogQuery) []LogEntry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	out := []LogEntry{}
+	ring, ok := s.rings[instanceID]
+	if !ok {
+		return out
+	}
+	ring.each(func(e *LogEntry) {
+		if q.matches(e) {
+			out = append(out, *e)
+		}
+	})
+	if q.Limit > 0 && len(out) > q.Limit {
+		out = out[len(out)-q.Limit:]
+	}
+	return out
+}
+
+// watch returns a channel signalled when records of an instance are added
+// The returned function stops watching
+func (s *logStore) watch(instanceID string) (<-chan struct{}, func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ch := make(chan struct{}, 1)
+	if s.watchers[instanceID] == nil {
+		s.watchers[instanceID] = make(map[chan struct{}]struct{})
+	}
+	s.watchers[instanceID][ch] = struct{}{}
+
+	return ch, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		delete(s.watchers[instanceID], ch)
+		if len(s.watchers[instanceID]) == 0 {
+			delete(s.watchers, instanceID)
+		}
+	}
+}
+
+// forget drops the records of an instance
+func (s *logStore) forget(instanceID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.rings, instanceID)
+}
+
+// ForwardLogs stores log records a plugin sends over HTTP
+func (m *PluginManager) ForwardLogs(req *types.LogBatchRequest) *types.LogBatchResponse {
+	if err := m.authenticate(req.SessionId, req.AuthToken); err != nil {
+		return &types.LogBatchResponse{Ok: false, Error: err.Error()}
+	}
+	if len(req.Records) > maxLogBatch {
+		return &types.LogBatchResponse{Ok: false, Error: "too many records in batch"}
+	}
+	m.logs.add(req.SessionId, req.Records)
+	return &types.LogBatchResponse{Ok: true, Accepted: len(req.Records)}
+}
+
+// pluginLogs stores a logs event received over the event stream
+func (m *PluginManager) pluginLogs(sessionID, data string) {
+	var records []types.LogRecord
+	if err := json.Unmarshal([]byte(data), &records); err != nil {
+		m.log.Warn("invalid logs event", "instance_id", sessionID, "error", err)
+		return
+	}
+	if len(records) > maxLogBatch {
+		records = records[len(records)-maxLogBatch:]
+	}
+	m.logs.add(sessionID, records)
+}
+
+// InstanceLogs returns the stored records of an instance matching q
+func (m *PluginManager) InstanceLogs(id string, q LogQuery) ([]LogEntry, error) {
+	if _, err := m.repo.GetInstance(id); err != nil {
+		return nil, err
+	}
+	return m.logs.query(id, q), nil
+}
+
+// WatchInstanceLogs returns a channel signalled when an instance forwards
+// new records; call the returned function to stop watching
+func (m *PluginManager) WatchInstanceLogs(id string) (<-chan struct{}, func()) {
+	return m.logs.watch(id)
+}
@@ -0,0 +1,143 @@
+package core
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/robrt95x/milpa-cloud/pkg/types"
+)
+
+func forwardLogs(t *testing.T, mgr *PluginManager, resp *types.HandshakeResponse, records ...types.LogRecord) {
+	t.Helper()
+
+	out := mgr.ForwardLogs(&types.LogBatchRequest{
+		SessionId: resp.SessionId,
+		AuthToken: resp.AuthToken,
+		Records:   records,
+	})
+	if !out.Ok || out.Accepted != len(records) {
+		t.Fatalf("ForwardLogs rejected: %+v", out)
+	}
+}
+
+func TestLogStoreCapacity(t *testing.T) {
+	store := newLogStore(3)
+	for _, msg := range []string{"a", "b", "c", "d"} {
+		store.add("inst-1", []types.LogRecord{{Level: "INFO", Message: msg}})
+	}
+
+	got := store.query("inst-1", LogQuery{})
+	if len(got) != 3 || got[0].Message != "b" || got[2].Message != "d" {
+		t.Fatalf("Expected the 3 most recent records, got %+v", got)
+	}
+	if got[0].Level != "info" || got[0].Time.IsZero() {
+		t.Errorf("Expected level to be normalized and time to be set, got %+v", got[0])
+	}
+	if got := store.query("inst-1", LogQuery{Limit: 1}); len(got) != 1 || got[0].Message != "d" {
+		t.Errorf("Expected limit to keep the most recent record, got %+v", got)
+	}
+	if got := store.query("inst-2", LogQuery{}); len(got) != 0 {
+		t.Errorf("Expected no records for another instance, got %+v", got)
+	}
+}
+
+func TestInstanceLogsHTTP(t *testing.T) {
+	cfg, log, mgr, _ := setupTest(t)
+	server := NewHTTPServer(cfg, log, mgr)
+	resp := handshake(t, mgr, "example")
+
+	if out := mgr.ForwardLogs(&types.LogBatchRequest{SessionId: resp.SessionId, AuthToken: "wrong"}); out.Ok {
+		t.Error("Expected logs with a wrong token to be rejected")
+	}
+
+	base := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	forwardLogs(t, mgr, resp,
+		types.LogRecord{Time: base, Level: "debug", Message: "polling"},
+		types.LogRecord{Time: base.Add(time.Minute), Level: "warn", Message: "slow upstream", Fields: map[string]string{"ms": "900"}},
+		types.LogRecord{Time: base.Add(2 * time.Minute), Level: "error", Message: "upstream down"},
+	)
+	// Records sent over the event stream are stored the same way
+	data, _ := json.Marshal([]types.LogRecord{{Time: base.Add(3 * time.Minute), Level: "info", Message: "recovered"}})
+	mgr.handlePluginEvent(context.Background(), resp.SessionId, &PluginEvent{Type: EventTypeLogs, Data: string(data)})
+
+	get := func(query string) InstanceLogsResponse {
+		t.Helper()
+		w := httptest.NewRecorder()
+		server.routes().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/plugins/instances/"+resp.SessionId+"/logs"+query, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: expected 200, got %d: %s", query, w.Code, w.Body.String())
+		}
+		var out InstanceLogsResponse
+		json.NewDecoder(w.Body).Decode(&out)
+		return out
+	}
+
+	if out := get(""); len(out.Records) != 4 || out.Records[3].Message != "recovered" {
+		t.Errorf("Expected all records, got %+v", out.Records)
+	}
+	if out := get("?level=warn"); len(out.Records) != 2 || out.Records[0].Fields["ms"] != "900" {
+		t.Errorf("Expected warn and error records, got %+v", out.Records)
+	}
+	if out := get("?since=2026-01-01T12:01:00Z&until=2026-01-01T12:02:00Z"); len(out.Records) != 2 {
+		t.Errorf("Expected records within the time range, got %+v", out.Records)
+	}
+
+	for _, query := range []string{"?level=loud", "?since=yesterday", "?limit=0"} {
+		w := httptest.NewRecorder()
+		server.routes().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/plugins/instances/"+resp.SessionId+"/logs"+query, nil))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", query, w.Code)
+		}
+	}
+	w := httptest.NewRecorder()
+	server.routes().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/plugins/instances/missing/logs", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unknown instance, got %d", w.Code)
+	}
+}
+
+func TestFollowInstanceLogs(t *testing.T) {
+	cfg, log, mgr, _ := setupTest(t)
+	server := NewHTTPServer(cfg, log, mgr)
+	srv := httptest.NewServer(server.routes())
+	defer srv.Close()
+	resp := handshake(t, mgr, "example")
+
+	forwardLogs(t, mgr, resp, types.LogRecord{Level: "info", Message: "before"})
+
+	res, err := http.Get(srv.URL + "/api/v1/plugins/instances/" + resp.SessionId + "/logs?follow=true&level=info")
+	if err != nil {
+		t.Fatalf("GET: %v", err)
+	}
+	defer res.Body.Close()
+	if ct := res.Header.Get("Content-Type"); ct != "application/x-ndjson" {
+		t.Errorf("Expected ndjson, got %q", ct)
+	}
+
+	lines := bufio.NewScanner(res.Body)
+	next := func() LogEntry {
+		t.Helper()
+		if !lines.Scan() {
+			t.Fatalf("Stream ended: %v", lines.Err())
+		}
+		var e LogEntry
+		json.Unmarshal(lines.Bytes(), &e)
+		return e
+	}
+
+	if e := next(); e.Message != "before" {
+		t.Errorf("Expected the existing record first, got %+v", e)
+	}
+	forwardLogs(t, mgr, resp,
+		types.LogRecord{Level: "debug", Message: "filtered"},
+		types.LogRecord{Level: "error", Message: "after"},
+	)
+	if e := next(); e.Message != "after" {
+		t.Errorf("Expected the new record matching the level, got %+v", e)
+	}
+}
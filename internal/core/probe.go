@@ -0,0 +1,298 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/robrt95x/milpa-cloud/internal/domain/entities"
+	"github.com/robrt95x/milpa-cloud/pkg/types"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/peer"
+)
+
+// maxConcurrentProbes bounds the probes run at once in one round
+const maxConcurrentProbes = 16
+
+// ProbeStatus is the runtime state of an instance's health probe
+type ProbeStatus struct {
+	Protocol            string     `json:"protocol"`
+	Address             string     `json:"address"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	LastError           string     `json:"last_error,omitempty"`
+	LastProbe           *time.Time `json:"last_probe,omitempty"`
+}
+
+// probeTarget is a registered health endpoint and its recent results
+type probeTarget struct {
+	addr      string
+	spec      entities.ProbeSpec
+	failures  int
+	lastErr   string
+	lastProbe time.Time
+}
+
+// probeTable tracks the health endpoints actively probed by the core
+// Results live in memory only; a restart starts every count from zero
+type probeTable struct {
+	mu      sync.RWMutex
+	targets map[string]*probeTarget
+}
+
+func newProbeTable() *probeTable {
+	return &probeTable{
+		targets: make(map[string]*probeTarget),
+	}
+}
+
+// register starts probing an instance's endpoint, or stops when it has none
+// Registering again resets the failure count
+func (t *probeTable) register(inst *entities.PluginInstance) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if inst.Probe == nil || inst.Port == 0 {
+		delete(t.targets, inst.ID)
+		return
+	}
+	t.targets[inst.ID] = &probeTarget{
+		addr: net.JoinHostPort(inst.Host, strconv.Itoa(inst.Port)),
+		spec: *inst.Probe,
+	}
+}
+
+// forget stops probing an instance
+func (t *probeTable) forget(id string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.targets, id)
+}
+
+// snapshot returns a copy of every registered target
+func (t *probeTable) snapshot() map[string]probeTarget {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	targets := make(map[string]probeTarget, len(t.targets))
+	for id, target := range t.targets {
+		targets[id] = *target
+	}
+	return targets
+}
+
+// record stores a probe result and returns the consecutive failure count
+func (t *probeTable) record(id string, err error, at time.Time) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	target, ok := t.targets[id]
+	if !ok {
+		return 0
+	}
+	target.lastProbe = at
+	if err == nil {
+		target.failures = 0
+		target.lastErr = ""
+		return 0
+	}
+	target.failures++
+	target.lastErr = err.Error()
+	return target.failures
+}
+
+// failing reports whether an instance's probe reached the failure threshold
+func (t *probeTable) failing(id string, threshold int) bool {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	target, ok := t.targets[id]
+	return ok && target.failures >= threshold
+}
+
+// status returns the probe state of an instance
+func (t *probeTable) status(id string) (*ProbeStatus, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	target, ok := t.targets[id]
+	if !ok {
+		return nil, false
+	}
+	s := &ProbeStatus{
+		Protocol:            target.spec.Protocol,
+		Address:             target.addr,
+		ConsecutiveFailures: target.failures,
+		LastError:           target.lastErr,
+	}
+	if !target.lastProbe.IsZero() {
+		at := target.lastProbe
+		s.LastProbe = &at
+	}
+	return s, true
+}
+
+// probeEndpoint validates a health endpoint sent at handshake and returns
+// the host, port and spec to store on the instance. A nil endpoint returns
+// a nil spec. The host must be the address the plugin connected from or
+// match allowedHosts, so the core cannot be used to probe arbitrary hosts.
+func probeEndpoint(ctx context.Context, ep *types.HealthEndpoint, allowedHosts []string) (string, int, *entities.ProbeSpec, error) {
+	if ep == nil {
+		return "", 0, nil, nil
+	}
+	if ep.Protocol != types.HealthProtocolHTTP && ep.Protocol != types.HealthProtocolGRPC {
+		return "", 0, nil, fmt.Errorf("unsupported health endpoint protocol %q", ep.Protocol)
+	}
+	if ep.Port <= 0 || ep.Port > 65535 {
+		return "", 0, nil, fmt.Errorf("invalid health endpoint port %d", ep.Port)
+	}
+
+	peerAddr := peerHost(ctx)
+	host := ep.Host
+	if host == "" {
+		host = peerAddr
+	}
+	if host == "" {
+		return "", 0, nil, fmt.Errorf("health endpoint host is required")
+	}
+	if !sameHost(host, peerAddr) && !hostAllowed(host, allowedHosts) {
+		return "", 0, nil, fmt.Errorf("health endpoint host %q is not the plugin's address", host)
+	}
+
+	spec := &entities.ProbeSpec{Protocol: ep.Protocol}
+	switch ep.Protocol {
+	case types.HealthProtocolHTTP:
+		spec.Path = ep.Path
+		if spec.Path == "" {
+			spec.Path = "/healthz"
+		}
+	case types.HealthProtocolGRPC:
+		spec.Service = ep.Service
+	}
+	return host, ep.Port, spec, nil
+}
+
+// sameHost reports whether two hosts are the same name or IP
+func sameHost(a, b string) bool {
+	if a == "" || b == "" {
+		return false
+	}
+	if ipA, ipB := net.ParseIP(a), net.ParseIP(b); ipA != nil && ipB != nil {
+		return ipA.Equal(ipB)
+	}
+	return strings.EqualFold(a, b)
+}
+
+// hostAllowed reports whether host matches one of the allowed hosts, IPs
+// or CIDRs
+func hostAllowed(host string, allowed []string) bool {
+	ip := net.ParseIP(host)
+	for _, a := range allowed {
+		if _, cidr, err := net.ParseCIDR(a); err == nil {
+			if ip != nil && cidr.Contains(ip) {
+				return true
+			}
+			continue
+		}
+		if sameHost(host, a) {
+			return true
+		}
+	}
+	return false
+}
+
+type peerAddrKey struct{}
+
+// withPeerAddr records the remote address of an HTTP request for the handshake
+func withPeerAddr(ctx context.Context, addr string) context.Context {
+	return context.WithValue(ctx, peerAddrKey{}, addr)
+}
+
+// unixPeerHost is the host of a plugin connected over a unix socket, which
+// runs on the core's machine
+const unixPeerHost = "127.0.0.1"
+
+// peerHost returns the remote host of an HTTP or gRPC request
+func peerHost(ctx context.Context) string {
+	if local, ok := ctx.Value(http.LocalAddrContextKey).(net.Addr); ok && local.Network() == "unix" {
+		return unixPeerHost
+	}
+	addr, ok := ctx.Value(peerAddrKey{}).(string)
+	if !ok {
+		p, ok := peer.FromContext(ctx)
+		if !ok {
+			return ""
+		}
+		if p.Addr.Network() == "unix" {
+			return unixPeerHost
+		}
+		addr = p.Addr.String()
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
+
+// probe runs one health check against a target
+func probe(ctx context.Context, target probeTarget) error {
+	switch target.spec.Protocol {
+	case types.HealthProtocolHTTP:
+		return probeHTTP(ctx, "http://"+target.addr+target.spec.Path)
+	case types.HealthProtocolGRPC:
+		return probeGRPC(ctx, target.addr, target.spec.Service)
+	default:
+		return fmt.Errorf("unsupported protocol %q", target.spec.Protocol)
+	}
+}
+
+// probeClient does not follow redirects, so a probe only reaches the
+// registered endpoint
+var probeClient = &http.Client{
+	CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+func probeHTTP(ctx context.Context, url string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := probeClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func probeGRPC(ctx context.Context, addr, service string) error {
+	conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	resp, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{Service: service})
+	if err != nil {
+		return err
+	}
+	if resp.Status != healthpb.HealthCheckResponse_SERVING {
+		return fmt.Errorf("status %s", resp.Status)
+	}
+	return nil
+}
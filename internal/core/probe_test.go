@@ -0,0 +1,275 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/robrt95x/milpa-cloud/internal/domain/entities"
+	"github.com/robrt95x/milpa-cloud/internal/infrastructure/config"
+	"github.com/robrt95x/milpa-cloud/pkg/types"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func splitHostPort(t *testing.T, addr string) (string, int) {
+	t.Helper()
+
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		t.Fatalf("SplitHostPort: %v", err)
+	}
+	port, _ := strconv.Atoi(portStr)
+	return host, port
+}
+
+func TestProbeEndpoint(t *testing.T) {
+	ctx := withPeerAddr(context.Background(), "10.0.0.7:51234")
+
+	host, port, spec, err := probeEndpoint(ctx, &types.HealthEndpoint{Protocol: "http", Port: 9000}, nil)
+	if err != nil {
+		t.Fatalf("probeEndpoint: %v", err)
+	}
+	if host != "10.0.0.7" || port != 9000 || spec.Path != "/healthz" {
+		t.Errorf("Expected peer host and default path, got %s:%d %+v", host, port, spec)
+	}
+
+	if _, _, spec, err := probeEndpoint(ctx, nil, nil); spec != nil || err != nil {
+		t.Errorf("Expected no probe without an endpoint, got %+v %v", spec, err)
+	}
+
+	for _, ep := range []*types.HealthEndpoint{
+		{Protocol: "tcp", Port: 9000},
+		{Protocol: "grpc", Port: 0},
+		{Protocol: "grpc", Port: 70000},
+	} {
+		if _, _, _, err := probeEndpoint(ctx, ep, nil); err == nil {
+			t.Errorf("Expected %+v to be rejected", ep)
+		}
+	}
+	if _, _, _, err := probeEndpoint(context.Background(), &types.HealthEndpoint{Protocol: "grpc", Port: 9000}, nil); err == nil {
+		t.Error("Expected an endpoint without host or peer to be rejected")
+	}
+
+	// Other hosts than the peer need to be allowed
+	tests := []struct {
+		host    string
+		allowed []string
+		ok      bool
+	}{
+		{"10.0.0.7", nil, true},
+		{"10.0.0.8", nil, false},
+		{"metadata.internal", nil, false},
+		{"10.0.0.8", []string{"10.0.0.0/24"}, true},
+		{"10.0.1.8", []string{"10.0.0.0/24"}, false},
+		{"Plugin.Internal", []string{"plugin.internal"}, true},
+	}
+	for _, tt := range tests {
+		ep := &types.HealthEndpoint{Protocol: "http", Host: tt.host, Port: 9000}
+		_, _, _, err := probeEndpoint(ctx, ep, tt.allowed)
+		if (err == nil) != tt.ok {
+			t.Errorf("probeEndpoint(%q, %v): expected ok=%v, got %v", tt.host, tt.allowed, tt.ok, err)
+		}
+	}
+}
+
+func TestHTTPProbeDoesNotFollowRedirects(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer target.Close()
+	plugin := httptest.NewServer(http.RedirectHandler(target.URL, http.StatusFound))
+	defer plugin.Close()
+
+	if err := probeHTTP(context.Background(), plugin.URL+"/healthz"); err == nil {
+		t.Error("Expected a redirect to fail the probe")
+	}
+}
+
+func TestHandshakeRejectsInvalidHealthEndpoint(t *testing.T) {
+	_, _, mgr, _ := setupTest(t)
+
+	resp, _ := mgr.HandshakeHTTP(context.Background(), &types.HandshakeRequest{
+		PluginId:       "example",
+		Version:        "1.0.0",
+		ApiVersion:     "1.0",
+		HealthEndpoint: &types.HealthEndpoint{Protocol: "tcp", Port: 9000},
+	})
+	if resp.Accepted {
+		t.Fatal("Expected handshake with an invalid health endpoint to be rejected")
+	}
+}
+
+func TestHTTPProbeMarksInstanceUnhealthy(t *testing.T) {
+	_, _, mgr, repo := setupTest(t)
+	mgr.config.Probes.FailureThreshold = 2
+
+	var healthy atomic.Bool
+	healthy.Store(true)
+	plugin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/ready" || !healthy.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer plugin.Close()
+	host, port := splitHostPort(t, plugin.Listener.Addr().String())
+
+	ctx := withPeerAddr(context.Background(), net.JoinHostPort(host, "51234"))
+	resp, err := mgr.HandshakeHTTP(ctx, &types.HandshakeRequest{
+		PluginId:   "example",
+		Version:    "1.0.0",
+		ApiVersion: "1.0",
+		HealthEndpoint: &types.HealthEndpoint{
+			Protocol: types.HealthProtocolHTTP,
+			Host:     host,
+			Port:     port,
+			Path:     "/ready",
+		},
+	})
+	if err != nil || !resp.Accepted {
+		t.Fatalf("Handshake failed: %v %+v", err, resp)
+	}
+
+	inst, _ := repo.GetInstance(resp.SessionId)
+	if inst.Host != host || inst.Port != port || inst.Probe == nil || inst.Probe.Path != "/ready" {
+		t.Fatalf("Expected health endpoint to be stored, got %s:%d %+v", inst.Host, inst.Port, inst.Probe)
+	}
+
+	mgr.runProbes()
+	if s, _ := mgr.ProbeStatus(resp.SessionId); s.ConsecutiveFailures != 0 || s.LastProbe == nil {
+		t.Errorf("Expected a passing probe, got %+v", s)
+	}
+
+	healthy.Store(false)
+	mgr.runProbes()
+	inst, _ = repo.GetInstance(resp.SessionId)
+	if inst.Status != entities.PluginStatusRunning {
+		t.Errorf("Expected one failure to stay below the threshold, got %s", inst.Status)
+	}
+	mgr.runProbes()
+	inst, _ = repo.GetInstance(resp.SessionId)
+	if inst.Status != entities.PluginStatusUnhealthy {
+		t.Fatalf("Expected failing probe to mark the instance unhealthy, got %s", inst.Status)
+	}
+
+	// Heartbeats do not revive the instance while the probe is failing
+	beat := func() {
+		t.Helper()
+		hb, _ := mgr.HeartbeatHTTP(context.Background(), &types.HeartbeatRequest{
+			SessionId: resp.SessionId,
+			AuthToken: resp.AuthToken,
+		})
+		if !hb.Ok {
+			t.Fatalf("Heartbeat rejected: %s", hb.Message)
+		}
+	}
+	beat()
+	inst, _ = repo.GetInstance(resp.SessionId)
+	if inst.Status != entities.PluginStatusUnhealthy {
+		t.Errorf("Expected heartbeat not to revive a failing instance, got %s", inst.Status)
+	}
+
+	healthy.Store(true)
+	mgr.runProbes()
+	beat()
+	inst, _ = repo.GetInstance(resp.SessionId)
+	if inst.Status != entities.PluginStatusRunning {
+		t.Errorf("Expected heartbeat to revive the instance once the probe passes, got %s", inst.Status)
+	}
+}
+
+func TestGRPCProbe(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	srv := grpc.NewServer()
+	hs := health.NewServer()
+	healthpb.RegisterHealthServer(srv, hs)
+	go srv.Serve(lis)
+	defer srv.Stop()
+
+	target := probeTarget{
+		addr: lis.Addr().String(),
+		spec: entities.ProbeSpec{Protocol: types.HealthProtocolGRPC, Service: "storage"},
+	}
+
+	hs.SetServingStatus("storage", healthpb.HealthCheckResponse_SERVING)
+	if err := probe(context.Background(), target); err != nil {
+		t.Errorf("Expected serving service to pass, got %v", err)
+	}
+
+	hs.SetServingStatus("storage", healthpb.HealthCheckResponse_NOT_SERVING)
+	if err := probe(context.Background(), target); err == nil {
+		t.Error("Expected not serving service to fail")
+	}
+}
+
+func TestHandshakeOverUnixSocketProbesLoopback(t *testing.T) {
+	cfg, log, mgr, repo := setupTest(t)
+	dir := t.TempDir()
+	cfg.Server.HTTP = config.ListenerConfig{Network: "unix", Address: filepath.Join(dir, "http.sock")}
+
+	plugin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer plugin.Close()
+	_, port := splitHostPort(t, plugin.Listener.Addr().String())
+	req := &types.HandshakeRequest{
+		PluginId:       "example",
+		Version:        "1.0.0",
+		ApiVersion:     "1.0",
+		HealthEndpoint: &types.HealthEndpoint{Protocol: types.HealthProtocolHTTP, Port: port},
+	}
+
+	server := NewHTTPServer(cfg, log, mgr)
+	if err := server.Start(); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer server.Shutdown(context.Background())
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", cfg.Server.HTTP.Address)
+		},
+	}}
+	body, _ := json.Marshal(req)
+	httpResp, err := client.Post("http://unix/api/v1/handshake", "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("Handshake over the unix socket failed: %v", err)
+	}
+	var resp types.HandshakeResponse
+	json.NewDecoder(httpResp.Body).Decode(&resp)
+	httpResp.Body.Close()
+	if !resp.Accepted {
+		t.Fatalf("Expected the handshake to be accepted, got %+v", resp)
+	}
+
+	grpcClient, _ := startGRPC(t, mgr, config.ListenerConfig{Network: "unix", Address: filepath.Join(dir, "grpc.sock")},
+		func(addr string) string { return "unix://" + addr })
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	grpcResp, err := grpcClient.Handshake(ctx, req)
+	if err != nil || !grpcResp.Accepted {
+		t.Fatalf("gRPC handshake over the unix socket failed: %v %+v", err, grpcResp)
+	}
+
+	// A plugin on the unix socket is probed on the loopback address
+	for _, id := range []string{resp.SessionId, grpcResp.SessionId} {
+		inst, _ := repo.GetInstance(id)
+		if inst.Host != "127.0.0.1" {
+			t.Errorf("Expected the loopback host for %s, got %q", id, inst.Host)
+		}
+	}
+	mgr.runProbes()
+	for _, id := range []string{resp.SessionId, grpcResp.SessionId} {
+		if s, _ := mgr.ProbeStatus(id); s.ConsecutiveFailures != 0 || s.LastProbe == nil {
+			t.Errorf("Expected a passing probe for %s, got %+v", id, s)
+		}
+	}
+}
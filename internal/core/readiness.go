@@ -0,0 +1,181 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Readiness component names
+const (
+	ComponentDatabase   = "database"
+	ComponentMigrations = "migrations"
+	ComponentGRPC       = "grpc"
+	ComponentEventBus   = "event_bus"
+	ComponentShutdown   = "shutdown"
+)
+
+// Component and report statuses
+const (
+	ComponentOK     = "ok"
+	ComponentFailed = "fail"
+
+	StatusReady    = "ready"
+	StatusNotReady = "not_ready"
+)
+
+// readinessTimeout bounds the checks that reach the database
+const readinessTimeout = 2 * time.Second
+
+// ComponentStatus is the readiness of one component of the core
+type ComponentStatus struct {
+	Name   string            `json:"name"`
+	Status string            `json:"status"`
+	Error  string            `json:"error,omitempty"`
+	Detail map[string]string `json:"detail,omitempty"`
+}
+
+// ReadinessReport is the body of /readyz
+type ReadinessReport struct {
+	Status     string            `json:"status"`
+	Components []ComponentStatus `json:"components"`
+}
+
+// Ready reports whether every component is ok
+func (r *ReadinessReport) Ready() bool {
+	return r.Status == StatusReady
+}
+
+// pinger is implemented by repositories backed by a database server
+type pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// schemaChecker is implemented by repositories with versioned migrations
+type schemaChecker interface {
+	CheckSchema() error
+	SchemaVersion() (int, error)
+	LatestSchemaVersion() (int, error)
+}
+
+// Readiness checks the components the core needs to serve plugins
+func (m *PluginManager) Readiness(ctx context.Context) *ReadinessReport {
+	ctx, cancel := context.WithTimeout(ctx, readinessTimeout)
+	defer cancel()
+
+	db := m.checkDatabase(ctx)
+	components := []ComponentStatus{
+		db,
+		m.checkMigrations(db.Status == ComponentOK),
+		m.checkGRPC(),
+		m.checkEventBus(),
+		m.checkShutdown(),
+	}
+
+	report := &ReadinessReport{Status: StatusReady, Components: components}
+	for _, c := range components {
+		if c.Status != ComponentOK {
+			report.Status = StatusNotReady
+		}
+	}
+	return report
+}
+
+// checkDatabase pings the database, when the repository has one
+func (m *PluginManager) checkDatabase(ctx context.Context) ComponentStatus {
+	status := ComponentStatus{Name: ComponentDatabase, Status: ComponentOK}
+	p, ok := m.repo.(pinger)
+	if !ok {
+		status.Detail = map[string]string{"backend": "memory"}
+		return status
+	}
+
+	start := time.Now()
+	if err := p.Ping(ctx); err != nil {
+		status.Status = ComponentFailed
+		status.Error = err.Error()
+		return status
+	}
+	status.Detail = map[string]string{"latency": time.Since(start).String()}
+	return status
+}
+
+// checkMigrations compares the applied schema with the embedded migrations
+// It is skipped when the database is unreachable.
+func (m *PluginManager) checkMigrations(reachable bool) ComponentStatus {
+	status := ComponentStatus{Name: ComponentMigrations, Status: ComponentOK}
+	sc, ok := m.repo.(schemaChecker)
+	if !ok {
+		return status
+	}
+	if !reachable {
+		status.Status = ComponentFailed
+		status.Error = "database unreachable"
+		return status
+	}
+
+	if err := sc.CheckSchema(); err != nil {
+		status.Status = ComponentFailed
+		status.Error = err.Error()
+	}
+	current, err := sc.SchemaVersion()
+	if err != nil {
+		status.Status = ComponentFailed
+		status.Error = err.Error()
+		return status
+	}
+	latest, err := sc.LatestSchemaVersion()
+	if err != nil {
+		status.Status = ComponentFailed
+		status.Error = err.Error()
+		return status
+	}
+	status.Detail = map[string]string{
+		"current": fmt.Sprint(current),
+		"latest":  fmt.Sprint(latest),
+	}
+	return status
+}
+
+// checkGRPC reports whether the gRPC listener is bound
+func (m *PluginManager) checkGRPC() ComponentStatus {
+	status := ComponentStatus{Name: ComponentGRPC, Status: ComponentOK}
+	network, addr, bound, err := m.grpcListener.get()
+	if addr != "" {
+		status.Detail = map[string]string{"network": network, "address": addr}
+	}
+	switch {
+	case bound:
+	case err != nil:
+		status.Status = ComponentFailed
+		status.Error = err.Error()
+	default:
+		status.Status = ComponentFailed
+		status.Error = "listener not bound"
+	}
+	return status
+}
+
+// checkEventBus reports whether the event bus is running
+func (m *PluginManager) checkEventBus() ComponentStatus {
+	status := ComponentStatus{Name: ComponentEventBus, Status: ComponentOK}
+	if !m.eventBus.Running() {
+		status.Status = ComponentFailed
+		status.Error = "event bus not running"
+		return status
+	}
+	status.Detail = map[string]string{"subscribers": fmt.Sprint(m.eventBus.subscriberCount())}
+	return status
+}
+
+// checkShutdown fails once the core started draining, so load balancers
+// stop routing new plugins to it
+func (m *PluginManager) checkShutdown() ComponentStatus {
+	status := ComponentStatus{Name: ComponentShutdown, Status: ComponentOK}
+	if m.drain.draining() {
+		status.Status = ComponentFailed
+		status.Error = "core shutting down"
+		status.Detail = map[string]string{"pending": fmt.Sprint(m.drain.remaining())}
+	}
+	return status
+}
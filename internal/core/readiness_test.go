@@ -0,0 +1,121 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// readyz returns the status code and report of /readyz
+func readyz(t *testing.T, server *HTTPServer) (int, ReadinessReport) {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	server.routes().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+	var report ReadinessReport
+	if err := json.NewDecoder(w.Body).Decode(&report); err != nil {
+		t.Fatalf("Failed to decode readiness report: %v", err)
+	}
+	return w.Code, report
+}
+
+func componentStatuses(report ReadinessReport) map[string]string {
+	out := make(map[string]string)
+	for _, c := range report.Components {
+		out[c.Name] = c.Status
+	}
+	return out
+}
+
+func TestHealthz(t *testing.T) {
+	cfg, log, mgr, _ := setupTest(t)
+	server := NewHTTPServer(cfg, log, mgr)
+
+	w := httptest.NewRecorder()
+	server.routes().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected 200 from /healthz, got %d", w.Code)
+	}
+}
+
+func TestReadyz(t *testing.T) {
+	cfg, log, mgr, repo := setupTest(t)
+	server := NewHTTPServer(cfg, log, mgr)
+
+	// Nothing started yet
+	code, report := readyz(t, server)
+	if code != http.StatusServiceUnavailable || report.Status != StatusNotReady {
+		t.Fatalf("Expected 503 not_ready before start, got %d %s", code, report.Status)
+	}
+	statuses := componentStatuses(report)
+	want := map[string]string{
+		ComponentDatabase:   ComponentOK,
+		ComponentMigrations: ComponentOK,
+		ComponentGRPC:       ComponentFailed,
+		ComponentEventBus:   ComponentFailed,
+	}
+	for name, status := range want {
+		if statuses[name] != status {
+			t.Errorf("Expected %s to be %s, got %q", name, status, statuses[name])
+		}
+	}
+
+	// Listener failed to bind
+	mgr.grpcListener.set("tcp", "0.0.0.0:8081", errors.New("address already in use"))
+	mgr.eventBus.Start()
+	defer mgr.eventBus.Stop()
+	_, report = readyz(t, server)
+	for _, c := range report.Components {
+		if c.Name == ComponentGRPC && (c.Error != "address already in use" || c.Detail["address"] != "0.0.0.0:8081") {
+			t.Errorf("Expected grpc failure detail, got %+v", c)
+		}
+	}
+
+	mgr.grpcListener.set("tcp", "0.0.0.0:8081", nil)
+	code, report = readyz(t, server)
+	if code != http.StatusOK || report.Status != StatusReady {
+		t.Fatalf("Expected 200 ready, got %d %+v", code, report)
+	}
+	for _, c := range report.Components {
+		if c.Name == ComponentMigrations && (c.Detail["current"] == "" || c.Detail["current"] != c.Detail["latest"]) {
+			t.Errorf("Expected current schema version detail, got %+v", c)
+		}
+	}
+
+	// Database gone
+	repo.Close()
+	code, report = readyz(t, server)
+	if code != http.StatusServiceUnavailable {
+		t.Fatalf("Expected 503 with the database closed, got %d", code)
+	}
+	statuses = componentStatuses(report)
+	if statuses[ComponentDatabase] != ComponentFailed || statuses[ComponentMigrations] != ComponentFailed {
+		t.Errorf("Expected database and migrations to fail, got %v", statuses)
+	}
+}
+
+func TestReadyzWhileDraining(t *testing.T) {
+	cfg, log, mgr, _ := setupTest(t)
+	server := NewHTTPServer(cfg, log, mgr)
+	mgr.grpcListener.set("tcp", "0.0.0.0:8081", nil)
+	mgr.eventBus.Start()
+	defer mgr.eventBus.Stop()
+
+	if code, report := readyz(t, server); code != http.StatusOK {
+		t.Fatalf("Expected 200 ready, got %d %+v", code, report)
+	}
+
+	mgr.drain.start([]string{"inst-1"})
+	code, report := readyz(t, server)
+	if code != http.StatusServiceUnavailable {
+		t.Fatalf("Expected 503 while draining, got %d", code)
+	}
+	for _, c := range report.Components {
+		if c.Name == ComponentShutdown && (c.Status != ComponentFailed || c.Detail["pending"] != "1") {
+			t.Errorf("Expected shutdown to fail with one pending plugin, got %+v", c)
+		}
+	}
+}
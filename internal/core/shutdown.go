@@ -0,0 +1,70 @@
+package core
+
+import (
+	"sync"
+)
+
+// drainTracker follows the connected instances during shutdown until each
+// one acknowledged the shutdown event or disconnected
+type drainTracker struct {
+	mu      sync.Mutex
+	active  bool
+	pending map[string]struct{}
+	done    chan struct{}
+}
+
+func newDrainTracker() *drainTracker {
+	return &drainTracker{
+		done: make(chan struct{}),
+	}
+}
+
+// start begins draining the given instances; the returned channel is
+// closed once none are pending
+func (d *drainTracker) start(ids []string) <-chan struct{} {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.active {
+		return d.done
+	}
+	d.active = true
+	d.pending = make(map[string]struct{}, len(ids))
+	for _, id := range ids {
+		d.pending[id] = struct{}{}
+	}
+	if len(d.pending) == 0 {
+		close(d.done)
+	}
+	return d.done
+}
+
+// draining reports whether shutdown started
+func (d *drainTracker) draining() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.active
+}
+
+// resolve stops waiting for an instance
+func (d *drainTracker) resolve(id string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if _, ok := d.pending[id]; !ok {
+		return
+	}
+	delete(d.pending, id)
+	if len(d.pending) == 0 {
+		close(d.done)
+	}
+}
+
+// remaining returns the number of instances still pending
+func (d *drainTracker) remaining() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return len(d.pending)
+}
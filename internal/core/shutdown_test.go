@@ -0,0 +1,101 @@
+package core
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/robrt95x/milpa-cloud/internal/domain/entities"
+	"github.com/robrt95x/milpa-cloud/pkg/types"
+)
+
+func TestStopDrainsPlugins(t *testing.T) {
+	_, _, mgr, repo := setupTest(t)
+	mgr.config.Shutdown.DrainTimeout = "10s"
+
+	a := handshake(t, mgr, "alpha")
+	b := handshake(t, mgr, "beta")
+	events := mgr.SubscribePlugin(a.SessionId)
+
+	stopped := make(chan struct{})
+	start := time.Now()
+	go func() {
+		mgr.Stop()
+		close(stopped)
+	}()
+
+	// The shutdown event reaches subscribers before their channel is closed
+	if ev := <-events; ev.Type != EventTypeShutdown {
+		t.Fatalf("Expected shutdown event, got %+v", ev)
+	}
+
+	if resp, _ := mgr.HandshakeHTTP(context.Background(), &types.HandshakeRequest{
+		PluginId:   "gamma",
+		Version:    "1.0.0",
+		ApiVersion: "1.0",
+	}); resp.Accepted {
+		t.Error("Expected handshakes to be refused while shutting down")
+	}
+
+	hb, _ := mgr.HeartbeatHTTP(context.Background(), &types.HeartbeatRequest{
+		SessionId: a.SessionId,
+		AuthToken: a.AuthToken,
+	})
+	if !hb.Ok || !hb.Shutdown {
+		t.Fatalf("Expected heartbeat response to announce shutdown, got %+v", hb)
+	}
+	mgr.HeartbeatHTTP(context.Background(), &types.HeartbeatRequest{
+		SessionId:   a.SessionId,
+		AuthToken:   a.AuthToken,
+		ShutdownAck: true,
+	})
+
+	select {
+	case <-stopped:
+		t.Fatal("Expected Stop to wait for the second plugin")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	mgr.DisconnectPlugin(b.SessionId, types.DisconnectDeregistered)
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected Stop to return once every plugin acknowledged or disconnected")
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Error("Expected Stop to return before the drain timeout")
+	}
+
+	// Heartbeats received while draining are flushed
+	inst, _ := repo.GetInstance(a.SessionId)
+	before, _ := repo.GetInstance(b.SessionId)
+	if !inst.LastHeartbeat.After(*before.LastHeartbeat) {
+		t.Error("Expected heartbeats received during shutdown to be flushed")
+	}
+}
+
+func TestStopDrainTimeout(t *testing.T) {
+	_, _, mgr, repo := setupTest(t)
+	mgr.config.Shutdown.DrainTimeout = "20ms"
+	resp := handshake(t, mgr, "example")
+
+	start := time.Now()
+	mgr.Stop()
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond {
+		t.Errorf("Expected Stop to wait for the drain timeout, returned after %v", elapsed)
+	}
+	// Stop is idempotent
+	mgr.Stop()
+
+	// Instances that never disconnected end the drain stopped
+	history, _ := repo.ListTransitions(resp.SessionId)
+	var got []string
+	for _, tr := range history {
+		got = append(got, tr.To)
+	}
+	want := []string{entities.PluginStatusRunning, entities.PluginStatusStopping, entities.PluginStatusStopped}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("Expected transitions %v, got %v", want, got)
+	}
+}
@@ -0,0 +1,203 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/robrt95x/milpa-cloud/pkg/tracing"
+	"github.com/robrt95x/milpa-cloud/pkg/types"
+)
+
+// Status query outcomes of a single instance
+const (
+	StatusQueryReplied     = "replied"
+	StatusQueryTimeout     = "timeout"
+	StatusQueryUnreachable = "unreachable"
+)
+
+// StatusQueryResult is the reply of one instance to a status query
+type StatusQueryResult struct {
+	InstanceID string              `json:"instance_id"`
+	PluginID   string              `json:"plugin_id"`
+	State      string              `json:"state"` // replied, timeout, unreachable
+	Status     map[string]string   `json:"status,omitempty"`
+	Health     *types.HealthReport `json:"health,omitempty"`
+	Error      string              `json:"error,omitempty"`
+	LatencyMs  int64               `json:"latency_ms,omitempty"`
+}
+
+// StatusQueryResponse aggregates the replies to a status query
+type StatusQueryResponse struct {
+	QueryID     string              `json:"query_id"`
+	Results     []StatusQueryResult `json:"results"`
+	Replied     int                 `json:"replied"`
+	TimedOut    int                 `json:"timed_out"`
+	Unreachable int                 `json:"unreachable"`
+}
+
+// statusReply is a reply received for a pending query
+type statusReply struct {
+	instanceID string
+	reply      *types.StatusReply
+	at         time.Time
+}
+
+// statusQueries routes status_reply events to the query waiting for them
+type statusQueries struct {
+	mu      sync.Mutex
+	pending map[string]chan statusReply
+}
+
+func newStatusQueries() *statusQueries {
+	return &statusQueries{
+		pending: make(map[string]chan statusReply),
+	}
+}
+
+// open registers a query expecting up to n replies
+func (q *statusQueries) open(queryID string, n int) <-chan statusReply {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	ch := make(chan statusReply, n)
+	q.pending[queryID] = ch
+	return ch
+}
+
+// close forgets a query; later replies are dropped
+func (q *statusQueries) close(queryID string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	delete(q.pending, queryID)
+}
+
+// deliver hands a reply to its query and reports whether one was waiting
+func (q *statusQueries) deliver(queryID string, r statusReply) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	ch, ok := q.pending[queryID]
+	if !ok {
+		return false
+	}
+	select {
+	case ch <- r:
+		return true
+	default:
+		// More replies than instances queried (e.g. duplicates)
+		return false
+	}
+}
+
+// QueryInstanceStatus asks one instance for its current status and waits
+// up to timeout for the reply
+func (m *PluginManager) QueryInstanceStatus(ctx context.Context, id string, timeout time.Duration) (*StatusQueryResponse, error) {
+	inst, err := m.repo.GetInstance(id)
+	if err != nil {
+		return nil, err
+	}
+	return m.queryStatus(ctx, map[string]string{id: inst.DefinitionID}, timeout), nil
+}
+
+// QueryStatus asks every connected instance (of pluginID, if set) for its
+// current status and waits up to timeout for the replies
+func (m *PluginManager) QueryStatus(ctx context.Context, pluginID string, timeout time.Duration) *StatusQueryResponse {
+	targets := m.conns.snapshot()
+	if pluginID != "" {
+		for id, p := range targets {
+			if p != pluginID {
+				delete(targets, id)
+			}
+		}
+	}
+	return m.queryStatus(ctx, targets, timeout)
+}
+
+// queryStatus sends a status_query event to each target (instance ID ->
+// plugin ID) and collects the status_reply events until all replied,
+// the timeout elapsed or ctx is done
+func (m *PluginManager) queryStatus(ctx context.Context, targets map[string]string, timeout time.Duration) *StatusQueryResponse {
+	ctx, span := m.tracer.Start(ctx, "status query", tracing.SpanKindProducer, "milpa.targets", len(targets))
+	defer span.End()
+
+	queryID := generateUUID()
+	replies := m.queries.open(queryID, len(targets))
+	defer m.queries.close(queryID)
+
+	data, _ := json.Marshal(&types.StatusQuery{QueryId: queryID})
+	sent := time.Now()
+	results := make(map[string]*StatusQueryResult, len(targets))
+	waiting := 0
+	for id, pluginID := range targets {
+		r := &StatusQueryResult{InstanceID: id, PluginID: pluginID}
+		results[id] = r
+		err := m.sendEvent(ctx, id, EventTypeStatusQuery, string(data))
+		if err != nil {
+			r.State = StatusQueryUnreachable
+			r.Error = err.Error()
+			continue
+		}
+		waiting++
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+wait:
+	for waiting > 0 {
+		select {
+		case rep := <-replies:
+			r, ok := results[rep.instanceID]
+			if !ok || r.State != "" {
+				continue
+			}
+			r.State = StatusQueryReplied
+			r.Status = rep.reply.Status
+			r.Health = rep.reply.Health
+			r.Error = rep.reply.Error
+			r.LatencyMs = rep.at.Sub(sent).Milliseconds()
+			waiting--
+		case <-timer.C:
+			break wait
+		case <-ctx.Done():
+			break wait
+		}
+	}
+
+	resp := &StatusQueryResponse{QueryID: queryID, Results: make([]StatusQueryResult, 0, len(results))}
+	for _, r := range results {
+		switch r.State {
+		case "":
+			r.State = StatusQueryTimeout
+			resp.TimedOut++
+		case StatusQueryReplied:
+			resp.Replied++
+		case StatusQueryUnreachable:
+			resp.Unreachable++
+		}
+		resp.Results = append(resp.Results, *r)
+	}
+	sort.Slice(resp.Results, func(i, j int) bool {
+		return resp.Results[i].InstanceID < resp.Results[j].InstanceID
+	})
+	span.SetAttributes("milpa.query_id", queryID, "milpa.replied", resp.Replied,
+		"milpa.timed_out", resp.TimedOut, "milpa.unreachable", resp.Unreachable)
+	m.log.WithContext(ctx).Debug("status query finished", "query_id", queryID, "replied", resp.Replied,
+		"timed_out", resp.TimedOut, "unreachable", resp.Unreachable)
+	return resp
+}
+
+// statusReply routes a status_reply event to its pending query
+func (m *PluginManager) statusReply(sessionID, data string) {
+	var reply types.StatusReply
+	if err := json.Unmarshal([]byte(data), &reply); err != nil {
+		m.log.Warn("invalid status reply", "instance_id", sessionID, "error", err)
+		return
+	}
+	if !m.queries.deliver(reply.QueryId, statusReply{instanceID: sessionID, reply: &reply, at: time.Now()}) {
+		m.log.Debug("status reply for unknown or finished query", "instance_id", sessionID, "query_id", reply.QueryId)
+	}
+}
@@ -0,0 +1,160 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/robrt95x/milpa-cloud/pkg/types"
+)
+
+// answerStatusQueries subscribes a session, then polls its events in the
+// background and replies to each status query with status until ctx is done
+func answerStatusQueries(ctx context.Context, mgr *PluginManager, resp *types.HandshakeResponse, status map[string]string) {
+	mgr.SubscribePlugin(resp.SessionId)
+	go pollStatusQueries(ctx, mgr, resp, status)
+}
+
+func pollStatusQueries(ctx context.Context, mgr *PluginManager, resp *types.HandshakeResponse, status map[string]string) {
+	for ctx.Err() == nil {
+		poll := mgr.PollEvents(ctx, &types.EventPollRequest{
+			SessionId: resp.SessionId,
+			AuthToken: resp.AuthToken,
+			WaitMs:    50,
+		})
+		for _, ev := range poll.Events {
+			if ev.Type != EventTypeStatusQuery {
+				continue
+			}
+			var query types.StatusQuery
+			json.Unmarshal([]byte(ev.Data), &query)
+			data, _ := json.Marshal(&types.StatusReply{QueryId: query.QueryId, Status: status})
+			mgr.SendPluginEvent(context.Background(), &types.PluginEventRequest{
+				SessionId: resp.SessionId,
+				AuthToken: resp.AuthToken,
+				Type:      EventTypeStatusReply,
+				Data:      string(data),
+			})
+		}
+	}
+}
+
+func TestQueryInstanceStatus(t *testing.T) {
+	_, _, mgr, _ := setupTest(t)
+	resp := handshake(t, mgr, "example")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	answerStatusQueries(ctx, mgr, resp, map[string]string{"queue": "3"})
+
+	out, err := mgr.QueryInstanceStatus(context.Background(), resp.SessionId, 2*time.Second)
+	if err != nil {
+		t.Fatalf("QueryInstanceStatus: %v", err)
+	}
+	if out.Replied != 1 || len(out.Results) != 1 {
+		t.Fatalf("Expected one reply, got %+v", out)
+	}
+	r := out.Results[0]
+	if r.State != StatusQueryReplied || r.PluginID != "example" || r.Status["queue"] != "3" {
+		t.Errorf("Unexpected result %+v", r)
+	}
+
+	if _, err := mgr.QueryInstanceStatus(context.Background(), "missing", time.Second); err == nil {
+		t.Error("Expected an error for an unknown instance")
+	}
+}
+
+func TestQueryStatusTimeoutAndUnreachable(t *testing.T) {
+	_, _, mgr, _ := setupTest(t)
+	silent := handshake(t, mgr, "alpha")
+	gone := handshake(t, mgr, "beta")
+	mgr.SubscribePlugin(silent.SessionId)
+	mgr.eventBus.Unsubscribe(gone.SessionId)
+
+	start := time.Now()
+	out := mgr.QueryStatus(context.Background(), "", 20*time.Millisecond)
+	if time.Since(start) > time.Second {
+		t.Error("Expected the query to return after its timeout")
+	}
+	if out.TimedOut != 1 || out.Unreachable != 1 || out.Replied != 0 {
+		t.Fatalf("Expected one timeout and one unreachable, got %+v", out)
+	}
+
+	// Late replies are dropped
+	data, _ := json.Marshal(&types.StatusReply{QueryId: out.QueryID})
+	mgr.SendPluginEvent(context.Background(), &types.PluginEventRequest{
+		SessionId: silent.SessionId,
+		AuthToken: silent.AuthToken,
+		Type:      EventTypeStatusReply,
+		Data:      string(data),
+	})
+
+	if out := mgr.QueryStatus(context.Background(), "alpha", 10*time.Millisecond); len(out.Results) != 1 {
+		t.Errorf("Expected definition filter to select one instance, got %+v", out.Results)
+	}
+}
+
+func TestStatusQueryBroadcastHTTP(t *testing.T) {
+	cfg, log, mgr, _ := setupTest(t)
+	server := NewHTTPServer(cfg, log, mgr)
+	a := handshake(t, mgr, "alpha")
+	b := handshake(t, mgr, "beta")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	answerStatusQueries(ctx, mgr, a, map[string]string{"name": "a"})
+	answerStatusQueries(ctx, mgr, b, map[string]string{"name": "b"})
+
+	w := httptest.NewRecorder()
+	server.routes().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/v1/plugins/instances/status-query?timeout=2s", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var out StatusQueryResponse
+	json.NewDecoder(w.Body).Decode(&out)
+	if out.Replied != 2 {
+		t.Errorf("Expected both instances to reply, got %+v", out)
+	}
+
+	w = httptest.NewRecorder()
+	server.routes().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/v1/plugins/instances/status-query?timeout=1h", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an excessive timeout, got %d", w.Code)
+	}
+}
+
+func TestPollEvents(t *testing.T) {
+	_, _, mgr, _ := setupTest(t)
+	resp := handshake(t, mgr, "example")
+
+	if out := mgr.PollEvents(context.Background(), &types.EventPollRequest{
+		SessionId: resp.SessionId,
+		AuthToken: "wrong",
+	}); out.Ok {
+		t.Error("Expected poll with a wrong token to be rejected")
+	}
+
+	mgr.SubscribePlugin(resp.SessionId)
+	mgr.SendEventToPlugin(resp.SessionId, EventTypeRestart, "1")
+	mgr.SendEventToPlugin(resp.SessionId, EventTypeRestart, "2")
+	out := mgr.PollEvents(context.Background(), &types.EventPollRequest{
+		SessionId: resp.SessionId,
+		AuthToken: resp.AuthToken,
+		WaitMs:    10,
+	})
+	if !out.Ok || len(out.Events) != 2 || out.Events[1].Data != "2" {
+		t.Errorf("Expected both queued events, got %+v", out)
+	}
+
+	out = mgr.PollEvents(context.Background(), &types.EventPollRequest{
+		SessionId: resp.SessionId,
+		AuthToken: resp.AuthToken,
+		WaitMs:    10,
+	})
+	if !out.Ok || len(out.Events) != 0 {
+		t.Errorf("Expected an empty poll after the wait, got %+v", out)
+	}
+}
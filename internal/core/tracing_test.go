@@ -0,0 +1,141 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/robrt95x/milpa-cloud/pkg/tracing"
+	"github.com/robrt95x/milpa-cloud/pkg/types"
+)
+
+// spanRecorder is an exporter keeping spans in memory
+type spanRecorder struct {
+	mu    sync.Mutex
+	spans []tracing.SpanData
+}
+
+func (r *spanRecorder) ExportSpans(ctx context.Context, service string, spans []tracing.SpanData) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.spans = append(r.spans, spans...)
+	return nil
+}
+
+func (r *spanRecorder) Shutdown(ctx context.Context) error {
+	return nil
+}
+
+// byName returns the recorded span with the given name
+func (r *spanRecorder) byName(t *testing.T, name string) tracing.SpanData {
+	t.Helper()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, s := range r.spans {
+		if s.Name == name {
+			return s
+		}
+	}
+	t.Fatalf("No span named %q", name)
+	return tracing.SpanData{}
+}
+
+func TestTracePropagation(t *testing.T) {
+	cfg, log, mgr, _ := setupTest(t)
+	rec := &spanRecorder{}
+	tracer := tracing.NewTracer("milpa-core", rec)
+	mgr.SetTracer(tracer)
+	server := NewHTTPServer(cfg, log, mgr)
+	resp := handshake(t, mgr, "example")
+	events := mgr.SubscribePlugin(resp.SessionId)
+
+	// The plugin answers the status query over HTTP, continuing the trace
+	// of the event it received
+	go func() {
+		ev := <-events
+		var query types.StatusQuery
+		json.Unmarshal([]byte(ev.Data), &query)
+		data, _ := json.Marshal(&types.StatusReply{QueryId: query.QueryId})
+		body, _ := json.Marshal(&types.PluginEventRequest{
+			SessionId: resp.SessionId,
+			AuthToken: resp.AuthToken,
+			Type:      EventTypeStatusReply,
+			Data:      string(data),
+		})
+		r := httptest.NewRequest(http.MethodPost, "/api/v1/events", strings.NewReader(string(body)))
+		r.Header.Set(tracing.TraceParentHeader, ev.TraceParent)
+		server.routes().ServeHTTP(httptest.NewRecorder(), r)
+	}()
+
+	caller, _ := tracing.ParseTraceParent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	r := httptest.NewRequest(http.MethodPost, "/api/v1/plugins/instances/"+resp.SessionId+"/status-query", nil)
+	r.Header.Set(tracing.TraceParentHeader, caller.TraceParent())
+	w := httptest.NewRecorder()
+	server.routes().ServeHTTP(w, r)
+	var out StatusQueryResponse
+	json.NewDecoder(w.Body).Decode(&out)
+	if out.Replied != 1 {
+		t.Fatalf("Expected the plugin to reply, got %+v", out)
+	}
+	tracer.Shutdown(context.Background())
+
+	request := rec.byName(t, "HTTP POST /api/v1/plugins/instances/:id/status-query")
+	query := rec.byName(t, "status query")
+	reply := rec.byName(t, "HTTP POST /api/v1/events")
+	event := rec.byName(t, "plugin event status_reply")
+
+	chain := []struct {
+		name   string
+		span   tracing.SpanData
+		parent tracing.SpanID
+	}{
+		{"request", request, caller.SpanID},
+		{"status query", query, request.SpanContext.SpanID},
+		{"plugin reply", reply, query.SpanContext.SpanID},
+		{"reply event", event, reply.SpanContext.SpanID},
+	}
+	for _, c := range chain {
+		if c.span.SpanContext.TraceID != caller.TraceID {
+			t.Errorf("Expected %s span in the caller's trace, got %s", c.name, c.span.SpanContext.TraceID)
+		}
+		if c.span.Parent != c.parent {
+			t.Errorf("Expected %s span parent %s, got %s", c.name, c.parent, c.span.Parent)
+		}
+	}
+	if request.Kind != tracing.SpanKindServer || query.Kind != tracing.SpanKindProducer || event.Kind != tracing.SpanKindConsumer {
+		t.Errorf("Unexpected span kinds %d %d %d", request.Kind, query.Kind, event.Kind)
+	}
+}
+
+func TestUnsampledPluginTraceIsRecorded(t *testing.T) {
+	cfg, log, mgr, _ := setupTest(t)
+	rec := &spanRecorder{}
+	tracer := tracing.NewTracer("milpa-core", rec)
+	mgr.SetTracer(tracer)
+	server := NewHTTPServer(cfg, log, mgr)
+
+	// The SDK's default tracer has no exporter, so it sends unsampled parents
+	caller, _ := tracing.ParseTraceParent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+	body, _ := json.Marshal(&types.HandshakeRequest{PluginId: "example", Version: "1.0.0", ApiVersion: "1.0"})
+	r := httptest.NewRequest(http.MethodPost, "/api/v1/handshake", strings.NewReader(string(body)))
+	r.Header.Set(tracing.TraceParentHeader, caller.TraceParent())
+	w := httptest.NewRecorder()
+	server.routes().ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	tracer.Shutdown(context.Background())
+
+	span := rec.byName(t, "HTTP POST /api/v1/handshake")
+	if span.SpanContext.TraceID != caller.TraceID || span.Parent != caller.SpanID {
+		t.Errorf("Expected the handshake span to continue the plugin's trace, got %+v", span)
+	}
+	if !span.SpanContext.Sampled {
+		t.Error("Expected the recorded span to be sampled")
+	}
+}
@@ -11,6 +11,7 @@ type PluginDefinition struct {
 	Capabilities []string         `json:"capabilities" gorm:"serializer:json"`
 	Enabled     bool              `json:"enabled" gorm:"default:true"`
 	Metadata    map[string]string `json:"metadata" gorm:"serializer:json"`
+	Revision    int64             `json:"revision" gorm:"not null"` // optimistic locking
 	CreatedAt   time.Time         `json:"created_at"`
 	UpdatedAt   time.Time         `json:"updated_at"`
 }
@@ -20,14 +21,20 @@ type PluginInstance struct {
 	ID             string            `json:"id" gorm:"primaryKey"`
 	DefinitionID  string            `json:"definition_id" gorm:"index"`
 	Definition    *PluginDefinition `json:"definition,omitempty" gorm:"foreignKey:DefinitionID"`
-	Status        string            `json:"status"` // running, stopped, unhealthy
+	Status        string            `json:"status"` // ver PluginStatus*; los cambios siguen CanTransition
 	Enabled       bool              `json:"enabled" gorm:"default:true"`
+	InstanceKey   string            `json:"instance_key"` // clave estable enviada por el plugin para reanudar la instancia
 	Host          string            `json:"host"`
 	Port          int               `json:"port"`
 	AuthToken     string            `json:"-"` // no exponer en JSON
 	LastHeartbeat *time.Time        `json:"last_heartbeat"`
 	StartedAt     time.Time         `json:"started_at"`
 	Metadata      map[string]string `json:"metadata" gorm:"serializer:json"`
+	Revision      int64             `json:"revision" gorm:"not null"` // optimistic locking
+	RetiredAt     *time.Time        `json:"retired_at"`
+	Health        *HealthReport     `json:"health,omitempty" gorm:"serializer:json"` // último informe de salud recibido
+	Probe         *ProbeSpec        `json:"probe,omitempty" gorm:"serializer:json"`  // endpoint de salud en Host:Port
+	QuarantinedUntil *time.Time     `json:"quarantined_until,omitempty"`            // fin de la cuarentena si está quarantined
 	CreatedAt     time.Time         `json:"created_at"`
 	UpdatedAt     time.Time         `json:"updated_at"`
 }
@@ -35,7 +42,122 @@ type PluginInstance struct {
 // PluginStatus constants
 const (
 	PluginStatusAvailable  = "available"
+	PluginStatusStarting   = "starting"
 	PluginStatusRunning    = "running"
-	PluginStatusStopped    = "stopped"
+	PluginStatusDegraded   = "degraded"
 	PluginStatusUnhealthy  = "unhealthy"
+	PluginStatusStopping   = "stopping"
+	PluginStatusStopped    = "stopped"
+	PluginStatusRetired    = "retired"
+	PluginStatusQuarantined = "quarantined"
+)
+
+// instanceTransitions lista los estados destino legales de cada estado
+// El estado vacío es el de una instancia recién creada
+var instanceTransitions = map[string][]string{
+	"":                    {PluginStatusStarting, PluginStatusRunning},
+	PluginStatusStarting:  {PluginStatusRunning, PluginStatusDegraded, PluginStatusUnhealthy, PluginStatusStopping, PluginStatusStopped, PluginStatusQuarantined},
+	PluginStatusRunning:   {PluginStatusDegraded, PluginStatusUnhealthy, PluginStatusStopping, PluginStatusStopped, PluginStatusQuarantined},
+	PluginStatusDegraded:  {PluginStatusRunning, PluginStatusUnhealthy, PluginStatusStopping, PluginStatusStopped, PluginStatusQuarantined},
+	PluginStatusUnhealthy: {PluginStatusStarting, PluginStatusRunning, PluginStatusDegraded, PluginStatusStopping, PluginStatusStopped, PluginStatusRetired, PluginStatusQuarantined},
+	// Una instancia que se está deteniendo (deregister o apagado del core) acaba parada
+	PluginStatusStopping:  {PluginStatusStopped, PluginStatusRetired},
+	PluginStatusStopped:   {PluginStatusStarting, PluginStatusRunning, PluginStatusRetired},
+	// Una instancia en cuarentena solo sale liberada (starting), parada o retirada
+	PluginStatusQuarantined: {PluginStatusStarting, PluginStatusStopped, PluginStatusRetired},
+}
+
+// CanTransition indica si una instancia puede pasar del estado from a to
+// Los estados retired y available no tienen salidas
+func CanTransition(from, to string) bool {
+	for _, s := range instanceTransitions[from] {
+		if s == to {
+			return true
+		}
+	}
+	return false
+}
+
+// IsLiveStatus indica si se esperan heartbeats de una instancia en ese estado
+func IsLiveStatus(status string) bool {
+	switch status {
+	case PluginStatusStarting, PluginStatusRunning, PluginStatusDegraded:
+		return true
+	}
+	return false
+}
+
+// Estados de un HealthReport y de sus comprobaciones
+const (
+	HealthPass = "pass"
+	HealthWarn = "warn"
+	HealthFail = "fail"
 )
+
+// HealthReport es el estado de salud que una instancia envía con sus heartbeats
+// Un informe guardado no se modifica; cada heartbeat trae uno nuevo
+type HealthReport struct {
+	Status     string             `json:"status"` // pass, warn, fail
+	Checks     []HealthCheck      `json:"checks,omitempty"`
+	Gauges     map[string]float64 `json:"gauges,omitempty"`
+	ReportedAt time.Time          `json:"reported_at"`
+}
+
+// HealthCheck es el resultado de una comprobación con nombre
+type HealthCheck struct {
+	Name    string `json:"name"`
+	Status  string `json:"status"` // pass, warn, fail
+	Message string `json:"message,omitempty"`
+}
+
+// FailingChecks devuelve los nombres de las comprobaciones en estado fail
+func (h *HealthReport) FailingChecks() []string {
+	var names []string
+	for _, c := range h.Checks {
+		if c.Status == HealthFail {
+			names = append(names, c.Name)
+		}
+	}
+	return names
+}
+
+// Degraded indica si el informe debe marcar la instancia como degraded
+func (h *HealthReport) Degraded() bool {
+	return h.Status == HealthFail || len(h.FailingChecks()) > 0
+}
+
+// ProbeSpec describe el endpoint de salud que el core sondea en Host:Port
+type ProbeSpec struct {
+	Protocol string `json:"protocol"` // http, grpc
+	Path     string `json:"path,omitempty"`
+	Service  string `json:"service,omitempty"`
+}
+
+// InstanceTransition registra un cambio de estado de una instancia
+type InstanceTransition struct {
+	ID         uint      `json:"id" gorm:"primaryKey"`
+	InstanceID string    `json:"instance_id" gorm:"index"`
+	PluginID   string    `json:"plugin_id"`
+	From       string    `json:"from" gorm:"column:from_status"`
+	To         string    `json:"to" gorm:"column:to_status"`
+	Reason     string    `json:"reason"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// PluginConfigEntry es un valor de configuración de un plugin
+type PluginConfigEntry struct {
+	PluginID  string    `json:"plugin_id" gorm:"primaryKey"`
+	Key       string    `json:"key" gorm:"primaryKey"`
+	Value     string    `json:"value"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// EventRecord es un evento del sistema persistido para auditoría
+type EventRecord struct {
+	ID         uint      `json:"id" gorm:"primaryKey"`
+	Type       string    `json:"type" gorm:"index"`
+	PluginID   string    `json:"plugin_id"`
+	InstanceID string    `json:"instance_id" gorm:"index"`
+	Data       string    `json:"data"`
+	CreatedAt  time.Time `json:"created_at"`
+}
@@ -66,3 +66,51 @@ func TestPluginDefinitionValidation(t *testing.T) {
 		})
 	}
 }
+
+func TestCanTransition(t *testing.T) {
+	tests := []struct {
+		from, to string
+		want     bool
+	}{
+		{"", PluginStatusRunning, true},
+		{"", PluginStatusStopped, false},
+		{PluginStatusRunning, PluginStatusUnhealthy, true},
+		{PluginStatusUnhealthy, PluginStatusRunning, true},
+		{PluginStatusStopped, PluginStatusStarting, true},
+		{PluginStatusStopping, PluginStatusRunning, false},
+		{PluginStatusStopping, PluginStatusRetired, true},
+		{PluginStatusRunning, PluginStatusRetired, false},
+		{PluginStatusRetired, PluginStatusRunning, false},
+		{PluginStatusRunning, PluginStatusRunning, false},
+		{PluginStatusUnhealthy, PluginStatusQuarantined, true},
+		{PluginStatusQuarantined, PluginStatusStarting, true},
+		{PluginStatusQuarantined, PluginStatusRunning, false},
+	}
+
+	for _, tt := range tests {
+		if got := CanTransition(tt.from, tt.to); got != tt.want {
+			t.Errorf("CanTransition(%q, %q) = %v, want %v", tt.from, tt.to, got, tt.want)
+		}
+	}
+}
+
+func TestHealthReportDegraded(t *testing.T) {
+	tests := []struct {
+		name   string
+		report HealthReport
+		want   bool
+	}{
+		{"pass", HealthReport{Status: HealthPass}, false},
+		{"warn check", HealthReport{Status: HealthWarn, Checks: []HealthCheck{{Name: "disk", Status: HealthWarn}}}, false},
+		{"failing check", HealthReport{Status: HealthPass, Checks: []HealthCheck{{Name: "db", Status: HealthFail}}}, true},
+		{"overall fail", HealthReport{Status: HealthFail}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.report.Degraded(); got != tt.want {
+				t.Errorf("Expected Degraded() = %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
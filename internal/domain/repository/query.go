@@ -0,0 +1,207 @@
+package repository
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/robrt95x/milpa-cloud/internal/domain/entities"
+)
+
+// ErrInvalidQuery is returned for unknown sort fields and malformed cursors
+var ErrInvalidQuery = errors.New("invalid query")
+
+// Page size limits for QueryDefinitions and QueryInstances
+const (
+	DefaultPageLimit = 100
+	MaxPageLimit     = 1000
+)
+
+// Sortable fields. Results are always ordered by ID after the sort field.
+var (
+	DefinitionSortFields = []string{"id", "version", "created_at", "updated_at"}
+	InstanceSortFields   = []string{"id", "definition_id", "status", "started_at", "created_at", "updated_at"}
+)
+
+// DefinitionQuery filters, sorts and paginates plugin definitions
+// Zero values match everything
+type DefinitionQuery struct {
+	Enabled    *bool
+	Capability string
+	// Sort is a field from DefinitionSortFields, "-" prefixed for descending
+	Sort   string
+	Limit  int
+	Cursor string
+}
+
+// InstanceQuery filters, sorts and paginates plugin instances
+// Zero values match everything
+type InstanceQuery struct {
+	DefinitionID string
+	Status       []string
+	Enabled      *bool
+	// Capability matches instances whose definition declares it
+	Capability string
+	// Heartbeat bounds compare against the stored (last flushed) heartbeat
+	HeartbeatBefore time.Time
+	HeartbeatAfter  time.Time
+	// Sort is a field from InstanceSortFields, "-" prefixed for descending
+	Sort   string
+	Limit  int
+	Cursor string
+}
+
+// DefinitionPage is one page of QueryDefinitions results
+type DefinitionPage struct {
+	Items []*entities.PluginDefinition
+	// Total counts every match, ignoring Limit and Cursor
+	Total int64
+	// NextCursor is empty on the last page
+	NextCursor string
+}
+
+// InstancePage is one page of QueryInstances results
+type InstancePage struct {
+	Items      []*entities.PluginInstance
+	Total      int64
+	NextCursor string
+}
+
+// Sort is a parsed sort parameter
+type Sort struct {
+	Field string
+	Desc  bool
+}
+
+func (s Sort) String() string {
+	if s.Desc {
+		return "-" + s.Field
+	}
+	return s.Field
+}
+
+// ParseSort validates a sort parameter against the allowed fields
+// An empty value sorts by ID ascending
+func ParseSort(value string, fields []string) (Sort, error) {
+	if value == "" {
+		return Sort{Field: "id"}, nil
+	}
+
+	s := Sort{Field: strings.TrimPrefix(value, "-"), Desc: strings.HasPrefix(value, "-")}
+	for _, f := range fields {
+		if f == s.Field {
+			return s, nil
+		}
+	}
+	return Sort{}, fmt.Errorf("%w: cannot sort by %q", ErrInvalidQuery, s.Field)
+}
+
+// PageLimit returns the effective page size for a requested limit
+func PageLimit(limit int) int {
+	switch {
+	case limit <= 0:
+		return DefaultPageLimit
+	case limit > MaxPageLimit:
+		return MaxPageLimit
+	default:
+		return limit
+	}
+}
+
+// Cursor marks the last row of a page for keyset pagination
+type Cursor struct {
+	Sort  string `json:"s"`
+	Value string `json:"v"`
+	ID    string `json:"id"`
+}
+
+// NewCursor returns the encoded cursor following a row
+func NewCursor(sort Sort, value interface{}, id string) string {
+	c := Cursor{Sort: sort.String(), Value: formatSortValue(value), ID: id}
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// DecodeCursor parses a cursor produced by NewCursor for the same sort
+// The returned value is a string or time.Time depending on the sort field
+func DecodeCursor(value string, sort Sort) (interface{}, string, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: malformed cursor", ErrInvalidQuery)
+	}
+
+	var c Cursor
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, "", fmt.Errorf("%w: malformed cursor", ErrInvalidQuery)
+	}
+	if c.Sort != sort.String() {
+		return nil, "", fmt.Errorf("%w: cursor was issued for sort %q", ErrInvalidQuery, c.Sort)
+	}
+
+	if !IsTimeSortField(sort.Field) {
+		return c.Value, c.ID, nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, c.Value)
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: malformed cursor", ErrInvalidQuery)
+	}
+	return t, c.ID, nil
+}
+
+// IsTimeSortField reports whether a sort field holds timestamps
+func IsTimeSortField(field string) bool {
+	return strings.HasSuffix(field, "_at")
+}
+
+// DefinitionSortValue returns the value of a sortable definition field
+func DefinitionSortValue(def *entities.PluginDefinition, field string) interface{} {
+	switch field {
+	case "version":
+		return def.Version
+	case "created_at":
+		return def.CreatedAt
+	case "updated_at":
+		return def.UpdatedAt
+	default:
+		return def.ID
+	}
+}
+
+// InstanceSortValue returns the value of a sortable instance field
+func InstanceSortValue(inst *entities.PluginInstance, field string) interface{} {
+	switch field {
+	case "definition_id":
+		return inst.DefinitionID
+	case "status":
+		return inst.Status
+	case "started_at":
+		return inst.StartedAt
+	case "created_at":
+		return inst.CreatedAt
+	case "updated_at":
+		return inst.UpdatedAt
+	default:
+		return inst.ID
+	}
+}
+
+// CompareSortValues orders two values returned by the *SortValue functions
+func CompareSortValues(a, b interface{}) int {
+	switch av := a.(type) {
+	case time.Time:
+		bv, _ := b.(time.Time)
+		return av.Compare(bv)
+	default:
+		return strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+	}
+}
+
+func formatSortValue(value interface{}) string {
+	if t, ok := value.(time.Time); ok {
+		return t.UTC().Format(time.RFC3339Nano)
+	}
+	return fmt.Sprint(value)
+}
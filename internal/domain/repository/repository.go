@@ -0,0 +1,109 @@
+// Package repository defines the storage contract used by the core.
+package repository
+
+import (
+	"errors"
+	"time"
+
+	"github.com/robrt95x/milpa-cloud/internal/domain/entities"
+)
+
+// Repository errors
+var (
+	// ErrNotFound is returned when a requested record does not exist
+	ErrNotFound = errors.New("record not found")
+	// ErrConflict is returned when a record changed since it was read
+	ErrConflict = errors.New("record was modified concurrently")
+)
+
+// Repository is the storage used by the plugin manager
+// Implementations: db.Repository (GORM) and memory.Repository
+type Repository interface {
+	DefinitionRepository
+	InstanceRepository
+	ConfigRepository
+	EventRepository
+	TransitionRepository
+
+	Close() error
+}
+
+// DefinitionRepository stores plugin definitions
+//
+// Writes are guarded by PluginDefinition.Revision: UpdateDefinition only
+// succeeds if the stored revision equals def.Revision and returns ErrConflict
+// otherwise. On success the revision is incremented and written back to def.
+type DefinitionRepository interface {
+	// UpsertDefinition creates a definition or refreshes its descriptive fields
+	// (version, capabilities, ...). It never changes Enabled on an existing row.
+	// A zero Revision skips the revision check.
+	UpsertDefinition(def *entities.PluginDefinition) error
+	UpdateDefinition(def *entities.PluginDefinition) error
+	GetDefinition(id string) (*entities.PluginDefinition, error)
+	ListDefinitions() ([]*entities.PluginDefinition, error)
+	// QueryDefinitions returns one page of filtered, sorted definitions
+	QueryDefinitions(q DefinitionQuery) (*DefinitionPage, error)
+	SetDefinitionEnabled(id string, enabled bool) error
+}
+
+// InstanceRepository stores plugin instances
+//
+// UpdateInstance is conditional on PluginInstance.Revision, like UpdateDefinition.
+// TouchHeartbeats is the exception: it only moves last_heartbeat forward and
+// does not bump the revision, so batched heartbeat flushes never conflict
+// with admin writes.
+type InstanceRepository interface {
+	CreateInstance(inst *entities.PluginInstance) error
+	GetInstance(id string) (*entities.PluginInstance, error)
+	ListInstances() ([]*entities.PluginInstance, error)
+	// QueryInstances returns one page of filtered, sorted instances
+	// Unlike ListInstances it does not load the definition
+	QueryInstances(q InstanceQuery) (*InstancePage, error)
+	UpdateInstance(inst *entities.PluginInstance) error
+	SetInstanceEnabled(id string, enabled bool) error
+	GetUnhealthyInstances(timeout string) ([]entities.PluginInstance, error)
+	TouchHeartbeats(beats map[string]time.Time) error
+	// SaveHealth stores the latest health report of each instance
+	// Like TouchHeartbeats it does not bump the revision
+	SaveHealth(reports map[string]*entities.HealthReport) error
+
+	// FindInstanceByKey returns the most recently started, non-retired
+	// instance of a plugin with the given instance key
+	FindInstanceByKey(definitionID, instanceKey string) (*entities.PluginInstance, error)
+	// RetireInstances marks enabled unhealthy, stopping or stopped instances whose last
+	// heartbeat is before staleBefore as retired, records the transitions
+	// with reason and returns them
+	RetireInstances(staleBefore time.Time, reason string) ([]*entities.InstanceTransition, error)
+	// DeleteRetiredInstances removes instances retired before retiredBefore,
+	// along with their transition history
+	DeleteRetiredInstances(retiredBefore time.Time) (int64, error)
+}
+
+// ConfigRepository stores per-plugin configuration values
+type ConfigRepository interface {
+	GetConfig(pluginID string) (map[string]string, error)
+	SetConfig(pluginID string, values map[string]string) error
+	DeleteConfig(pluginID, key string) error
+}
+
+// EventRepository stores the system event log
+type EventRepository interface {
+	AppendEvent(ev *entities.EventRecord) error
+	ListEvents(filter EventFilter) ([]*entities.EventRecord, error)
+}
+
+// TransitionRepository stores the status history of instances
+type TransitionRepository interface {
+	AppendTransition(t *entities.InstanceTransition) error
+	// ListTransitions returns the history of an instance, oldest first
+	ListTransitions(instanceID string) ([]*entities.InstanceTransition, error)
+}
+
+// EventFilter narrows ListEvents results
+// Zero values match everything; results are ordered oldest first
+type EventFilter struct {
+	Type       string
+	InstanceID string
+	Since      time.Time
+	Limit      int
+}
@@ -0,0 +1,795 @@
+// Package repotest is the contract test suite shared by all
+// repository.Repository implementations.
+package repotest
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/robrt95x/milpa-cloud/internal/domain/entities"
+	"github.com/robrt95x/milpa-cloud/internal/domain/repository"
+)
+
+// Factory returns a new, empty repository for a single test
+type Factory func(t *testing.T) repository.Repository
+
+// Run executes the contract suite against the repository returned by newRepo
+func Run(t *testing.T, newRepo Factory) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, repo repository.Repository)
+	}{
+		{"Definitions", testDefinitions},
+		{"DefinitionNotFound", testDefinitionNotFound},
+		{"Instances", testInstances},
+		{"InstanceNotFound", testInstanceNotFound},
+		{"InstanceIsolation", testInstanceIsolation},
+		{"UnhealthyInstances", testUnhealthyInstances},
+		{"InstanceOptimisticLocking", testInstanceOptimisticLocking},
+		{"DefinitionOptimisticLocking", testDefinitionOptimisticLocking},
+		{"TouchHeartbeats", testTouchHeartbeats},
+		{"SaveHealth", testSaveHealth},
+		{"InstanceKeys", testInstanceKeys},
+		{"RetireInstances", testRetireInstances},
+		{"Transitions", testTransitions},
+		{"QueryDefinitions", testQueryDefinitions},
+		{"QueryInstances", testQueryInstances},
+		{"QueryPagination", testQueryPagination},
+		{"QueryInvalid", testQueryInvalid},
+		{"Config", testConfig},
+		{"Events", testEvents},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.fn(t, newRepo(t))
+		})
+	}
+}
+
+func createDefinition(t *testing.T, repo repository.Repository, id string) {
+	t.Helper()
+
+	err := repo.UpsertDefinition(&entities.PluginDefinition{
+		ID:           id,
+		Version:      "1.0.0",
+		APIVersion:   "1.0",
+		DependsOn:    []string{},
+		Capabilities: []string{"test"},
+		Enabled:      true,
+	})
+	if err != nil {
+		t.Fatalf("UpsertDefinition(%q): %v", id, err)
+	}
+}
+
+func createInstance(t *testing.T, repo repository.Repository, inst *entities.PluginInstance) {
+	t.Helper()
+
+	if err := repo.CreateInstance(inst); err != nil {
+		t.Fatalf("CreateInstance(%q): %v", inst.ID, err)
+	}
+}
+
+func testDefinitions(t *testing.T, repo repository.Repository) {
+	createDefinition(t, repo, "alpha")
+	createDefinition(t, repo, "beta")
+
+	// Upsert updates the existing row
+	err := repo.UpsertDefinition(&entities.PluginDefinition{
+		ID:           "alpha",
+		Version:      "2.0.0",
+		Capabilities: []string{"a", "b"},
+		Enabled:      true,
+	})
+	if err != nil {
+		t.Fatalf("UpsertDefinition: %v", err)
+	}
+
+	got, err := repo.GetDefinition("alpha")
+	if err != nil {
+		t.Fatalf("GetDefinition: %v", err)
+	}
+	if got.Version != "2.0.0" {
+		t.Errorf("Expected version 2.0.0, got %s", got.Version)
+	}
+	if len(got.Capabilities) != 2 {
+		t.Errorf("Expected 2 capabilities, got %v", got.Capabilities)
+	}
+	if got.APIVersion != "1.0" {
+		t.Errorf("Expected api version to be kept, got %q", got.APIVersion)
+	}
+
+	defs, err := repo.ListDefinitions()
+	if err != nil {
+		t.Fatalf("ListDefinitions: %v", err)
+	}
+	if len(defs) != 2 {
+		t.Errorf("Expected 2 definitions, got %d", len(defs))
+	}
+
+	if err := repo.SetDefinitionEnabled("alpha", false); err != nil {
+		t.Fatalf("SetDefinitionEnabled: %v", err)
+	}
+	got, _ = repo.GetDefinition("alpha")
+	if got.Enabled {
+		t.Error("Expected definition to be disabled")
+	}
+}
+
+func testDefinitionNotFound(t *testing.T, repo repository.Repository) {
+	if _, err := repo.GetDefinition("missing"); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("GetDefinition: expected ErrNotFound, got %v", err)
+	}
+	if err := repo.SetDefinitionEnabled("missing", true); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("SetDefinitionEnabled: expected ErrNotFound, got %v", err)
+	}
+}
+
+func testInstances(t *testing.T, repo repository.Repository) {
+	createDefinition(t, repo, "alpha")
+
+	now := time.Now()
+	createInstance(t, repo, &entities.PluginInstance{
+		ID:            "inst-1",
+		DefinitionID:  "alpha",
+		Status:        entities.PluginStatusRunning,
+		Enabled:       true,
+		AuthToken:     "token",
+		LastHeartbeat: &now,
+		StartedAt:     now,
+		Metadata:      map[string]string{"hostname": "node-1"},
+	})
+
+	got, err := repo.GetInstance("inst-1")
+	if err != nil {
+		t.Fatalf("GetInstance: %v", err)
+	}
+	if got.AuthToken != "token" || got.Metadata["hostname"] != "node-1" {
+		t.Errorf("Instance fields not stored: %+v", got)
+	}
+	if got.CreatedAt.IsZero() {
+		t.Error("Expected CreatedAt to be set")
+	}
+
+	got.Status = entities.PluginStatusUnhealthy
+	if err := repo.UpdateInstance(got); err != nil {
+		t.Fatalf("UpdateInstance: %v", err)
+	}
+	got, _ = repo.GetInstance("inst-1")
+	if got.Status != entities.PluginStatusUnhealthy {
+		t.Errorf("Expected status unhealthy, got %s", got.Status)
+	}
+
+	if err := repo.SetInstanceEnabled("inst-1", false); err != nil {
+		t.Fatalf("SetInstanceEnabled: %v", err)
+	}
+	got, _ = repo.GetInstance("inst-1")
+	if got.Enabled {
+		t.Error("Expected instance to be disabled")
+	}
+
+	instances, err := repo.ListInstances()
+	if err != nil {
+		t.Fatalf("ListInstances: %v", err)
+	}
+	if len(instances) != 1 {
+		t.Fatalf("Expected 1 instance, got %d", len(instances))
+	}
+	if instances[0].Definition == nil || instances[0].Definition.ID != "alpha" {
+		t.Errorf("Expected ListInstances to load the definition, got %+v", instances[0].Definition)
+	}
+
+	// Duplicate IDs are rejected
+	dup := &entities.PluginInstance{ID: "inst-1", DefinitionID: "alpha", Status: entities.PluginStatusRunning}
+	if err := repo.CreateInstance(dup); err == nil {
+		t.Error("Expected error creating duplicate instance")
+	}
+}
+
+func testInstanceNotFound(t *testing.T, repo repository.Repository) {
+	if _, err := repo.GetInstance("missing"); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("GetInstance: expected ErrNotFound, got %v", err)
+	}
+	if err := repo.SetInstanceEnabled("missing", true); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("SetInstanceEnabled: expected ErrNotFound, got %v", err)
+	}
+}
+
+func testInstanceIsolation(t *testing.T, repo repository.Repository) {
+	createDefinition(t, repo, "alpha")
+	createInstance(t, repo, &entities.PluginInstance{
+		ID:           "inst-1",
+		DefinitionID: "alpha",
+		Status:       entities.PluginStatusRunning,
+		Metadata:     map[string]string{"k": "v"},
+	})
+
+	// Mutating a returned entity must not change stored state
+	got, _ := repo.GetInstance("inst-1")
+	got.Status = entities.PluginStatusStopped
+	got.Metadata["k"] = "changed"
+
+	again, _ := repo.GetInstance("inst-1")
+	if again.Status != entities.PluginStatusRunning {
+		t.Errorf("Expected stored status running, got %s", again.Status)
+	}
+	if again.Metadata["k"] != "v" {
+		t.Errorf("Expected stored metadata v, got %s", again.Metadata["k"])
+	}
+}
+
+func testUnhealthyInstances(t *testing.T, repo repository.Repository) {
+	createDefinition(t, repo, "alpha")
+
+	stale := time.Now().Add(-time.Minute)
+	fresh := time.Now()
+	createInstance(t, repo, &entities.PluginInstance{ID: "inst-stale", DefinitionID: "alpha", Status: entities.PluginStatusRunning, LastHeartbeat: &stale})
+	createInstance(t, repo, &entities.PluginInstance{ID: "inst-fresh", DefinitionID: "alpha", Status: entities.PluginStatusRunning, LastHeartbeat: &fresh})
+	createInstance(t, repo, &entities.PluginInstance{ID: "inst-stopped", DefinitionID: "alpha", Status: entities.PluginStatusStopped, LastHeartbeat: &stale})
+
+	unhealthy, err := repo.GetUnhealthyInstances("30s")
+	if err != nil {
+		t.Fatalf("GetUnhealthyInstances: %v", err)
+	}
+	if len(unhealthy) != 1 || unhealthy[0].ID != "inst-stale" {
+		t.Errorf("Expected only inst-stale, got %v", unhealthy)
+	}
+
+	if _, err := repo.GetUnhealthyInstances("soon"); err == nil {
+		t.Error("Expected error for invalid timeout")
+	}
+}
+
+func testInstanceOptimisticLocking(t *testing.T, repo repository.Repository) {
+	createDefinition(t, repo, "alpha")
+	createInstance(t, repo, &entities.PluginInstance{ID: "inst-1", DefinitionID: "alpha", Status: entities.PluginStatusRunning, Enabled: true})
+
+	a, _ := repo.GetInstance("inst-1")
+	b, _ := repo.GetInstance("inst-1")
+	if a.Revision != 1 {
+		t.Fatalf("Expected new instance at revision 1, got %d", a.Revision)
+	}
+
+	a.Status = entities.PluginStatusStopped
+	if err := repo.UpdateInstance(a); err != nil {
+		t.Fatalf("UpdateInstance: %v", err)
+	}
+	if a.Revision != 2 {
+		t.Errorf("Expected revision 2 after update, got %d", a.Revision)
+	}
+
+	// b was read before a's write and must not overwrite it
+	b.Status = entities.PluginStatusRunning
+	if err := repo.UpdateInstance(b); !errors.Is(err, repository.ErrConflict) {
+		t.Fatalf("Expected ErrConflict for stale write, got %v", err)
+	}
+	if b.Revision != 1 {
+		t.Errorf("Expected failed write to keep revision 1, got %d", b.Revision)
+	}
+
+	got, _ := repo.GetInstance("inst-1")
+	if got.Status != entities.PluginStatusStopped || got.Revision != 2 {
+		t.Errorf("Expected stopped at revision 2, got %s at %d", got.Status, got.Revision)
+	}
+
+	if err := repo.SetInstanceEnabled("inst-1", false); err != nil {
+		t.Fatalf("SetInstanceEnabled: %v", err)
+	}
+	got, _ = repo.GetInstance("inst-1")
+	if got.Revision != 3 {
+		t.Errorf("Expected SetInstanceEnabled to bump revision to 3, got %d", got.Revision)
+	}
+
+	missing := &entities.PluginInstance{ID: "missing", Revision: 1}
+	if err := repo.UpdateInstance(missing); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound updating missing instance, got %v", err)
+	}
+}
+
+func testDefinitionOptimisticLocking(t *testing.T, repo repository.Repository) {
+	createDefinition(t, repo, "alpha")
+
+	a, _ := repo.GetDefinition("alpha")
+	b, _ := repo.GetDefinition("alpha")
+
+	a.Enabled = false
+	if err := repo.UpdateDefinition(a); err != nil {
+		t.Fatalf("UpdateDefinition: %v", err)
+	}
+
+	b.Version = "9.9.9"
+	if err := repo.UpdateDefinition(b); !errors.Is(err, repository.ErrConflict) {
+		t.Fatalf("Expected ErrConflict for stale write, got %v", err)
+	}
+
+	// Upsert refreshes descriptive fields but never re-enables a definition
+	err := repo.UpsertDefinition(&entities.PluginDefinition{ID: "alpha", Version: "2.0.0", Enabled: true})
+	if err != nil {
+		t.Fatalf("UpsertDefinition: %v", err)
+	}
+	got, _ := repo.GetDefinition("alpha")
+	if got.Enabled {
+		t.Error("Expected upsert to keep the definition disabled")
+	}
+	if got.Version != "2.0.0" {
+		t.Errorf("Expected version 2.0.0, got %s", got.Version)
+	}
+	if got.Revision != 3 {
+		t.Errorf("Expected revision 3, got %d", got.Revision)
+	}
+
+	stale := &entities.PluginDefinition{ID: "alpha", Version: "3.0.0", Revision: 1}
+	if err := repo.UpsertDefinition(stale); !errors.Is(err, repository.ErrConflict) {
+		t.Errorf("Expected ErrConflict for stale upsert, got %v", err)
+	}
+
+	missing := &entities.PluginDefinition{ID: "missing", Revision: 1}
+	if err := repo.UpdateDefinition(missing); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound updating missing definition, got %v", err)
+	}
+}
+
+func testSaveHealth(t *testing.T, repo repository.Repository) {
+	createDefinition(t, repo, "alpha")
+	createInstance(t, repo, &entities.PluginInstance{ID: "inst-1", DefinitionID: "alpha", Status: entities.PluginStatusRunning, Enabled: true})
+
+	report := &entities.HealthReport{
+		Status:     entities.HealthWarn,
+		Checks:     []entities.HealthCheck{{Name: "db", Status: entities.HealthFail, Message: "connection refused"}},
+		Gauges:     map[string]float64{"queue_depth": 12},
+		ReportedAt: time.Now().UTC().Truncate(time.Millisecond),
+	}
+	if err := repo.SaveHealth(map[string]*entities.HealthReport{"inst-1": report, "missing": report}); err != nil {
+		t.Fatalf("SaveHealth: %v", err)
+	}
+
+	inst, err := repo.GetInstance("inst-1")
+	if err != nil {
+		t.Fatalf("GetInstance: %v", err)
+	}
+	if inst.Health == nil {
+		t.Fatal("Expected health report to be stored")
+	}
+	if inst.Health.Status != entities.HealthWarn || len(inst.Health.Checks) != 1 ||
+		inst.Health.Checks[0].Message != "connection refused" || inst.Health.Gauges["queue_depth"] != 12 {
+		t.Errorf("Unexpected health report %+v", inst.Health)
+	}
+	if !inst.Health.ReportedAt.Equal(report.ReportedAt) {
+		t.Errorf("Expected reported_at %v, got %v", report.ReportedAt, inst.Health.ReportedAt)
+	}
+	if inst.Revision != 1 {
+		t.Errorf("Expected SaveHealth not to bump revision, got %d", inst.Revision)
+	}
+}
+
+func testTouchHeartbeats(t *testing.T, repo repository.Repository) {
+	createDefinition(t, repo, "alpha")
+
+	old := time.Now().Add(-time.Minute).Truncate(time.Millisecond)
+	createInstance(t, repo, &entities.PluginInstance{ID: "inst-1", DefinitionID: "alpha", Status: entities.PluginStatusRunning, LastHeartbeat: &old})
+	createInstance(t, repo, &entities.PluginInstance{ID: "inst-2", DefinitionID: "alpha", Status: entities.PluginStatusRunning})
+
+	now := time.Now().Truncate(time.Millisecond)
+	err := repo.TouchHeartbeats(map[string]time.Time{
+		"inst-1":  now,
+		"inst-2":  now,
+		"missing": now,
+	})
+	if err != nil {
+		t.Fatalf("TouchHeartbeats: %v", err)
+	}
+
+	for _, id := range []string{"inst-1", "inst-2"} {
+		got, _ := repo.GetInstance(id)
+		if got.LastHeartbeat == nil || !got.LastHeartbeat.Equal(now) {
+			t.Errorf("%s: expected last heartbeat %v, got %v", id, now, got.LastHeartbeat)
+		}
+		if got.Revision != 1 {
+			t.Errorf("%s: expected revision to stay 1, got %d", id, got.Revision)
+		}
+	}
+
+	// Older timestamps are ignored
+	repo.TouchHeartbeats(map[string]time.Time{"inst-1": old})
+	got, _ := repo.GetInstance("inst-1")
+	if !got.LastHeartbeat.Equal(now) {
+		t.Errorf("Expected older heartbeat to be ignored, got %v", got.LastHeartbeat)
+	}
+}
+
+func testConfig(t *testing.T, repo repository.Repository) {
+	got, err := repo.GetConfig("alpha")
+	if err != nil {
+		t.Fatalf("GetConfig: %v", err)
+	}
+	if len(got) != 0 {
+		t.Errorf("Expected empty config, got %v", got)
+	}
+
+	if err := repo.SetConfig("alpha", map[string]string{"a": "1", "b": "2"}); err != nil {
+		t.Fatalf("SetConfig: %v", err)
+	}
+	if err := repo.SetConfig("alpha", map[string]string{"b": "3"}); err != nil {
+		t.Fatalf("SetConfig: %v", err)
+	}
+	if err := repo.SetConfig("beta", map[string]string{"a": "x"}); err != nil {
+		t.Fatalf("SetConfig: %v", err)
+	}
+
+	got, _ = repo.GetConfig("alpha")
+	if len(got) != 2 || got["a"] != "1" || got["b"] != "3" {
+		t.Errorf("Expected {a:1 b:3}, got %v", got)
+	}
+
+	if err := repo.DeleteConfig("alpha", "a"); err != nil {
+		t.Fatalf("DeleteConfig: %v", err)
+	}
+	got, _ = repo.GetConfig("alpha")
+	if _, ok := got["a"]; ok {
+		t.Error("Expected key a to be deleted")
+	}
+	if err := repo.DeleteConfig("alpha", "a"); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("DeleteConfig: expected ErrNotFound, got %v", err)
+	}
+}
+
+func testEvents(t *testing.T, repo repository.Repository) {
+	start := time.Now().Add(-time.Second)
+
+	for _, ev := range []*entities.EventRecord{
+		{Type: "plugin_connected", PluginID: "alpha", InstanceID: "inst-1", Data: "alpha"},
+		{Type: "plugin_connected", PluginID: "beta", InstanceID: "inst-2", Data: "beta"},
+		{Type: "plugin_disconnected", PluginID: "alpha", InstanceID: "inst-1", Data: "alpha"},
+	} {
+		if err := repo.AppendEvent(ev); err != nil {
+			t.Fatalf("AppendEvent: %v", err)
+		}
+		if ev.ID == 0 {
+			t.Error("Expected AppendEvent to assign an ID")
+		}
+	}
+
+	all, err := repo.ListEvents(repository.EventFilter{})
+	if err != nil {
+		t.Fatalf("ListEvents: %v", err)
+	}
+	if len(all) != 3 {
+		t.Fatalf("Expected 3 events, got %d", len(all))
+	}
+	if all[0].Data != "alpha" || all[2].Type != "plugin_disconnected" {
+		t.Errorf("Expected events oldest first, got %+v", all)
+	}
+
+	byType, _ := repo.ListEvents(repository.EventFilter{Type: "plugin_connected"})
+	if len(byType) != 2 {
+		t.Errorf("Expected 2 connected events, got %d", len(byType))
+	}
+
+	byInstance, _ := repo.ListEvents(repository.EventFilter{InstanceID: "inst-1"})
+	if len(byInstance) != 2 {
+		t.Errorf("Expected 2 events for inst-1, got %d", len(byInstance))
+	}
+
+	limited, _ := repo.ListEvents(repository.EventFilter{Limit: 1})
+	if len(limited) != 1 {
+		t.Errorf("Expected 1 event with limit, got %d", len(limited))
+	}
+
+	since, _ := repo.ListEvents(repository.EventFilter{Since: start})
+	if len(since) != 3 {
+		t.Errorf("Expected 3 events since start, got %d", len(since))
+	}
+	future, _ := repo.ListEvents(repository.EventFilter{Since: time.Now().Add(time.Hour)})
+	if len(future) != 0 {
+		t.Errorf("Expected no events in the future, got %d", len(future))
+	}
+}
+
+func testInstanceKeys(t *testing.T, repo repository.Repository) {
+	createDefinition(t, repo, "alpha")
+	createDefinition(t, repo, "beta")
+
+	now := time.Now()
+	createInstance(t, repo, &entities.PluginInstance{ID: "inst-old", DefinitionID: "alpha", InstanceKey: "host-a", Status: entities.PluginStatusUnhealthy, Enabled: true, StartedAt: now.Add(-time.Hour)})
+	createInstance(t, repo, &entities.PluginInstance{ID: "inst-new", DefinitionID: "alpha", InstanceKey: "host-a", Status: entities.PluginStatusUnhealthy, Enabled: true, StartedAt: now})
+	createInstance(t, repo, &entities.PluginInstance{ID: "inst-other", DefinitionID: "beta", InstanceKey: "host-a", Status: entities.PluginStatusRunning, Enabled: true, StartedAt: now})
+
+	inst, err := repo.FindInstanceByKey("alpha", "host-a")
+	if err != nil {
+		t.Fatalf("FindInstanceByKey: %v", err)
+	}
+	if inst.ID != "inst-new" || inst.InstanceKey != "host-a" {
+		t.Errorf("Expected latest instance inst-new, got %s", inst.ID)
+	}
+
+	if _, err := repo.FindInstanceByKey("alpha", "host-b"); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound for unknown key, got %v", err)
+	}
+
+	// Retired instances are never resumed
+	inst.Status = entities.PluginStatusRetired
+	if err := repo.UpdateInstance(inst); err != nil {
+		t.Fatalf("UpdateInstance: %v", err)
+	}
+	inst, err = repo.FindInstanceByKey("alpha", "host-a")
+	if err != nil || inst.ID != "inst-old" {
+		t.Errorf("Expected inst-old after retiring inst-new, got %v %v", inst, err)
+	}
+}
+
+func testRetireInstances(t *testing.T, repo repository.Repository) {
+	createDefinition(t, repo, "alpha")
+
+	now := time.Now()
+	old := now.Add(-2 * time.Hour)
+	createInstance(t, repo, &entities.PluginInstance{ID: "inst-stale", DefinitionID: "alpha", Status: entities.PluginStatusUnhealthy, Enabled: true, LastHeartbeat: &old})
+	createInstance(t, repo, &entities.PluginInstance{ID: "inst-stopped", DefinitionID: "alpha", Status: entities.PluginStatusStopped, Enabled: true, LastHeartbeat: &old})
+	createInstance(t, repo, &entities.PluginInstance{ID: "inst-stopping", DefinitionID: "alpha", Status: entities.PluginStatusStopping, Enabled: true, LastHeartbeat: &old})
+	createInstance(t, repo, &entities.PluginInstance{ID: "inst-recent", DefinitionID: "alpha", Status: entities.PluginStatusUnhealthy, Enabled: true, LastHeartbeat: &now})
+	createInstance(t, repo, &entities.PluginInstance{ID: "inst-running", DefinitionID: "alpha", Status: entities.PluginStatusRunning, Enabled: true, LastHeartbeat: &old})
+	createInstance(t, repo, &entities.PluginInstance{ID: "inst-disabled", DefinitionID: "alpha", Status: entities.PluginStatusStopped, Enabled: true, LastHeartbeat: &old})
+	repo.SetInstanceEnabled("inst-disabled", false)
+
+	retired, err := repo.RetireInstances(now.Add(-time.Hour), "stale")
+	if err != nil {
+		t.Fatalf("RetireInstances: %v", err)
+	}
+	var got []string
+	for _, tr := range retired {
+		got = append(got, tr.InstanceID)
+		if tr.To != entities.PluginStatusRetired || tr.Reason != "stale" || tr.PluginID != "alpha" {
+			t.Errorf("Unexpected transition %+v", tr)
+		}
+	}
+	if !equalStrings(got, []string{"inst-stale", "inst-stopped", "inst-stopping"}) {
+		t.Errorf("Expected stale, stopped and stopping instances retired, got %v", got)
+	}
+	if retired[0].From != entities.PluginStatusUnhealthy || retired[1].From != entities.PluginStatusStopped {
+		t.Errorf("Expected previous statuses in transitions, got %s and %s", retired[0].From, retired[1].From)
+	}
+	history, _ := repo.ListTransitions("inst-stale")
+	if len(history) != 1 || history[0].To != entities.PluginStatusRetired {
+		t.Errorf("Expected retirement to be recorded, got %v", history)
+	}
+
+	stored, _ := repo.GetInstance("inst-stale")
+	if stored.Status != entities.PluginStatusRetired || stored.RetiredAt == nil {
+		t.Errorf("Expected retired status and timestamp, got %s %v", stored.Status, stored.RetiredAt)
+	}
+	if stored.Revision != 2 {
+		t.Errorf("Expected retiring to bump revision, got %d", stored.Revision)
+	}
+	for _, id := range []string{"inst-recent", "inst-running", "inst-disabled"} {
+		if inst, _ := repo.GetInstance(id); inst.Status == entities.PluginStatusRetired {
+			t.Errorf("Expected %s not to be retired", id)
+		}
+	}
+
+	// Nothing is deleted before the retention period
+	if n, err := repo.DeleteRetiredInstances(now.Add(-time.Minute)); err != nil || n != 0 {
+		t.Errorf("Expected no deletions, got %d %v", n, err)
+	}
+	n, err := repo.DeleteRetiredInstances(time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatalf("DeleteRetiredInstances: %v", err)
+	}
+	if n != 3 {
+		t.Errorf("Expected 3 deleted instances, got %d", n)
+	}
+	if _, err := repo.GetInstance("inst-stale"); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("Expected retired instance to be deleted, got %v", err)
+	}
+	if history, _ := repo.ListTransitions("inst-stale"); len(history) != 0 {
+		t.Errorf("Expected history of deleted instance to be removed, got %d", len(history))
+	}
+	if _, err := repo.GetInstance("inst-recent"); err != nil {
+		t.Errorf("Expected other instances to be kept, got %v", err)
+	}
+}
+
+func testTransitions(t *testing.T, repo repository.Repository) {
+	for _, tr := range []*entities.InstanceTransition{
+		{InstanceID: "inst-1", PluginID: "alpha", To: entities.PluginStatusRunning, Reason: "handshake accepted"},
+		{InstanceID: "inst-2", PluginID: "alpha", To: entities.PluginStatusRunning, Reason: "handshake accepted"},
+		{InstanceID: "inst-1", PluginID: "alpha", From: entities.PluginStatusRunning, To: entities.PluginStatusUnhealthy, Reason: "heartbeat timeout"},
+	} {
+		if err := repo.AppendTransition(tr); err != nil {
+			t.Fatalf("AppendTransition: %v", err)
+		}
+		if tr.ID == 0 || tr.CreatedAt.IsZero() {
+			t.Errorf("Expected ID and timestamp to be set, got %+v", tr)
+		}
+	}
+
+	history, err := repo.ListTransitions("inst-1")
+	if err != nil {
+		t.Fatalf("ListTransitions: %v", err)
+	}
+	if len(history) != 2 {
+		t.Fatalf("Expected 2 transitions, got %d", len(history))
+	}
+	if history[0].To != entities.PluginStatusRunning || history[1].From != entities.PluginStatusRunning ||
+		history[1].To != entities.PluginStatusUnhealthy || history[1].Reason != "heartbeat timeout" {
+		t.Errorf("Unexpected history %+v %+v", history[0], history[1])
+	}
+
+	if history, _ := repo.ListTransitions("missing"); len(history) != 0 {
+		t.Errorf("Expected empty history, got %d", len(history))
+	}
+}
+
+func testQueryDefinitions(t *testing.T, repo repository.Repository) {
+	createDefinition(t, repo, "alpha")
+	createDefinition(t, repo, "beta")
+	repo.UpsertDefinition(&entities.PluginDefinition{ID: "gamma", Version: "1.0.0", Capabilities: []string{"storage", "sync"}, Enabled: true})
+	repo.SetDefinitionEnabled("beta", false)
+
+	disabled := false
+	page, err := repo.QueryDefinitions(repository.DefinitionQuery{Enabled: &disabled})
+	if err != nil {
+		t.Fatalf("QueryDefinitions: %v", err)
+	}
+	if len(page.Items) != 1 || page.Items[0].ID != "beta" || page.Total != 1 {
+		t.Errorf("Expected only beta, got %+v", page)
+	}
+
+	page, err = repo.QueryDefinitions(repository.DefinitionQuery{Capability: "sync"})
+	if err != nil {
+		t.Fatalf("QueryDefinitions: %v", err)
+	}
+	if len(page.Items) != 1 || page.Items[0].ID != "gamma" {
+		t.Errorf("Expected only gamma for capability sync, got %+v", page.Items)
+	}
+
+	page, err = repo.QueryDefinitions(repository.DefinitionQuery{Sort: "-id"})
+	if err != nil {
+		t.Fatalf("QueryDefinitions: %v", err)
+	}
+	if len(page.Items) != 3 || page.Items[0].ID != "gamma" || page.Items[2].ID != "alpha" {
+		t.Errorf("Expected descending IDs, got %v", definitionIDs(page.Items))
+	}
+}
+
+func testQueryInstances(t *testing.T, repo repository.Repository) {
+	createDefinition(t, repo, "alpha")
+	repo.UpsertDefinition(&entities.PluginDefinition{ID: "gamma", Version: "1.0.0", Capabilities: []string{"sync"}, Enabled: true})
+
+	now := time.Now()
+	old := now.Add(-time.Hour)
+	createInstance(t, repo, &entities.PluginInstance{ID: "inst-1", DefinitionID: "alpha", Status: entities.PluginStatusRunning, Enabled: true, LastHeartbeat: &now, StartedAt: now})
+	createInstance(t, repo, &entities.PluginInstance{ID: "inst-2", DefinitionID: "alpha", Status: entities.PluginStatusStopped, Enabled: true, LastHeartbeat: &old, StartedAt: old})
+	createInstance(t, repo, &entities.PluginInstance{ID: "inst-3", DefinitionID: "gamma", Status: entities.PluginStatusUnhealthy, Enabled: true, LastHeartbeat: &old, StartedAt: now})
+	repo.SetInstanceEnabled("inst-3", false)
+
+	tests := []struct {
+		name  string
+		query repository.InstanceQuery
+		want  []string
+	}{
+		{"all", repository.InstanceQuery{}, []string{"inst-1", "inst-2", "inst-3"}},
+		{"definition", repository.InstanceQuery{DefinitionID: "alpha"}, []string{"inst-1", "inst-2"}},
+		{"status", repository.InstanceQuery{Status: []string{"stopped", "unhealthy"}}, []string{"inst-2", "inst-3"}},
+		{"enabled", repository.InstanceQuery{Enabled: boolPtr(false)}, []string{"inst-3"}},
+		{"capability", repository.InstanceQuery{Capability: "sync"}, []string{"inst-3"}},
+		{"heartbeat before", repository.InstanceQuery{HeartbeatBefore: now.Add(-time.Minute)}, []string{"inst-2", "inst-3"}},
+		{"heartbeat after", repository.InstanceQuery{HeartbeatAfter: now.Add(-time.Minute)}, []string{"inst-1"}},
+		// Bounds in another zone compare by instant
+		{"heartbeat before utc", repository.InstanceQuery{HeartbeatBefore: now.Add(-time.Minute).UTC()}, []string{"inst-2", "inst-3"}},
+		{"heartbeat after utc", repository.InstanceQuery{HeartbeatAfter: now.Add(-time.Minute).UTC()}, []string{"inst-1"}},
+		// Ties on started_at are broken by ID in the same direction
+		{"sort", repository.InstanceQuery{Sort: "-started_at"}, []string{"inst-3", "inst-1", "inst-2"}},
+	}
+
+	for _, tt := range tests {
+		page, err := repo.QueryInstances(tt.query)
+		if err != nil {
+			t.Fatalf("%s: QueryInstances: %v", tt.name, err)
+		}
+		got := instanceIDs(page.Items)
+		if !equalStrings(got, tt.want) {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.want, got)
+		}
+		if page.Total != int64(len(tt.want)) {
+			t.Errorf("%s: expected total %d, got %d", tt.name, len(tt.want), page.Total)
+		}
+	}
+}
+
+func testQueryPagination(t *testing.T, repo repository.Repository) {
+	createDefinition(t, repo, "alpha")
+
+	// Equal started_at values are ordered by ID across pages
+	start := time.Now().Add(-time.Hour)
+	for i := 0; i < 7; i++ {
+		started := start.Add(time.Duration(i/2) * time.Minute)
+		createInstance(t, repo, &entities.PluginInstance{
+			ID:           "inst-" + string(rune('a'+i)),
+			DefinitionID: "alpha",
+			Status:       entities.PluginStatusRunning,
+			Enabled:      true,
+			StartedAt:    started,
+		})
+	}
+
+	for _, sort := range []string{"", "-id", "started_at", "-started_at"} {
+		var seen []string
+		cursor := ""
+		for pages := 0; ; pages++ {
+			if pages > 10 {
+				t.Fatalf("sort %q: pagination did not terminate", sort)
+			}
+			page, err := repo.QueryInstances(repository.InstanceQuery{Sort: sort, Limit: 3, Cursor: cursor})
+			if err != nil {
+				t.Fatalf("sort %q: QueryInstances: %v", sort, err)
+			}
+			if page.Total != 7 {
+				t.Errorf("sort %q: expected total 7, got %d", sort, page.Total)
+			}
+			seen = append(seen, instanceIDs(page.Items)...)
+			if page.NextCursor == "" {
+				break
+			}
+			cursor = page.NextCursor
+		}
+
+		if len(seen) != 7 {
+			t.Errorf("sort %q: expected 7 instances across pages, got %v", sort, seen)
+		}
+		unique := map[string]bool{}
+		for _, id := range seen {
+			unique[id] = true
+		}
+		if len(unique) != 7 {
+			t.Errorf("sort %q: expected no duplicates across pages, got %v", sort, seen)
+		}
+	}
+}
+
+func testQueryInvalid(t *testing.T, repo repository.Repository) {
+	if _, err := repo.QueryInstances(repository.InstanceQuery{Sort: "auth_token"}); !errors.Is(err, repository.ErrInvalidQuery) {
+		t.Errorf("Expected ErrInvalidQuery for unknown sort, got %v", err)
+	}
+	if _, err := repo.QueryDefinitions(repository.DefinitionQuery{Cursor: "garbage"}); !errors.Is(err, repository.ErrInvalidQuery) {
+		t.Errorf("Expected ErrInvalidQuery for bad cursor, got %v", err)
+	}
+
+	// A cursor only applies to the sort it was issued for
+	cursor := repository.NewCursor(repository.Sort{Field: "id"}, "a", "a")
+	if _, err := repo.QueryInstances(repository.InstanceQuery{Sort: "-id", Cursor: cursor}); !errors.Is(err, repository.ErrInvalidQuery) {
+		t.Errorf("Expected ErrInvalidQuery for mismatched cursor, got %v", err)
+	}
+}
+
+func definitionIDs(defs []*entities.PluginDefinition) []string {
+	ids := make([]string, 0, len(defs))
+	for _, def := range defs {
+		ids = append(ids, def.ID)
+	}
+	return ids
+}
+
+func instanceIDs(instances []*entities.PluginInstance) []string {
+	ids := make([]string, 0, len(instances))
+	for _, inst := range instances {
+		ids = append(ids, inst.ID)
+	}
+	return ids
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func boolPtr(b bool) *bool {
+	return &b
+}
@@ -3,6 +3,7 @@ package config
 import (
 	"fmt"
 	"os"
+	"strconv"
 
 	"gopkg.in/yaml.v3"
 )
@@ -10,28 +11,164 @@ import (
 // Config holds all configuration for the application
 // TODO: Add validation with specific errors
 type Config struct {
-	Server   ServerConfig   `yaml:"server"`
-	Database DatabaseConfig `yaml:"database"`
-	Security SecurityConfig `yaml:"security"`
-	LogLevel string         `yaml:"log_level"`
+	Server     ServerConfig     `yaml:"server"`
+	Database   DatabaseConfig   `yaml:"database"`
+	Security   SecurityConfig   `yaml:"security"`
+	Heartbeat  HeartbeatConfig  `yaml:"heartbeat"`
+	Backup     BackupConfig     `yaml:"backup"`
+	Instances  InstancesConfig  `yaml:"instances"`
+	Probes     ProbeConfig      `yaml:"probes"`
+	Flapping   FlappingConfig   `yaml:"flapping"`
+	Shutdown   ShutdownConfig   `yaml:"shutdown"`
+	PluginLogs PluginLogsConfig `yaml:"plugin_logs"`
+	LogLevel   string           `yaml:"log_level"`
+	Log        LogConfig        `yaml:"log"`
+	Tracing    TracingConfig    `yaml:"tracing"`
 }
 
 // ServerConfig holds HTTP and gRPC server settings
+// Host, Port and HTTPPort give the gRPC and HTTP addresses of listeners
+// whose address is not set.
 type ServerConfig struct {
 	Host     string `yaml:"host"`
 	Port     int    `yaml:"port"`
 	HTTPPort int    `yaml:"http_port"`
+	// GRPC is the plugin gRPC service
+	GRPC ListenerConfig `yaml:"grpc"`
+	// HTTP is the plugin HTTP API, and the admin API unless Admin is set
+	HTTP ListenerConfig `yaml:"http"`
+	// Admin serves the admin API (definitions, instances, log levels and
+	// metrics) on its own listener
+	Admin ListenerConfig `yaml:"admin"`
 }
 
 // DatabaseConfig holds database connection settings
 type DatabaseConfig struct {
-	Type     string `yaml:"type"`
-	Path     string `yaml:"path"`
-	Host     string `yaml:"host"`
-	Port     int    `yaml:"port"`
-	Name     string `yaml:"name"`
-	User     string `yaml:"user"`
-	Password string `yaml:"password"`
+	Type     string     `yaml:"type"` // sqlite, postgres, memory
+	Path     string     `yaml:"path"`
+	Host     string     `yaml:"host"`
+	Port     int        `yaml:"port"`
+	Name     string     `yaml:"name"`
+	User     string     `yaml:"user"`
+	Password string     `yaml:"password"`
+	SSLMode  string     `yaml:"ssl_mode"`
+	Schema   string     `yaml:"schema"` // Postgres search_path
+	Pool     PoolConfig `yaml:"pool"`
+	// AutoMigrate applies pending migrations at startup
+	// When false the core refuses to start until `milpa migrate up` is run
+	AutoMigrate bool `yaml:"auto_migrate"`
+}
+
+// PoolConfig holds connection pool settings
+// Zero values keep the database/sql defaults
+type PoolConfig struct {
+	MaxOpenConns    int    `yaml:"max_open_conns"`
+	MaxIdleConns    int    `yaml:"max_idle_conns"`
+	ConnMaxLifetime string `yaml:"conn_max_lifetime"`
+	ConnMaxIdleTime string `yaml:"conn_max_idle_time"`
+}
+
+// Database types
+const (
+	DatabaseTypeSQLite   = "sqlite"
+	DatabaseTypePostgres = "postgres"
+	DatabaseTypeMemory   = "memory"
+)
+
+// HeartbeatConfig holds heartbeat processing settings
+// The heartbeat timeout itself lives in SecurityConfig
+type HeartbeatConfig struct {
+	// CheckInterval is how often liveness is checked for expired heartbeats
+	CheckInterval string `yaml:"check_interval"`
+	// FlushInterval is how often heartbeat timestamps are written to the database
+	FlushInterval string `yaml:"flush_interval"`
+}
+
+// BackupConfig holds scheduled backup settings
+type BackupConfig struct {
+	Enabled  bool   `yaml:"enabled"`
+	Dir      string `yaml:"dir"`
+	Interval string `yaml:"interval"`
+	// Retention is the number of backups kept in Dir
+	Retention int `yaml:"retention"`
+}
+
+// InstancesConfig holds settings for stale instance cleanup
+type InstancesConfig struct {
+	// RetireAfter is how long an unhealthy or stopped instance is kept
+	// before it is retired and can no longer be resumed
+	RetireAfter string `yaml:"retire_after"`
+	// Retention is how long retired instances are kept before deletion
+	Retention string `yaml:"retention"`
+	// GCInterval is how often stale instances are retired and purged
+	GCInterval string `yaml:"gc_interval"`
+}
+
+// ProbeConfig holds settings for active health probes of plugin endpoints
+type ProbeConfig struct {
+	Interval string `yaml:"interval"`
+	Timeout  string `yaml:"timeout"`
+	// FailureThreshold is the number of consecutive failed probes that
+	// marks an instance unhealthy
+	FailureThreshold int `yaml:"failure_threshold"`
+	// AllowedHosts lists the hosts, IPs and CIDRs a plugin may name as its
+	// health endpoint host besides the address it connected from
+	AllowedHosts []string `yaml:"allowed_hosts"`
+}
+
+// FlappingConfig holds settings for quarantining flapping instances
+type FlappingConfig struct {
+	// Window is the sliding window in which flaps are counted
+	Window string `yaml:"window"`
+	// Threshold is the number of times an instance may become unhealthy
+	// within Window before it is quarantined
+	Threshold int `yaml:"threshold"`
+	// Cooldown is how long an instance stays quarantined unless an admin
+	// releases it first
+	Cooldown string `yaml:"cooldown"`
+}
+
+// ShutdownConfig holds graceful shutdown settings
+type ShutdownConfig struct {
+	// DrainTimeout is how long plugins get to acknowledge the shutdown event
+	// or disconnect before state is flushed and the servers are closed
+	DrainTimeout string `yaml:"drain_timeout"`
+	// ServerTimeout is how long, after the drain, the HTTP server gets to
+	// finish its requests and the tracer to export the remaining spans
+	ServerTimeout string `yaml:"server_timeout"`
+}
+
+// PluginLogsConfig holds settings for logs forwarded by plugins
+type PluginLogsConfig struct {
+	// PerInstance is the number of recent records kept for each instance
+	PerInstance int `yaml:"per_instance"`
+}
+
+// LogConfig holds log output settings; the level is LogLevel
+type LogConfig struct {
+	// Format is text or json
+	Format string `yaml:"format"`
+	// File is the log file; empty writes to stdout
+	File string `yaml:"file"`
+	// MaxSizeMB and MaxAge rotate the file once it grows past the size or
+	// has been written for longer than the age; zero disables each
+	MaxSizeMB int    `yaml:"max_size_mb"`
+	MaxAge    string `yaml:"max_age"`
+	// MaxBackups is the number of rotated files kept (0 keeps all)
+	MaxBackups int `yaml:"max_backups"`
+}
+
+// TracingConfig holds span export settings
+// Trace context is propagated even when no exporter is set.
+type TracingConfig struct {
+	// Exporter is otlp, file or empty to record no spans
+	Exporter string `yaml:"exporter"`
+	// Endpoint is the OTLP/HTTP collector, e.g. http://localhost:4318
+	Endpoint string `yaml:"endpoint"`
+	// File receives spans as OTLP/JSON lines with the file exporter
+	File string `yaml:"file"`
+	// ServiceName is reported as service.name
+	ServiceName string `yaml:"service_name"`
 }
 
 // SecurityConfig holds security settings
@@ -54,32 +191,76 @@ func Load() (*Config, error) {
 			HTTPPort: 8080,
 		},
 		Database: DatabaseConfig{
-			Type: "sqlite",
-			Path: "./milpa.db",
+			Type:    DatabaseTypeSQLite,
+			Path:    "./milpa.db",
+			Port:    5432,
+			SSLMode: "disable",
 		},
 		Security: SecurityConfig{
 			Enabled:          false,
 			HeartbeatTimeout: "30s",
 		},
+		Heartbeat: HeartbeatConfig{
+			CheckInterval: "10s",
+			FlushInterval: "5s",
+		},
+		Backup: BackupConfig{
+			Enabled:   false,
+			Dir:       "./backups",
+			Interval:  "24h",
+			Retention: 7,
+		},
+		Instances: InstancesConfig{
+			RetireAfter: "1h",
+			Retention:   "168h",
+			GCInterval:  "10m",
+		},
+		Probes: ProbeConfig{
+			Interval:         "15s",
+			Timeout:          "2s",
+			FailureThreshold: 3,
+		},
+		Flapping: FlappingConfig{
+			Window:    "10m",
+			Threshold: 3,
+			Cooldown:  "30m",
+		},
+		Shutdown: ShutdownConfig{
+			DrainTimeout:  "10s",
+			ServerTimeout: "10s",
+		},
+		PluginLogs: PluginLogsConfig{
+			PerInstance: 1000,
+		},
 		LogLevel: "info",
+		Log: LogConfig{
+			Format:     "text",
+			MaxSizeMB:  100,
+			MaxBackups: 7,
+		},
+		Tracing: TracingConfig{
+			ServiceName: "milpa-core",
+		},
 	}
 
 	// Try to read config file
 	data, err := os.ReadFile("config.yml")
-	if err != nil {
-		if os.IsNotExist(err) {
-			// Config file is optional - use defaults
-			return applyEnvOverrides(cfg), nil
-		}
+	if err != nil && !os.IsNotExist(err) {
 		return nil, fmt.Errorf("failed to read config: %w", err)
 	}
 
-	// Parse YAML
-	if err := yaml.Unmarshal(data, cfg); err != nil {
-		return nil, fmt.Errorf("failed to parse config: %w", err)
+	// Parse YAML; the config file is optional
+	if err == nil {
+		if err := yaml.Unmarshal(data, cfg); err != nil {
+			return nil, fmt.Errorf("failed to parse config: %w", err)
+		}
 	}
 
-	return applyEnvOverrides(cfg), nil
+	cfg = applyEnvOverrides(cfg)
+	if err := cfg.Server.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+	return cfg, nil
 }
 
 // applyEnvOverrides applies environment variable overrides
@@ -94,6 +275,55 @@ func applyEnvOverrides(cfg *Config) *Config {
 		cfg.Database.Path = path
 	}
 
+	// Database backend and credentials from environment
+	if dbType := os.Getenv("MILPA_DB_TYPE"); dbType != "" {
+		cfg.Database.Type = dbType
+	}
+	if host := os.Getenv("MILPA_DB_HOST"); host != "" {
+		cfg.Database.Host = host
+	}
+	if port := os.Getenv("MILPA_DB_PORT"); port != "" {
+		if p, err := strconv.Atoi(port); err == nil {
+			cfg.Database.Port = p
+		}
+	}
+	if name := os.Getenv("MILPA_DB_NAME"); name != "" {
+		cfg.Database.Name = name
+	}
+	if user := os.Getenv("MILPA_DB_USER"); user != "" {
+		cfg.Database.User = user
+	}
+	if password := os.Getenv("MILPA_DB_PASSWORD"); password != "" {
+		cfg.Database.Password = password
+	}
+
+	// Listener addresses from environment ("unix:PATH" for a unix socket)
+	if addr := os.Getenv("MILPA_GRPC_ADDR"); addr != "" {
+		cfg.Server.GRPC = ParseListener(addr)
+	}
+	if addr := os.Getenv("MILPA_HTTP_ADDR"); addr != "" {
+		cfg.Server.HTTP = ParseListener(addr)
+	}
+	if addr := os.Getenv("MILPA_ADMIN_ADDR"); addr != "" {
+		cfg.Server.Admin = ParseListener(addr)
+	}
+
+	// Log output from environment
+	if format := os.Getenv("MILPA_LOG_FORMAT"); format != "" {
+		cfg.Log.Format = format
+	}
+	if file := os.Getenv("MILPA_LOG_FILE"); file != "" {
+		cfg.Log.File = file
+	}
+
+	// Span export from environment
+	if exporter := os.Getenv("MILPA_TRACING_EXPORTER"); exporter != "" {
+		cfg.Tracing.Exporter = exporter
+	}
+	if endpoint := os.Getenv("MILPA_TRACING_ENDPOINT"); endpoint != "" {
+		cfg.Tracing.Endpoint = endpoint
+	}
+
 	// Validate security config
 	if cfg.Security.Enabled && cfg.Security.PluginToken == "" {
 		panic("security.enabled is true but MILPA_PLUGIN_TOKEN is not set")
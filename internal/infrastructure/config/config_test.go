@@ -82,3 +82,56 @@ type configError struct {
 func (e *configError) Error() string {
 	return e.msg
 }
+
+func TestServerListeners(t *testing.T) {
+	server := ServerConfig{Host: "0.0.0.0", Port: 8081, HTTPPort: 8080}
+
+	if got := server.GRPCListener(); got.Address != "0.0.0.0:8081" || got.NetworkOrDefault() != NetworkTCP {
+		t.Errorf("Expected gRPC on tcp 0.0.0.0:8081, got %+v", got)
+	}
+	if got := server.HTTPListener(); got.Address != "0.0.0.0:8080" {
+		t.Errorf("Expected HTTP on 0.0.0.0:8080, got %+v", got)
+	}
+	if _, ok := server.AdminListener(); ok {
+		t.Error("Expected the admin API to share the HTTP listener by default")
+	}
+	if err := server.Validate(); err != nil {
+		t.Errorf("Expected defaults to be valid: %v", err)
+	}
+
+	server.GRPC = ParseListener("unix:/run/milpa/grpc.sock")
+	server.GRPC.Mode = "0660"
+	server.Admin = ParseListener("127.0.0.1:9090")
+	if got := server.GRPCListener(); got.Network != NetworkUnix || got.Address != "/run/milpa/grpc.sock" {
+		t.Errorf("Expected the unix socket listener, got %+v", got)
+	}
+	if mode, err := server.GRPC.FileMode(); err != nil || mode != 0o660 {
+		t.Errorf("Expected mode 0660, got %v (%v)", mode, err)
+	}
+	if err := server.Validate(); err != nil {
+		t.Errorf("Expected listeners to be valid: %v", err)
+	}
+}
+
+func TestServerListenersValidation(t *testing.T) {
+	tests := []struct {
+		name   string
+		server ServerConfig
+	}{
+		{"unknown network", ServerConfig{GRPC: ListenerConfig{Network: "udp", Address: ":1"}, HTTPPort: 8080}},
+		{"missing port", ServerConfig{HTTP: ListenerConfig{Address: "localhost"}, Port: 8081}},
+		{"mode on tcp", ServerConfig{HTTP: ListenerConfig{Address: ":8080", Mode: "0600"}, Port: 8081}},
+		{"bad mode", ServerConfig{GRPC: ListenerConfig{Network: "unix", Address: "/tmp/g.sock", Mode: "rw"}, HTTPPort: 8080}},
+		{"no socket path", ServerConfig{GRPC: ListenerConfig{Network: "unix"}, HTTPPort: 8080}},
+		{"shared address", ServerConfig{Host: "0.0.0.0", Port: 8080, HTTPPort: 8080}},
+		{"admin on http", ServerConfig{Host: "0.0.0.0", Port: 8081, HTTPPort: 8080, Admin: ListenerConfig{Address: "0.0.0.0:8080"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.server.Validate(); err == nil {
+				t.Error("Expected a validation error")
+			}
+		})
+	}
+}
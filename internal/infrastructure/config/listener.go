@@ -0,0 +1,133 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Listener networks
+const (
+	NetworkTCP  = "tcp"
+	NetworkUnix = "unix"
+)
+
+// ListenerConfig is the address a server listens on
+type ListenerConfig struct {
+	// Network is tcp (default) or unix
+	Network string `yaml:"network"`
+	// Address is host:port for tcp or the socket path for unix
+	Address string `yaml:"address"`
+	// Mode is the octal file mode of a unix socket, e.g. "0660"; empty
+	// keeps the mode given by the umask. With a mode the socket is created
+	// owner-only and then changed to it.
+	Mode string `yaml:"mode"`
+}
+
+// IsSet reports whether the listener is configured
+func (l ListenerConfig) IsSet() bool {
+	return l.Network != "" || l.Address != ""
+}
+
+// NetworkOrDefault returns the listener network, tcp when unset
+func (l ListenerConfig) NetworkOrDefault() string {
+	if l.Network == "" {
+		return NetworkTCP
+	}
+	return l.Network
+}
+
+// FileMode returns the socket file mode, or 0 when Mode is empty
+func (l ListenerConfig) FileMode() (os.FileMode, error) {
+	if l.Mode == "" {
+		return 0, nil
+	}
+	mode, err := strconv.ParseUint(l.Mode, 8, 32)
+	if err != nil || mode > 0o777 {
+		return 0, fmt.Errorf("invalid socket mode %q", l.Mode)
+	}
+	return os.FileMode(mode), nil
+}
+
+// Validate checks the network, address and mode of the listener
+func (l ListenerConfig) Validate() error {
+	switch l.NetworkOrDefault() {
+	case NetworkTCP:
+		if _, _, err := net.SplitHostPort(l.Address); err != nil {
+			return fmt.Errorf("invalid tcp address %q: %w", l.Address, err)
+		}
+		if l.Mode != "" {
+			return fmt.Errorf("mode is only valid for unix sockets")
+		}
+	case NetworkUnix:
+		if l.Address == "" {
+			return fmt.Errorf("unix socket path is required")
+		}
+		if _, err := l.FileMode(); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown network %q (want tcp or unix)", l.Network)
+	}
+	return nil
+}
+
+// String returns the listener as network://address
+func (l ListenerConfig) String() string {
+	return l.NetworkOrDefault() + "://" + l.Address
+}
+
+// GRPCListener returns the gRPC listener, Host:Port when unset
+func (s ServerConfig) GRPCListener() ListenerConfig {
+	if s.GRPC.IsSet() {
+		return s.GRPC
+	}
+	return ListenerConfig{Network: NetworkTCP, Address: net.JoinHostPort(s.Host, strconv.Itoa(s.Port))}
+}
+
+// HTTPListener returns the plugin HTTP API listener, Host:HTTPPort when unset
+func (s ServerConfig) HTTPListener() ListenerConfig {
+	if s.HTTP.IsSet() {
+		return s.HTTP
+	}
+	return ListenerConfig{Network: NetworkTCP, Address: net.JoinHostPort(s.Host, strconv.Itoa(s.HTTPPort))}
+}
+
+// AdminListener returns the admin API listener; false means the admin API
+// is served by the plugin HTTP API listener
+func (s ServerConfig) AdminListener() (ListenerConfig, bool) {
+	return s.Admin, s.Admin.IsSet()
+}
+
+// Validate checks the listeners, which must not share an address
+func (s ServerConfig) Validate() error {
+	names := []string{"grpc", "http"}
+	listeners := []ListenerConfig{s.GRPCListener(), s.HTTPListener()}
+	if admin, ok := s.AdminListener(); ok {
+		names = append(names, "admin")
+		listeners = append(listeners, admin)
+	}
+
+	seen := make(map[string]string)
+	for i, l := range listeners {
+		if err := l.Validate(); err != nil {
+			return fmt.Errorf("server.%s: %w", names[i], err)
+		}
+		if other, ok := seen[l.String()]; ok {
+			return fmt.Errorf("server.%s: %s is already used by server.%s", names[i], l, other)
+		}
+		seen[l.String()] = names[i]
+	}
+	return nil
+}
+
+// ParseListener parses "unix:PATH" as a unix socket and anything else as
+// a tcp host:port
+func ParseListener(addr string) ListenerConfig {
+	if path, ok := strings.CutPrefix(addr, NetworkUnix+":"); ok {
+		return ListenerConfig{Network: NetworkUnix, Address: path}
+	}
+	return ListenerConfig{Network: NetworkTCP, Address: addr}
+}
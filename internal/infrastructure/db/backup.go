@@ -0,0 +1,534 @@
+package db
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/robrt95x/milpa-cloud/internal/domain/entities"
+	"github.com/robrt95x/milpa-cloud/internal/infrastructure/config"
+	"github.com/robrt95x/milpa-cloud/pkg/logger"
+
+	"gorm.io/gorm"
+)
+
+// Backup formats
+const (
+	// BackupFormatSQLite is a copy of the SQLite database file made with VACUUM INTO
+	BackupFormatSQLite = "sqlite"
+	// BackupFormatSnapshot is a gzipped JSON export of every table, used for Postgres
+	BackupFormatSnapshot = "snapshot"
+)
+
+// Backup errors
+var (
+	ErrInvalidBackup        = errors.New("file is not a milpa backup")
+	ErrBackupSchemaMismatch = errors.New("backup schema version does not match this binary")
+)
+
+const (
+	backupPrefix       = "milpa-"
+	sqliteBackupExt    = ".db"
+	snapshotBackupExt  = ".snapshot.json.gz"
+	snapshotFormatName = "milpa-snapshot"
+)
+
+var sqliteHeader = []byte("SQLite format 3\x00")
+
+// BackupInfo describes a backup file
+type BackupInfo struct {
+	Path          string
+	Format        string
+	SchemaVersion int
+	CreatedAt     time.Time
+	Size          int64
+}
+
+// snapshot is the on-disk layout of a BackupFormatSnapshot file
+type snapshot struct {
+	Format        string                        `json:"format"`
+	Dialect       string                        `json:"dialect"`
+	SchemaVersion int                           `json:"schema_version"`
+	CreatedAt     time.Time                     `json:"created_at"`
+	Definitions   []entities.PluginDefinition   `json:"definitions"`
+	Instances     []snapshotInstance            `json:"instances"`
+	Config        []entities.PluginConfigEntry  `json:"config"`
+	Events        []entities.EventRecord        `json:"events"`
+	Transitions   []entities.InstanceTransition `json:"transitions"`
+}
+
+// snapshotInstance keeps the auth token, which PluginInstance hides from JSON
+type snapshotInstance struct {
+	entities.PluginInstance
+	AuthToken string `json:"auth_token"`
+}
+
+// Backup writes a consistent copy of the database to dest while it is in use
+// SQLite databases are copied with VACUUM INTO; other backends are exported
+// as a snapshot inside a read-only repeatable-read transaction. The file is
+// written next to dest and renamed into place once complete.
+func (r *Repository) Backup(ctx context.Context, dest string) (*BackupInfo, error) {
+	version, err := r.SchemaVersion()
+	if err != nil {
+		return nil, err
+	}
+
+	tmp := dest + ".tmp"
+	os.Remove(tmp)
+	defer os.Remove(tmp)
+
+	info := &BackupInfo{
+		Path:          dest,
+		SchemaVersion: version,
+		CreatedAt:     time.Now().UTC(),
+	}
+
+	if r.db.Dialector.Name() == "sqlite" {
+		info.Format = BackupFormatSQLite
+		err = r.db.WithContext(ctx).Exec("VACUUM INTO ?", tmp).Error
+	} else {
+		info.Format = BackupFormatSnapshot
+		err = r.writeSnapshot(ctx, tmp, info)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("backup failed: %w", err)
+	}
+
+	if err := os.Rename(tmp, dest); err != nil {
+		return nil, err
+	}
+	if st, err := os.Stat(dest); err == nil {
+		info.Size = st.Size()
+	}
+
+	return info, nil
+}
+
+// BackupToDir writes a timestamped backup into dir
+func (r *Repository) BackupToDir(ctx context.Context, dir string) (*BackupInfo, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+
+	format := BackupFormatSnapshot
+	if r.db.Dialector.Name() == "sqlite" {
+		format = BackupFormatSQLite
+	}
+	return r.Backup(ctx, filepath.Join(dir, BackupFileName(format, time.Now())))
+}
+
+func (r *Repository) writeSnapshot(ctx context.Context, path string, info *BackupInfo) error {
+	snap := snapshot{
+		Format:        snapshotFormatName,
+		Dialect:       r.db.Dialector.Name(),
+		SchemaVersion: info.SchemaVersion,
+		CreatedAt:     info.CreatedAt,
+	}
+
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Order("id").Find(&snap.Definitions).Error; err != nil {
+			return err
+		}
+		var instances []entities.PluginInstance
+		if err := tx.Order("id").Find(&instances).Error; err != nil {
+			return err
+		}
+		for _, inst := range instances {
+			snap.Instances = append(snap.Instances, snapshotInstance{PluginInstance: inst, AuthToken: inst.AuthToken})
+		}
+		if err := tx.Order("plugin_id, key").Find(&snap.Config).Error; err != nil {
+			return err
+		}
+		if err := tx.Order("id").Find(&snap.Events).Error; err != nil {
+			return err
+		}
+		return tx.Order("id").Find(&snap.Transitions).Error
+	}, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	zw := gzip.NewWriter(f)
+	if err := json.NewEncoder(zw).Encode(&snap); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+// Restore replaces the configured database with the contents of a backup
+// The backup must be at the schema version of this binary. The core must
+// be stopped while restoring: ErrCoreRunning is returned while a core holds
+// a lease on the database. A SQLite database file being replaced is kept
+// as <path>.pre-restore.
+func Restore(ctx context.Context, cfg *config.Config, src string) (*BackupInfo, error) {
+	format, err := DetectBackupFormat(src)
+	if err != nil {
+		return nil, err
+	}
+
+	switch format {
+	case BackupFormatSQLite:
+		return restoreSQLite(ctx, cfg, src)
+	default:
+		return restoreSnapshot(ctx, cfg, src)
+	}
+}
+
+// DetectBackupFormat inspects the header of a backup file
+func DetectBackupFormat(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	header := make([]byte, len(sqliteHeader))
+	n, _ := io.ReadFull(f, header)
+	header = header[:n]
+
+	switch {
+	case bytes.Equal(header, sqliteHeader):
+		return BackupFormatSQLite, nil
+	case len(header) >= 2 && header[0] == 0x1f && header[1] == 0x8b:
+		return BackupFormatSnapshot, nil
+	default:
+		return "", fmt.Errorf("%w: %s", ErrInvalidBackup, path)
+	}
+}
+
+func restoreSQLite(ctx context.Context, cfg *config.Config, src string) (*BackupInfo, error) {
+	dbType := strings.ToLower(cfg.Database.Type)
+	if dbType != "" && dbType != config.DatabaseTypeSQLite {
+		return nil, fmt.Errorf("a sqlite backup cannot be restored into a %s database", cfg.Database.Type)
+	}
+
+	target := cfg.Database.Path
+	staging := target + ".restore"
+	os.Remove(staging)
+	defer os.Remove(staging)
+
+	// Validate a copy so neither the backup nor the live database is touched
+	if err := copyFile(src, staging); err != nil {
+		return nil, err
+	}
+	version, err := validateSQLiteBackup(ctx, staging)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := os.Stat(target); err == nil {
+		if err := checkNoRunningCore(ctx, cfg); err != nil {
+			return nil, err
+		}
+		if err := os.Rename(target, target+".pre-restore"); err != nil {
+			return nil, err
+		}
+	}
+	os.Remove(target + "-wal")
+	os.Remove(target + "-shm")
+	if err := os.Rename(staging, target); err != nil {
+		return nil, err
+	}
+
+	info := &BackupInfo{Path: src, Format: BackupFormatSQLite, SchemaVersion: version}
+	if st, err := os.Stat(target); err == nil {
+		info.Size = st.Size()
+		info.CreatedAt = st.ModTime()
+	}
+	return info, nil
+}
+
+// checkNoRunningCore opens the configured database and returns
+// ErrCoreRunning when a core holds a lease on it
+func checkNoRunningCore(ctx context.Context, cfg *config.Config) error {
+	repo, err := Open(cfg)
+	if err != nil {
+		return err
+	}
+	defer repo.Close()
+	return repo.checkNoRunningCore(ctx)
+}
+
+// validateSQLiteBackup checks the integrity and schema version of a staged
+// database file, and clears the core leases copied with it
+func validateSQLiteBackup(ctx context.Context, path string) (int, error) {
+	repo, err := Open(&config.Config{Database: config.DatabaseConfig{
+		Type: config.DatabaseTypeSQLite,
+		Path: path,
+	}})
+	if err != nil {
+		return 0, err
+	}
+	defer repo.Close()
+
+	var result string
+	if err := repo.db.WithContext(ctx).Raw("PRAGMA integrity_check").Scan(&result).Error; err != nil {
+		return 0, fmt.Errorf("%w: %v", ErrInvalidBackup, err)
+	}
+	if result != "ok" {
+		return 0, fmt.Errorf("%w: integrity check failed: %s", ErrInvalidBackup, result)
+	}
+	// Leases copied with the file belong to the core that was running then
+	if repo.db.Migrator().HasTable(&coreLease{}) {
+		if err := repo.db.WithContext(ctx).Exec("DELETE FROM core_leases").Error; err != nil {
+			return 0, err
+		}
+	}
+
+	version, err := repo.SchemaVersion()
+	if err != nil {
+		return 0, err
+	}
+	if err := repo.CheckSchema(); err != nil {
+		return version, fmt.Errorf("%w: %v", ErrBackupSchemaMismatch, err)
+	}
+	return version, nil
+}
+
+func restoreSnapshot(ctx context.Context, cfg *config.Config, src string) (*BackupInfo, error) {
+	snap, err := readSnapshot(src)
+	if err != nil {
+		return nil, err
+	}
+
+	repo, err := Open(cfg)
+	if err != nil {
+		return nil, err
+	}
+	defer repo.Close()
+
+	latest, err := repo.LatestSchemaVersion()
+	if err != nil {
+		return nil, err
+	}
+	if snap.SchemaVersion != latest {
+		return nil, fmt.Errorf("%w: backup is at version %d, binary expects %d",
+			ErrBackupSchemaMismatch, snap.SchemaVersion, latest)
+	}
+	if err := repo.checkNoRunningCore(ctx); err != nil {
+		return nil, err
+	}
+
+	// The target must be empty or at the same version as the backup
+	if err := repo.prepareSchema(false); err != nil {
+		return nil, err
+	}
+
+	err = repo.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		for _, table := range []string{"instance_transitions", "event_records", "plugin_config_entries", "plugin_instances", "plugin_definitions"} {
+			if err := tx.Exec("DELETE FROM " + table).Error; err != nil {
+				return err
+			}
+		}
+
+		// GORM replaces a false enabled with the column default on insert,
+		// so it is written again afterwards
+		for i := range snap.Definitions {
+			def := &snap.Definitions[i]
+			enabled := def.Enabled
+			if err := tx.Create(def).Error; err != nil {
+				return err
+			}
+			if err := tx.Model(def).UpdateColumn("enabled", enabled).Error; err != nil {
+				return err
+			}
+		}
+		for i := range snap.Instances {
+			inst := snap.Instances[i].PluginInstance
+			inst.AuthToken = snap.Instances[i].AuthToken
+			enabled := inst.Enabled
+			if err := tx.Omit("Definition").Create(&inst).Error; err != nil {
+				return err
+			}
+			if err := tx.Model(&inst).UpdateColumn("enabled", enabled).Error; err != nil {
+				return err
+			}
+		}
+		for i := range snap.Config {
+			if err := tx.Create(&snap.Config[i]).Error; err != nil {
+				return err
+			}
+		}
+		for i := range snap.Events {
+			if err := tx.Create(&snap.Events[i]).Error; err != nil {
+				return err
+			}
+		}
+		for i := range snap.Transitions {
+			if err := tx.Create(&snap.Transitions[i]).Error; err != nil {
+				return err
+			}
+		}
+
+		if tx.Dialector.Name() == "postgres" {
+			for _, table := range []string{"event_records", "instance_transitions"} {
+				err := tx.Exec(fmt.Sprintf(`SELECT setval(pg_get_serial_sequence('%[1]s', 'id'),
+					COALESCE(MAX(id), 1), MAX(id) IS NOT NULL) FROM %[1]s`, table)).Error
+				if err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("restore failed: %w", err)
+	}
+
+	info := &BackupInfo{
+		Path:          src,
+		Format:        BackupFormatSnapshot,
+		SchemaVersion: snap.SchemaVersion,
+		CreatedAt:     snap.CreatedAt,
+	}
+	if st, err := os.Stat(src); err == nil {
+		info.Size = st.Size()
+	}
+	return info, nil
+}
+
+func readSnapshot(path string) (*snapshot, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidBackup, err)
+	}
+	defer zr.Close()
+
+	var snap snapshot
+	if err := json.NewDecoder(zr).Decode(&snap); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidBackup, err)
+	}
+	if snap.Format != snapshotFormatName {
+		return nil, fmt.Errorf("%w: unknown format %q", ErrInvalidBackup, snap.Format)
+	}
+	return &snap, nil
+}
+
+// BackupFileName returns the file name used for backups taken at t
+// Names sort chronologically, which PruneBackups relies on
+func BackupFileName(format string, t time.Time) string {
+	ext := snapshotBackupExt
+	if format == BackupFormatSQLite {
+		ext = sqliteBackupExt
+	}
+	return backupPrefix + t.UTC().Format("20060102T150405.000Z") + ext
+}
+
+// ListBackups returns the backup files in dir, oldest first
+func ListBackups(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, backupPrefix) {
+			continue
+		}
+		if strings.HasSuffix(name, sqliteBackupExt) || strings.HasSuffix(name, snapshotBackupExt) {
+			files = append(files, filepath.Join(dir, name))
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// PruneBackups deletes all but the newest keep backups in dir
+func PruneBackups(dir string, keep int) ([]string, error) {
+	files, err := ListBackups(dir)
+	if err != nil {
+		return nil, err
+	}
+	if keep < 1 || len(files) <= keep {
+		return nil, nil
+	}
+
+	var removed []string
+	for _, file := range files[:len(files)-keep] {
+		if err := os.Remove(file); err != nil {
+			return removed, err
+		}
+		removed = append(removed, file)
+	}
+	return removed, nil
+}
+
+// RunScheduledBackups takes a backup every interval until ctx is cancelled
+// Only the newest cfg.Retention backups are kept
+func (r *Repository) RunScheduledBackups(ctx context.Context, cfg config.BackupConfig, log logger.Logger) {
+	interval, err := time.ParseDuration(cfg.Interval)
+	if err != nil || interval <= 0 {
+		log.Error("invalid backup interval, scheduled backups disabled", "interval", cfg.Interval)
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			info, err := r.BackupToDir(ctx, cfg.Dir)
+			if err != nil {
+				log.Error("scheduled backup failed", "error", err)
+				continue
+			}
+			log.Info("backup written", "path", info.Path, "size", info.Size)
+
+			removed, err := PruneBackups(cfg.Dir, cfg.Retention)
+			if err != nil {
+				log.Error("failed to prune backups", "error", err)
+			}
+			for _, file := range removed {
+				log.Debug("old backup removed", "path", file)
+			}
+		}
+	}
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,286 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/robrt95x/milpa-cloud/internal/domain/entities"
+	"github.com/robrt95x/milpa-cloud/internal/domain/repository"
+	"github.com/robrt95x/milpa-cloud/internal/infrastructure/config"
+	"github.com/robrt95x/milpa-cloud/internal/infrastructure/db/dbtest"
+	"github.com/robrt95x/milpa-cloud/pkg/logger"
+)
+
+func seedBackupData(t *testing.T, repo *Repository) {
+	t.Helper()
+
+	def := &entities.PluginDefinition{ID: "webdav", Version: "1.0.0", Enabled: true}
+	if err := repo.UpsertDefinition(def); err != nil {
+		t.Fatalf("Failed to create definition: %v", err)
+	}
+	if err := repo.SetDefinitionEnabled("webdav", false); err != nil {
+		t.Fatalf("Failed to disable definition: %v", err)
+	}
+	inst := &entities.PluginInstance{
+		ID:           "inst-1",
+		DefinitionID: "webdav",
+		Status:       entities.PluginStatusRunning,
+		Enabled:      true,
+		AuthToken:    "secret-token",
+		StartedAt:    time.Now(),
+	}
+	if err := repo.CreateInstance(inst); err != nil {
+		t.Fatalf("Failed to create instance: %v", err)
+	}
+	if err := repo.SetConfig("webdav", map[string]string{"root": "/srv"}); err != nil {
+		t.Fatalf("Failed to set config: %v", err)
+	}
+	if err := repo.AppendEvent(&entities.EventRecord{Type: "plugin_connected", InstanceID: "inst-1"}); err != nil {
+		t.Fatalf("Failed to append event: %v", err)
+	}
+	if err := repo.AppendTransition(&entities.InstanceTransition{InstanceID: "inst-1", PluginID: "webdav", To: entities.PluginStatusRunning}); err != nil {
+		t.Fatalf("Failed to append transition: %v", err)
+	}
+}
+
+func assertBackupData(t *testing.T, repo *Repository) {
+	t.Helper()
+
+	def, err := repo.GetDefinition("webdav")
+	if err != nil {
+		t.Fatalf("Expected restored definition: %v", err)
+	}
+	if def.Enabled {
+		t.Error("Expected restored definition to stay disabled")
+	}
+	inst, err := repo.GetInstance("inst-1")
+	if err != nil {
+		t.Fatalf("Expected restored instance: %v", err)
+	}
+	if inst.AuthToken != "secret-token" {
+		t.Errorf("Expected auth token to be restored, got %q", inst.AuthToken)
+	}
+	cfg, _ := repo.GetConfig("webdav")
+	if cfg["root"] != "/srv" {
+		t.Errorf("Expected restored config, got %v", cfg)
+	}
+	events, _ := repo.ListEvents(repository.EventFilter{})
+	if len(events) != 1 {
+		t.Errorf("Expected 1 restored event, got %d", len(events))
+	}
+	history, _ := repo.ListTransitions("inst-1")
+	if len(history) != 1 {
+		t.Errorf("Expected 1 restored transition, got %d", len(history))
+	}
+}
+
+func TestBackupRestoreSQLite(t *testing.T) {
+	if dbtest.Type() != config.DatabaseTypeSQLite {
+		t.Skip("sqlite only")
+	}
+
+	cfg := dbtest.Config(t)
+	repo, err := NewRepository(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create repository: %v", err)
+	}
+	seedBackupData(t, repo)
+	lease, err := repo.AcquireCoreLease(logger.New("error"))
+	if err != nil {
+		t.Fatalf("AcquireCoreLease failed: %v", err)
+	}
+
+	dest := filepath.Join(t.TempDir(), "backup.db")
+	info, err := repo.Backup(context.Background(), dest)
+	if err != nil {
+		t.Fatalf("Backup failed: %v", err)
+	}
+	if info.Format != BackupFormatSQLite || info.Size == 0 {
+		t.Errorf("Unexpected backup info: %+v", info)
+	}
+
+	// Changes after the backup are rolled back by the restore, once the
+	// core has stopped
+	repo.DeleteConfig("webdav", "root")
+	if _, err := Restore(context.Background(), cfg, dest); !errors.Is(err, ErrCoreRunning) {
+		t.Fatalf("Expected ErrCoreRunning, got %v", err)
+	}
+	if err := lease.Release(); err != nil {
+		t.Fatalf("Release failed: %v", err)
+	}
+	repo.Close()
+
+	if _, err := Restore(context.Background(), cfg, dest); err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+	if _, err := os.Stat(cfg.Database.Path + ".pre-restore"); err != nil {
+		t.Errorf("Expected previous database to be kept: %v", err)
+	}
+
+	restored, err := NewRepository(cfg)
+	if err != nil {
+		t.Fatalf("Failed to open restored database: %v", err)
+	}
+	defer restored.Close()
+	assertBackupData(t, restored)
+
+	// The lease copied with the file is not restored
+	if err := restored.checkNoRunningCore(context.Background()); err != nil {
+		t.Errorf("Expected no lease in the restored database, got %v", err)
+	}
+}
+
+func TestBackupRestoreSnapshot(t *testing.T) {
+	repo := newTestRepository(t)
+	seedBackupData(t, repo)
+
+	version, _ := repo.SchemaVersion()
+	dest := filepath.Join(t.TempDir(), BackupFileName(BackupFormatSnapshot, time.Now()))
+	if err := repo.writeSnapshot(context.Background(), dest, &BackupInfo{SchemaVersion: version}); err != nil {
+		t.Fatalf("Snapshot failed: %v", err)
+	}
+	if format, _ := DetectBackupFormat(dest); format != BackupFormatSnapshot {
+		t.Fatalf("Expected snapshot format, got %q", format)
+	}
+
+	// Restore into a fresh database
+	cfg := dbtest.Config(t)
+	if _, err := Restore(context.Background(), cfg, dest); err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+
+	restored, err := NewRepository(cfg)
+	if err != nil {
+		t.Fatalf("Failed to open restored database: %v", err)
+	}
+	defer restored.Close()
+	assertBackupData(t, restored)
+
+	// New events continue after the restored ones
+	ev := &entities.EventRecord{Type: "plugin_disconnected"}
+	if err := restored.AppendEvent(ev); err != nil {
+		t.Fatalf("Failed to append event after restore: %v", err)
+	}
+	if ev.ID <= 1 {
+		t.Errorf("Expected event ID after restored events, got %d", ev.ID)
+	}
+}
+
+func TestRestoreRefusedWhileCoreRunning(t *testing.T) {
+	cfg := dbtest.Config(t)
+	repo, err := NewRepository(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create repository: %v", err)
+	}
+	defer repo.Close()
+	seedBackupData(t, repo)
+
+	version, _ := repo.SchemaVersion()
+	dest := filepath.Join(t.TempDir(), BackupFileName(BackupFormatSnapshot, time.Now()))
+	if err := repo.writeSnapshot(context.Background(), dest, &BackupInfo{SchemaVersion: version}); err != nil {
+		t.Fatalf("Snapshot failed: %v", err)
+	}
+
+	lease, err := repo.AcquireCoreLease(logger.New("error"))
+	if err != nil {
+		t.Fatalf("AcquireCoreLease failed: %v", err)
+	}
+	repo.DeleteConfig("webdav", "root")
+	if _, err := Restore(context.Background(), cfg, dest); !errors.Is(err, ErrCoreRunning) {
+		t.Fatalf("Expected ErrCoreRunning, got %v", err)
+	}
+	if c, _ := repo.GetConfig("webdav"); c["root"] != "" {
+		t.Error("Expected the database to be left alone")
+	}
+
+	// The lease of a core that crashed expires
+	if err := lease.Release(); err != nil {
+		t.Fatalf("Release failed: %v", err)
+	}
+	old := time.Now().Add(-2 * coreLeaseTTL)
+	if err := repo.db.Create(&coreLease{ID: "crashed", PID: 1, StartedAt: old, RenewedAt: old}).Error; err != nil {
+		t.Fatalf("Failed to create lease: %v", err)
+	}
+	if _, err := Restore(context.Background(), cfg, dest); err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+	assertBackupData(t, repo)
+}
+
+func TestRestoreRejectsSchemaMismatch(t *testing.T) {
+	repo := newTestRepository(t)
+
+	dest := filepath.Join(t.TempDir(), "old.snapshot.json.gz")
+	if err := repo.writeSnapshot(context.Background(), dest, &BackupInfo{SchemaVersion: 1}); err != nil {
+		t.Fatalf("Snapshot failed: %v", err)
+	}
+
+	_, err := Restore(context.Background(), dbtest.Config(t), dest)
+	if !errors.Is(err, ErrBackupSchemaMismatch) {
+		t.Errorf("Expected ErrBackupSchemaMismatch, got %v", err)
+	}
+}
+
+func TestRestoreRejectsOldSQLiteBackup(t *testing.T) {
+	if dbtest.Type() != config.DatabaseTypeSQLite {
+		t.Skip("sqlite only")
+	}
+
+	repo := newTestRepository(t)
+	if _, err := repo.MigrateDown(1); err != nil {
+		t.Fatalf("Failed to migrate down: %v", err)
+	}
+	dest := filepath.Join(t.TempDir(), "old.db")
+	if _, err := repo.Backup(context.Background(), dest); err != nil {
+		t.Fatalf("Backup failed: %v", err)
+	}
+
+	cfg := dbtest.Config(t)
+	_, err := Restore(context.Background(), cfg, dest)
+	if !errors.Is(err, ErrBackupSchemaMismatch) {
+		t.Errorf("Expected ErrBackupSchemaMismatch, got %v", err)
+	}
+	if _, err := os.Stat(cfg.Database.Path); !os.IsNotExist(err) {
+		t.Error("Expected target database to be left alone")
+	}
+}
+
+func TestRestoreRejectsInvalidFile(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "notes.txt")
+	os.WriteFile(src, []byte("not a backup"), 0o600)
+
+	_, err := Restore(context.Background(), dbtest.Config(t), src)
+	if !errors.Is(err, ErrInvalidBackup) {
+		t.Errorf("Expected ErrInvalidBackup, got %v", err)
+	}
+}
+
+func TestPruneBackups(t *testing.T) {
+	dir := t.TempDir()
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 5; i++ {
+		name := BackupFileName(BackupFormatSQLite, start.Add(time.Duration(i)*time.Hour))
+		os.WriteFile(filepath.Join(dir, name), nil, 0o600)
+	}
+	os.WriteFile(filepath.Join(dir, "unrelated.db"), nil, 0o600)
+
+	removed, err := PruneBackups(dir, 2)
+	if err != nil {
+		t.Fatalf("Prune failed: %v", err)
+	}
+	if len(removed) != 3 {
+		t.Errorf("Expected 3 removed backups, got %d", len(removed))
+	}
+
+	left, _ := ListBackups(dir)
+	if len(left) != 2 || filepath.Base(left[1]) != BackupFileName(BackupFormatSQLite, start.Add(4*time.Hour)) {
+		t.Errorf("Expected newest backups to be kept, got %v", left)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "unrelated.db")); err != nil {
+		t.Error("Expected unrelated files to be kept")
+	}
+}
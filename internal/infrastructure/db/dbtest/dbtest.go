@@ -0,0 +1,125 @@
+// Package dbtest provides database configurations for tests.
+//
+// Tests run against SQLite by default. Set MILPA_TEST_DB_TYPE=postgres to run
+// the same suite against a locally started PostgreSQL server; connection
+// settings are read from MILPA_TEST_DB_HOST, MILPA_TEST_DB_PORT,
+// MILPA_TEST_DB_NAME, MILPA_TEST_DB_USER and MILPA_TEST_DB_PASSWORD.
+// Each test gets its own Postgres schema, dropped on cleanup.
+package dbtest
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/robrt95x/milpa-cloud/internal/infrastructure/config"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	gormlogger "gorm.io/gorm/logger"
+)
+
+// Type returns the database backend selected for tests
+func Type() string {
+	if t := os.Getenv("MILPA_TEST_DB_TYPE"); t != "" {
+		return t
+	}
+	return config.DatabaseTypeSQLite
+}
+
+// Config returns an application config pointing at an isolated test database
+func Config(t testing.TB) *config.Config {
+	t.Helper()
+
+	return &config.Config{
+		Database: Database(t),
+	}
+}
+
+// Database returns database settings for an isolated test database
+func Database(t testing.TB) config.DatabaseConfig {
+	t.Helper()
+
+	switch Type() {
+	case config.DatabaseTypePostgres, "postgresql":
+		return postgresDatabase(t)
+	default:
+		return config.DatabaseConfig{
+			Type: config.DatabaseTypeSQLite,
+			Path: filepath.Join(t.TempDir(), "milpa.db"),
+		}
+	}
+}
+
+func postgresDatabase(t testing.TB) config.DatabaseConfig {
+	t.Helper()
+
+	port := 5432
+	if p := os.Getenv("MILPA_TEST_DB_PORT"); p != "" {
+		n, err := strconv.Atoi(p)
+		if err != nil {
+			t.Fatalf("invalid MILPA_TEST_DB_PORT: %v", err)
+		}
+		port = n
+	}
+
+	cfg := config.DatabaseConfig{
+		Type:     config.DatabaseTypePostgres,
+		Host:     getenv("MILPA_TEST_DB_HOST", "localhost"),
+		Port:     port,
+		Name:     getenv("MILPA_TEST_DB_NAME", "postgres"),
+		User:     getenv("MILPA_TEST_DB_USER", "postgres"),
+		Password: os.Getenv("MILPA_TEST_DB_PASSWORD"),
+		SSLMode:  "disable",
+	}
+
+	// Isolate each test in its own schema
+	b := make([]byte, 6)
+	rand.Read(b)
+	schema := "milpa_test_" + hex.EncodeToString(b)
+
+	admin := adminConn(t, cfg)
+	if err := admin.Exec("CREATE SCHEMA " + schema).Error; err != nil {
+		t.Fatalf("failed to create test schema: %v", err)
+	}
+	t.Cleanup(func() {
+		admin.Exec("DROP SCHEMA IF EXISTS " + schema + " CASCADE")
+		if sqlDB, err := admin.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+
+	cfg.Schema = schema
+	return cfg
+}
+
+func adminConn(t testing.TB, cfg config.DatabaseConfig) *gorm.DB {
+	t.Helper()
+
+	dsn := "host=" + cfg.Host +
+		" port=" + strconv.Itoa(cfg.Port) +
+		" dbname=" + cfg.Name +
+		" user=" + cfg.User +
+		" sslmode=" + cfg.SSLMode
+	if cfg.Password != "" {
+		dsn += " password=" + cfg.Password
+	}
+
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{
+		Logger: gormlogger.Default.LogMode(gormlogger.Silent),
+	})
+	if err != nil {
+		t.Fatalf("failed to connect to test postgres: %v", err)
+	}
+	return db
+}
+
+func getenv(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
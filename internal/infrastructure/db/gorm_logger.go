@@ -0,0 +1,64 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/robrt95x/milpa-cloud/pkg/logger"
+
+	"gorm.io/gorm"
+	gormlogger "gorm.io/gorm/logger"
+)
+
+// slowQueryThreshold is the duration above which queries are logged as warnings
+const slowQueryThreshold = 200 * time.Millisecond
+
+// gormLogger writes GORM messages to a milpa logger, so the database
+// follows the runtime level of its subsystem: failed and slow queries are
+// errors and warnings, every other query is logged at debug
+type gormLogger struct {
+	log logger.Logger
+}
+
+var _ gormlogger.Interface = (*gormLogger)(nil)
+
+// LogMode is a no-op; the level comes from the milpa logger
+func (l *gormLogger) LogMode(gormlogger.LogLevel) gormlogger.Interface {
+	return l
+}
+
+func (l *gormLogger) Info(ctx context.Context, msg string, args ...interface{}) {
+	l.log.Info(fmt.Sprintf(msg, args...))
+}
+
+func (l *gormLogger) Warn(ctx context.Context, msg string, args ...interface{}) {
+	l.log.Warn(fmt.Sprintf(msg, args...))
+}
+
+func (l *gormLogger) Error(ctx context.Context, msg string, args ...interface{}) {
+	l.log.Error(fmt.Sprintf(msg, args...))
+}
+
+// Trace logs a finished query
+// Not-found lookups are expected and reported as repository.ErrNotFound
+func (l *gormLogger) Trace(ctx context.Context, begin time.Time, fc func() (string, int64), err error) {
+	elapsed := time.Since(begin)
+	switch {
+	case err != nil && !errors.Is(err, gorm.ErrRecordNotFound):
+		sql, rows := fc()
+		l.log.Error("query failed", "sql", sql, "rows", rows, "elapsed", elapsed, "error", err)
+	case elapsed > slowQueryThreshold:
+		sql, rows := fc()
+		l.log.Warn("slow query", "sql", sql, "rows", rows, "elapsed", elapsed)
+	case l.log.IsDebug():
+		sql, rows := fc()
+		l.log.Debug("query", "sql", sql, "rows", rows, "elapsed", elapsed)
+	}
+}
+
+// SetLogger sends the database log to log instead of stderr
+func (r *Repository) SetLogger(log logger.Logger) {
+	r.db.Logger = &gormLogger{log: log}
+}
@@ -0,0 +1,125 @@
+package db
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/robrt95x/milpa-cloud/pkg/logger"
+)
+
+// A running core renews its lease every coreLeaseRenewal; a lease not
+// renewed within coreLeaseTTL belongs to a core that is gone
+const (
+	coreLeaseTTL     = 30 * time.Second
+	coreLeaseRenewal = 10 * time.Second
+)
+
+// ErrCoreRunning rejects a restore while a core is using the database
+var ErrCoreRunning = errors.New("database is in use by a running core")
+
+// coreLease is a row in the core_leases table
+type coreLease struct {
+	ID        string `gorm:"primaryKey"`
+	Hostname  string
+	PID       int `gorm:"column:pid"`
+	StartedAt time.Time
+	RenewedAt time.Time
+}
+
+func (coreLease) TableName() string {
+	return "core_leases"
+}
+
+// CoreLease marks the database as in use by this process until released
+type CoreLease struct {
+	repo *Repository
+	row  coreLease
+	log  logger.Logger
+	stop chan struct{}
+	done chan struct{}
+}
+
+// AcquireCoreLease records this process as a running core and keeps the
+// record fresh until Release. Restore refuses to run while it is fresh.
+func (r *Repository) AcquireCoreLease(log logger.Logger) (*CoreLease, error) {
+	b := make([]byte, 8)
+	rand.Read(b)
+	hostname, _ := os.Hostname()
+	now := time.Now()
+
+	l := &CoreLease{
+		repo: r,
+		row: coreLease{
+			ID:        hex.EncodeToString(b),
+			Hostname:  hostname,
+			PID:       os.Getpid(),
+			StartedAt: now,
+			RenewedAt: now,
+		},
+		log:  log,
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	if err := r.db.Create(&l.row).Error; err != nil {
+		return nil, fmt.Errorf("failed to record core lease: %w", err)
+	}
+
+	go l.renew()
+	return l, nil
+}
+
+// renew refreshes the lease until Release
+// Save recreates the row if it is missing.
+func (l *CoreLease) renew() {
+	defer close(l.done)
+
+	ticker := time.NewTicker(coreLeaseRenewal)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C:
+			l.row.RenewedAt = time.Now()
+			if err := l.repo.db.Save(&l.row).Error; err != nil {
+				l.log.Error("failed to renew core lease", "error", err)
+			}
+		}
+	}
+}
+
+// Release stops renewing the lease and deletes it
+func (l *CoreLease) Release() error {
+	close(l.stop)
+	<-l.done
+	return l.repo.db.Delete(&coreLease{}, "id = ?", l.row.ID).Error
+}
+
+// checkNoRunningCore returns ErrCoreRunning when a lease was renewed
+// within coreLeaseTTL
+func (r *Repository) checkNoRunningCore(ctx context.Context) error {
+	if !r.db.Migrator().HasTable(&coreLease{}) {
+		return nil
+	}
+
+	var leases []coreLease
+	err := r.db.WithContext(ctx).
+		Where("renewed_at > ?", time.Now().Add(-coreLeaseTTL)).
+		Order("renewed_at DESC").
+		Find(&leases).Error
+	if err != nil {
+		return err
+	}
+	if len(leases) > 0 {
+		l := leases[0]
+		return fmt.Errorf("%w (%s, pid %d, last seen %s)",
+			ErrCoreRunning, l.Hostname, l.PID, l.RenewedAt.Format(time.RFC3339))
+	}
+	return nil
+}
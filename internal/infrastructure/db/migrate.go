@@ -0,0 +1,355 @@
+package db
+
+import (
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+//go:embed migrations/sqlite/*.sql migrations/postgres/*.sql
+var migrationFiles embed.FS
+
+// Migration errors
+var (
+	ErrSchemaAhead      = errors.New("database schema is newer than this binary")
+	ErrSchemaBehind     = errors.New("database schema has pending migrations")
+	ErrChecksumMismatch = errors.New("applied migration does not match embedded migration")
+	ErrNoMigration      = errors.New("no migration to roll back")
+)
+
+// Migration is a numbered, checksummed schema change embedded in the binary
+type Migration struct {
+	Version  int
+	Name     string
+	Up       string
+	Down     string
+	Checksum string
+}
+
+// MigrationStatus describes a migration known to the binary or the database
+type MigrationStatus struct {
+	Version   int
+	Name      string
+	Applied   bool
+	AppliedAt *time.Time
+	// Unknown is set for migrations recorded in the database but not embedded
+	Unknown bool
+	// Modified is set when the applied checksum differs from the embedded one
+	Modified bool
+}
+
+// schemaMigration is a row in the migrations tracking table
+type schemaMigration struct {
+	Version   int `gorm:"primaryKey;autoIncrement:false"`
+	Name      string
+	Checksum  string
+	AppliedAt time.Time
+}
+
+func (schemaMigration) TableName() string {
+	return "schema_migrations"
+}
+
+// loadMigrations reads the embedded migrations for a dialect, sorted by version
+// Files are named NNNN_name.up.sql and NNNN_name.down.sql
+func loadMigrations(dialect string) ([]Migration, error) {
+	dir := path.Join("migrations", dialect)
+	entries, err := fs.ReadDir(migrationFiles, dir)
+	if err != nil {
+		return nil, fmt.Errorf("no migrations for dialect %q: %w", dialect, err)
+	}
+
+	byVersion := map[int]*Migration{}
+	for _, entry := range entries {
+		file := entry.Name()
+
+		var direction string
+		switch {
+		case strings.HasSuffix(file, ".up.sql"):
+			direction = "up"
+		case strings.HasSuffix(file, ".down.sql"):
+			direction = "down"
+		default:
+			continue
+		}
+
+		base := strings.TrimSuffix(file, "."+direction+".sql")
+		num, name, ok := strings.Cut(base, "_")
+		if !ok {
+			return nil, fmt.Errorf("invalid migration file name: %s", file)
+		}
+		version, err := strconv.Atoi(num)
+		if err != nil {
+			return nil, fmt.Errorf("invalid migration version in %s: %w", file, err)
+		}
+
+		data, err := migrationFiles.ReadFile(path.Join(dir, file))
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: name}
+			byVersion[version] = m
+		}
+		if direction == "up" {
+			m.Up = string(data)
+			sum := sha256.Sum256(data)
+			m.Checksum = hex.EncodeToString(sum[:])
+		} else {
+			m.Down = string(data)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migration %04d_%s is missing its up or down file", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	return migrations, nil
+}
+
+// splitStatements splits a migration script on statement-terminating semicolons
+func splitStatements(script string) []string {
+	var stmts []string
+	var current strings.Builder
+
+	for _, line := range strings.Split(script, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "--") {
+			continue
+		}
+		current.WriteString(line)
+		current.WriteString("\n")
+		if strings.HasSuffix(trimmed, ";") {
+			stmts = append(stmts, strings.TrimSpace(current.String()))
+			current.Reset()
+		}
+	}
+	if rest := strings.TrimSpace(current.String()); rest != "" {
+		stmts = append(stmts, rest)
+	}
+
+	return stmts
+}
+
+func (r *Repository) migrations() ([]Migration, error) {
+	return loadMigrations(r.db.Dialector.Name())
+}
+
+func (r *Repository) ensureMigrationsTable() error {
+	return r.db.AutoMigrate(&schemaMigration{})
+}
+
+func (r *Repository) appliedMigrations() ([]schemaMigration, error) {
+	if err := r.ensureMigrationsTable(); err != nil {
+		return nil, fmt.Errorf("failed to create migrations table: %w", err)
+	}
+
+	var applied []schemaMigration
+	err := r.db.Order("version").Find(&applied).Error
+	return applied, err
+}
+
+// SchemaVersion returns the highest applied migration version (0 if none)
+func (r *Repository) SchemaVersion() (int, error) {
+	applied, err := r.appliedMigrations()
+	if err != nil {
+		return 0, err
+	}
+	if len(applied) == 0 {
+		return 0, nil
+	}
+	return applied[len(applied)-1].Version, nil
+}
+
+// LatestSchemaVersion returns the highest migration version embedded in the binary
+func (r *Repository) LatestSchemaVersion() (int, error) {
+	migrations, err := r.migrations()
+	if err != nil {
+		return 0, err
+	}
+	if len(migrations) == 0 {
+		return 0, nil
+	}
+	return migrations[len(migrations)-1].Version, nil
+}
+
+// MigrationStatus reports every embedded and applied migration
+func (r *Repository) MigrationStatus() ([]MigrationStatus, error) {
+	migrations, err := r.migrations()
+	if err != nil {
+		return nil, err
+	}
+	applied, err := r.appliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	appliedByVersion := map[int]schemaMigration{}
+	for _, a := range applied {
+		appliedByVersion[a.Version] = a
+	}
+
+	var result []MigrationStatus
+	for _, m := range migrations {
+		st := MigrationStatus{Version: m.Version, Name: m.Name}
+		if a, ok := appliedByVersion[m.Version]; ok {
+			at := a.AppliedAt
+			st.Applied = true
+			st.AppliedAt = &at
+			st.Modified = a.Checksum != m.Checksum
+			delete(appliedByVersion, m.Version)
+		}
+		result = append(result, st)
+	}
+	for _, a := range applied {
+		if _, ok := appliedByVersion[a.Version]; !ok {
+			continue
+		}
+		at := a.AppliedAt
+		result = append(result, MigrationStatus{
+			Version:   a.Version,
+			Name:      a.Name,
+			Applied:   true,
+			AppliedAt: &at,
+			Unknown:   true,
+		})
+	}
+
+	return result, nil
+}
+
+// CheckSchema verifies the database schema matches the embedded migrations
+// It returns ErrSchemaAhead, ErrChecksumMismatch or ErrSchemaBehind (in that order)
+func (r *Repository) CheckSchema() error {
+	statuses, err := r.MigrationStatus()
+	if err != nil {
+		return err
+	}
+
+	for _, st := range statuses {
+		if st.Unknown {
+			return fmt.Errorf("%w: migration %d (%s) is not known", ErrSchemaAhead, st.Version, st.Name)
+		}
+	}
+	for _, st := range statuses {
+		if st.Modified {
+			return fmt.Errorf("%w: migration %d (%s)", ErrChecksumMismatch, st.Version, st.Name)
+		}
+	}
+	for _, st := range statuses {
+		if !st.Applied {
+			return fmt.Errorf("%w: migration %d (%s) not applied", ErrSchemaBehind, st.Version, st.Name)
+		}
+	}
+
+	return nil
+}
+
+// MigrateUp applies all pending migrations and returns the ones applied
+func (r *Repository) MigrateUp() ([]Migration, error) {
+	if err := r.CheckSchema(); err != nil && !errors.Is(err, ErrSchemaBehind) {
+		return nil, err
+	}
+
+	migrations, err := r.migrations()
+	if err != nil {
+		return nil, err
+	}
+	current, err := r.SchemaVersion()
+	if err != nil {
+		return nil, err
+	}
+
+	var applied []Migration
+	for _, m := range migrations {
+		if m.Version <= current {
+			continue
+		}
+		err := r.db.Transaction(func(tx *gorm.DB) error {
+			if err := execScript(tx, m.Up); err != nil {
+				return err
+			}
+			return tx.Create(&schemaMigration{
+				Version:   m.Version,
+				Name:      m.Name,
+				Checksum:  m.Checksum,
+				AppliedAt: time.Now(),
+			}).Error
+		})
+		if err != nil {
+			return applied, fmt.Errorf("migration %04d_%s failed: %w", m.Version, m.Name, err)
+		}
+		applied = append(applied, m)
+	}
+
+	return applied, nil
+}
+
+// MigrateDown rolls back the given number of applied migrations, newest first
+func (r *Repository) MigrateDown(steps int) ([]Migration, error) {
+	if err := r.CheckSchema(); err != nil && !errors.Is(err, ErrSchemaBehind) {
+		return nil, err
+	}
+
+	migrations, err := r.migrations()
+	if err != nil {
+		return nil, err
+	}
+	byVersion := map[int]Migration{}
+	for _, m := range migrations {
+		byVersion[m.Version] = m
+	}
+
+	appliedRows, err := r.appliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+	if len(appliedRows) == 0 {
+		return nil, ErrNoMigration
+	}
+
+	var reverted []Migration
+	for i := len(appliedRows) - 1; i >= 0 && len(reverted) < steps; i-- {
+		m := byVersion[appliedRows[i].Version]
+		err := r.db.Transaction(func(tx *gorm.DB) error {
+			if err := execScript(tx, m.Down); err != nil {
+				return err
+			}
+			return tx.Delete(&schemaMigration{}, "version = ?", m.Version).Error
+		})
+		if err != nil {
+			return reverted, fmt.Errorf("rollback of %04d_%s failed: %w", m.Version, m.Name, err)
+		}
+		reverted = append(reverted, m)
+	}
+
+	return reverted, nil
+}
+
+func execScript(tx *gorm.DB, script string) error {
+	for _, stmt := range splitStatements(script) {
+		if err := tx.Exec(stmt).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
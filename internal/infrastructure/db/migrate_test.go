@@ -0,0 +1,235 @@
+package db
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/robrt95x/milpa-cloud/internal/domain/entities"
+	"github.com/robrt95x/milpa-cloud/internal/domain/repository"
+	"github.com/robrt95x/milpa-cloud/internal/infrastructure/config"
+	"github.com/robrt95x/milpa-cloud/internal/infrastructure/db/dbtest"
+)
+
+func TestLoadMigrations(t *testing.T) {
+	for _, dialect := range []string{"sqlite", "postgres"} {
+		t.Run(dialect, func(t *testing.T) {
+			migrations, err := loadMigrations(dialect)
+			if err != nil {
+				t.Fatalf("Failed to load migrations: %v", err)
+			}
+			if len(migrations) == 0 {
+				t.Fatal("Expected embedded migrations")
+			}
+			for i, m := range migrations {
+				if m.Version != i+1 {
+					t.Errorf("Expected contiguous versions, got %d at position %d", m.Version, i)
+				}
+				if m.Checksum == "" {
+					t.Errorf("Expected checksum for migration %d", m.Version)
+				}
+			}
+		})
+	}
+
+	sqlite, _ := loadMigrations("sqlite")
+	postgres, _ := loadMigrations("postgres")
+	if len(sqlite) != len(postgres) {
+		t.Errorf("Expected same number of migrations per dialect, got %d and %d", len(sqlite), len(postgres))
+	}
+}
+
+func TestSplitStatements(t *testing.T) {
+	script := `-- comment
+CREATE TABLE a (
+	id TEXT
+);
+
+CREATE INDEX idx_a ON a(id);
+`
+	stmts := splitStatements(script)
+	if len(stmts) != 2 {
+		t.Fatalf("Expected 2 statements, got %d: %q", len(stmts), stmts)
+	}
+}
+
+func TestMigrateUpDown(t *testing.T) {
+	repo := newTestRepository(t)
+
+	latest, err := repo.LatestSchemaVersion()
+	if err != nil {
+		t.Fatalf("Failed to get latest version: %v", err)
+	}
+	current, err := repo.SchemaVersion()
+	if err != nil {
+		t.Fatalf("Failed to get schema version: %v", err)
+	}
+	if current != latest {
+		t.Errorf("Expected new database at version %d, got %d", latest, current)
+	}
+	if err := repo.CheckSchema(); err != nil {
+		t.Errorf("Expected schema to be current, got %v", err)
+	}
+
+	reverted, err := repo.MigrateDown(latest)
+	if err != nil {
+		t.Fatalf("Failed to migrate down: %v", err)
+	}
+	if len(reverted) != latest {
+		t.Errorf("Expected %d reverted migrations, got %d", latest, len(reverted))
+	}
+	if err := repo.CheckSchema(); !errors.Is(err, ErrSchemaBehind) {
+		t.Errorf("Expected ErrSchemaBehind, got %v", err)
+	}
+	if _, err := repo.MigrateDown(1); !errors.Is(err, ErrNoMigration) {
+		t.Errorf("Expected ErrNoMigration, got %v", err)
+	}
+
+	applied, err := repo.MigrateUp()
+	if err != nil {
+		t.Fatalf("Failed to migrate up: %v", err)
+	}
+	if len(applied) != latest {
+		t.Errorf("Expected %d applied migrations, got %d", latest, len(applied))
+	}
+
+	// Schema is usable after a full round trip
+	if err := repo.UpsertDefinition(&entities.PluginDefinition{ID: "p", Version: "1.0.0"}); err != nil {
+		t.Errorf("Failed to use migrated schema: %v", err)
+	}
+}
+
+func TestNewRepositoryRefusesNewerSchema(t *testing.T) {
+	cfg := dbtest.Config(t)
+
+	repo, err := NewRepository(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create repository: %v", err)
+	}
+	repo.db.Create(&schemaMigration{Version: 9999, Name: "future", Checksum: "x", AppliedAt: time.Now()})
+	repo.Close()
+
+	if _, err := NewRepository(cfg); !errors.Is(err, ErrSchemaAhead) {
+		t.Errorf("Expected ErrSchemaAhead, got %v", err)
+	}
+}
+
+func TestNewRepositoryDetectsModifiedMigration(t *testing.T) {
+	cfg := dbtest.Config(t)
+
+	repo, err := NewRepository(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create repository: %v", err)
+	}
+	repo.db.Model(&schemaMigration{}).Where("version = ?", 1).Update("checksum", "tampered")
+	repo.Close()
+
+	if _, err := NewRepository(cfg); !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("Expected ErrChecksumMismatch, got %v", err)
+	}
+}
+
+func TestNewRepositoryPendingMigrations(t *testing.T) {
+	cfg := dbtest.Config(t)
+
+	repo, err := NewRepository(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create repository: %v", err)
+	}
+	latest, _ := repo.LatestSchemaVersion()
+	if latest < 2 {
+		t.Skip("needs at least two migrations")
+	}
+	if _, err := repo.MigrateDown(latest - 1); err != nil {
+		t.Fatalf("Failed to migrate down: %v", err)
+	}
+	repo.Close()
+
+	// Existing schema is never upgraded silently
+	if _, err := NewRepository(cfg); !errors.Is(err, ErrSchemaBehind) {
+		t.Errorf("Expected ErrSchemaBehind, got %v", err)
+	}
+
+	cfg.Database.AutoMigrate = true
+	repo, err = NewRepository(cfg)
+	if err != nil {
+		t.Fatalf("Expected auto_migrate to upgrade schema, got %v", err)
+	}
+	defer repo.Close()
+
+	if v, _ := repo.SchemaVersion(); v != latest {
+		t.Errorf("Expected version %d, got %d", latest, v)
+	}
+}
+
+func TestNewRepositoryRefusesUnversionedSchema(t *testing.T) {
+	cfg := dbtest.Config(t)
+
+	// Tables created by AutoMigrate before versioned migrations existed
+	repo, err := Open(cfg)
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	for _, stmt := range []string{
+		"CREATE TABLE plugin_definitions (id TEXT PRIMARY KEY, version TEXT, enabled BOOLEAN)",
+		"CREATE TABLE plugin_instances (id TEXT PRIMARY KEY, definition_id TEXT, status TEXT)",
+	} {
+		if err := repo.db.Exec(stmt).Error; err != nil {
+			t.Fatalf("Failed to create baseline table: %v", err)
+		}
+	}
+	repo.Close()
+
+	_, err = NewRepository(cfg)
+	if !errors.Is(err, ErrSchemaBehind) || !strings.Contains(err.Error(), "milpa migrate up") {
+		t.Fatalf("Expected ErrSchemaBehind asking for `milpa migrate up`, got %v", err)
+	}
+
+	repo, err = Open(cfg)
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	defer repo.Close()
+	if v, _ := repo.SchemaVersion(); v != 0 {
+		t.Errorf("Expected no migrations to be applied, got version %d", v)
+	}
+}
+
+func TestMigrateRewritesTimestampsInUTC(t *testing.T) {
+	if dbtest.Type() != config.DatabaseTypeSQLite {
+		t.Skip("only SQLite stores timestamps as text")
+	}
+	repo := newTestRepository(t)
+	if _, err := repo.MigrateDown(1); err != nil {
+		t.Fatalf("Failed to migrate down: %v", err)
+	}
+
+	// Rows written by earlier versions keep the offset of their process
+	err := repo.db.Exec(`INSERT INTO plugin_instances (id, definition_id, status, last_heartbeat) VALUES
+		('inst-earlier', 'p', 'running', '2026-03-29 19:00:00.5+09:00'),
+		('inst-later', 'p', 'running', '2026-03-29 06:00:00-05:00'),
+		('inst-none', 'p', 'running', NULL)`).Error
+	if err != nil {
+		t.Fatalf("Failed to insert instances: %v", err)
+	}
+	if _, err := repo.MigrateUp(); err != nil {
+		t.Fatalf("Failed to migrate up: %v", err)
+	}
+
+	before := time.Date(2026, 3, 29, 10, 30, 0, 0, time.UTC)
+	page, err := repo.QueryInstances(repository.InstanceQuery{HeartbeatBefore: before})
+	if err != nil {
+		t.Fatalf("QueryInstances: %v", err)
+	}
+	if ids := instanceIDs(page.Items); strings.Join(ids, ",") != "inst-earlier" {
+		t.Errorf("Expected only the earlier heartbeat, got %v", ids)
+	}
+	want := time.Date(2026, 3, 29, 10, 0, 0, 5e8, time.UTC)
+	if inst, _ := repo.GetInstance("inst-earlier"); inst.LastHeartbeat == nil || !inst.LastHeartbeat.Equal(want) {
+		t.Errorf("Expected heartbeat %s, got %v", want, inst.LastHeartbeat)
+	}
+	if inst, _ := repo.GetInstance("inst-none"); inst.LastHeartbeat != nil {
+		t.Errorf("Expected missing heartbeat to stay NULL, got %v", inst.LastHeartbeat)
+	}
+}
@@ -0,0 +1,31 @@
+CREATE TABLE IF NOT EXISTS plugin_definitions (
+	id TEXT NOT NULL,
+	version TEXT,
+	api_version TEXT,
+	depends_on TEXT,
+	capabilities TEXT,
+	enabled BOOLEAN DEFAULT true,
+	metadata TEXT,
+	created_at TIMESTAMPTZ,
+	updated_at TIMESTAMPTZ,
+	PRIMARY KEY (id)
+);
+
+CREATE TABLE IF NOT EXISTS plugin_instances (
+	id TEXT NOT NULL,
+	definition_id TEXT,
+	status TEXT,
+	enabled BOOLEAN DEFAULT true,
+	host TEXT,
+	port BIGINT,
+	auth_token TEXT,
+	last_heartbeat TIMESTAMPTZ,
+	started_at TIMESTAMPTZ,
+	metadata TEXT,
+	created_at TIMESTAMPTZ,
+	updated_at TIMESTAMPTZ,
+	PRIMARY KEY (id),
+	CONSTRAINT fk_plugin_instances_definition FOREIGN KEY (definition_id) REFERENCES plugin_definitions(id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_plugin_instances_definition_id ON plugin_instances(definition_id);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS event_records;
+DROP TABLE IF EXISTS plugin_config_entries;
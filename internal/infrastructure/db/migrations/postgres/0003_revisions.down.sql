@@ -0,0 +1,2 @@
+ALTER TABLE plugin_instances DROP COLUMN revision;
+ALTER TABLE plugin_definitions DROP COLUMN revision;
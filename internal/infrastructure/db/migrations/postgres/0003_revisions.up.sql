@@ -0,0 +1,2 @@
+ALTER TABLE plugin_definitions ADD COLUMN revision BIGINT NOT NULL DEFAULT 1;
+ALTER TABLE plugin_instances ADD COLUMN revision BIGINT NOT NULL DEFAULT 1;
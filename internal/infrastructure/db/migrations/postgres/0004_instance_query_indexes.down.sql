@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_plugin_instances_last_heartbeat;
+DROP INDEX IF EXISTS idx_plugin_instances_started_at;
+DROP INDEX IF EXISTS idx_plugin_instances_status;
@@ -0,0 +1,3 @@
+CREATE INDEX IF NOT EXISTS idx_plugin_instances_status ON plugin_instances(status);
+CREATE INDEX IF NOT EXISTS idx_plugin_instances_started_at ON plugin_instances(started_at);
+CREATE INDEX IF NOT EXISTS idx_plugin_instances_last_heartbeat ON plugin_instances(last_heartbeat);
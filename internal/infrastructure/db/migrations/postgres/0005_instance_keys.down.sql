@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_plugin_instances_instance_key;
+ALTER TABLE plugin_instances DROP COLUMN retired_at;
+ALTER TABLE plugin_instances DROP COLUMN instance_key;
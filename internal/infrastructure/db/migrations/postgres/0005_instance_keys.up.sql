@@ -0,0 +1,3 @@
+ALTER TABLE plugin_instances ADD COLUMN instance_key TEXT;
+ALTER TABLE plugin_instances ADD COLUMN retired_at TIMESTAMPTZ;
+CREATE INDEX IF NOT EXISTS idx_plugin_instances_instance_key ON plugin_instances(definition_id, instance_key);
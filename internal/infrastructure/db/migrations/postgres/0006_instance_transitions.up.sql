@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS instance_transitions (
+	id BIGSERIAL PRIMARY KEY,
+	instance_id TEXT,
+	plugin_id TEXT,
+	from_status TEXT,
+	to_status TEXT,
+	reason TEXT,
+	created_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_instance_transitions_instance_id ON instance_transitions(instance_id);
@@ -0,0 +1 @@
+ALTER TABLE plugin_instances DROP COLUMN health;
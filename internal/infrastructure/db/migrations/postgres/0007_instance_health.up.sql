@@ -0,0 +1 @@
+ALTER TABLE plugin_instances ADD COLUMN health TEXT;
@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS core_leases (
+	id TEXT PRIMARY KEY,
+	hostname TEXT,
+	pid INTEGER,
+	started_at TIMESTAMPTZ,
+	renewed_at TIMESTAMPTZ
+);
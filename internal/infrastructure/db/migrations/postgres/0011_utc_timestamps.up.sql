@@ -0,0 +1 @@
+-- TIMESTAMPTZ values are stored as instants; nothing to rewrite
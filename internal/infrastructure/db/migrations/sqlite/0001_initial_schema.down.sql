@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS plugin_instances;
+DROP TABLE IF EXISTS plugin_definitions;
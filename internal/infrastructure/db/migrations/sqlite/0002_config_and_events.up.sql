@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS plugin_config_entries (
+	plugin_id TEXT NOT NULL,
+	key TEXT NOT NULL,
+	value TEXT,
+	updated_at DATETIME,
+	PRIMARY KEY (plugin_id, key)
+);
+
+CREATE TABLE IF NOT EXISTS event_records (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	type TEXT,
+	plugin_id TEXT,
+	instance_id TEXT,
+	data TEXT,
+	created_at DATETIME
+);
+
+CREATE INDEX IF NOT EXISTS idx_event_records_type ON event_records(type);
+CREATE INDEX IF NOT EXISTS idx_event_records_instance_id ON event_records(instance_id);
@@ -0,0 +1 @@
+ALTER TABLE plugin_instances ADD COLUMN quarantined_until DATETIME;
@@ -0,0 +1 @@
+-- UTC timestamps are valid for earlier versions; nothing to undo
@@ -0,0 +1,15 @@
+-- Timestamps were written with the UTC offset of the writing process;
+-- rewrite them in UTC so they compare as text in any order.
+UPDATE plugin_definitions SET created_at = strftime('%Y-%m-%d %H:%M:%f+00:00', created_at) WHERE strftime('%Y-%m-%d %H:%M:%f+00:00', created_at) IS NOT NULL;
+UPDATE plugin_definitions SET updated_at = strftime('%Y-%m-%d %H:%M:%f+00:00', updated_at) WHERE strftime('%Y-%m-%d %H:%M:%f+00:00', updated_at) IS NOT NULL;
+UPDATE plugin_instances SET last_heartbeat = strftime('%Y-%m-%d %H:%M:%f+00:00', last_heartbeat) WHERE strftime('%Y-%m-%d %H:%M:%f+00:00', last_heartbeat) IS NOT NULL;
+UPDATE plugin_instances SET started_at = strftime('%Y-%m-%d %H:%M:%f+00:00', started_at) WHERE strftime('%Y-%m-%d %H:%M:%f+00:00', started_at) IS NOT NULL;
+UPDATE plugin_instances SET created_at = strftime('%Y-%m-%d %H:%M:%f+00:00', created_at) WHERE strftime('%Y-%m-%d %H:%M:%f+00:00', created_at) IS NOT NULL;
+UPDATE plugin_instances SET updated_at = strftime('%Y-%m-%d %H:%M:%f+00:00', updated_at) WHERE strftime('%Y-%m-%d %H:%M:%f+00:00', updated_at) IS NOT NULL;
+UPDATE plugin_instances SET retired_at = strftime('%Y-%m-%d %H:%M:%f+00:00', retired_at) WHERE strftime('%Y-%m-%d %H:%M:%f+00:00', retired_at) IS NOT NULL;
+UPDATE plugin_instances SET quarantined_until = strftime('%Y-%m-%d %H:%M:%f+00:00', quarantined_until) WHERE strftime('%Y-%m-%d %H:%M:%f+00:00', quarantined_until) IS NOT NULL;
+UPDATE plugin_config_entries SET updated_at = strftime('%Y-%m-%d %H:%M:%f+00:00', updated_at) WHERE strftime('%Y-%m-%d %H:%M:%f+00:00', updated_at) IS NOT NULL;
+UPDATE event_records SET created_at = strftime('%Y-%m-%d %H:%M:%f+00:00', created_at) WHERE strftime('%Y-%m-%d %H:%M:%f+00:00', created_at) IS NOT NULL;
+UPDATE instance_transitions SET created_at = strftime('%Y-%m-%d %H:%M:%f+00:00', created_at) WHERE strftime('%Y-%m-%d %H:%M:%f+00:00', created_at) IS NOT NULL;
+UPDATE core_leases SET started_at = strftime('%Y-%m-%d %H:%M:%f+00:00', started_at) WHERE strftime('%Y-%m-%d %H:%M:%f+00:00', started_at) IS NOT NULL;
+UPDATE core_leases SET renewed_at = strftime('%Y-%m-%d %H:%M:%f+00:00', renewed_at) WHERE strftime('%Y-%m-%d %H:%M:%f+00:00', renewed_at) IS NOT NULL;
@@ -0,0 +1,138 @@
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/robrt95x/milpa-cloud/internal/domain/entities"
+	"github.com/robrt95x/milpa-cloud/internal/domain/repository"
+
+	"gorm.io/gorm"
+)
+
+// QueryDefinitions returns one page of filtered, sorted definitions
+func (r *Repository) QueryDefinitions(q repository.DefinitionQuery) (*repository.DefinitionPage, error) {
+	sort, err := repository.ParseSort(q.Sort, repository.DefinitionSortFields)
+	if err != nil {
+		return nil, err
+	}
+
+	tx := r.db.Model(&entities.PluginDefinition{})
+	if q.Enabled != nil {
+		tx = tx.Where("enabled = ?", *q.Enabled)
+	}
+	if q.Capability != "" {
+		cond, arg := r.capabilityCondition(q.Capability)
+		tx = tx.Where(cond, arg)
+	}
+	tx = tx.Session(&gorm.Session{})
+
+	page := &repository.DefinitionPage{}
+	if err := tx.Count(&page.Total).Error; err != nil {
+		return nil, err
+	}
+
+	tx, err = paginate(tx, sort, q.Cursor)
+	if err != nil {
+		return nil, err
+	}
+	limit := repository.PageLimit(q.Limit)
+	if err := tx.Limit(limit + 1).Find(&page.Items).Error; err != nil {
+		return nil, err
+	}
+
+	if len(page.Items) > limit {
+		page.Items = page.Items[:limit]
+		last := page.Items[limit-1]
+		page.NextCursor = repository.NewCursor(sort, repository.DefinitionSortValue(last, sort.Field), last.ID)
+	}
+	return page, nil
+}
+
+// QueryInstances returns one page of filtered, sorted instances
+func (r *Repository) QueryInstances(q repository.InstanceQuery) (*repository.InstancePage, error) {
+	sort, err := repository.ParseSort(q.Sort, repository.InstanceSortFields)
+	if err != nil {
+		return nil, err
+	}
+
+	tx := r.db.Model(&entities.PluginInstance{})
+	if q.DefinitionID != "" {
+		tx = tx.Where("definition_id = ?", q.DefinitionID)
+	}
+	if len(q.Status) > 0 {
+		tx = tx.Where("status IN ?", q.Status)
+	}
+	if q.Enabled != nil {
+		tx = tx.Where("enabled = ?", *q.Enabled)
+	}
+	if q.Capability != "" {
+		cond, arg := r.capabilityCondition(q.Capability)
+		tx = tx.Where("definition_id IN (SELECT id FROM plugin_definitions WHERE "+cond+")", arg)
+	}
+	if !q.HeartbeatBefore.IsZero() {
+		tx = tx.Where("last_heartbeat < ?", q.HeartbeatBefore)
+	}
+	if !q.HeartbeatAfter.IsZero() {
+		tx = tx.Where("last_heartbeat > ?", q.HeartbeatAfter)
+	}
+	tx = tx.Session(&gorm.Session{})
+
+	page := &repository.InstancePage{}
+	if err := tx.Count(&page.Total).Error; err != nil {
+		return nil, err
+	}
+
+	tx, err = paginate(tx, sort, q.Cursor)
+	if err != nil {
+		return nil, err
+	}
+	limit := repository.PageLimit(q.Limit)
+	if err := tx.Limit(limit + 1).Find(&page.Items).Error; err != nil {
+		return nil, err
+	}
+
+	if len(page.Items) > limit {
+		page.Items = page.Items[:limit]
+		last := page.Items[limit-1]
+		page.NextCursor = repository.NewCursor(sort, repository.InstanceSortValue(last, sort.Field), last.ID)
+	}
+	return page, nil
+}
+
+// capabilityCondition matches rows of plugin_definitions whose JSON
+// capabilities array contains capability
+func (r *Repository) capabilityCondition(capability string) (string, interface{}) {
+	if r.db.Dialector.Name() == "postgres" {
+		arg, _ := json.Marshal([]string{capability})
+		return "plugin_definitions.capabilities::jsonb @> ?::jsonb", string(arg)
+	}
+	return "EXISTS (SELECT 1 FROM json_each(plugin_definitions.capabilities) WHERE json_each.value = ?)", capability
+}
+
+// paginate orders by the sort field then ID, and skips rows up to the cursor
+// Sort fields are validated by repository.ParseSort, so they are safe to
+// interpolate as column names.
+func paginate(tx *gorm.DB, sort repository.Sort, cursor string) (*gorm.DB, error) {
+	dir, op := "ASC", ">"
+	if sort.Desc {
+		dir, op = "DESC", "<"
+	}
+
+	if cursor != "" {
+		value, id, err := repository.DecodeCursor(cursor, sort)
+		if err != nil {
+			return nil, err
+		}
+		if sort.Field == "id" {
+			tx = tx.Where(fmt.Sprintf("id %s ?", op), id)
+		} else {
+			tx = tx.Where(fmt.Sprintf("(%[1]s %[2]s ? OR (%[1]s = ? AND id %[2]s ?))", sort.Field, op), value, value, id)
+		}
+	}
+
+	if sort.Field == "id" {
+		return tx.Order("id " + dir), nil
+	}
+	return tx.Order(fmt.Sprintf("%s %s, id %s", sort.Field, dir, dir)), nil
+}
@@ -1,13 +1,26 @@
 package db
 
 import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/robrt95x/milpa-cloud/internal/domain/entities"
+	"github.com/robrt95x/milpa-cloud/internal/domain/repository"
 	"github.com/robrt95x/milpa-cloud/internal/infrastructure/config"
 
+	"gorm.io/driver/postgres"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	gormlogger "gorm.io/gorm/logger"
 )
 
 // Repository handles database operations
@@ -15,46 +28,223 @@ type Repository struct {
 	db *gorm.DB
 }
 
-// NewRepository creates a new database repository
+var _ repository.Repository = (*Repository)(nil)
+
+// NewRepository opens the database and verifies its schema
+// A database without tables is initialized with all migrations. Any other
+// database with pending migrations, including one created before versioned
+// migrations, is only upgraded when database.auto_migrate is set; otherwise
+// `milpa migrate up` must be run first. A schema newer than the
+// binary is always rejected.
 func NewRepository(cfg *config.Config) (*Repository, error) {
-	// TODO: Support PostgreSQL for production
-	// TODO: Add connection pooling configuration
-	// TODO: Add migration strategy (auto-migrate vs versioned)
+	repo, err := Open(cfg)
+	if err != nil {
+		return nil, err
+	}
 
-	dsn := fmt.Sprintf("%s?cache=shared", cfg.Database.Path)
-	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{})
+	if err := repo.prepareSchema(cfg.Database.AutoMigrate); err != nil {
+		repo.Close()
+		return nil, err
+	}
+
+	return repo, nil
+}
+
+// Open connects to the database without checking or migrating the schema
+// Used by the migrate subcommands
+func Open(cfg *config.Config) (*Repository, error) {
+	dialector, err := openDialector(cfg.Database)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := gorm.Open(dialector, &gorm.Config{
+		// Timestamps are stored in UTC; see utcPool
+		NowFunc: func() time.Time { return time.Now().UTC() },
+		// Not-found lookups are expected and reported as repository.ErrNotFound
+		Logger: gormlogger.New(log.New(os.Stderr, "", log.LstdFlags), gormlogger.Config{
+			SlowThreshold:             slowQueryThreshold,
+			LogLevel:                  gormlogger.Warn,
+			IgnoreRecordNotFoundError: true,
+		}),
+	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to database: %w", err)
 	}
 
-	repo := &Repository{db: db}
+	if err := configurePool(db, cfg.Database.Pool); err != nil {
+		return nil, err
+	}
+
+	return &Repository{db: db}, nil
+}
 
-	// Auto-migrate schemas
-	// TODO: Add indexes for better query performance
-	// TODO: Add migrations table for version tracking
-	if err := repo.migrate(); err != nil {
-		return nil, fmt.Errorf("failed to migrate database: %w", err)
+// openDialector selects the GORM driver from the configured database type
+func openDialector(cfg config.DatabaseConfig) (gorm.Dialector, error) {
+	switch strings.ToLower(cfg.Type) {
+	case "", config.DatabaseTypeSQLite:
+		dsn := fmt.Sprintf("%s?cache=shared", cfg.Path)
+		conn, err := sql.Open(sqlite.DriverName, dsn)
+		if err != nil {
+			return nil, err
+		}
+		return sqlite.New(sqlite.Config{DSN: dsn, Conn: newUTCPool(conn)}), nil
+	case config.DatabaseTypePostgres, "postgresql":
+		return postgres.Open(postgresDSN(cfg)), nil
+	default:
+		return nil, fmt.Errorf("unsupported database type: %q", cfg.Type)
 	}
+}
 
-	return repo, nil
+// postgresDSN builds a libpq keyword/value connection string
+func postgresDSN(cfg config.DatabaseConfig) string {
+	params := []string{}
+	add := func(key, value string) {
+		if value == "" {
+			return
+		}
+		value = strings.ReplaceAll(value, `\`, `\\`)
+		value = strings.ReplaceAll(value, `'`, `\'`)
+		params = append(params, fmt.Sprintf("%s='%s'", key, value))
+	}
+
+	add("host", cfg.Host)
+	if cfg.Port != 0 {
+		add("port", fmt.Sprintf("%d", cfg.Port))
+	}
+	add("dbname", cfg.Name)
+	add("user", cfg.User)
+	add("password", cfg.Password)
+	add("sslmode", cfg.SSLMode)
+	add("search_path", cfg.Schema)
+
+	return strings.Join(params, " ")
 }
 
-func (r *Repository) migrate() error {
-	// TODO: Use proper migrations instead of AutoMigrate
-	// AutoMigrate is fine for development/MVP but not for production
-	return r.db.AutoMigrate(
-		&entities.PluginDefinition{},
-		&entities.PluginInstance{},
-	)
+// configurePool applies connection pool settings to the underlying sql.DB
+func configurePool(db *gorm.DB, cfg config.PoolConfig) error {
+	sqlDB, err := db.DB()
+	if err != nil {
+		return err
+	}
+
+	if cfg.MaxOpenConns > 0 {
+		sqlDB.SetMaxOpenConns(cfg.MaxOpenConns)
+	}
+	if cfg.MaxIdleConns > 0 {
+		sqlDB.SetMaxIdleConns(cfg.MaxIdleConns)
+	}
+	if cfg.ConnMaxLifetime != "" {
+		d, err := time.ParseDuration(cfg.ConnMaxLifetime)
+		if err != nil {
+			return fmt.Errorf("invalid pool.conn_max_lifetime: %w", err)
+		}
+		sqlDB.SetConnMaxLifetime(d)
+	}
+	if cfg.ConnMaxIdleTime != "" {
+		d, err := time.ParseDuration(cfg.ConnMaxIdleTime)
+		if err != nil {
+			return fmt.Errorf("invalid pool.conn_max_idle_time: %w", err)
+		}
+		sqlDB.SetConnMaxIdleTime(d)
+	}
+
+	return nil
+}
+
+func (r *Repository) prepareSchema(autoMigrate bool) error {
+	err := r.CheckSchema()
+	if !errors.Is(err, ErrSchemaBehind) {
+		return err
+	}
+
+	if !autoMigrate {
+		empty, eerr := r.isEmpty()
+		if eerr != nil {
+			return eerr
+		}
+		if !empty {
+			return fmt.Errorf("%w (run `milpa migrate up`)", err)
+		}
+	}
+
+	if _, err := r.MigrateUp(); err != nil {
+		return fmt.Errorf("failed to migrate database: %w", err)
+	}
+	return nil
+}
+
+// isEmpty reports whether the database has no tables besides the
+// migrations tracking table
+func (r *Repository) isEmpty() (bool, error) {
+	tables, err := r.db.Migrator().GetTables()
+	if err != nil {
+		return false, err
+	}
+	for _, table := range tables {
+		if table != (schemaMigration{}).TableName() {
+			return false, nil
+		}
+	}
+	return true, nil
 }
 
 // ============ Plugin Definitions ============
 
 // UpsertDefinition creates or updates a plugin definition
 func (r *Repository) UpsertDefinition(def *entities.PluginDefinition) error {
-	// TODO: Add optimistic locking
 	// TODO: Add audit logging
-	return r.db.Where("id = ?", def.ID).Assign(*def).FirstOrCreate(def).Error
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		var existing entities.PluginDefinition
+		err := tx.Where("id = ?", def.ID).First(&existing).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			def.Revision = 1
+			return tx.Create(def).Error
+		}
+		if err != nil {
+			return err
+		}
+		if def.Revision != 0 && def.Revision != existing.Revision {
+			return repository.ErrConflict
+		}
+
+		// Only non-zero fields overwrite the stored row
+		merged := existing
+		if def.Version != "" {
+			merged.Version = def.Version
+		}
+		if def.APIVersion != "" {
+			merged.APIVersion = def.APIVersion
+		}
+		if def.DependsOn != nil {
+			merged.DependsOn = def.DependsOn
+		}
+		if def.Capabilities != nil {
+			merged.Capabilities = def.Capabilities
+		}
+		if def.Metadata != nil {
+			merged.Metadata = def.Metadata
+		}
+		merged.Revision = existing.Revision + 1
+
+		res := tx.Model(&merged).
+			Where("revision = ?", existing.Revision).
+			Select("version", "api_version", "depends_on", "capabilities", "metadata", "revision", "updated_at").
+			Updates(&merged)
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return repository.ErrConflict
+		}
+		*def = merged
+		return nil
+	})
+}
+
+// UpdateDefinition saves all fields of a definition if its revision is current
+func (r *Repository) UpdateDefinition(def *entities.PluginDefinition) error {
+	return r.conditionalSave(def, &def.Revision, &entities.PluginDefinition{}, def.ID)
 }
 
 // GetDefinition returns a plugin definition by ID
@@ -62,7 +252,7 @@ func (r *Repository) GetDefinition(id string) (*entities.PluginDefinition, error
 	var def entities.PluginDefinition
 	err := r.db.Where("id = ?", id).First(&def).Error
 	if err != nil {
-		return nil, err
+		return nil, translateError(err)
 	}
 	return &def, nil
 }
@@ -78,7 +268,11 @@ func (r *Repository) ListDefinitions() ([]*entities.PluginDefinition, error) {
 func (r *Repository) SetDefinitionEnabled(id string, enabled bool) error {
 	// TODO: Add validation
 	// TODO: Add event/callback for state changes
-	return r.db.Model(&entities.PluginDefinition{}).Where("id = ?", id).Update("enabled", enabled).Error
+	res := r.db.Model(&entities.PluginDefinition{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"enabled":  enabled,
+		"revision": gorm.Expr("revision + 1"),
+	})
+	return rowsAffected(res)
 }
 
 // ============ Plugin Instances ============
@@ -87,7 +281,8 @@ func (r *Repository) SetDefinitionEnabled(id string, enabled bool) error {
 func (r *Repository) CreateInstance(inst *entities.PluginInstance) error {
 	// TODO: Add validation
 	// TODO: Add audit logging
-	return r.db.Create(inst).Error
+	inst.Revision = 1
+	return r.db.Omit("Definition").Create(inst).Error
 }
 
 // GetInstance returns a plugin instance by ID
@@ -95,7 +290,7 @@ func (r *Repository) GetInstance(id string) (*entities.PluginInstance, error) {
 	var inst entities.PluginInstance
 	err := r.db.Where("id = ?", id).First(&inst).Error
 	if err != nil {
-		return nil, err
+		return nil, translateError(err)
 	}
 	return &inst, nil
 }
@@ -107,27 +302,310 @@ func (r *Repository) ListInstances() ([]*entities.PluginInstance, error) {
 	return instances, err
 }
 
-// UpdateInstance updates a plugin instance
+// UpdateInstance saves all fields of an instance if its revision is current
 func (r *Repository) UpdateInstance(inst *entities.PluginInstance) error {
-	// TODO: Add optimistic locking
-	return r.db.Save(inst).Error
+	return r.conditionalSave(inst, &inst.Revision, &entities.PluginInstance{}, inst.ID)
+}
+
+// conditionalSave writes every column of model where the stored revision
+// matches *revision, incrementing it on success
+func (r *Repository) conditionalSave(model interface{}, revision *int64, table interface{}, id string) error {
+	expected := *revision
+	*revision = expected + 1
+
+	res := r.db.Model(model).
+		Where("revision = ?", expected).
+		Select("*").
+		Omit("id", "created_at", "Definition").
+		Updates(model)
+	if res.Error != nil {
+		*revision = expected
+		return res.Error
+	}
+	if res.RowsAffected == 1 {
+		return nil
+	}
+
+	*revision = expected
+	var count int64
+	if err := r.db.Model(table).Where("id = ?", id).Count(&count).Error; err != nil {
+		return err
+	}
+	if count == 0 {
+		return repository.ErrNotFound
+	}
+	return repository.ErrConflict
 }
 
 // SetInstanceEnabled enables or disables a plugin instance
 func (r *Repository) SetInstanceEnabled(id string, enabled bool) error {
-	return r.db.Model(&entities.PluginInstance{}).Where("id = ?", id).Update("enabled", enabled).Error
+	res := r.db.Model(&entities.PluginInstance{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"enabled":  enabled,
+		"revision": gorm.Expr("revision + 1"),
+	})
+	return rowsAffected(res)
+}
+
+// TouchHeartbeats writes a batch of heartbeat timestamps in one transaction
+// Older timestamps never overwrite newer ones
+func (r *Repository) TouchHeartbeats(beats map[string]time.Time) error {
+	if len(beats) == 0 {
+		return nil
+	}
+
+	// Stable order keeps row locks consistent between concurrent flushes
+	ids := make([]string, 0, len(beats))
+	for id := range beats {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		for _, id := range ids {
+			at := beats[id]
+			err := tx.Model(&entities.PluginInstance{}).
+				Where("id = ? AND (last_heartbeat IS NULL OR last_heartbeat < ?)", id, at).
+				UpdateColumn("last_heartbeat", at).Error
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// SaveHealth writes health reports in one transaction without bumping revisions
+func (r *Repository) SaveHealth(reports map[string]*entities.HealthReport) error {
+	if len(reports) == 0 {
+		return nil
+	}
+
+	ids := make([]string, 0, len(reports))
+	for id := range reports {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		for _, id := range ids {
+			data, err := json.Marshal(reports[id])
+			if err != nil {
+				return err
+			}
+			err = tx.Model(&entities.PluginInstance{}).Where("id = ?", id).
+				UpdateColumn("health", string(data)).Error
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
 }
 
 // GetUnhealthyInstances returns instances that haven't sent heartbeat recently
+// The cutoff is computed in Go so the query is portable across drivers
 func (r *Repository) GetUnhealthyInstances(timeout string) ([]entities.PluginInstance, error) {
-	// TODO: Implement proper time parsing
-	// TODO: Add database-level query for efficiency
+	d, err := time.ParseDuration(timeout)
+	if err != nil {
+		return nil, fmt.Errorf("invalid heartbeat timeout %q: %w", timeout, err)
+	}
+	cutoff := time.Now().Add(-d)
+
 	var instances []entities.PluginInstance
-	err := r.db.Where("status = ? AND last_heartbeat < datetime('now', ?)", 
-		entities.PluginStatusRunning, "-"+timeout).Find(&instances).Error
+	err = r.db.Where("status = ? AND (last_heartbeat IS NULL OR last_heartbeat < ?)",
+		entities.PluginStatusRunning, cutoff).Find(&instances).Error
 	return instances, err
 }
 
+// FindInstanceByKey returns the latest non-retired instance with an instance key
+func (r *Repository) FindInstanceByKey(definitionID, instanceKey string) (*entities.PluginInstance, error) {
+	var inst entities.PluginInstance
+	err := r.db.
+		Where("definition_id = ? AND instance_key = ? AND status <> ?", definitionID, instanceKey, entities.PluginStatusRetired).
+		Order("started_at DESC, id DESC").
+		First(&inst).Error
+	if err != nil {
+		return nil, translateError(err)
+	}
+	return &inst, nil
+}
+
+// RetireInstances marks stale unhealthy, stopping or stopped instances as retired
+// Disabled instances are kept so the admin's decision survives reconnects
+func (r *Repository) RetireInstances(staleBefore time.Time, reason string) ([]*entities.InstanceTransition, error) {
+	var transitions []*entities.InstanceTransition
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		stale := func() *gorm.DB {
+			return tx.Model(&entities.PluginInstance{}).
+				Where("status IN ? AND enabled = ?", []string{entities.PluginStatusUnhealthy, entities.PluginStatusStopping, entities.PluginStatusStopped}, true).
+				Where("(last_heartbeat IS NULL OR last_heartbeat < ?)", staleBefore)
+		}
+		var retired []*entities.PluginInstance
+		if err := stale().Order("id").Find(&retired).Error; err != nil {
+			return err
+		}
+		if len(retired) == 0 {
+			return nil
+		}
+
+		ids := make([]string, len(retired))
+		for i, inst := range retired {
+			ids[i] = inst.ID
+		}
+		now := time.Now()
+		err := stale().Where("id IN ?", ids).Updates(map[string]interface{}{
+			"status":     entities.PluginStatusRetired,
+			"retired_at": now,
+			"revision":   gorm.Expr("revision + 1"),
+			"updated_at": now,
+		}).Error
+		if err != nil {
+			return err
+		}
+
+		for _, inst := range retired {
+			transitions = append(transitions, &entities.InstanceTransition{
+				InstanceID: inst.ID,
+				PluginID:   inst.DefinitionID,
+				From:       inst.Status,
+				To:         entities.PluginStatusRetired,
+				Reason:     reason,
+				CreatedAt:  now,
+			})
+		}
+		return tx.Create(&transitions).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return transitions, nil
+}
+
+// DeleteRetiredInstances removes instances retired before retiredBefore
+func (r *Repository) DeleteRetiredInstances(retiredBefore time.Time) (int64, error) {
+	var deleted int64
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		expired := tx.Model(&entities.PluginInstance{}).Select("id").
+			Where("status = ? AND retired_at < ?", entities.PluginStatusRetired, retiredBefore)
+		if err := tx.Where("instance_id IN (?)", expired).Delete(&entities.InstanceTransition{}).Error; err != nil {
+			return err
+		}
+		res := tx.
+			Where("status = ? AND retired_at < ?", entities.PluginStatusRetired, retiredBefore).
+			Delete(&entities.PluginInstance{})
+		deleted = res.RowsAffected
+		return res.Error
+	})
+	return deleted, err
+}
+
+// ============ Plugin Config ============
+
+// GetConfig returns the configuration values stored for a plugin
+func (r *Repository) GetConfig(pluginID string) (map[string]string, error) {
+	var entries []entities.PluginConfigEntry
+	if err := r.db.Where("plugin_id = ?", pluginID).Find(&entries).Error; err != nil {
+		return nil, err
+	}
+
+	values := make(map[string]string, len(entries))
+	for _, e := range entries {
+		values[e.Key] = e.Value
+	}
+	return values, nil
+}
+
+// SetConfig creates or updates configuration values for a plugin
+// Keys not present in values are left untouched
+func (r *Repository) SetConfig(pluginID string, values map[string]string) error {
+	if len(values) == 0 {
+		return nil
+	}
+
+	now := time.Now()
+	entries := make([]entities.PluginConfigEntry, 0, len(values))
+	for k, v := range values {
+		entries = append(entries, entities.PluginConfigEntry{
+			PluginID:  pluginID,
+			Key:       k,
+			Value:     v,
+			UpdatedAt: now,
+		})
+	}
+
+	return r.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "plugin_id"}, {Name: "key"}},
+		DoUpdates: clause.AssignmentColumns([]string{"value", "updated_at"}),
+	}).Create(&entries).Error
+}
+
+// DeleteConfig removes a configuration value for a plugin
+func (r *Repository) DeleteConfig(pluginID, key string) error {
+	res := r.db.Where("plugin_id = ? AND key = ?", pluginID, key).Delete(&entities.PluginConfigEntry{})
+	return rowsAffected(res)
+}
+
+// ============ Events ============
+
+// AppendEvent stores an event in the event log
+func (r *Repository) AppendEvent(ev *entities.EventRecord) error {
+	return r.db.Create(ev).Error
+}
+
+// ListEvents returns logged events matching the filter, oldest first
+func (r *Repository) ListEvents(filter repository.EventFilter) ([]*entities.EventRecord, error) {
+	q := r.db.Model(&entities.EventRecord{})
+	if filter.Type != "" {
+		q = q.Where("type = ?", filter.Type)
+	}
+	if filter.InstanceID != "" {
+		q = q.Where("instance_id = ?", filter.InstanceID)
+	}
+	if !filter.Since.IsZero() {
+		q = q.Where("created_at >= ?", filter.Since)
+	}
+	if filter.Limit > 0 {
+		q = q.Limit(filter.Limit)
+	}
+
+	var events []*entities.EventRecord
+	err := q.Order("id").Find(&events).Error
+	return events, err
+}
+
+// ============ Instance Transitions ============
+
+// AppendTransition stores an instance status change
+func (r *Repository) AppendTransition(t *entities.InstanceTransition) error {
+	return r.db.Create(t).Error
+}
+
+// ListTransitions returns the status history of an instance, oldest first
+func (r *Repository) ListTransitions(instanceID string) ([]*entities.InstanceTransition, error) {
+	var transitions []*entities.InstanceTransition
+	err := r.db.Where("instance_id = ?", instanceID).Order("id").Find(&transitions).Error
+	return transitions, err
+}
+
+// translateError maps GORM errors to repository errors
+func translateError(err error) error {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return repository.ErrNotFound
+	}
+	return err
+}
+
+// rowsAffected returns ErrNotFound when an update or delete matched nothing
+func rowsAffected(res *gorm.DB) error {
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return repository.ErrNotFound
+	}
+	return nil
+}
+
 // Close closes the database connection
 func (r *Repository) Close() error {
 	// TODO: Implement proper cleanup
@@ -137,3 +615,12 @@ func (r *Repository) Close() error {
 	}
 	return sqlDB.Close()
 }
+
+// Ping checks that the database is reachable
+func (r *Repository) Ping(ctx context.Context) error {
+	sqlDB, err := r.db.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.PingContext(ctx)
+}
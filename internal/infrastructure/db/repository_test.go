@@ -2,32 +2,39 @@ package db
 
 import (
 	"os"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/robrt95x/milpa-cloud/internal/domain/entities"
+	"github.com/robrt95x/milpa-cloud/internal/domain/repository"
+	"github.com/robrt95x/milpa-cloud/internal/domain/repository/repotest"
 	"github.com/robrt95x/milpa-cloud/internal/infrastructure/config"
+	"github.com/robrt95x/milpa-cloud/internal/infrastructure/db/dbtest"
 )
 
-func TestRepositoryPluginDefinition(t *testing.T) {
-	tmpFile, err := os.CreateTemp("", "milpa-*.db")
-	if err != nil {
-		t.Fatalf("Failed to create temp file: %v", err)
-	}
-	defer os.Remove(tmpFile.Name())
-	tmpFile.Close()
+func TestMain(m *testing.M) {
+	// Timestamps are stored in UTC; a local zone other than UTC catches
+	// values written or compared without the conversion
+	time.Local = time.FixedZone("UTC+9", 9*60*60)
+	os.Exit(m.Run())
+}
 
-	cfg := &config.Config{
-		Database: config.DatabaseConfig{
-			Type: "sqlite",
-			Path: tmpFile.Name(),
-		},
-	}
+// newTestRepository opens a repository on the backend selected by dbtest
+func newTestRepository(t *testing.T) *Repository {
+	t.Helper()
 
-	repo, err := NewRepository(cfg)
+	repo, err := NewRepository(dbtest.Config(t))
 	if err != nil {
 		t.Fatalf("Failed to create repository: %v", err)
 	}
-	defer repo.Close()
+	t.Cleanup(func() { repo.Close() })
+
+	return repo
+}
+
+func TestRepositoryPluginDefinition(t *testing.T) {
+	repo := newTestRepository(t)
 
 	def := &entities.PluginDefinition{
 		ID:           "test-plugin",
@@ -69,25 +76,7 @@ func TestRepositoryPluginDefinition(t *testing.T) {
 }
 
 func TestRepositoryPluginInstance(t *testing.T) {
-	tmpFile, err := os.CreateTemp("", "milpa-*.db")
-	if err != nil {
-		t.Fatalf("Failed to create temp file: %v", err)
-	}
-	defer os.Remove(tmpFile.Name())
-	tmpFile.Close()
-
-	cfg := &config.Config{
-		Database: config.DatabaseConfig{
-			Type: "sqlite",
-			Path: tmpFile.Name(),
-		},
-	}
-
-	repo, err := NewRepository(cfg)
-	if err != nil {
-		t.Fatalf("Failed to create repository: %v", err)
-	}
-	defer repo.Close()
+	repo := newTestRepository(t)
 
 	def := &entities.PluginDefinition{
 		ID:          "test-plugin",
@@ -133,3 +122,129 @@ func TestRepositoryPluginInstance(t *testing.T) {
 		t.Errorf("Expected Enabled=false, got %v", got.Enabled)
 	}
 }
+
+func TestRepositoryGetUnhealthyInstances(t *testing.T) {
+	repo := newTestRepository(t)
+
+	repo.UpsertDefinition(&entities.PluginDefinition{ID: "test-plugin", Version: "1.0.0", Enabled: true})
+
+	stale := time.Now().Add(-time.Minute)
+	fresh := time.Now()
+
+	for _, inst := range []*entities.PluginInstance{
+		{ID: "inst-stale", DefinitionID: "test-plugin", Status: entities.PluginStatusRunning, LastHeartbeat: &stale},
+		{ID: "inst-fresh", DefinitionID: "test-plugin", Status: entities.PluginStatusRunning, LastHeartbeat: &fresh},
+		{ID: "inst-stopped", DefinitionID: "test-plugin", Status: entities.PluginStatusStopped, LastHeartbeat: &stale},
+	} {
+		if err := repo.CreateInstance(inst); err != nil {
+			t.Fatalf("Failed to create instance: %v", err)
+		}
+	}
+
+	unhealthy, err := repo.GetUnhealthyInstances("30s")
+	if err != nil {
+		t.Fatalf("Failed to get unhealthy instances: %v", err)
+	}
+	if len(unhealthy) != 1 || unhealthy[0].ID != "inst-stale" {
+		t.Errorf("Expected only inst-stale, got %v", unhealthy)
+	}
+
+	if _, err := repo.GetUnhealthyInstances("soon"); err == nil {
+		t.Error("Expected error for invalid timeout")
+	}
+}
+
+func TestNewRepositoryUnsupportedType(t *testing.T) {
+	cfg := &config.Config{
+		Database: config.DatabaseConfig{Type: "oracle"},
+	}
+
+	if _, err := NewRepository(cfg); err == nil {
+		t.Error("Expected error for unsupported database type")
+	}
+}
+
+func TestPostgresDSN(t *testing.T) {
+	dsn := postgresDSN(config.DatabaseConfig{
+		Host:     "db.local",
+		Port:     5432,
+		Name:     "milpa",
+		User:     "milpa",
+		Password: "it's secret",
+		SSLMode:  "require",
+	})
+
+	for _, want := range []string{
+		"host='db.local'",
+		"port='5432'",
+		"dbname='milpa'",
+		"user='milpa'",
+		`password='it\'s secret'`,
+		"sslmode='require'",
+	} {
+		if !strings.Contains(dsn, want) {
+			t.Errorf("Expected DSN to contain %s, got %s", want, dsn)
+		}
+	}
+}
+
+func TestRepositoryContract(t *testing.T) {
+	repotest.Run(t, func(t *testing.T) repository.Repository {
+		return newTestRepository(t)
+	})
+}
+
+func TestTimestampsComparedAcrossZones(t *testing.T) {
+	repo := newTestRepository(t)
+	repo.UpsertDefinition(&entities.PluginDefinition{ID: "test-plugin", Version: "1.0.0"})
+
+	// Written by processes in different zones, e.g. before and after a DST
+	// change: the earlier instance has the larger local clock reading
+	base := time.Date(2026, 3, 29, 10, 0, 0, 0, time.UTC)
+	times := map[string]time.Time{
+		"inst-earlier": base.In(time.FixedZone("UTC+9", 9*60*60)),
+		"inst-later":   base.Add(time.Hour).In(time.FixedZone("UTC-5", -5*60*60)),
+	}
+	for id, ts := range times {
+		ts := ts
+		inst := &entities.PluginInstance{ID: id, DefinitionID: "test-plugin", Status: entities.PluginStatusRunning, LastHeartbeat: &ts, StartedAt: ts}
+		if err := repo.CreateInstance(inst); err != nil {
+			t.Fatalf("Failed to create instance: %v", err)
+		}
+	}
+
+	page, err := repo.QueryInstances(repository.InstanceQuery{HeartbeatBefore: base.Add(30 * time.Minute)})
+	if err != nil {
+		t.Fatalf("QueryInstances: %v", err)
+	}
+	if ids := instanceIDs(page.Items); strings.Join(ids, ",") != "inst-earlier" {
+		t.Errorf("Expected only the earlier heartbeat, got %v", ids)
+	}
+
+	var got []string
+	q := repository.InstanceQuery{Sort: "-started_at", Limit: 1}
+	for {
+		page, err := repo.QueryInstances(q)
+		if err != nil {
+			t.Fatalf("QueryInstances: %v", err)
+		}
+		for _, inst := range page.Items {
+			got = append(got, inst.ID)
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		q.Cursor = page.NextCursor
+	}
+	if strings.Join(got, ",") != "inst-later,inst-earlier" {
+		t.Errorf("Expected the later instance first, got %v", got)
+	}
+}
+
+func instanceIDs(instances []*entities.PluginInstance) []string {
+	ids := make([]string, 0, len(instances))
+	for _, inst := range instances {
+		ids = append(ids, inst.ID)
+	}
+	return ids
+}
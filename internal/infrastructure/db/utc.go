@@ -0,0 +1,109 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// SQLite stores times as text with the writer's UTC offset and compares
+// them as strings, so a value written or compared in another zone (or
+// across a DST change) sorts wrongly. utcPool converts every time argument
+// to UTC before it reaches the driver, whatever the process time zone.
+
+// sqlConn is implemented by *sql.DB and *sql.Tx
+type sqlConn interface {
+	PrepareContext(ctx context.Context, query string) (*sql.Stmt, error)
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// utcConn is a GORM connection that writes and compares times in UTC
+type utcConn struct {
+	conn sqlConn
+}
+
+func (c utcConn) PrepareContext(ctx context.Context, query string) (*sql.Stmt, error) {
+	return c.conn.PrepareContext(ctx, query)
+}
+
+func (c utcConn) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return c.conn.ExecContext(ctx, query, utcArgs(args)...)
+}
+
+func (c utcConn) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	return c.conn.QueryContext(ctx, query, utcArgs(args)...)
+}
+
+func (c utcConn) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	return c.conn.QueryRowContext(ctx, query, utcArgs(args)...)
+}
+
+// utcPool is the utcConn of a *sql.DB; its transactions are utcTx
+type utcPool struct {
+	utcConn
+	db *sql.DB
+}
+
+func newUTCPool(db *sql.DB) *utcPool {
+	return &utcPool{utcConn: utcConn{conn: db}, db: db}
+}
+
+// BeginTx implements gorm.ConnPoolBeginner
+func (p *utcPool) BeginTx(ctx context.Context, opts *sql.TxOptions) (gorm.ConnPool, error) {
+	tx, err := p.db.BeginTx(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &utcTx{utcConn: utcConn{conn: tx}, tx: tx}, nil
+}
+
+// GetDBConn implements gorm.GetDBConnector, so gorm.DB.DB keeps working
+func (p *utcPool) GetDBConn() (*sql.DB, error) {
+	return p.db, nil
+}
+
+// utcTx is the utcConn of a transaction
+type utcTx struct {
+	utcConn
+	tx *sql.Tx
+}
+
+func (t *utcTx) Commit() error {
+	return t.tx.Commit()
+}
+
+func (t *utcTx) Rollback() error {
+	return t.tx.Rollback()
+}
+
+// utcArgs returns args with times converted to UTC
+func utcArgs(args []interface{}) []interface{} {
+	var out []interface{}
+	for i, arg := range args {
+		var utc interface{}
+		switch v := arg.(type) {
+		case time.Time:
+			utc = v.UTC()
+		case *time.Time:
+			if v == nil {
+				continue
+			}
+			t := v.UTC()
+			utc = &t
+		default:
+			continue
+		}
+		if out == nil {
+			out = append([]interface{}(nil), args...)
+		}
+		out[i] = utc
+	}
+	if out == nil {
+		return args
+	}
+	return out
+}
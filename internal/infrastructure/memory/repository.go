@@ -0,0 +1,681 @@
+// Package memory implements an in-process repository.
+//
+// Nothing is persisted; it is meant for unit tests and throwaway
+// development environments (database.type: memory).
+package memory
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/robrt95x/milpa-cloud/internal/domain/entities"
+	"github.com/robrt95x/milpa-cloud/internal/domain/repository"
+)
+
+// Repository stores definitions, instances, config, events and transitions in maps
+// Every read and write copies the entity so callers never share state
+type Repository struct {
+	mu               sync.RWMutex
+	definitions      map[string]*entities.PluginDefinition
+	instances        map[string]*entities.PluginInstance
+	config           map[string]map[string]string
+	events           []*entities.EventRecord
+	nextEventID      uint
+	transitions      map[string][]*entities.InstanceTransition
+	nextTransitionID uint
+}
+
+var _ repository.Repository = (*Repository)(nil)
+
+// New creates an empty in-memory repository
+func New() *Repository {
+	return &Repository{
+		definitions: make(map[string]*entities.PluginDefinition),
+		instances:   make(map[string]*entities.PluginInstance),
+		config:      make(map[string]map[string]string),
+		transitions: make(map[string][]*entities.InstanceTransition),
+	}
+}
+
+// ============ Plugin Definitions ============
+
+// UpsertDefinition creates a definition or refreshes its descriptive fields
+func (r *Repository) UpsertDefinition(def *entities.PluginDefinition) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	existing, ok := r.definitions[def.ID]
+	if !ok {
+		def.Revision = 1
+		def.CreatedAt = now
+		def.UpdatedAt = now
+		r.definitions[def.ID] = copyDefinition(def)
+		return nil
+	}
+	if def.Revision != 0 && def.Revision != existing.Revision {
+		return repository.ErrConflict
+	}
+
+	// Like the GORM implementation, only non-zero fields overwrite the stored row
+	if def.Version != "" {
+		existing.Version = def.Version
+	}
+	if def.APIVersion != "" {
+		existing.APIVersion = def.APIVersion
+	}
+	if def.DependsOn != nil {
+		existing.DependsOn = append([]string(nil), def.DependsOn...)
+	}
+	if def.Capabilities != nil {
+		existing.Capabilities = append([]string(nil), def.Capabilities...)
+	}
+	if def.Metadata != nil {
+		existing.Metadata = copyMap(def.Metadata)
+	}
+	existing.UpdatedAt = now
+	existing.Revision++
+
+	*def = *copyDefinition(existing)
+	return nil
+}
+
+// UpdateDefinition saves all fields of a definition if its revision is current
+func (r *Repository) UpdateDefinition(def *entities.PluginDefinition) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	existing, ok := r.definitions[def.ID]
+	if !ok {
+		return repository.ErrNotFound
+	}
+	if existing.Revision != def.Revision {
+		return repository.ErrConflict
+	}
+
+	def.Revision++
+	def.CreatedAt = existing.CreatedAt
+	def.UpdatedAt = time.Now()
+	r.definitions[def.ID] = copyDefinition(def)
+	return nil
+}
+
+// GetDefinition returns a plugin definition by ID
+func (r *Repository) GetDefinition(id string) (*entities.PluginDefinition, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	def, ok := r.definitions[id]
+	if !ok {
+		return nil, repository.ErrNotFound
+	}
+	return copyDefinition(def), nil
+}
+
+// ListDefinitions returns all plugin definitions ordered by ID
+func (r *Repository) ListDefinitions() ([]*entities.PluginDefinition, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	defs := make([]*entities.PluginDefinition, 0, len(r.definitions))
+	for _, def := range r.definitions {
+		defs = append(defs, copyDefinition(def))
+	}
+	sort.Slice(defs, func(i, j int) bool { return defs[i].ID < defs[j].ID })
+	return defs, nil
+}
+
+// QueryDefinitions returns one page of filtered, sorted definitions
+func (r *Repository) QueryDefinitions(q repository.DefinitionQuery) (*repository.DefinitionPage, error) {
+	order, err := repository.ParseSort(q.Sort, repository.DefinitionSortFields)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.RLock()
+	var defs []*entities.PluginDefinition
+	for _, def := range r.definitions {
+		if q.Enabled != nil && def.Enabled != *q.Enabled {
+			continue
+		}
+		if q.Capability != "" && !contains(def.Capabilities, q.Capability) {
+			continue
+		}
+		defs = append(defs, copyDefinition(def))
+	}
+	r.mu.RUnlock()
+
+	page := &repository.DefinitionPage{Total: int64(len(defs))}
+	page.Items, page.NextCursor, err = paginate(defs, order, q.Limit, q.Cursor, func(def *entities.PluginDefinition) (interface{}, string) {
+		return repository.DefinitionSortValue(def, order.Field), def.ID
+	})
+	if err != nil {
+		return nil, err
+	}
+	return page, nil
+}
+
+// SetDefinitionEnabled enables or disables a plugin definition
+func (r *Repository) SetDefinitionEnabled(id string, enabled bool) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	def, ok := r.definitions[id]
+	if !ok {
+		return repository.ErrNotFound
+	}
+	def.Enabled = enabled
+	def.Revision++
+	def.UpdatedAt = time.Now()
+	return nil
+}
+
+// ============ Plugin Instances ============
+
+// CreateInstance creates a new plugin instance
+func (r *Repository) CreateInstance(inst *entities.PluginInstance) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.instances[inst.ID]; ok {
+		return fmt.Errorf("instance %q already exists", inst.ID)
+	}
+
+	now := time.Now()
+	inst.Revision = 1
+	inst.CreatedAt = now
+	inst.UpdatedAt = now
+	r.instances[inst.ID] = copyInstance(inst)
+	return nil
+}
+
+// GetInstance returns a plugin instance by ID
+func (r *Repository) GetInstance(id string) (*entities.PluginInstance, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	inst, ok := r.instances[id]
+	if !ok {
+		return nil, repository.ErrNotFound
+	}
+	return copyInstance(inst), nil
+}
+
+// ListInstances returns all plugin instances with their definitions, ordered by ID
+func (r *Repository) ListInstances() ([]*entities.PluginInstance, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	instances := make([]*entities.PluginInstance, 0, len(r.instances))
+	for _, inst := range r.instances {
+		c := copyInstance(inst)
+		if def, ok := r.definitions[inst.DefinitionID]; ok {
+			c.Definition = copyDefinition(def)
+		}
+		instances = append(instances, c)
+	}
+	sort.Slice(instances, func(i, j int) bool { return instances[i].ID < instances[j].ID })
+	return instances, nil
+}
+
+// QueryInstances returns one page of filtered, sorted instances
+func (r *Repository) QueryInstances(q repository.InstanceQuery) (*repository.InstancePage, error) {
+	order, err := repository.ParseSort(q.Sort, repository.InstanceSortFields)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.RLock()
+	var instances []*entities.PluginInstance
+	for _, inst := range r.instances {
+		if q.DefinitionID != "" && inst.DefinitionID != q.DefinitionID {
+			continue
+		}
+		if len(q.Status) > 0 && !contains(q.Status, inst.Status) {
+			continue
+		}
+		if q.Enabled != nil && inst.Enabled != *q.Enabled {
+			continue
+		}
+		if q.Capability != "" {
+			def, ok := r.definitions[inst.DefinitionID]
+			if !ok || !contains(def.Capabilities, q.Capability) {
+				continue
+			}
+		}
+		if !q.HeartbeatBefore.IsZero() && (inst.LastHeartbeat == nil || !inst.LastHeartbeat.Before(q.HeartbeatBefore)) {
+			continue
+		}
+		if !q.HeartbeatAfter.IsZero() && (inst.LastHeartbeat == nil || !inst.LastHeartbeat.After(q.HeartbeatAfter)) {
+			continue
+		}
+		instances = append(instances, copyInstance(inst))
+	}
+	r.mu.RUnlock()
+
+	page := &repository.InstancePage{Total: int64(len(instances))}
+	page.Items, page.NextCursor, err = paginate(instances, order, q.Limit, q.Cursor, func(inst *entities.PluginInstance) (interface{}, string) {
+		return repository.InstanceSortValue(inst, order.Field), inst.ID
+	})
+	if err != nil {
+		return nil, err
+	}
+	return page, nil
+}
+
+// UpdateInstance saves all fields of an instance if its revision is current
+func (r *Repository) UpdateInstance(inst *entities.PluginInstance) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	existing, ok := r.instances[inst.ID]
+	if !ok {
+		return repository.ErrNotFound
+	}
+	if existing.Revision != inst.Revision {
+		return repository.ErrConflict
+	}
+
+	inst.Revision++
+	inst.CreatedAt = existing.CreatedAt
+	inst.UpdatedAt = time.Now()
+	r.instances[inst.ID] = copyInstance(inst)
+	return nil
+}
+
+// SetInstanceEnabled enables or disables a plugin instance
+func (r *Repository) SetInstanceEnabled(id string, enabled bool) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	inst, ok := r.instances[id]
+	if !ok {
+		return repository.ErrNotFound
+	}
+	inst.Enabled = enabled
+	inst.Revision++
+	inst.UpdatedAt = time.Now()
+	return nil
+}
+
+// TouchHeartbeats moves heartbeat timestamps forward without bumping revisions
+func (r *Repository) TouchHeartbeats(beats map[string]time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for id, at := range beats {
+		inst, ok := r.instances[id]
+		if !ok {
+			continue
+		}
+		if inst.LastHeartbeat == nil || inst.LastHeartbeat.Before(at) {
+			t := at
+			inst.LastHeartbeat = &t
+		}
+	}
+	return nil
+}
+
+// SaveHealth stores the latest health reports without bumping revisions
+func (r *Repository) SaveHealth(reports map[string]*entities.HealthReport) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for id, report := range reports {
+		if inst, ok := r.instances[id]; ok {
+			inst.Health = copyHealth(report)
+		}
+	}
+	return nil
+}
+
+// GetUnhealthyInstances returns running instances that haven't sent heartbeat recently
+func (r *Repository) GetUnhealthyInstances(timeout string) ([]entities.PluginInstance, error) {
+	d, err := time.ParseDuration(timeout)
+	if err != nil {
+		return nil, fmt.Errorf("invalid heartbeat timeout %q: %w", timeout, err)
+	}
+	cutoff := time.Now().Add(-d)
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var result []entities.PluginInstance
+	for _, inst := range r.instances {
+		if inst.Status != entities.PluginStatusRunning {
+			continue
+		}
+		if inst.LastHeartbeat == nil || inst.LastHeartbeat.Before(cutoff) {
+			result = append(result, *copyInstance(inst))
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].ID < result[j].ID })
+	return result, nil
+}
+
+// FindInstanceByKey returns the latest non-retired instance with an instance key
+func (r *Repository) FindInstanceByKey(definitionID, instanceKey string) (*entities.PluginInstance, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var found *entities.PluginInstance
+	for _, inst := range r.instances {
+		if inst.DefinitionID != definitionID || inst.InstanceKey != instanceKey || inst.Status == entities.PluginStatusRetired {
+			continue
+		}
+		if found == nil || inst.StartedAt.After(found.StartedAt) ||
+			(inst.StartedAt.Equal(found.StartedAt) && inst.ID > found.ID) {
+			found = inst
+		}
+	}
+	if found == nil {
+		return nil, repository.ErrNotFound
+	}
+	return copyInstance(found), nil
+}
+
+// RetireInstances marks stale unhealthy, stopping or stopped instances as retired
+func (r *Repository) RetireInstances(staleBefore time.Time, reason string) ([]*entities.InstanceTransition, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	var retired []*entities.PluginInstance
+	for _, inst := range r.instances {
+		switch inst.Status {
+		case entities.PluginStatusUnhealthy, entities.PluginStatusStopping, entities.PluginStatusStopped:
+		default:
+			continue
+		}
+		if !inst.Enabled || (inst.LastHeartbeat != nil && !inst.LastHeartbeat.Before(staleBefore)) {
+			continue
+		}
+		retired = append(retired, inst)
+	}
+	sort.Slice(retired, func(i, j int) bool { return retired[i].ID < retired[j].ID })
+
+	transitions := make([]*entities.InstanceTransition, 0, len(retired))
+	for _, inst := range retired {
+		t := &entities.InstanceTransition{
+			InstanceID: inst.ID,
+			PluginID:   inst.DefinitionID,
+			From:       inst.Status,
+			To:         entities.PluginStatusRetired,
+			Reason:     reason,
+			CreatedAt:  now,
+		}
+		r.appendTransition(t)
+		transitions = append(transitions, t)
+
+		retiredAt := now
+		inst.Status = entities.PluginStatusRetired
+		inst.RetiredAt = &retiredAt
+		inst.Revision++
+		inst.UpdatedAt = now
+	}
+	return transitions, nil
+}
+
+// DeleteRetiredInstances removes instances retired before retiredBefore
+func (r *Repository) DeleteRetiredInstances(retiredBefore time.Time) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var n int64
+	for id, inst := range r.instances {
+		if inst.Status == entities.PluginStatusRetired && inst.RetiredAt != nil && inst.RetiredAt.Before(retiredBefore) {
+			delete(r.instances, id)
+			delete(r.transitions, id)
+			n++
+		}
+	}
+	return n, nil
+}
+
+// ============ Plugin Config ============
+
+// GetConfig returns the configuration values stored for a plugin
+func (r *Repository) GetConfig(pluginID string) (map[string]string, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	values := copyMap(r.config[pluginID])
+	if values == nil {
+		values = map[string]string{}
+	}
+	return values, nil
+}
+
+// SetConfig creates or updates configuration values for a plugin
+func (r *Repository) SetConfig(pluginID string, values map[string]string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(values) == 0 {
+		return nil
+	}
+	cfg, ok := r.config[pluginID]
+	if !ok {
+		cfg = make(map[string]string, len(values))
+		r.config[pluginID] = cfg
+	}
+	for k, v := range values {
+		cfg[k] = v
+	}
+	return nil
+}
+
+// DeleteConfig removes a configuration value for a plugin
+func (r *Repository) DeleteConfig(pluginID, key string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	cfg, ok := r.config[pluginID]
+	if !ok {
+		return repository.ErrNotFound
+	}
+	if _, ok := cfg[key]; !ok {
+		return repository.ErrNotFound
+	}
+	delete(cfg, key)
+	return nil
+}
+
+// ============ Events ============
+
+// AppendEvent stores an event in the event log
+func (r *Repository) AppendEvent(ev *entities.EventRecord) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.nextEventID++
+	ev.ID = r.nextEventID
+	if ev.CreatedAt.IsZero() {
+		ev.CreatedAt = time.Now()
+	}
+	c := *ev
+	r.events = append(r.events, &c)
+	return nil
+}
+
+// ListEvents returns logged events matching the filter, oldest first
+func (r *Repository) ListEvents(filter repository.EventFilter) ([]*entities.EventRecord, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var result []*entities.EventRecord
+	for _, ev := range r.events {
+		if filter.Type != "" && ev.Type != filter.Type {
+			continue
+		}
+		if filter.InstanceID != "" && ev.InstanceID != filter.InstanceID {
+			continue
+		}
+		if !filter.Since.IsZero() && ev.CreatedAt.Before(filter.Since) {
+			continue
+		}
+		c := *ev
+		result = append(result, &c)
+		if filter.Limit > 0 && len(result) == filter.Limit {
+			break
+		}
+	}
+	return result, nil
+}
+
+// ============ Instance Transitions ============
+
+// AppendTransition stores an instance status change
+func (r *Repository) AppendTransition(t *entities.InstanceTransition) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.appendTransition(t)
+	return nil
+}
+
+// appendTransition assigns an ID and stores a copy; r.mu must be held
+func (r *Repository) appendTransition(t *entities.InstanceTransition) {
+	r.nextTransitionID++
+	t.ID = r.nextTransitionID
+	if t.CreatedAt.IsZero() {
+		t.CreatedAt = time.Now()
+	}
+	c := *t
+	r.transitions[t.InstanceID] = append(r.transitions[t.InstanceID], &c)
+}
+
+// ListTransitions returns the status history of an instance, oldest first
+func (r *Repository) ListTransitions(instanceID string) ([]*entities.InstanceTransition, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	result := make([]*entities.InstanceTransition, 0, len(r.transitions[instanceID]))
+	for _, t := range r.transitions[instanceID] {
+		c := *t
+		result = append(result, &c)
+	}
+	return result, nil
+}
+
+// Close is a no-op for the in-memory repository
+func (r *Repository) Close() error {
+	return nil
+}
+
+// ============ Query helpers ============
+
+// paginate sorts items by (sort value, ID) and returns the page after cursor
+func paginate[T any](items []T, order repository.Sort, limit int, cursor string, key func(T) (interface{}, string)) ([]T, string, error) {
+	compare := func(av interface{}, aid string, bv interface{}, bid string) int {
+		c := repository.CompareSortValues(av, bv)
+		if c == 0 {
+			c = strings.Compare(aid, bid)
+		}
+		if order.Desc {
+			c = -c
+		}
+		return c
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		av, aid := key(items[i])
+		bv, bid := key(items[j])
+		return compare(av, aid, bv, bid) < 0
+	})
+
+	start := 0
+	if cursor != "" {
+		value, id, err := repository.DecodeCursor(cursor, order)
+		if err != nil {
+			return nil, "", err
+		}
+		start = sort.Search(len(items), func(i int) bool {
+			v, vid := key(items[i])
+			return compare(v, vid, value, id) > 0
+		})
+	}
+
+	limit = repository.PageLimit(limit)
+	end := start + limit
+	if end >= len(items) {
+		return items[start:], "", nil
+	}
+	last, lastID := key(items[end-1])
+	return items[start:end], repository.NewCursor(order, last, lastID), nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// ============ Copy helpers ============
+
+func copyDefinition(def *entities.PluginDefinition) *entities.PluginDefinition {
+	c := *def
+	if def.DependsOn != nil {
+		c.DependsOn = append([]string(nil), def.DependsOn...)
+	}
+	if def.Capabilities != nil {
+		c.Capabilities = append([]string(nil), def.Capabilities...)
+	}
+	c.Metadata = copyMap(def.Metadata)
+	return &c
+}
+
+func copyInstance(inst *entities.PluginInstance) *entities.PluginInstance {
+	c := *inst
+	c.Definition = nil
+	if inst.LastHeartbeat != nil {
+		t := *inst.LastHeartbeat
+		c.LastHeartbeat = &t
+	}
+	if inst.RetiredAt != nil {
+		t := *inst.RetiredAt
+		c.RetiredAt = &t
+	}
+	if inst.QuarantinedUntil != nil {
+		t := *inst.QuarantinedUntil
+		c.QuarantinedUntil = &t
+	}
+	c.Metadata = copyMap(inst.Metadata)
+	c.Health = copyHealth(inst.Health)
+	if inst.Probe != nil {
+		p := *inst.Probe
+		c.Probe = &p
+	}
+	return &c
+}
+
+func copyHealth(h *entities.HealthReport) *entities.HealthReport {
+	if h == nil {
+		return nil
+	}
+	c := *h
+	c.Checks = append([]entities.HealthCheck(nil), h.Checks...)
+	if h.Gauges != nil {
+		c.Gauges = make(map[string]float64, len(h.Gauges))
+		for k, v := range h.Gauges {
+			c.Gauges[k] = v
+		}
+	}
+	return &c
+}
+
+func copyMap(m map[string]string) map[string]string {
+	if m == nil {
+		return nil
+	}
+	c := make(map[string]string, len(m))
+	for k, v := range m {
+		c[k] = v
+	}
+	return c
+}
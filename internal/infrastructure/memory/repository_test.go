@@ -0,0 +1,14 @@
+package memory
+
+import (
+	"testing"
+
+	"github.com/robrt95x/milpa-cloud/internal/domain/repository"
+	"github.com/robrt95x/milpa-cloud/internal/domain/repository/repotest"
+)
+
+func TestRepositoryContract(t *testing.T) {
+	repotest.Run(t, func(t *testing.T) repository.Repository {
+		return New()
+	})
+}
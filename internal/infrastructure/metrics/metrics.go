@@ -0,0 +1,361 @@
+// Package metrics is a minimal Prometheus-compatible metrics registry
+// It supports counters, gauges and histograms with labels, and writes them
+// in the Prometheus text exposition format (version 0.0.4).
+package metrics
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Metric types
+const (
+	TypeCounter   = "counter"
+	TypeGauge     = "gauge"
+	TypeHistogram = "histogram"
+)
+
+// DefaultBuckets are histogram buckets for durations in seconds
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Label is a label name and value
+type Label struct {
+	Name  string
+	Value string
+}
+
+// Sample is one value of a family
+// Name defaults to the family name; histograms add _bucket, _sum and _count
+type Sample struct {
+	Name   string
+	Labels []Label
+	Value  float64
+}
+
+// Family is a named group of samples of one type
+type Family struct {
+	Name    string
+	Help    string
+	Type    string
+	Samples []Sample
+}
+
+// Collector produces families when metrics are scraped
+type Collector interface {
+	Collect() []Family
+}
+
+// CollectorFunc adapts a function to a Collector
+type CollectorFunc func() []Family
+
+func (f CollectorFunc) Collect() []Family {
+	return f()
+}
+
+// Registry holds the collectors exposed together
+type Registry struct {
+	mu         sync.Mutex
+	collectors []Collector
+}
+
+// NewRegistry creates an empty registry
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// Register adds a collector
+func (r *Registry) Register(c Collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.collectors = append(r.collectors, c)
+}
+
+// Gather collects every family, sorted by name
+// Families with the same name and type are merged.
+func (r *Registry) Gather() []Family {
+	r.mu.Lock()
+	collectors := append([]Collector(nil), r.collectors...)
+	r.mu.Unlock()
+
+	byName := make(map[string]*Family)
+	var names []string
+	for _, c := range collectors {
+		for _, f := range c.Collect() {
+			if existing, ok := byName[f.Name]; ok {
+				if existing.Type == f.Type {
+					existing.Samples = append(existing.Samples, f.Samples...)
+				}
+				continue
+			}
+			f := f
+			byName[f.Name] = &f
+			names = append(names, f.Name)
+		}
+	}
+	sort.Strings(names)
+
+	out := make([]Family, 0, len(names))
+	for _, name := range names {
+		out = append(out, *byName[name])
+	}
+	return out
+}
+
+// NewCounterVec registers a counter with the given label names
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	v := &CounterVec{vec: newVec(name, help, TypeCounter, labels)}
+	r.Register(v)
+	return v
+}
+
+// NewGaugeVec registers a gauge with the given label names
+func (r *Registry) NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	v := &GaugeVec{vec: newVec(name, help, TypeGauge, labels)}
+	r.Register(v)
+	return v
+}
+
+// NewHistogramVec registers a histogram with the given buckets (upper
+// bounds, ascending) and label names
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	v := &HistogramVec{vec: newVec(name, help, TypeHistogram, labels), buckets: buckets}
+	r.Register(v)
+	return v
+}
+
+// NewGaugeFunc registers a gauge whose samples are computed on each scrape
+func (r *Registry) NewGaugeFunc(name, help string, fn func() []Sample) {
+	r.Register(CollectorFunc(func() []Family {
+		return []Family{{Name: name, Help: help, Type: TypeGauge, Samples: fn()}}
+	}))
+}
+
+// vec is the label handling shared by all metric vectors
+type vec struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+
+	mu     sync.Mutex
+	values map[string]interface{}
+	keys   map[string][]string
+}
+
+func newVec(name, help, kind string, labels []string) vec {
+	return vec{
+		name:   name,
+		help:   help,
+		kind:   kind,
+		labels: labels,
+		values: make(map[string]interface{}),
+		keys:   make(map[string][]string),
+	}
+}
+
+// get returns the value for the label values, creating it with create
+func (v *vec) get(values []string, create func() interface{}) interface{} {
+	if len(values) != len(v.labels) {
+		panic("metrics: " + v.name + ": wrong number of label values")
+	}
+	key := strings.Join(values, "\xff")
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	m, ok := v.values[key]
+	if !ok {
+		m = create()
+		v.values[key] = m
+		v.keys[key] = append([]string(nil), values...)
+	}
+	return m
+}
+
+// each calls fn for every value with its labels, in a stable order
+func (v *vec) each(fn func(labels []Label, m interface{})) {
+	v.mu.Lock()
+	keys := make([]string, 0, len(v.values))
+	for key := range v.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	values := make([]interface{}, len(keys))
+	labelValues := make([][]string, len(keys))
+	for i, key := range keys {
+		values[i] = v.values[key]
+		labelValues[i] = v.keys[key]
+	}
+	v.mu.Unlock()
+
+	for i := range keys {
+		labels := make([]Label, len(v.labels))
+		for j, name := range v.labels {
+			labels[j] = Label{Name: name, Value: labelValues[i][j]}
+		}
+		fn(labels, values[i])
+	}
+}
+
+// Delete removes the value for the label values
+func (v *vec) Delete(values ...string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	key := strings.Join(values, "\xff")
+	delete(v.values, key)
+	delete(v.keys, key)
+}
+
+// atomicFloat is a float64 updated atomically
+type atomicFloat struct {
+	bits atomic.Uint64
+}
+
+func (f *atomicFloat) add(delta float64) {
+	for {
+		old := f.bits.Load()
+		if f.bits.CompareAndSwap(old, math.Float64bits(math.Float64frombits(old)+delta)) {
+			return
+		}
+	}
+}
+
+func (f *atomicFloat) set(v float64) {
+	f.bits.Store(math.Float64bits(v))
+}
+
+func (f *atomicFloat) load() float64 {
+	return math.Float64frombits(f.bits.Load())
+}
+
+// Counter is a value that only increases
+type Counter struct {
+	v atomicFloat
+}
+
+// Inc adds one
+func (c *Counter) Inc() {
+	c.v.add(1)
+}
+
+// Add adds a non-negative delta
+func (c *Counter) Add(delta float64) {
+	if delta < 0 {
+		return
+	}
+	c.v.add(delta)
+}
+
+// CounterVec is a counter partitioned by labels
+type CounterVec struct {
+	vec
+}
+
+// With returns the counter for the label values
+func (v *CounterVec) With(values ...string) *Counter {
+	return v.get(values, func() interface{} { return &Counter{} }).(*Counter)
+}
+
+func (v *CounterVec) Collect() []Family {
+	f := Family{Name: v.name, Help: v.help, Type: TypeCounter}
+	v.each(func(labels []Label, m interface{}) {
+		f.Samples = append(f.Samples, Sample{Labels: labels, Value: m.(*Counter).v.load()})
+	})
+	return []Family{f}
+}
+
+// Gauge is a value that can go up and down
+type Gauge struct {
+	v atomicFloat
+}
+
+// Set replaces the value
+func (g *Gauge) Set(v float64) {
+	g.v.set(v)
+}
+
+// Add adds delta, which may be negative
+func (g *Gauge) Add(delta float64) {
+	g.v.add(delta)
+}
+
+// GaugeVec is a gauge partitioned by labels
+type GaugeVec struct {
+	vec
+}
+
+// With returns the gauge for the label values
+func (v *GaugeVec) With(values ...string) *Gauge {
+	return v.get(values, func() interface{} { return &Gauge{} }).(*Gauge)
+}
+
+func (v *GaugeVec) Collect() []Family {
+	f := Family{Name: v.name, Help: v.help, Type: TypeGauge}
+	v.each(func(labels []Label, m interface{}) {
+		f.Samples = append(f.Samples, Sample{Labels: labels, Value: m.(*Gauge).v.load()})
+	})
+	return []Family{f}
+}
+
+// Histogram counts observations in buckets
+type Histogram struct {
+	buckets []float64
+	counts  []atomic.Uint64 // per bucket, not cumulative; the last is +Inf
+	sum     atomicFloat
+	count   atomic.Uint64
+}
+
+// Observe records a value
+func (h *Histogram) Observe(v float64) {
+	i := sort.SearchFloat64s(h.buckets, v)
+	h.counts[i].Add(1)
+	h.sum.add(v)
+	h.count.Add(1)
+}
+
+// HistogramVec is a histogram partitioned by labels
+type HistogramVec struct {
+	vec
+	buckets []float64
+}
+
+// With returns the histogram for the label values
+func (v *HistogramVec) With(values ...string) *Histogram {
+	return v.get(values, func() interface{} {
+		return &Histogram{buckets: v.buckets, counts: make([]atomic.Uint64, len(v.buckets)+1)}
+	}).(*Histogram)
+}
+
+func (v *HistogramVec) Collect() []Family {
+	f := Family{Name: v.name, Help: v.help, Type: TypeHistogram}
+	v.each(func(labels []Label, m interface{}) {
+		h := m.(*Histogram)
+		var cumulative uint64
+		for i, upper := range h.buckets {
+			cumulative += h.counts[i].Load()
+			f.Samples = append(f.Samples, Sample{
+				Name:   v.name + "_bucket",
+				Labels: withLabel(labels, "le", formatFloat(upper)),
+				Value:  float64(cumulative),
+			})
+		}
+		cumulative += h.counts[len(h.buckets)].Load()
+		f.Samples = append(f.Samples,
+			Sample{Name: v.name + "_bucket", Labels: withLabel(labels, "le", "+Inf"), Value: float64(cumulative)},
+			Sample{Name: v.name + "_sum", Labels: labels, Value: h.sum.load()},
+			Sample{Name: v.name + "_count", Labels: labels, Value: float64(h.count.Load())},
+		)
+	})
+	return []Family{f}
+}
+
+func withLabel(labels []Label, name, value string) []Label {
+	out := make([]Label, len(labels), len(labels)+1)
+	copy(out, labels)
+	return append(out, Label{Name: name, Value: value})
+}
@@ -0,0 +1,95 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWriteText(t *testing.T) {
+	reg := NewRegistry()
+	requests := reg.NewCounterVec("requests_total", "Requests handled.", "code")
+	requests.With("200").Inc()
+	requests.With("200").Add(2)
+	requests.With("500").Inc()
+	requests.With("404").Add(-1) // ignored
+
+	reg.NewGaugeVec("temperature", "Line one\nline two.", "room").With(`a"b\c`).Set(-1.5)
+
+	latency := reg.NewHistogramVec("latency_seconds", "", []float64{0.1, 1}, "route")
+	latency.With("/").Observe(0.05)
+	latency.With("/").Observe(0.5)
+	latency.With("/").Observe(2)
+
+	reg.NewGaugeFunc("up", "Always up.", func() []Sample {
+		return []Sample{{Value: 1}}
+	})
+
+	var b strings.Builder
+	if err := WriteText(&b, reg.Gather()); err != nil {
+		t.Fatalf("WriteText: %v", err)
+	}
+	want := `# TYPE latency_seconds histogram
+latency_seconds_bucket{route="/",le="0.1"} 1
+latency_seconds_bucket{route="/",le="1"} 2
+latency_seconds_bucket{route="/",le="+Inf"} 3
+latency_seconds_sum{route="/"} 2.55
+latency_seconds_count{route="/"} 3
+# HELP requests_total Requests handled.
+# TYPE requests_total counter
+requests_total{code="200"} 3
+requests_total{code="404"} 0
+requests_total{code="500"} 1
+# HELP temperature Line one\nline two.
+# TYPE temperature gauge
+temperature{room="a\"b\\c"} -1.5
+# HELP up Always up.
+# TYPE up gauge
+up 1
+`
+	if got := b.String(); got != want {
+		t.Errorf("Unexpected exposition:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestGatherMergesFamilies(t *testing.T) {
+	reg := NewRegistry()
+	for _, v := range []float64{1, 2} {
+		v := v
+		reg.Register(CollectorFunc(func() []Family {
+			return []Family{{Name: "x", Type: TypeGauge, Samples: []Sample{{Value: v}}}}
+		}))
+	}
+	families := reg.Gather()
+	if len(families) != 1 || len(families[0].Samples) != 2 {
+		t.Errorf("Expected one family with two samples, got %+v", families)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	reg := NewRegistry()
+	reg.NewCounterVec("hits_total", "Hits.").With().Inc()
+
+	w := httptest.NewRecorder()
+	reg.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if ct := w.Header().Get("Content-Type"); ct != ContentType {
+		t.Errorf("Unexpected content type %q", ct)
+	}
+	if !strings.Contains(w.Body.String(), "hits_total 1\n") {
+		t.Errorf("Unexpected body:\n%s", w.Body.String())
+	}
+}
+
+func TestValidNames(t *testing.T) {
+	for name, want := range map[string]bool{"a_b:c": true, "1a": false, "a-b": false, "": false} {
+		if got := ValidMetricName(name); got != want {
+			t.Errorf("ValidMetricName(%q) = %v, want %v", name, got, want)
+		}
+	}
+	for name, want := range map[string]bool{"route": true, "__name": false, "a:b": false} {
+		if got := ValidLabelName(name); got != want {
+			t.Errorf("ValidLabelName(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,20 @@
+// Package storage selects the repository backend from the configuration.
+package storage
+
+import (
+	"strings"
+
+	"github.com/robrt95x/milpa-cloud/internal/domain/repository"
+	"github.com/robrt95x/milpa-cloud/internal/infrastructure/config"
+	"github.com/robrt95x/milpa-cloud/internal/infrastructure/db"
+	"github.com/robrt95x/milpa-cloud/internal/infrastructure/memory"
+)
+
+// New returns the repository selected by database.type
+// "memory" keeps everything in process and is lost on exit
+func New(cfg *config.Config) (repository.Repository, error) {
+	if strings.ToLower(cfg.Database.Type) == config.DatabaseTypeMemory {
+		return memory.New(), nil
+	}
+	return db.NewRepository(cfg)
+}
@@ -0,0 +1,33 @@
+package storage
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/robrt95x/milpa-cloud/internal/infrastructure/config"
+	"github.com/robrt95x/milpa-cloud/internal/infrastructure/db"
+	"github.com/robrt95x/milpa-cloud/internal/infrastructure/memory"
+)
+
+func TestNew(t *testing.T) {
+	repo, err := New(&config.Config{Database: config.DatabaseConfig{Type: "Memory"}})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	if _, ok := repo.(*memory.Repository); !ok {
+		t.Errorf("Expected a memory repository, got %T", repo)
+	}
+
+	cfg := &config.Config{Database: config.DatabaseConfig{
+		Type: config.DatabaseTypeSQLite,
+		Path: filepath.Join(t.TempDir(), "milpa.db"),
+	}}
+	repo, err = New(cfg)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer repo.Close()
+	if _, ok := repo.(*db.Repository); !ok {
+		t.Errorf("Expected a database repository, got %T", repo)
+	}
+}
@@ -0,0 +1,35 @@
+package logger
+
+import "context"
+
+// Common context field keys
+const (
+	FieldInstanceID = "instance_id"
+	FieldPluginID   = "plugin_id"
+	FieldRequestID  = "request_id"
+	FieldTraceID    = "trace_id"
+)
+
+type fieldsKey struct{}
+
+// ContextWith returns a copy of ctx carrying the key/value pairs in
+// addition to those already stored; Logger.WithContext adds them to messages
+func ContextWith(ctx context.Context, args ...interface{}) context.Context {
+	if len(args) == 0 {
+		return ctx
+	}
+	prev := ContextFields(ctx)
+	fields := make([]interface{}, 0, len(prev)+len(args))
+	fields = append(fields, prev...)
+	fields = append(fields, args...)
+	return context.WithValue(ctx, fieldsKey{}, fields)
+}
+
+// ContextFields returns the key/value pairs stored in ctx by ContextWith
+func ContextFields(ctx context.Context) []interface{} {
+	if ctx == nil {
+		return nil
+	}
+	fields, _ := ctx.Value(fieldsKey{}).([]interface{})
+	return fields
+}
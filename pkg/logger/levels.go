@@ -0,0 +1,154 @@
+package logger
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+// Levels holds the level of a root logger and the overrides of its
+// subsystems; both can change at runtime
+type Levels struct {
+	root hclog.Logger
+
+	mu        sync.Mutex
+	level     hclog.Level
+	overrides map[string]hclog.Level
+	// effective caches the level of each subsystem ("" is the root) so
+	// that log calls only do an atomic load
+	effective sync.Map // string -> *atomic.Int32
+}
+
+func newLevels(root hclog.Logger, level hclog.Level) *Levels {
+	return &Levels{
+		root:      root,
+		level:     level,
+		overrides: make(map[string]hclog.Level),
+	}
+}
+
+// enabled reports whether a subsystem writes messages of the given level
+func (l *Levels) enabled(subsystem string, lvl hclog.Level) bool {
+	return lvl >= l.effectiveLevel(subsystem)
+}
+
+func (l *Levels) effectiveLevel(subsystem string) hclog.Level {
+	if v, ok := l.effective.Load(subsystem); ok {
+		return hclog.Level(v.(*atomic.Int32).Load())
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	v := new(atomic.Int32)
+	v.Store(int32(l.resolve(subsystem)))
+	actual, _ := l.effective.LoadOrStore(subsystem, v)
+	return hclog.Level(actual.(*atomic.Int32).Load())
+}
+
+// resolve returns the configured level of a subsystem; mu must be held
+func (l *Levels) resolve(subsystem string) hclog.Level {
+	if lvl, ok := l.overrides[subsystem]; ok {
+		return lvl
+	}
+	return l.level
+}
+
+// refresh recomputes the cached levels; mu must be held
+func (l *Levels) refresh() {
+	l.effective.Range(func(key, value interface{}) bool {
+		value.(*atomic.Int32).Store(int32(l.resolve(key.(string))))
+		return true
+	})
+}
+
+// Level returns the root level
+func (l *Levels) Level() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.level.String()
+}
+
+// SetLevel changes the root level, which every subsystem without an
+// override follows
+func (l *Levels) SetLevel(level string) error {
+	lvl, err := parseLevel(level)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.level = lvl
+	l.refresh()
+	return nil
+}
+
+// SetOverride sets the level of a subsystem; an empty level removes the
+// override so the subsystem follows the root level again
+func (l *Levels) SetOverride(subsystem, level string) error {
+	if subsystem == "" {
+		return fmt.Errorf("empty subsystem name")
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if level == "" {
+		delete(l.overrides, subsystem)
+	} else {
+		lvl, err := parseLevel(level)
+		if err != nil {
+			return err
+		}
+		l.overrides[subsystem] = lvl
+	}
+	l.refresh()
+	return nil
+}
+
+// Overrides returns the subsystem overrides by name
+func (l *Levels) Overrides() map[string]string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	out := make(map[string]string, len(l.overrides))
+	for name, lvl := range l.overrides {
+		out[name] = lvl.String()
+	}
+	return out
+}
+
+// ValidLevels lists the accepted level names, most verbose first
+var ValidLevels = []string{"trace", "debug", "info", "warn", "error", "off"}
+
+// ValidateLevel reports whether level is one of ValidLevels
+func ValidateLevel(level string) error {
+	_, err := parseLevel(level)
+	return err
+}
+
+// parseLevel converts a level name; unlike hclog.LevelFromString it
+// rejects unknown names
+func parseLevel(level string) (hclog.Level, error) {
+	switch strings.ToLower(strings.TrimSpace(level)) {
+	case "trace":
+		return hclog.Trace, nil
+	case "debug":
+		return hclog.Debug, nil
+	case "info":
+		return hclog.Info, nil
+	case "warn", "warning":
+		return hclog.Warn, nil
+	case "error":
+		return hclog.Error, nil
+	case "off":
+		return hclog.Off, nil
+	}
+	return hclog.NoLevel, fmt.Errorf("invalid log level %q (valid: %s)", level, strings.Join(ValidLevels, ", "))
+}
@@ -0,0 +1,45 @@
+package logger
+
+import "testing"
+
+func TestSubsystemLevels(t *testing.T) {
+	root := New("info")
+	manager := root.Subsystem("manager")
+	db := root.Subsystem("db")
+
+	if manager.IsDebug() {
+		t.Fatal("Expected subsystems to follow the root level")
+	}
+
+	levels := root.Levels()
+	if err := levels.SetOverride("db", "debug"); err != nil {
+		t.Fatalf("SetOverride: %v", err)
+	}
+	if !db.IsDebug() || manager.IsDebug() || root.IsDebug() {
+		t.Error("Expected the override to apply to its subsystem only")
+	}
+
+	levels.SetLevel("debug")
+	if !manager.IsDebug() || !root.IsDebug() {
+		t.Error("Expected root level changes to reach subsystems without override")
+	}
+	levels.SetOverride("db", "error")
+	levels.SetLevel("info")
+	if db.IsDebug() || levels.Overrides()["db"] != "error" {
+		t.Errorf("Expected db override to be kept, got %v", levels.Overrides())
+	}
+
+	// Removing the override makes the subsystem follow the root again
+	levels.SetOverride("db", "")
+	levels.SetLevel("trace")
+	if !db.IsDebug() || len(levels.Overrides()) != 0 {
+		t.Error("Expected db to follow the root level after removing its override")
+	}
+
+	if err := levels.SetLevel("verbose"); err == nil {
+		t.Error("Expected an unknown level to be rejected")
+	}
+	if levels.Level() != "trace" {
+		t.Errorf("Expected a rejected level to leave trace in place, got %s", levels.Level())
+	}
+}
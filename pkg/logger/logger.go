@@ -1,8 +1,12 @@
 package logger
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"os"
+	"strings"
+	"sync/atomic"
 
 	"github.com/hashicorp/go-hclog"
 )
@@ -12,41 +16,181 @@ type Logger interface {
 	Info(msg string, args ...interface{})
 	Warn(msg string, args ...interface{})
 	Error(msg string, args ...interface{})
+
+	// IsDebug reports whether debug messages are written
+	IsDebug() bool
+	// With returns a logger that adds the key/value pairs to every message
+	With(args ...interface{}) Logger
+	// Named returns a child logger whose name is appended to this one's;
+	// it shares this logger's level
+	Named(name string) Logger
+	// WithContext returns a logger that adds the fields stored in ctx by
+	// ContextWith
+	WithContext(ctx context.Context) Logger
+	// Subsystem returns the logger of a subsystem of the root logger; its
+	// level follows the root level unless overridden in Levels
+	Subsystem(name string) Logger
+	// Levels returns the runtime level control shared with the root logger
+	Levels() *Levels
+}
+
+// Output formats
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// Options configures a root logger
+type Options struct {
+	Name  string
+	Level string // unknown levels fall back to info
+	// Format is FormatText (default) or FormatJSON
+	Format string
+	// Output defaults to stdout
+	Output io.Writer
+	// Sink, if set, also receives every message written
+	Sink Sink
+}
+
+// Sink receives the messages a logger writes, after level filtering
+// args holds the key/value pairs, including those added with With.
+type Sink interface {
+	Accept(name, level, msg string, args ...interface{})
+}
+
+// sinkAdapter passes hclog messages to a Sink
+type sinkAdapter struct {
+	sink Sink
+}
+
+func (a sinkAdapter) Accept(name string, level hclog.Level, msg string, args ...interface{}) {
+	a.sink.Accept(name, level.String(), msg, args...)
 }
 
 type hclogAdapter struct {
-	log hclog.Logger
+	log       hclog.Logger
+	levels    *Levels
+	subsystem string
 }
 
 func (h *hclogAdapter) Debug(msg string, args ...interface{}) {
-	h.log.Debug(msg, args...)
+	if h.levels.enabled(h.subsystem, hclog.Debug) {
+		h.log.Debug(msg, args...)
+	}
 }
 
 func (h *hclogAdapter) Info(msg string, args ...interface{}) {
-	h.log.Info(msg, args...)
+	if h.levels.enabled(h.subsystem, hclog.Info) {
+		h.log.Info(msg, args...)
+	}
 }
 
 func (h *hclogAdapter) Warn(msg string, args ...interface{}) {
-	h.log.Warn(msg, args...)
+	if h.levels.enabled(h.subsystem, hclog.Warn) {
+		h.log.Warn(msg, args...)
+	}
 }
 
 func (h *hclogAdapter) Error(msg string, args ...interface{}) {
-	h.log.Error(msg, args...)
+	if h.levels.enabled(h.subsystem, hclog.Error) {
+		h.log.Error(msg, args...)
+	}
+}
+
+func (h *hclogAdapter) IsDebug() bool {
+	return h.levels.enabled(h.subsystem, hclog.Debug)
+}
+
+func (h *hclogAdapter) With(args ...interface{}) Logger {
+	if len(args) == 0 {
+		return h
+	}
+	return h.derive(h.log.With(args...))
+}
+
+func (h *hclogAdapter) Named(name string) Logger {
+	return h.derive(h.log.Named(name))
+}
+
+func (h *hclogAdapter) WithContext(ctx context.Context) Logger {
+	return h.With(ContextFields(ctx)...)
+}
+
+// Subsystem names are flat: a subsystem of a subsystem is a sibling
+func (h *hclogAdapter) Subsystem(name string) Logger {
+	return &hclogAdapter{
+		log:       h.levels.root.Named(name),
+		levels:    h.levels,
+		subsystem: name,
+	}
+}
+
+func (h *hclogAdapter) Levels() *Levels {
+	return h.levels
 }
 
+// derive wraps an hclog logger derived from h, keeping h's subsystem
+func (h *hclogAdapter) derive(log hclog.Logger) *hclogAdapter {
+	return &hclogAdapter{
+		log:       log,
+		levels:    h.levels,
+		subsystem: h.subsystem,
+	}
+}
+
+// New creates a root logger named "milpa" writing text to stdout
+// An unknown level falls back to info
 func New(level string) Logger {
-	lvl := hclog.LevelFromString(level)
-	if lvl == hclog.NoLevel {
+	return NewNamed("milpa", level)
+}
+
+// NewNamed creates a root logger with the given name writing text to stdout
+func NewNamed(name, level string) Logger {
+	log, _ := NewWithOptions(Options{Name: name, Level: level})
+	return log
+}
+
+// NewWithOptions creates a root logger
+// It fails on an unknown format
+func NewWithOptions(opts Options) (Logger, error) {
+	lvl, err := parseLevel(opts.Level)
+	if err != nil {
 		lvl = hclog.Info
 	}
 
-	return &hclogAdapter{
-		log: hclog.New(&hclog.LoggerOptions{
-			Name:   "milpa",
-			Level:  lvl,
-			Output: os.Stdout,
-		}),
+	var json bool
+	switch strings.ToLower(opts.Format) {
+	case "", FormatText:
+	case FormatJSON:
+		json = true
+	default:
+		return nil, fmt.Errorf("invalid log format %q (valid: %s, %s)", opts.Format, FormatText, FormatJSON)
 	}
+
+	output := opts.Output
+	if output == nil {
+		output = os.Stdout
+	}
+
+	// Levels filter messages before they reach hclog
+	hopts := &hclog.LoggerOptions{
+		Name:       opts.Name,
+		Level:      hclog.Trace,
+		Output:     output,
+		JSONFormat: json,
+	}
+	var root hclog.Logger
+	if opts.Sink != nil {
+		intercept := hclog.NewInterceptLogger(hopts)
+		intercept.RegisterSink(sinkAdapter{opts.Sink})
+		root = intercept
+	} else {
+		root = hclog.New(hopts)
+	}
+	return &hclogAdapter{
+		log:    root,
+		levels: newLevels(root, lvl),
+	}, nil
 }
 
 func NewHCLog(name string) hclog.Logger {
@@ -56,19 +200,37 @@ func NewHCLog(name string) hclog.Logger {
 	})
 }
 
-// Convenience function for quick logging
+// std is the logger used by the package-level functions
+var std atomic.Value // Logger
+
+func init() {
+	std.Store(New("info"))
+}
+
+// Default returns the logger used by the package-level functions
+func Default() Logger {
+	return std.Load().(Logger)
+}
+
+// SetDefault replaces the logger used by the package-level functions
+func SetDefault(log Logger) {
+	std.Store(log)
+}
+
+// Convenience functions for quick logging with the default logger
+// args are key/value pairs, as for Logger
 func Debug(msg string, args ...interface{}) {
-	hclog.Default().Debug(fmt.Sprintf(msg, args...))
+	Default().Debug(msg, args...)
 }
 
 func Info(msg string, args ...interface{}) {
-	hclog.Default().Info(fmt.Sprintf(msg, args...))
+	Default().Info(msg, args...)
 }
 
 func Warn(msg string, args ...interface{}) {
-	hclog.Default().Warn(fmt.Sprintf(msg, args...))
+	Default().Warn(msg, args...)
 }
 
 func Error(msg string, args ...interface{}) {
-	hclog.Default().Error(fmt.Sprintf(msg, args...))
+	Default().Error(msg, args...)
 }
@@ -0,0 +1,86 @@
+package logger
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// decodeLines parses JSON log lines
+func decodeLines(t *testing.T, buf *bytes.Buffer) []map[string]interface{} {
+	t.Helper()
+
+	var lines []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var m map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &m); err != nil {
+			t.Fatalf("Invalid JSON line %q: %v", line, err)
+		}
+		lines = append(lines, m)
+	}
+	return lines
+}
+
+func TestJSONOutput(t *testing.T) {
+	var buf bytes.Buffer
+	root, err := NewWithOptions(Options{Name: "milpa", Level: "info", Format: FormatJSON, Output: &buf})
+	if err != nil {
+		t.Fatalf("NewWithOptions: %v", err)
+	}
+
+	ctx := ContextWith(context.Background(), FieldRequestID, "req-1")
+	ctx = ContextWith(ctx, FieldInstanceID, "inst-1")
+	log := root.Subsystem("manager").Named("probe").With("plugin_id", "example")
+	log.WithContext(ctx).Info("probe failed", "attempt", 2)
+	log.Debug("not written")
+
+	lines := decodeLines(t, &buf)
+	if len(lines) != 1 {
+		t.Fatalf("Expected one line, got %d", len(lines))
+	}
+	line := lines[0]
+	want := map[string]interface{}{
+		"@message":    "probe failed",
+		"@level":      "info",
+		"@module":     "milpa.manager.probe",
+		"plugin_id":   "example",
+		"request_id":  "req-1",
+		"instance_id": "inst-1",
+		"attempt":     float64(2),
+	}
+	for k, v := range want {
+		if line[k] != v {
+			t.Errorf("%s: expected %v, got %v", k, v, line[k])
+		}
+	}
+
+	// Named loggers follow their subsystem's level
+	root.Levels().SetOverride("manager", "debug")
+	buf.Reset()
+	log.Debug("written")
+	if !strings.Contains(buf.String(), "written") {
+		t.Error("Expected the named logger to follow the manager override")
+	}
+}
+
+func TestInvalidFormat(t *testing.T) {
+	if _, err := NewWithOptions(Options{Format: "xml"}); err == nil {
+		t.Error("Expected an unknown format to be rejected")
+	}
+}
+
+func TestDefaultHelpersKeepKeyValues(t *testing.T) {
+	var buf bytes.Buffer
+	log, _ := NewWithOptions(Options{Name: "milpa", Format: FormatJSON, Output: &buf})
+	prev := Default()
+	SetDefault(log)
+	defer SetDefault(prev)
+
+	Info("100% done", "instance_id", "inst-1")
+	line := decodeLines(t, &buf)[0]
+	if line["@message"] != "100% done" || line["instance_id"] != "inst-1" {
+		t.Errorf("Expected message and key/value pair to be kept, got %v", line)
+	}
+}
@@ -0,0 +1,221 @@
+package logger
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rotatedTimeFormat names rotated files; it sorts chronologically
+const rotatedTimeFormat = "20060102T150405.000"
+
+// RotateOptions controls when a RotatingFile starts a new file
+// Zero values disable the corresponding limit
+type RotateOptions struct {
+	// MaxSize is the size in bytes after which the file is rotated
+	MaxSize int64
+	// MaxAge is how long a file is written before it is rotated
+	MaxAge time.Duration
+	// MaxBackups is the number of rotated files kept
+	MaxBackups int
+}
+
+// RotatingFile is a log file rotated by size and age
+// Rotated files are renamed to name-<timestamp>.ext next to the file.
+type RotatingFile struct {
+	path string
+	opts RotateOptions
+
+	mu     sync.Mutex
+	file   *os.File
+	closed bool
+	size   int64
+	opened time.Time
+	now    func() time.Time
+	rename func(oldpath, newpath string) error
+}
+
+// OpenRotatingFile opens (or creates) a log file for appending
+func OpenRotatingFile(path string, opts RotateOptions) (*RotatingFile, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("create log directory: %w", err)
+	}
+	f := &RotatingFile{path: path, opts: opts, now: time.Now, rename: os.Rename}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// open opens the current file; mu must be held
+// An existing file counts as opened when it was last written
+func (f *RotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("open log file: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("open log file: %w", err)
+	}
+
+	f.file = file
+	f.size = info.Size()
+	f.opened = f.now()
+	if f.size > 0 {
+		f.opened = info.ModTime()
+	}
+	return nil
+}
+
+// Write appends p, rotating first when it would exceed MaxSize or the file
+// is older than MaxAge
+// A failed rotation is retried on the next write; p is still written to the
+// current file so logging keeps going.
+func (f *RotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.closed {
+		return 0, os.ErrClosed
+	}
+	if f.file == nil {
+		if err := f.open(); err != nil {
+			return 0, err
+		}
+	}
+	if f.dueLocked(int64(len(p))) {
+		if err := f.rotateLocked(); err != nil && f.file == nil {
+			return 0, err
+		}
+	}
+
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// dueLocked reports whether writing n bytes requires a rotation
+func (f *RotatingFile) dueLocked(n int64) bool {
+	if f.size == 0 {
+		return false
+	}
+	if f.opts.MaxSize > 0 && f.size+n > f.opts.MaxSize {
+		return true
+	}
+	return f.opts.MaxAge > 0 && f.now().Sub(f.opened) >= f.opts.MaxAge
+}
+
+// Rotate renames the current file and starts a new one
+func (f *RotatingFile) Rotate() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.closed {
+		return os.ErrClosed
+	}
+	if f.file == nil {
+		if err := f.open(); err != nil {
+			return err
+		}
+	}
+	return f.rotateLocked()
+}
+
+// rotateLocked renames the current file and opens a new one; mu must be held
+// When the rename fails the current file is reopened, so f.file is only nil
+// afterwards when no file could be opened at all.
+func (f *RotatingFile) rotateLocked() error {
+	rotated, err := f.rotatedName()
+	if err != nil {
+		return err
+	}
+
+	f.file.Close()
+	f.file = nil
+	if err := f.rename(f.path, rotated); err != nil {
+		err = fmt.Errorf("rotate log file: %w", err)
+		if oerr := f.open(); oerr != nil {
+			return fmt.Errorf("%w; %v", err, oerr)
+		}
+		return err
+	}
+	if err := f.open(); err != nil {
+		return err
+	}
+	return f.pruneLocked()
+}
+
+// rotatedName returns a free name for the rotated file
+// Rotations within the same millisecond get the next free timestamp.
+func (f *RotatingFile) rotatedName() (string, error) {
+	ext := filepath.Ext(f.path)
+	for at := f.now(); ; at = at.Add(time.Millisecond) {
+		rotated := strings.TrimSuffix(f.path, ext) + "-" + at.Format(rotatedTimeFormat) + ext
+		_, err := os.Stat(rotated)
+		if os.IsNotExist(err) {
+			return rotated, nil
+		}
+		if err != nil {
+			return "", fmt.Errorf("rotate log file: %w", err)
+		}
+	}
+}
+
+// pruneLocked removes the oldest rotated files beyond MaxBackups
+func (f *RotatingFile) pruneLocked() error {
+	if f.opts.MaxBackups <= 0 {
+		return nil
+	}
+	backups, err := f.backups()
+	if err != nil {
+		return err
+	}
+	for len(backups) > f.opts.MaxBackups {
+		if err := os.Remove(backups[0]); err != nil {
+			return fmt.Errorf("remove rotated log file: %w", err)
+		}
+		backups = backups[1:]
+	}
+	return nil
+}
+
+// backups returns the rotated files, oldest first
+func (f *RotatingFile) backups() ([]string, error) {
+	ext := filepath.Ext(f.path)
+	base := strings.TrimSuffix(f.path, ext)
+	matches, err := filepath.Glob(base + "-*" + ext)
+	if err != nil {
+		return nil, err
+	}
+
+	// Only names with a timestamp, so similarly named files are left alone
+	backups := matches[:0]
+	for _, m := range matches {
+		stamp := strings.TrimSuffix(strings.TrimPrefix(m, base+"-"), ext)
+		if _, err := time.Parse(rotatedTimeFormat, stamp); err == nil {
+			backups = append(backups, m)
+		}
+	}
+	sort.Strings(backups)
+	return backups, nil
+}
+
+// Close closes the current file
+func (f *RotatingFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.closed = true
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
@@ -0,0 +1,101 @@
+package logger
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRotateBySize(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "logs", "milpa.log")
+	f, err := OpenRotatingFile(path, RotateOptions{MaxSize: 10, MaxBackups: 2})
+	if err != nil {
+		t.Fatalf("OpenRotatingFile: %v", err)
+	}
+	defer f.Close()
+
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		if _, err := f.Write([]byte(line)); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+	}
+
+	data, _ := os.ReadFile(path)
+	if string(data) != "fourth\n" {
+		t.Errorf("Expected the current file to hold the last line, got %q", data)
+	}
+	backups, _ := f.backups()
+	if len(backups) != 2 {
+		t.Fatalf("Expected 2 rotated files to be kept, got %v", backups)
+	}
+	if data, _ := os.ReadFile(backups[0]); string(data) != "second\n" {
+		t.Errorf("Expected the oldest kept file to hold the second line, got %q", data)
+	}
+}
+
+func TestRotateByAge(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "milpa.log")
+	f, err := OpenRotatingFile(path, RotateOptions{MaxAge: time.Hour})
+	if err != nil {
+		t.Fatalf("OpenRotatingFile: %v", err)
+	}
+	defer f.Close()
+
+	now := time.Now()
+	f.now = func() time.Time { return now }
+	f.Write([]byte("old\n"))
+	f.opened = now.Add(-2 * time.Hour)
+	f.Write([]byte("new\n"))
+
+	backups, _ := f.backups()
+	if len(backups) != 1 {
+		t.Fatalf("Expected one rotated file, got %v", backups)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "new\n" {
+		t.Errorf("Expected the current file to be restarted, got %q", data)
+	}
+
+	// Files written by another process with a similar name are left alone
+	other := filepath.Join(filepath.Dir(path), "milpa-archive.log")
+	os.WriteFile(other, []byte("x"), 0o644)
+	if backups, _ := f.backups(); len(backups) != 1 {
+		t.Errorf("Expected unrelated files to be ignored, got %v", backups)
+	}
+}
+
+func TestRotateFailureKeepsLogging(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "milpa.log")
+	f, err := OpenRotatingFile(path, RotateOptions{MaxSize: 10})
+	if err != nil {
+		t.Fatalf("OpenRotatingFile: %v", err)
+	}
+	defer f.Close()
+
+	f.rename = func(string, string) error { return errors.New("device busy") }
+	for _, line := range []string{"first\n", "second\n"} {
+		if _, err := f.Write([]byte(line)); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+	}
+	if err := f.Rotate(); err == nil {
+		t.Error("Expected the failed rename to be reported")
+	}
+
+	// Once renaming works again the pending rotation happens
+	f.rename = os.Rename
+	if _, err := f.Write([]byte("third\n")); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "third\n" {
+		t.Errorf("Expected the current file to be restarted, got %q", data)
+	}
+	backups, _ := f.backups()
+	if len(backups) != 1 {
+		t.Fatalf("Expected one rotated file, got %v", backups)
+	}
+	if data, _ := os.ReadFile(backups[0]); string(data) != "first\nsecond\n" {
+		t.Errorf("Expected the lines written during the failure to be kept, got %q", data)
+	}
+}
@@ -0,0 +1,116 @@
+package rpc
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/robrt95x/milpa-cloud/pkg/types"
+)
+
+// ServiceName is the gRPC service served by the core
+const ServiceName = "milpa.v1.PluginService"
+
+// Full method names of the service
+const (
+	MethodHandshake  = "/" + ServiceName + "/Handshake"
+	MethodHeartbeat  = "/" + ServiceName + "/Heartbeat"
+	MethodConfigure  = "/" + ServiceName + "/Configure"
+	MethodDeregister = "/" + ServiceName + "/Deregister"
+	MethodStream     = "/" + ServiceName + "/Stream"
+)
+
+// Stream metadata identifying the session
+const (
+	MetadataSessionID = "session-id"
+	MetadataAuthToken = "auth-token"
+)
+
+// StreamDesc describes the bidirectional event stream
+var StreamDesc = grpc.StreamDesc{
+	StreamName:    "Stream",
+	ServerStreams: true,
+	ClientStreams: true,
+}
+
+// Client calls the core's PluginService with the JSON codec
+type Client struct {
+	cc grpc.ClientConnInterface
+}
+
+// NewClient creates a client on an established connection
+func NewClient(cc grpc.ClientConnInterface) *Client {
+	return &Client{cc: cc}
+}
+
+// Handshake registers the plugin with the core
+func (c *Client) Handshake(ctx context.Context, req *types.HandshakeRequest, opts ...grpc.CallOption) (*types.HandshakeResponse, error) {
+	out := new(types.HandshakeResponse)
+	if err := c.invoke(ctx, MethodHandshake, req, out, opts); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// Heartbeat reports that the plugin is alive
+func (c *Client) Heartbeat(ctx context.Context, req *types.HeartbeatRequest, opts ...grpc.CallOption) (*types.HeartbeatResponse, error) {
+	out := new(types.HeartbeatResponse)
+	if err := c.invoke(ctx, MethodHeartbeat, req, out, opts); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// Configure sends the plugin configuration to the core
+func (c *Client) Configure(ctx context.Context, req *types.ConfigureRequest, opts ...grpc.CallOption) (*types.ConfigureResponse, error) {
+	out := new(types.ConfigureResponse)
+	if err := c.invoke(ctx, MethodConfigure, req, out, opts); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// Deregister ends the session
+func (c *Client) Deregister(ctx context.Context, req *types.DeregisterRequest, opts ...grpc.CallOption) (*types.DeregisterResponse, error) {
+	out := new(types.DeregisterResponse)
+	if err := c.invoke(ctx, MethodDeregister, req, out, opts); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// Stream opens the event stream of a session
+// The core sends CoreEvents; the plugin sends PluginEvents such as
+// shutdown acknowledgements and status replies.
+func (c *Client) Stream(ctx context.Context, sessionID, authToken string, opts ...grpc.CallOption) (*EventStream, error) {
+	ctx = metadata.AppendToOutgoingContext(ctx, MetadataSessionID, sessionID, MetadataAuthToken, authToken)
+	stream, err := c.cc.NewStream(ctx, &StreamDesc, MethodStream, append([]grpc.CallOption{CallOption()}, opts...)...)
+	if err != nil {
+		return nil, err
+	}
+	return &EventStream{ClientStream: stream}, nil
+}
+
+func (c *Client) invoke(ctx context.Context, method string, req, out interface{}, opts []grpc.CallOption) error {
+	return c.cc.Invoke(ctx, method, req, out, append([]grpc.CallOption{CallOption()}, opts...)...)
+}
+
+// EventStream is the plugin side of the event stream
+type EventStream struct {
+	grpc.ClientStream
+}
+
+// Send sends an event to the core
+func (s *EventStream) Send(ev *types.PluginEvent) error {
+	return s.ClientStream.SendMsg(ev)
+}
+
+// Recv waits for the next event from the core
+func (s *EventStream) Recv() (*types.CoreEvent, error) {
+	ev := new(types.CoreEvent)
+	if err := s.ClientStream.RecvMsg(ev); err != nil {
+		return nil, err
+	}
+	return ev, nil
+}
@@ -0,0 +1,49 @@
+// Package rpc carries the milpa.v1.PluginService contract over gRPC
+//
+// Messages are the pkg/types structs, encoded as JSON by a codec
+// registered under the "json" content-subtype (application/grpc+json).
+// proto/milpa/v1/plugin.proto describes the same contract; its field
+// names are the JSON names of the structs.
+package rpc
+
+import (
+	"encoding/json"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/encoding"
+)
+
+// CodecName is the content-subtype of the JSON codec
+const CodecName = "json"
+
+func init() {
+	encoding.RegisterCodec(Codec{})
+}
+
+// Codec encodes gRPC messages as JSON
+type Codec struct{}
+
+// Marshal encodes v as JSON
+func (Codec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+// Unmarshal decodes JSON data into v
+func (Codec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+// Name returns the content-subtype the codec is registered under
+func (Codec) Name() string {
+	return CodecName
+}
+
+// CallOption selects the JSON codec for a call
+func CallOption() grpc.CallOption {
+	return grpc.CallContentSubtype(CodecName)
+}
+
+// DialOption selects the JSON codec for every call on a connection
+func DialOption() grpc.DialOption {
+	return grpc.WithDefaultCallOptions(CallOption())
+}
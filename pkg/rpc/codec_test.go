@@ -0,0 +1,28 @@
+package rpc
+
+import (
+	"testing"
+
+	"google.golang.org/grpc/encoding"
+
+	"github.com/robrt95x/milpa-cloud/pkg/types"
+)
+
+func TestCodecRegistered(t *testing.T) {
+	codec := encoding.GetCodec(CodecName)
+	if codec == nil {
+		t.Fatal("Expected the json codec to be registered")
+	}
+
+	data, err := codec.Marshal(&types.HandshakeRequest{PluginId: "example", Metadata: map[string]string{"a": "b"}})
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	var got types.HandshakeRequest
+	if err := codec.Unmarshal(data, &got); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	if got.PluginId != "example" || got.Metadata["a"] != "b" {
+		t.Errorf("Unexpected round trip %+v", got)
+	}
+}
@@ -0,0 +1,193 @@
+package sdk
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/robrt95x/milpa-cloud/pkg/logger"
+	"github.com/robrt95x/milpa-cloud/pkg/types"
+)
+
+// logBatchSize is the maximum number of records sent per request
+const logBatchSize = 500
+
+// logForwarder buffers the plugin's log records until they are sent to
+// the core. While the core is unreachable records stay buffered; beyond
+// the buffer size the oldest ones are dropped.
+type logForwarder struct {
+	mu      sync.Mutex
+	buffer  []types.LogRecord
+	max     int
+	dropped int
+	// ready is signalled when a full batch is buffered
+	ready chan struct{}
+}
+
+func newLogForwarder(max int) *logForwarder {
+	return &logForwarder{
+		max:   max,
+		ready: make(chan struct{}, 1),
+	}
+}
+
+// Accept buffers a message written to the plugin logger
+func (f *logForwarder) Accept(name, level, msg string, args ...interface{}) {
+	rec := types.LogRecord{
+		Time:    time.Now(),
+		Level:   level,
+		Logger:  name,
+		Message: msg,
+	}
+	if len(args) > 0 {
+		rec.Fields = make(map[string]string, len(args)/2)
+		for i := 0; i < len(args); i += 2 {
+			if i+1 == len(args) {
+				rec.Fields["EXTRA_VALUE_AT_END"] = fmt.Sprint(args[i])
+				break
+			}
+			rec.Fields[fmt.Sprint(args[i])] = fmt.Sprint(args[i+1])
+		}
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.buffer = append(f.buffer, rec)
+	f.trimLocked()
+	if len(f.buffer) >= logBatchSize {
+		select {
+		case f.ready <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// take removes up to n records from the buffer, oldest first
+func (f *logForwarder) take(n int) []types.LogRecord {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if n > len(f.buffer) {
+		n = len(f.buffer)
+	}
+	batch := make([]types.LogRecord, n)
+	copy(batch, f.buffer)
+	f.buffer = f.buffer[n:]
+	return batch
+}
+
+// requeue puts back a batch that could not be sent
+func (f *logForwarder) requeue(batch []types.LogRecord) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.buffer = append(batch, f.buffer...)
+	f.trimLocked()
+}
+
+// trimLocked drops the oldest records beyond max
+func (f *logForwarder) trimLocked() {
+	if over := len(f.buffer) - f.max; over > 0 {
+		f.buffer = f.buffer[over:]
+		f.dropped += over
+	}
+}
+
+// pending returns the number of buffered records
+func (f *logForwarder) pending() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return len(f.buffer)
+}
+
+// logLoop sends buffered records every LogFlushInterval, or as soon as a
+// full batch is buffered
+func (p *Plugin) logLoop() {
+	defer p.wg.Done()
+
+	ticker := time.NewTicker(p.config.LogFlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.ctx.Done():
+			return
+		case <-ticker.C:
+		case <-p.logs.ready:
+		}
+		p.flushLogs(p.ctx)
+	}
+}
+
+// flushLogs sends the buffered records until the buffer is empty or a
+// request fails
+func (p *Plugin) flushLogs(ctx context.Context) {
+	for p.logs.pending() > 0 {
+		sessionID, authToken := p.client.session()
+		if sessionID == "" {
+			return
+		}
+
+		batch := p.logs.take(logBatchSize)
+		reqCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		resp, err := p.client.SendLogs(reqCtx, &types.LogBatchRequest{
+			SessionId: sessionID,
+			AuthToken: authToken,
+			Records:   batch,
+		})
+		cancel()
+		if err == nil && !resp.Ok {
+			err = fmt.Errorf("rejected: %s", resp.Error)
+		}
+		if err != nil {
+			p.logs.requeue(batch)
+			if !p.logsFailing {
+				p.logsFailing = true
+				p.log.Warn("log forwarding failed, buffering", "error", err)
+			}
+			return
+		}
+		if p.logsFailing {
+			p.logsFailing = false
+			p.log.Info("log forwarding resumed")
+		}
+	}
+}
+
+// LogWriter returns a writer that logs each line written to it at info
+// level, e.g. to forward the standard library logger:
+//
+//	log.SetOutput(plugin.LogWriter())
+func (p *Plugin) LogWriter() io.Writer {
+	return &lineWriter{log: p.log}
+}
+
+// lineWriter logs every complete line written to it
+type lineWriter struct {
+	mu   sync.Mutex
+	log  logger.Logger
+	line []byte
+}
+
+func (w *lineWriter) Write(b []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.line = append(w.line, b...)
+	for {
+		i := bytes.IndexByte(w.line, '\n')
+		if i < 0 {
+			break
+		}
+		if msg := string(bytes.TrimSpace(w.line[:i])); msg != "" {
+			w.log.Info(msg)
+		}
+		w.line = w.line[i+1:]
+	}
+	return len(b), nil
+}
@@ -3,16 +3,24 @@ package sdk
 import (
 	"bytes"
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
-	"log"
+	mathrand "math/rand/v2"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
+	"github.com/robrt95x/milpa-cloud/pkg/logger"
+	"github.com/robrt95x/milpa-cloud/pkg/tracing"
 	"github.com/robrt95x/milpa-cloud/pkg/types"
 )
 
@@ -26,14 +34,62 @@ type PluginConfig struct {
 	Capabilities []string
 	Metadata    map[string]string
 	HeartbeatInterval time.Duration
+	// InstanceKey identifies this plugin host across reconnects so the core
+	// resumes the same instance. Defaults to the key in InstanceKeyFile,
+	// or the hostname when no file is set. The core only resumes by key an
+	// instance whose session is no longer live, so replicas sharing a host
+	// get separate instances
+	InstanceKey string
+	// InstanceKeyFile persists a generated instance key (created on first start)
+	InstanceKeyFile string
+	// ReconnectBackoff and MaxReconnectBackoff bound the jittered exponential
+	// backoff between re-handshakes after the core loses the session
+	ReconnectBackoff    time.Duration
+	MaxReconnectBackoff time.Duration
+	// HealthFunc returns the health report sent with each heartbeat
+	// Failing checks mark the instance degraded in the core
+	HealthFunc func() *types.HealthReport
+	// MetricsFunc returns custom metrics sent with each heartbeat; the core
+	// exposes them on /metrics as milpa_plugin_<name>
+	MetricsFunc func() []types.Metric
+	// HealthEndpoint is an HTTP or gRPC health endpoint served by the plugin
+	// that the core probes actively. Host defaults to the connection's address;
+	// another host must be in the core's probes.allowed_hosts
+	HealthEndpoint *types.HealthEndpoint
 	// EventHandler is called when the plugin receives an event from the core
 	EventHandler func(event *types.CoreEvent)
+	// OnStatusQuery answers the core's on-demand status queries; the reply
+	// also carries the HealthFunc report. Errors are returned to the caller.
+	OnStatusQuery func(ctx context.Context) (map[string]string, error)
+	// LogLevel is the initial level of the plugin logger (default "info");
+	// the core changes it at runtime with log_level events
+	LogLevel string
+	// LogFormat is the local output format of the plugin logger (text or json)
+	LogFormat string
+	// DisableLogForwarding keeps the plugin logger's records local instead
+	// of sending them to the core
+	DisableLogForwarding bool
+	// LogBufferSize is the number of records buffered while the core is
+	// unreachable (default 1000); the oldest are dropped beyond it
+	LogBufferSize int
+	// LogFlushInterval is how often buffered records are sent (default 1s)
+	LogFlushInterval time.Duration
+	// Tracer records the plugin's spans. Without one, trace context from
+	// the core is still propagated but no spans are recorded
+	Tracer *tracing.Tracer
 }
 
 // Plugin represents a Milpa Cloud plugin
 type Plugin struct {
-	config PluginConfig
-	client *PluginClient
+	config      PluginConfig
+	client      *PluginClient
+	instanceKey string
+	log         logger.Logger
+	logs        *logForwarder // nil when forwarding is disabled
+	// logsFailing is set while forwarding fails (log loop only)
+	logsFailing bool
+	// shutdownSeen is set once the core announced its shutdown
+	shutdownSeen atomic.Bool
 	wg     sync.WaitGroup
 	ctx    context.Context
 	cancel context.CancelFunc
@@ -44,6 +100,12 @@ func NewPlugin(cfg PluginConfig) *Plugin {
 	if cfg.HeartbeatInterval == 0 {
 		cfg.HeartbeatInterval = 10 * time.Second
 	}
+	if cfg.ReconnectBackoff == 0 {
+		cfg.ReconnectBackoff = time.Second
+	}
+	if cfg.MaxReconnectBackoff == 0 {
+		cfg.MaxReconnectBackoff = 30 * time.Second
+	}
 	if cfg.Capabilities == nil {
 		cfg.Capabilities = []string{}
 	}
@@ -51,13 +113,53 @@ func NewPlugin(cfg PluginConfig) *Plugin {
 		cfg.Metadata = map[string]string{}
 	}
 
+	if cfg.LogLevel == "" {
+		cfg.LogLevel = "info"
+	}
+	if cfg.LogBufferSize == 0 {
+		cfg.LogBufferSize = 1000
+	}
+	if cfg.LogFlushInterval == 0 {
+		cfg.LogFlushInterval = time.Second
+	}
+	if cfg.Tracer == nil {
+		cfg.Tracer = tracing.NewTracer(cfg.ID, nil)
+	}
+
 	ctx, cancel := context.WithCancel(context.Background())
 
-	return &Plugin{
+	p := &Plugin{
 		config: cfg,
 		ctx:    ctx,
 		cancel: cancel,
 	}
+
+	opts := logger.Options{Name: cfg.ID, Level: cfg.LogLevel, Format: cfg.LogFormat}
+	if !cfg.DisableLogForwarding {
+		p.logs = newLogForwarder(cfg.LogBufferSize)
+		opts.Sink = p.logs
+	}
+	log, err := logger.NewWithOptions(opts)
+	if err != nil {
+		// Unknown format: fall back to text
+		opts.Format = logger.FormatText
+		log, _ = logger.NewWithOptions(opts)
+	}
+	p.log = log
+	return p
+}
+
+// Logger returns the plugin logger, whose level follows the core's
+// log_level events
+func (p *Plugin) Logger() logger.Logger {
+	return p.log
+}
+
+// Tracer returns the plugin tracer
+// Use it with tracing.ContextWithTraceParent(ctx, event.TraceParent) to
+// continue the core's trace while handling an event.
+func (p *Plugin) Tracer() *tracing.Tracer {
+	return p.config.Tracer
 }
 
 // Start connects to the core and performs handshake
@@ -67,14 +169,54 @@ func (p *Plugin) Start(ctx context.Context) error {
 		CoreAddr: p.config.CoreAddr,
 	}
 
+	instanceKey, err := resolveInstanceKey(p.config)
+	if err != nil {
+		return fmt.Errorf("instance key: %w", err)
+	}
+	p.instanceKey = instanceKey
+
+	if err := p.handshake(ctx); err != nil {
+		return err
+	}
+
+	// Start heartbeat loop
+	p.wg.Add(1)
+	go p.heartbeatLoop()
+
+	// Start event listener loop
+	p.wg.Add(1)
+	go p.eventLoop()
+
+	if p.logs != nil {
+		p.wg.Add(1)
+		go p.logLoop()
+	}
+
+	return nil
+}
+
+// handshake registers with the core, resuming the current session if any,
+// and stores the returned session
+func (p *Plugin) handshake(ctx context.Context) (err error) {
+	ctx, span := p.config.Tracer.Start(ctx, "handshake", tracing.SpanKindClient)
+	defer func() {
+		span.SetError(err)
+		span.End()
+	}()
+
 	// Perform handshake via HTTP
+	sessionID, authToken := p.client.session()
 	resp, err := p.client.Handshake(ctx, &types.HandshakeRequest{
-		PluginId:     p.config.ID,
-		Version:      p.config.Version,
-		ApiVersion:   p.config.APIVersion,
-		Capabilities: p.config.Capabilities,
-		Metadata:     p.config.Metadata,
-		Token:        p.config.Token,
+		PluginId:        p.config.ID,
+		Version:         p.config.Version,
+		ApiVersion:      p.config.APIVersion,
+		Capabilities:    p.config.Capabilities,
+		Metadata:        p.config.Metadata,
+		Token:           p.config.Token,
+		InstanceKey:     p.instanceKey,
+		ResumeSessionId: sessionID,
+		ResumeAuthToken: authToken,
+		HealthEndpoint:  p.config.HealthEndpoint,
 	})
 	if err != nil {
 		return err
@@ -84,31 +226,125 @@ func (p *Plugin) Start(ctx context.Context) error {
 		return fmt.Errorf("handshake rejected: %s", resp.Error)
 	}
 
-	log.Printf("Milpa SDK: Handshake accepted, session_id=%s", resp.SessionId)
+	p.log.Info("handshake accepted", "session_id", resp.SessionId, "resumed", resp.Resumed)
 
-	// Store session info
-	p.client.SessionID = resp.SessionId
-	p.client.AuthToken = resp.AuthToken
+	p.client.setSession(resp.SessionId, resp.AuthToken)
+	return nil
+}
 
-	// Start heartbeat loop
-	p.wg.Add(1)
-	go p.heartbeatLoop()
+// rehandshake retries the handshake with jittered exponential backoff until
+// it succeeds or the plugin stops
+func (p *Plugin) rehandshake() {
+	for attempt := 0; ; attempt++ {
+		ctx, cancel := context.WithTimeout(p.ctx, 5*time.Second)
+		err := p.handshake(ctx)
+		cancel()
+		if err == nil {
+			return
+		}
 
-	// Start event listener loop if handler is provided
-	if p.config.EventHandler != nil {
-		p.wg.Add(1)
-		go p.eventLoop()
+		delay := backoff(attempt, p.config.ReconnectBackoff, p.config.MaxReconnectBackoff)
+		p.log.Warn("re-handshake failed", "error", err, "retry_in", delay)
+		select {
+		case <-p.ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+	}
+}
+
+// backoff returns a delay between half and all of base*2^attempt, capped at max
+func backoff(attempt int, base, max time.Duration) time.Duration {
+	d := base
+	for i := 0; i < attempt && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
 	}
+	half := d / 2
+	return half + mathrand.N(d-half+1)
+}
 
-	return nil
+// isSessionLost reports whether a heartbeat rejection requires a new handshake
+func isSessionLost(resp *types.HeartbeatResponse) bool {
+	return !resp.Ok && (resp.Message == "session not found" || resp.Message == "invalid auth token")
+}
+
+// resolveInstanceKey returns the configured instance key, the one persisted
+// in InstanceKeyFile (generating it on first use), or the hostname
+func resolveInstanceKey(cfg PluginConfig) (string, error) {
+	if cfg.InstanceKey != "" {
+		return cfg.InstanceKey, nil
+	}
+	if cfg.InstanceKeyFile == "" {
+		return os.Hostname()
+	}
+
+	data, err := os.ReadFile(cfg.InstanceKeyFile)
+	if err == nil {
+		if key := strings.TrimSpace(string(data)); key != "" {
+			return key, nil
+		}
+	} else if !os.IsNotExist(err) {
+		return "", err
+	}
+
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	key := hex.EncodeToString(b)
+
+	if err := os.MkdirAll(filepath.Dir(cfg.InstanceKeyFile), 0o755); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(cfg.InstanceKeyFile, []byte(key+"\n"), 0o600); err != nil {
+		return "", err
+	}
+	return key, nil
 }
 
-// Stop gracefully shuts down the plugin
+// Stop gracefully shuts down the plugin and deregisters it from the core
 func (p *Plugin) Stop() {
-	log.Println("Milpa SDK: Stopping plugin...")
+	p.log.Info("stopping plugin")
 	p.cancel()
 	p.wg.Wait()
-	log.Println("Milpa SDK: Plugin stopped")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	// Send the remaining logs while the session is still valid
+	if p.logs != nil && p.client != nil {
+		p.flushLogs(ctx)
+	}
+	if err := p.Deregister(ctx, "plugin stopped"); err != nil {
+		p.log.Warn("deregister failed", "error", err)
+	}
+	p.log.Info("plugin stopped")
+}
+
+// Deregister tells the core this instance is going away, so it is marked
+// stopped immediately instead of after a heartbeat timeout
+func (p *Plugin) Deregister(ctx context.Context, reason string) error {
+	if p.client == nil {
+		return nil
+	}
+	sessionID, authToken := p.client.session()
+	if sessionID == "" {
+		return nil
+	}
+	resp, err := p.client.Deregister(ctx, &types.DeregisterRequest{
+		SessionId: sessionID,
+		AuthToken: authToken,
+		Reason:    reason,
+	})
+	if err != nil {
+		return err
+	}
+	if !resp.Ok {
+		return fmt.Errorf("deregister rejected: %s", resp.Error)
+	}
+	return nil
 }
 
 // Wait blocks until the plugin receives a shutdown signal
@@ -130,53 +366,251 @@ func (p *Plugin) heartbeatLoop() {
 		case <-p.ctx.Done():
 			return
 		case <-ticker.C:
-			ctx, cancel := context.WithTimeout(p.ctx, 5*time.Second)
-			resp, err := p.client.Heartbeat(ctx, &types.HeartbeatRequest{
-				SessionId: p.client.SessionID,
-				AuthToken: p.client.AuthToken,
-				Status:    map[string]string{"status": "healthy"},
-			})
-			cancel()
-
+			resp, err := p.heartbeat(false)
 			if err != nil {
-				log.Printf("Milpa SDK: Heartbeat error: %v", err)
+				p.log.Warn("heartbeat failed", "error", err)
 				continue
 			}
-			if !resp.Ok {
-				log.Printf("Milpa SDK: Heartbeat rejected: %s", resp.Message)
+			if resp.Shutdown {
+				p.onCoreShutdown()
+			} else {
+				p.shutdownSeen.Store(false)
+			}
+			if isSessionLost(resp) {
+				// The core no longer knows this session (e.g. it lost its state)
+				p.log.Warn("heartbeat rejected, re-handshaking", "message", resp.Message)
+				p.rehandshake()
+			} else if !resp.Ok {
+				p.log.Warn("heartbeat rejected", "message", resp.Message)
 			}
 		}
 	}
 }
 
-// eventLoop listens for events from the core
+// heartbeat sends one heartbeat, optionally acknowledging a core shutdown
+func (p *Plugin) heartbeat(shutdownAck bool) (resp *types.HeartbeatResponse, err error) {
+	ctx, cancel := context.WithTimeout(p.ctx, 5*time.Second)
+	defer cancel()
+	ctx, span := p.config.Tracer.Start(ctx, "heartbeat", tracing.SpanKindClient)
+	defer func() {
+		span.SetError(err)
+		span.End()
+	}()
+
+	sessionID, authToken := p.client.session()
+	return p.client.Heartbeat(ctx, &types.HeartbeatRequest{
+		SessionId:   sessionID,
+		AuthToken:   authToken,
+		Status:      map[string]string{"status": "healthy"},
+		Health:      p.health(),
+		ShutdownAck: shutdownAck,
+		Metrics:     p.metrics(),
+	})
+}
+
+// onCoreShutdown delivers the core's shutdown to EventHandler and
+// acknowledges it, once per shutdown whether it arrived as an event or on
+// a heartbeat. The plugin keeps running and heartbeats resume the session
+// once the core is back.
+func (p *Plugin) onCoreShutdown() {
+	if !p.shutdownSeen.CompareAndSwap(false, true) {
+		return
+	}
+	p.log.Info("core is shutting down")
+	if p.config.EventHandler != nil {
+		p.config.EventHandler(&types.CoreEvent{Type: "shutdown", Data: "system shutting down"})
+	}
+	if _, err := p.heartbeat(true); err != nil {
+		p.log.Warn("shutdown acknowledgement failed", "error", err)
+	}
+}
+
+// health returns the report from HealthFunc, if any
+func (p *Plugin) health() *types.HealthReport {
+	if p.config.HealthFunc == nil {
+		return nil
+	}
+	return p.config.HealthFunc()
+}
+
+// metrics returns the custom metrics to send, if any
+func (p *Plugin) metrics() []types.Metric {
+	if p.config.MetricsFunc == nil {
+		return nil
+	}
+	return p.config.MetricsFunc()
+}
+
+// eventLoop long-polls the core for events and dispatches them
 func (p *Plugin) eventLoop() {
 	defer p.wg.Done()
 
-	log.Println("Milpa SDK: Event listener started")
+	p.log.Debug("event listener started")
+	defer p.log.Debug("event listener stopped")
+
+	for attempt := 0; p.ctx.Err() == nil; {
+		sessionID, authToken := p.client.session()
+		resp, err := p.client.PollEvents(p.ctx, &types.EventPollRequest{
+			SessionId: sessionID,
+			AuthToken: authToken,
+		})
+		if err == nil && !resp.Ok {
+			// Session lost; the heartbeat loop re-handshakes
+			err = fmt.Errorf("poll rejected: %s", resp.Error)
+		}
+		if err != nil {
+			if p.ctx.Err() != nil {
+				return
+			}
+			delay := backoff(attempt, p.config.ReconnectBackoff, p.config.MaxReconnectBackoff)
+			attempt++
+			p.log.Warn("event poll failed", "error", err, "retry_in", delay)
+			select {
+			case <-p.ctx.Done():
+			case <-time.After(delay):
+			}
+			continue
+		}
+
+		attempt = 0
+		for i := range resp.Events {
+			p.dispatch(&resp.Events[i])
+		}
+	}
+}
 
-	ticker := time.NewTicker(5 * time.Second)
-	defer ticker.Stop()
+// dispatch handles one event from the core, continuing the trace of the
+// operation that sent it
+func (p *Plugin) dispatch(event *types.CoreEvent) {
+	ctx := tracing.ContextWithTraceParent(p.ctx, event.TraceParent)
+	ctx, span := p.config.Tracer.Start(ctx, "event "+event.Type, tracing.SpanKindConsumer, "milpa.event.type", event.Type)
+	defer span.End()
+
+	switch event.Type {
+	case "shutdown":
+		p.onCoreShutdown()
+	case "status_query":
+		p.replyStatus(ctx, event.Data)
+	case "log_level":
+		p.applyLogLevel(event.Data)
+		if p.config.EventHandler != nil {
+			p.config.EventHandler(event)
+		}
+	default:
+		if p.config.EventHandler != nil {
+			p.config.EventHandler(event)
+		}
+	}
+}
 
-	for {
-		select {
-		case <-p.ctx.Done():
-			log.Println("Milpa SDK: Event listener stopped")
-			return
-		case <-ticker.C:
-			// TODO: Poll for events
+// applyLogLevel changes the plugin logger's level from a log_level event
+func (p *Plugin) applyLogLevel(data string) {
+	var ev types.LogLevelEvent
+	if err := json.Unmarshal([]byte(data), &ev); err != nil {
+		p.log.Warn("invalid log level event", "error", err)
+		return
+	}
+	if err := p.log.Levels().SetLevel(ev.Level); err != nil {
+		p.log.Warn("invalid log level event", "error", err)
+		return
+	}
+	p.log.Info("log level changed", "level", ev.Level)
+}
+
+// replyStatus answers a status_query event with OnStatusQuery and HealthFunc
+func (p *Plugin) replyStatus(ctx context.Context, data string) {
+	var query types.StatusQuery
+	if err := json.Unmarshal([]byte(data), &query); err != nil {
+		p.log.Warn("invalid status query", "error", err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	reply := types.StatusReply{QueryId: query.QueryId, Health: p.health()}
+	if p.config.OnStatusQuery != nil {
+		status, err := p.config.OnStatusQuery(ctx)
+		reply.Status = status
+		if err != nil {
+			reply.Error = err.Error()
 		}
 	}
+	body, _ := json.Marshal(&reply)
+
+	sessionID, authToken := p.client.session()
+	resp, err := p.client.SendEvent(ctx, &types.PluginEventRequest{
+		SessionId: sessionID,
+		AuthToken: authToken,
+		Type:      "status_reply",
+		Data:      string(body),
+	})
+	if err != nil {
+		p.log.Warn("status reply failed", "error", err)
+	} else if !resp.Ok {
+		p.log.Warn("status reply rejected", "error", resp.Error)
+	}
 }
 
 // Client wraps the HTTP connection to the core
 type PluginClient struct {
+	// CoreAddr is the host:port of the core's HTTP API, or unix:PATH for
+	// its unix socket
 	CoreAddr string
 
+	clientOnce sync.Once
+	httpClient *http.Client
+
+	// SessionID and AuthToken identify the current session; use session()
+	// to read them while the plugin is running
+	mu        sync.RWMutex
 	SessionID string
 	AuthToken string
 }
 
+// url returns the URL of an API path on the core
+func (c *PluginClient) url(path string) string {
+	if strings.HasPrefix(c.CoreAddr, "unix:") {
+		// The host is ignored by the unix socket dialer
+		return "http://unix" + path
+	}
+	return "http://" + c.CoreAddr + path
+}
+
+// client returns the HTTP client for CoreAddr
+func (c *PluginClient) client() *http.Client {
+	c.clientOnce.Do(func() {
+		path, ok := strings.CutPrefix(c.CoreAddr, "unix:")
+		if !ok {
+			c.httpClient = http.DefaultClient
+			return
+		}
+		var dialer net.Dialer
+		c.httpClient = &http.Client{Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return dialer.DialContext(ctx, "unix", path)
+			},
+		}}
+	})
+	return c.httpClient
+}
+
+// session returns the current session ID and auth token
+func (c *PluginClient) session() (string, string) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.SessionID, c.AuthToken
+}
+
+// setSession stores the session returned by a handshake
+func (c *PluginClient) setSession(sessionID, authToken string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.SessionID, c.AuthToken = sessionID, authToken
+}
+
 // Handshake performs a handshake with the core
 func (c *PluginClient) Handshake(ctx context.Context, req *types.HandshakeRequest) (*types.HandshakeResponse, error) {
 	body, err := json.Marshal(req)
@@ -184,13 +618,14 @@ func (c *PluginClient) Handshake(ctx context.Context, req *types.HandshakeReques
 		return nil, err
 	}
 
-	httpReq, err := http.NewRequestWithContext(ctx, "POST", "http://"+c.CoreAddr+"/api/v1/handshake", bytes.NewReader(body))
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", c.url("/api/v1/handshake"), bytes.NewReader(body))
 	if err != nil {
 		return nil, err
 	}
 	httpReq.Header.Set("Content-Type", "application/json")
+	tracing.Inject(ctx, httpReq.Header)
 
-	resp, err := http.DefaultClient.Do(httpReq)
+	resp, err := c.client().Do(httpReq)
 	if err != nil {
 		return nil, err
 	}
@@ -211,13 +646,14 @@ func (c *PluginClient) Heartbeat(ctx context.Context, req *types.HeartbeatReques
 		return nil, err
 	}
 
-	httpReq, err := http.NewRequestWithContext(ctx, "POST", "http://"+c.CoreAddr+"/api/v1/heartbeat", bytes.NewReader(body))
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", c.url("/api/v1/heartbeat"), bytes.NewReader(body))
 	if err != nil {
 		return nil, err
 	}
 	httpReq.Header.Set("Content-Type", "application/json")
+	tracing.Inject(ctx, httpReq.Header)
 
-	resp, err := http.DefaultClient.Do(httpReq)
+	resp, err := c.client().Do(httpReq)
 	if err != nil {
 		return nil, err
 	}
@@ -238,13 +674,14 @@ func (c *PluginClient) Configure(ctx context.Context, req *types.ConfigureReques
 		return nil, err
 	}
 
-	httpReq, err := http.NewRequestWithContext(ctx, "POST", "http://"+c.CoreAddr+"/api/v1/configure", bytes.NewReader(body))
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", c.url("/api/v1/configure"), bytes.NewReader(body))
 	if err != nil {
 		return nil, err
 	}
 	httpReq.Header.Set("Content-Type", "application/json")
+	tracing.Inject(ctx, httpReq.Header)
 
-	resp, err := http.DefaultClient.Do(httpReq)
+	resp, err := c.client().Do(httpReq)
 	if err != nil {
 		return nil, err
 	}
@@ -257,3 +694,83 @@ func (c *PluginClient) Configure(ctx context.Context, req *types.ConfigureReques
 
 	return &result, nil
 }
+
+// Deregister ends the session with the core
+func (c *PluginClient) Deregister(ctx context.Context, req *types.DeregisterRequest) (*types.DeregisterResponse, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", c.url("/api/v1/deregister"), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	tracing.Inject(ctx, httpReq.Header)
+
+	resp, err := c.client().Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var result types.DeregisterResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// PollEvents waits for events addressed to this session
+// The request is bound to ctx only, since the core holds it open for up to
+// WaitMs
+func (c *PluginClient) PollEvents(ctx context.Context, req *types.EventPollRequest) (*types.EventPollResponse, error) {
+	var result types.EventPollResponse
+	if err := c.post(ctx, "/api/v1/events/poll", req, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// SendEvent sends an event (e.g. a status reply) to the core
+func (c *PluginClient) SendEvent(ctx context.Context, req *types.PluginEventRequest) (*types.PluginEventResponse, error) {
+	var result types.PluginEventResponse
+	if err := c.post(ctx, "/api/v1/events", req, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// SendLogs forwards a batch of log records to the core
+func (c *PluginClient) SendLogs(ctx context.Context, req *types.LogBatchRequest) (*types.LogBatchResponse, error) {
+	var result types.LogBatchResponse
+	if err := c.post(ctx, "/api/v1/logs", req, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// post sends req as JSON to the core and decodes the response into result
+func (c *PluginClient) post(ctx context.Context, path string, req, result interface{}) error {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", c.url(path), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	tracing.Inject(ctx, httpReq.Header)
+
+	resp, err := c.client().Do(httpReq)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	return json.NewDecoder(resp.Body).Decode(result)
+}
@@ -1,8 +1,21 @@
 package sdk
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
 	"time"
+
+	"github.com/robrt95x/milpa-cloud/pkg/tracing"
+	"github.com/robrt95x/milpa-cloud/pkg/types"
 )
 
 func TestNewPluginDefaultValues(t *testing.T) {
@@ -105,3 +118,445 @@ func TestPluginConfigValidation(t *testing.T) {
 		})
 	}
 }
+
+func TestResolveInstanceKey(t *testing.T) {
+	key, err := resolveInstanceKey(PluginConfig{InstanceKey: "explicit"})
+	if err != nil || key != "explicit" {
+		t.Errorf("Expected explicit key, got %q %v", key, err)
+	}
+
+	hostname, _ := os.Hostname()
+	key, err = resolveInstanceKey(PluginConfig{})
+	if err != nil || key != hostname {
+		t.Errorf("Expected hostname %q, got %q %v", hostname, key, err)
+	}
+
+	// A generated key is persisted and reused
+	path := filepath.Join(t.TempDir(), "state", "instance-key")
+	first, err := resolveInstanceKey(PluginConfig{InstanceKeyFile: path})
+	if err != nil || first == "" {
+		t.Fatalf("Expected generated key, got %q %v", first, err)
+	}
+	second, err := resolveInstanceKey(PluginConfig{InstanceKeyFile: path})
+	if err != nil || second != first {
+		t.Errorf("Expected persisted key %q, got %q %v", first, second, err)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	for attempt := 0; attempt < 10; attempt++ {
+		d := backoff(attempt, time.Second, 8*time.Second)
+		want := time.Second << attempt
+		if want > 8*time.Second {
+			want = 8 * time.Second
+		}
+		if d < want/2 || d > want {
+			t.Errorf("attempt %d: expected delay in [%v, %v], got %v", attempt, want/2, want, d)
+		}
+	}
+}
+
+// fakeCore forgets the first session after one heartbeat, like a core
+// restarted without its database
+type fakeCore struct {
+	mu          sync.Mutex
+	handshakes  []types.HandshakeRequest
+	heartbeats  int
+	deregisters []types.DeregisterRequest
+}
+
+func (c *fakeCore) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	switch r.URL.Path {
+	case "/api/v1/handshake":
+		var req types.HandshakeRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		c.handshakes = append(c.handshakes, req)
+		json.NewEncoder(w).Encode(types.HandshakeResponse{
+			Accepted:  true,
+			SessionId: "session-2",
+			AuthToken: "token-2",
+		})
+	case "/api/v1/heartbeat":
+		var req types.HeartbeatRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		c.heartbeats++
+		if len(c.handshakes) == 1 && c.heartbeats > 1 {
+			json.NewEncoder(w).Encode(types.HeartbeatResponse{Ok: false, Message: "session not found"})
+			return
+		}
+		json.NewEncoder(w).Encode(types.HeartbeatResponse{Ok: true, Message: "ok"})
+	case "/api/v1/deregister":
+		var req types.DeregisterRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		c.deregisters = append(c.deregisters, req)
+		json.NewEncoder(w).Encode(types.DeregisterResponse{Ok: true})
+	}
+}
+
+func TestPluginRehandshakesWhenSessionLost(t *testing.T) {
+	core := &fakeCore{}
+	srv := httptest.NewServer(core)
+	defer srv.Close()
+
+	plugin := NewPlugin(PluginConfig{
+		ID:                "test",
+		Version:           "1.0.0",
+		APIVersion:        "1.0",
+		CoreAddr:          strings.TrimPrefix(srv.URL, "http://"),
+		InstanceKey:       "host-a",
+		HeartbeatInterval: 5 * time.Millisecond,
+		ReconnectBackoff:  time.Millisecond,
+	})
+	if err := plugin.Start(context.Background()); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		core.mu.Lock()
+		n := len(core.handshakes)
+		core.mu.Unlock()
+		if n >= 2 || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	plugin.Stop()
+
+	core.mu.Lock()
+	defer core.mu.Unlock()
+	if len(core.handshakes) != 2 {
+		t.Fatalf("Expected 2 handshakes, got %d", len(core.handshakes))
+	}
+	resume := core.handshakes[1]
+	if resume.ResumeSessionId != "session-2" || resume.ResumeAuthToken != "token-2" || resume.InstanceKey != "host-a" {
+		t.Errorf("Expected re-handshake to resume the previous session, got %+v", resume)
+	}
+	if len(core.deregisters) != 1 || core.deregisters[0].SessionId != "session-2" {
+		t.Errorf("Expected Stop to deregister the session, got %+v", core.deregisters)
+	}
+}
+
+func TestPluginAcknowledgesCoreShutdown(t *testing.T) {
+	var mu sync.Mutex
+	var acks int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/handshake":
+			json.NewEncoder(w).Encode(types.HandshakeResponse{Accepted: true, SessionId: "s", AuthToken: "t"})
+		case "/api/v1/heartbeat":
+			var req types.HeartbeatRequest
+			json.NewDecoder(r.Body).Decode(&req)
+			mu.Lock()
+			if req.ShutdownAck {
+				acks++
+			}
+			mu.Unlock()
+			json.NewEncoder(w).Encode(types.HeartbeatResponse{Ok: true, Shutdown: true})
+		default:
+			json.NewEncoder(w).Encode(types.DeregisterResponse{Ok: true})
+		}
+	}))
+	defer srv.Close()
+
+	events := make(chan string, 10)
+	plugin := NewPlugin(PluginConfig{
+		ID:                "test",
+		Version:           "1.0.0",
+		APIVersion:        "1.0",
+		CoreAddr:          strings.TrimPrefix(srv.URL, "http://"),
+		InstanceKey:       "host-a",
+		HeartbeatInterval: 5 * time.Millisecond,
+		EventHandler:      func(ev *types.CoreEvent) { events <- ev.Type },
+	})
+	if err := plugin.Start(context.Background()); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+
+	select {
+	case ev := <-events:
+		if ev != "shutdown" {
+			t.Errorf("Expected shutdown event, got %s", ev)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected EventHandler to receive the core shutdown")
+	}
+	time.Sleep(30 * time.Millisecond)
+	plugin.Stop()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if acks != 1 {
+		t.Errorf("Expected the shutdown to be acknowledged once, got %d", acks)
+	}
+}
+
+func TestPluginAnswersStatusQuery(t *testing.T) {
+	var once sync.Once
+	replies := make(chan types.PluginEventRequest, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/handshake":
+			json.NewEncoder(w).Encode(types.HandshakeResponse{Accepted: true, SessionId: "s", AuthToken: "t"})
+		case "/api/v1/events/poll":
+			resp := types.EventPollResponse{Ok: true}
+			once.Do(func() {
+				resp.Events = []types.CoreEvent{{Type: "status_query", Data: `{"query_id":"q1"}`}}
+			})
+			if resp.Events == nil {
+				time.Sleep(10 * time.Millisecond)
+			}
+			json.NewEncoder(w).Encode(resp)
+		case "/api/v1/events":
+			var req types.PluginEventRequest
+			json.NewDecoder(r.Body).Decode(&req)
+			replies <- req
+			json.NewEncoder(w).Encode(types.PluginEventResponse{Ok: true})
+		case "/api/v1/heartbeat":
+			json.NewEncoder(w).Encode(types.HeartbeatResponse{Ok: true})
+		default:
+			json.NewEncoder(w).Encode(types.DeregisterResponse{Ok: true})
+		}
+	}))
+	defer srv.Close()
+
+	plugin := NewPlugin(PluginConfig{
+		ID:          "test",
+		Version:     "1.0.0",
+		APIVersion:  "1.0",
+		CoreAddr:    strings.TrimPrefix(srv.URL, "http://"),
+		InstanceKey: "host-a",
+		OnStatusQuery: func(ctx context.Context) (map[string]string, error) {
+			return map[string]string{"jobs": "2"}, nil
+		},
+	})
+	if err := plugin.Start(context.Background()); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	defer plugin.Stop()
+
+	select {
+	case req := <-replies:
+		var reply types.StatusReply
+		json.Unmarshal([]byte(req.Data), &reply)
+		if req.Type != "status_reply" || req.SessionId != "s" || reply.QueryId != "q1" || reply.Status["jobs"] != "2" {
+			t.Errorf("Unexpected status reply %+v %+v", req, reply)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected the plugin to answer the status query")
+	}
+}
+
+func TestPluginContinuesTrace(t *testing.T) {
+	const traceParent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	var once sync.Once
+	replies := make(chan string, 1)
+	handshakes := make(chan string, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/handshake":
+			handshakes <- r.Header.Get(tracing.TraceParentHeader)
+			json.NewEncoder(w).Encode(types.HandshakeResponse{Accepted: true, SessionId: "s", AuthToken: "t"})
+		case "/api/v1/events/poll":
+			resp := types.EventPollResponse{Ok: true}
+			once.Do(func() {
+				resp.Events = []types.CoreEvent{{Type: "status_query", Data: `{"query_id":"q1"}`, TraceParent: traceParent}}
+			})
+			if resp.Events == nil {
+				time.Sleep(10 * time.Millisecond)
+			}
+			json.NewEncoder(w).Encode(resp)
+		case "/api/v1/events":
+			replies <- r.Header.Get(tracing.TraceParentHeader)
+			json.NewEncoder(w).Encode(types.PluginEventResponse{Ok: true})
+		default:
+			json.NewEncoder(w).Encode(types.HeartbeatResponse{Ok: true})
+		}
+	}))
+	defer srv.Close()
+
+	var spans bytes.Buffer
+	tracer := tracing.NewTracer("test", tracing.NewWriterExporter(&spans))
+	plugin := NewPlugin(PluginConfig{
+		ID:          "test",
+		Version:     "1.0.0",
+		APIVersion:  "1.0",
+		CoreAddr:    strings.TrimPrefix(srv.URL, "http://"),
+		InstanceKey: "host-a",
+		Tracer:      tracer,
+	})
+	if err := plugin.Start(context.Background()); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	defer plugin.Stop()
+
+	// The handshake starts a trace of its own
+	if sc, err := tracing.ParseTraceParent(<-handshakes); err != nil || !sc.Sampled {
+		t.Errorf("Expected a sampled traceparent on the handshake, got %+v %v", sc, err)
+	}
+
+	select {
+	case header := <-replies:
+		sc, err := tracing.ParseTraceParent(header)
+		if err != nil || sc.TraceID.String() != "4bf92f3577b34da6a3ce929d0e0e4736" {
+			t.Fatalf("Expected the reply to continue the event's trace, got %q", header)
+		}
+		if sc.SpanID.String() == "00f067aa0ba902b7" {
+			t.Error("Expected the reply to come from the plugin's own span")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected the plugin to answer the status query")
+	}
+
+	plugin.Stop()
+	tracer.Shutdown(context.Background())
+	if !strings.Contains(spans.String(), `"name":"event status_query"`) || !strings.Contains(spans.String(), `"parentSpanId":"00f067aa0ba902b7"`) {
+		t.Errorf("Expected an exported event span under the core's span, got %s", spans.String())
+	}
+}
+
+func TestPluginAppliesLogLevel(t *testing.T) {
+	var once sync.Once
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/handshake":
+			json.NewEncoder(w).Encode(types.HandshakeResponse{Accepted: true, SessionId: "s", AuthToken: "t"})
+		case "/api/v1/events/poll":
+			resp := types.EventPollResponse{Ok: true}
+			once.Do(func() {
+				resp.Events = []types.CoreEvent{{Type: "log_level", Data: `{"level":"debug"}`}}
+			})
+			if resp.Events == nil {
+				time.Sleep(10 * time.Millisecond)
+			}
+			json.NewEncoder(w).Encode(resp)
+		default:
+			json.NewEncoder(w).Encode(types.HeartbeatResponse{Ok: true})
+		}
+	}))
+	defer srv.Close()
+
+	events := make(chan *types.CoreEvent, 1)
+	plugin := NewPlugin(PluginConfig{
+		ID:           "test",
+		Version:      "1.0.0",
+		APIVersion:   "1.0",
+		CoreAddr:     strings.TrimPrefix(srv.URL, "http://"),
+		InstanceKey:  "host-a",
+		EventHandler: func(ev *types.CoreEvent) { events <- ev },
+	})
+	if plugin.Logger().IsDebug() {
+		t.Fatal("Expected the plugin logger to start at info")
+	}
+	if err := plugin.Start(context.Background()); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	defer plugin.Stop()
+
+	select {
+	case ev := <-events:
+		if ev.Type != "log_level" {
+			t.Errorf("Expected log_level event, got %s", ev.Type)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected the log_level event to reach EventHandler")
+	}
+	if !plugin.Logger().IsDebug() {
+		t.Error("Expected the plugin logger to switch to debug")
+	}
+}
+
+func TestPluginForwardsLogs(t *testing.T) {
+	var mu sync.Mutex
+	var records []types.LogRecord
+	failing := true
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/handshake":
+			json.NewEncoder(w).Encode(types.HandshakeResponse{Accepted: true, SessionId: "s", AuthToken: "t"})
+		case "/api/v1/logs":
+			var req types.LogBatchRequest
+			json.NewDecoder(r.Body).Decode(&req)
+			mu.Lock()
+			defer mu.Unlock()
+			if failing {
+				// The core is unavailable at first; records stay buffered
+				failing = false
+				http.Error(w, "unavailable", http.StatusServiceUnavailable)
+				return
+			}
+			records = append(records, req.Records...)
+			json.NewEncoder(w).Encode(types.LogBatchResponse{Ok: true, Accepted: len(req.Records)})
+		case "/api/v1/events/poll":
+			time.Sleep(10 * time.Millisecond)
+			json.NewEncoder(w).Encode(types.EventPollResponse{Ok: true})
+		default:
+			json.NewEncoder(w).Encode(types.HeartbeatResponse{Ok: true})
+		}
+	}))
+	defer srv.Close()
+
+	plugin := NewPlugin(PluginConfig{
+		ID:               "test",
+		Version:          "1.0.0",
+		APIVersion:       "1.0",
+		CoreAddr:         strings.TrimPrefix(srv.URL, "http://"),
+		InstanceKey:      "host-a",
+		LogFlushInterval: 5 * time.Millisecond,
+	})
+	if err := plugin.Start(context.Background()); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	plugin.Logger().With("job", "sync").Info("job finished", "count", 3)
+	plugin.Logger().Debug("not forwarded")
+	time.Sleep(50 * time.Millisecond)
+	plugin.Stop()
+
+	mu.Lock()
+	defer mu.Unlock()
+	var found *types.LogRecord
+	for i := range records {
+		if records[i].Level == "debug" {
+			t.Errorf("Expected records below the logger level to be skipped, got %+v", records[i])
+		}
+		if records[i].Message == "job finished" {
+			found = &records[i]
+		}
+	}
+	if found == nil {
+		t.Fatalf("Expected the record to be forwarded after the core recovered, got %+v", records)
+	}
+	if found.Level != "info" || found.Logger != "test" || found.Fields["job"] != "sync" || found.Fields["count"] != "3" {
+		t.Errorf("Unexpected record %+v", found)
+	}
+}
+
+func TestClientOverUnixSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "core.sock")
+	lis, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/handshake" {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(types.HandshakeResponse{Accepted: true, SessionId: "s", AuthToken: "t"})
+	}))
+	srv.Listener = lis
+	srv.Start()
+	defer srv.Close()
+
+	client := &PluginClient{CoreAddr: "unix:" + path}
+	resp, err := client.Handshake(context.Background(), &types.HandshakeRequest{PluginId: "test"})
+	if err != nil {
+		t.Fatalf("Handshake over the unix socket: %v", err)
+	}
+	if !resp.Accepted || resp.SessionId != "s" {
+		t.Errorf("Unexpected handshake response %+v", resp)
+	}
+}
@@ -0,0 +1,180 @@
+package tracing
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// collector is an OTLP/HTTP collector stand-in that keeps received spans
+type collector struct {
+	mu    sync.Mutex
+	spans []otlpSpan
+	names []string // service.name of each request
+}
+
+func (c *collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/v1/traces" || r.Header.Get("Content-Type") != "application/json" {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	var req otlpRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, rs := range req.ResourceSpans {
+		c.names = append(c.names, *rs.Resource.Attributes[0].Value.StringValue)
+		for _, ss := range rs.ScopeSpans {
+			c.spans = append(c.spans, ss.Spans...)
+		}
+	}
+	w.Write([]byte("{}"))
+}
+
+func (c *collector) received() []otlpSpan {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]otlpSpan(nil), c.spans...)
+}
+
+func TestOTLPExport(t *testing.T) {
+	c := &collector{}
+	srv := httptest.NewServer(c)
+	defer srv.Close()
+
+	exp, err := NewExporter("otlp", srv.URL)
+	if err != nil {
+		t.Fatalf("NewExporter: %v", err)
+	}
+	tracer := NewTracerWithOptions("milpa-core", exp, BatchOptions{Interval: time.Hour})
+
+	ctx, parent := tracer.Start(context.Background(), "GET /api", SpanKindServer, "http.route", "/api")
+	_, child := tracer.Start(ctx, "query", SpanKindInternal, "rows", 3, "cached", true, "ratio", 0.5)
+	child.SetError(errors.New("boom"))
+	child.End()
+	parent.End()
+	parent.End() // ignored
+
+	if err := tracer.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+	spans := c.received()
+	if len(spans) != 2 {
+		t.Fatalf("Expected 2 spans, got %d", len(spans))
+	}
+	q, p := spans[0], spans[1]
+	if p.Name != "GET /api" || p.Kind != SpanKindServer || p.ParentSpanID != "" {
+		t.Errorf("Unexpected parent span %+v", p)
+	}
+	if q.TraceID != p.TraceID || q.ParentSpanID != p.SpanID {
+		t.Errorf("Expected child of %s in trace %s, got %+v", p.SpanID, p.TraceID, q)
+	}
+	if q.Status.Code != StatusError || q.Status.Message != "boom" {
+		t.Errorf("Expected error status, got %+v", q.Status)
+	}
+	if len(q.Attributes) != 3 || *q.Attributes[0].Value.IntValue != "3" || !*q.Attributes[1].Value.BoolValue || *q.Attributes[2].Value.DoubleValue != 0.5 {
+		t.Errorf("Unexpected attributes %+v", q.Attributes)
+	}
+	if c.names[0] != "milpa-core" {
+		t.Errorf("Expected service.name milpa-core, got %v", c.names)
+	}
+
+	// Spans ended after shutdown are dropped
+	_, late := tracer.Start(context.Background(), "late", SpanKindInternal)
+	late.End()
+}
+
+func TestOTLPExportError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	exp, _ := NewOTLPExporter(srv.URL)
+	if err := exp.ExportSpans(context.Background(), "x", nil); err == nil {
+		t.Error("Expected an error for a failing collector")
+	}
+	if _, err := NewOTLPExporter("localhost:4318"); err == nil {
+		t.Error("Expected an endpoint without scheme to be rejected")
+	}
+	if _, err := NewExporter("zipkin", ""); err == nil {
+		t.Error("Expected an unknown exporter to be rejected")
+	}
+}
+
+func TestFileExport(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "spans.jsonl")
+	exp, err := NewExporter("file", path)
+	if err != nil {
+		t.Fatalf("NewExporter: %v", err)
+	}
+	tracer := NewTracerWithOptions("plugin", exp, BatchOptions{MaxBatch: 1, Interval: time.Hour})
+	for _, name := range []string{"a", "b"} {
+		_, span := tracer.Start(context.Background(), name, SpanKindInternal)
+		span.End()
+	}
+	if err := tracer.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer f.Close()
+	var names []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var req otlpRequest
+		if err := json.Unmarshal(scanner.Bytes(), &req); err != nil {
+			t.Fatalf("Invalid line %q: %v", scanner.Text(), err)
+		}
+		for _, s := range req.ResourceSpans[0].ScopeSpans[0].Spans {
+			names = append(names, s.Name)
+		}
+	}
+	if len(names) != 2 || names[0] != "a" || names[1] != "b" {
+		t.Errorf("Expected one line per batch with spans a and b, got %v", names)
+	}
+}
+
+func TestForceFlush(t *testing.T) {
+	r, w := io.Pipe()
+	defer r.Close()
+	tracer := NewTracerWithOptions("x", NewWriterExporter(w), BatchOptions{Interval: time.Hour})
+	defer tracer.Shutdown(context.Background())
+
+	_, span := tracer.Start(context.Background(), "flushed", SpanKindInternal)
+	span.End()
+
+	line := make(chan string, 1)
+	go func() {
+		s := bufio.NewScanner(r)
+		s.Scan()
+		line <- s.Text()
+	}()
+	if err := tracer.ForceFlush(context.Background()); err != nil {
+		t.Fatalf("ForceFlush: %v", err)
+	}
+	select {
+	case l := <-line:
+		var req otlpRequest
+		if err := json.Unmarshal([]byte(l), &req); err != nil || req.ResourceSpans[0].ScopeSpans[0].Spans[0].Name != "flushed" {
+			t.Errorf("Unexpected export %q: %v", l, err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("ForceFlush did not export")
+	}
+}
@@ -0,0 +1,95 @@
+package tracing
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// UnaryServerInterceptor traces unary calls, continuing the trace of the
+// caller's traceparent metadata
+func UnaryServerInterceptor(t *Tracer) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, span := t.Start(extractMetadata(ctx), info.FullMethod, SpanKindServer, "rpc.system", "grpc", "rpc.method", info.FullMethod)
+		defer span.End()
+
+		resp, err := handler(ctx, req)
+		endRPC(span, err)
+		return resp, err
+	}
+}
+
+// StreamServerInterceptor traces streams, continuing the trace of the
+// caller's traceparent metadata
+func StreamServerInterceptor(t *Tracer) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, span := t.Start(extractMetadata(ss.Context()), info.FullMethod, SpanKindServer, "rpc.system", "grpc", "rpc.method", info.FullMethod)
+		defer span.End()
+
+		err := handler(srv, &tracedServerStream{ServerStream: ss, ctx: ctx})
+		endRPC(span, err)
+		return err
+	}
+}
+
+// UnaryClientInterceptor traces outgoing unary calls and sends their
+// traceparent as metadata
+func UnaryClientInterceptor(t *Tracer) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		ctx, span := t.Start(ctx, method, SpanKindClient, "rpc.system", "grpc", "rpc.method", method)
+		defer span.End()
+
+		err := invoker(injectMetadata(ctx), method, req, reply, cc, opts...)
+		endRPC(span, err)
+		return err
+	}
+}
+
+// StreamClientInterceptor traces the setup of outgoing streams and sends
+// their traceparent as metadata
+func StreamClientInterceptor(t *Tracer) grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		ctx, span := t.Start(ctx, method, SpanKindClient, "rpc.system", "grpc", "rpc.method", method)
+		defer span.End()
+
+		cs, err := streamer(injectMetadata(ctx), desc, cc, method, opts...)
+		endRPC(span, err)
+		return cs, err
+	}
+}
+
+// tracedServerStream replaces the context of a server stream
+type tracedServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *tracedServerStream) Context() context.Context {
+	return s.ctx
+}
+
+// extractMetadata continues the trace in the incoming metadata, if any
+func extractMetadata(ctx context.Context) context.Context {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if values := md.Get(TraceParentHeader); len(values) > 0 {
+		return ContextWithTraceParent(ctx, values[0])
+	}
+	return ctx
+}
+
+// injectMetadata adds the traceparent of ctx to the outgoing metadata
+func injectMetadata(ctx context.Context) context.Context {
+	if tp := TraceParentFromContext(ctx); tp != "" {
+		return metadata.AppendToOutgoingContext(ctx, TraceParentHeader, tp)
+	}
+	return ctx
+}
+
+// endRPC records the gRPC status of a call
+func endRPC(span *Span, err error) {
+	st, _ := status.FromError(err)
+	span.SetAttributes("rpc.grpc.status_code", int(st.Code()))
+	span.SetError(err)
+}
@@ -0,0 +1,69 @@
+package tracing
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func TestGRPCPropagation(t *testing.T) {
+	exp := &recordingExporter{}
+	serverTracer := NewTracerWithOptions("server", exp, BatchOptions{Interval: time.Hour})
+	clientTracer := NewTracer("client", nil)
+
+	lis := bufconn.Listen(1 << 20)
+	srv := grpc.NewServer(grpc.UnaryInterceptor(UnaryServerInterceptor(serverTracer)))
+	healthpb.RegisterHealthServer(srv, health.NewServer())
+	go srv.Serve(lis)
+	defer srv.Stop()
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithUnaryInterceptor(UnaryClientInterceptor(clientTracer)))
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	defer conn.Close()
+
+	remote, _ := ParseTraceParent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	ctx := ContextWithRemote(context.Background(), remote)
+	if _, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{}); err != nil {
+		t.Fatalf("Check: %v", err)
+	}
+	serverTracer.Shutdown(context.Background())
+
+	spans := exp.spans
+	if len(spans) != 1 {
+		t.Fatalf("Expected one server span, got %d", len(spans))
+	}
+	s := spans[0]
+	if s.SpanContext.TraceID != remote.TraceID || s.Kind != SpanKindServer || s.Name != "/grpc.health.v1.Health/Check" {
+		t.Errorf("Expected server span in the caller's trace, got %+v", s)
+	}
+	// The parent is the client span, not the remote caller
+	if s.Parent == remote.SpanID || !s.Parent.IsValid() {
+		t.Errorf("Expected the client span as parent, got %s", s.Parent)
+	}
+}
+
+// recordingExporter keeps exported spans in memory
+type recordingExporter struct {
+	spans []SpanData
+}
+
+func (e *recordingExporter) ExportSpans(ctx context.Context, service string, spans []SpanData) error {
+	e.spans = append(e.spans, spans...)
+	return nil
+}
+
+func (e *recordingExporter) Shutdown(ctx context.Context) error {
+	return nil
+}
@@ -0,0 +1,347 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/robrt95x/milpa-cloud/pkg/logger"
+)
+
+// SpanKind describes the role of a span; values match OTLP
+type SpanKind int
+
+// Span kinds
+const (
+	SpanKindInternal SpanKind = 1
+	SpanKindServer   SpanKind = 2
+	SpanKindClient   SpanKind = 3
+	SpanKindProducer SpanKind = 4
+	SpanKindConsumer SpanKind = 5
+)
+
+// StatusCode is the outcome of a span; values match OTLP
+type StatusCode int
+
+// Status codes
+const (
+	StatusUnset StatusCode = 0
+	StatusOK    StatusCode = 1
+	StatusError StatusCode = 2
+)
+
+// Attribute is a key/value pair attached to a span
+type Attribute struct {
+	Key   string
+	Value interface{}
+}
+
+// SpanData is a finished span as handed to exporters
+type SpanData struct {
+	Name          string
+	Kind          SpanKind
+	SpanContext   SpanContext
+	Parent        SpanID // zero for root spans
+	Start         time.Time
+	End           time.Time
+	Attributes    []Attribute
+	StatusCode    StatusCode
+	StatusMessage string
+}
+
+// Span is an operation being traced
+// All methods are safe on a nil span.
+type Span struct {
+	tracer    *Tracer
+	sc        SpanContext
+	recording bool
+
+	mu    sync.Mutex
+	data  SpanData
+	ended bool
+}
+
+// SpanContext returns the propagated part of the span
+func (s *Span) SpanContext() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.sc
+}
+
+// IsRecording reports whether the span will be exported
+func (s *Span) IsRecording() bool {
+	return s != nil && s.recording
+}
+
+// SetName replaces the span name, e.g. once the route is known
+func (s *Span) SetName(name string) {
+	if !s.IsRecording() {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.data.Name = name
+}
+
+// SetAttributes adds key/value pairs; keys must be strings
+func (s *Span) SetAttributes(kv ...interface{}) {
+	if !s.IsRecording() {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := 0; i+1 < len(kv); i += 2 {
+		s.data.Attributes = append(s.data.Attributes, Attribute{Key: fmt.Sprint(kv[i]), Value: kv[i+1]})
+	}
+}
+
+// SetStatus sets the outcome of the span
+func (s *Span) SetStatus(code StatusCode, message string) {
+	if !s.IsRecording() {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.data.StatusCode = code
+	s.data.StatusMessage = message
+}
+
+// SetError marks the span failed with err; nil is ignored
+func (s *Span) SetError(err error) {
+	if err != nil {
+		s.SetStatus(StatusError, err.Error())
+	}
+}
+
+// End finishes the span and queues it for export
+// Calls after the first are ignored.
+func (s *Span) End() {
+	if !s.IsRecording() {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.End = time.Now()
+	data := s.data
+	s.mu.Unlock()
+
+	s.tracer.enqueue(data)
+}
+
+// Tracer starts spans and exports the finished ones in batches
+// A nil Tracer, or one without exporter, propagates trace context without
+// recording spans.
+type Tracer struct {
+	service  string
+	exporter Exporter
+	opts     BatchOptions
+
+	queue    chan SpanData
+	flush    chan chan struct{}
+	done     chan struct{} // closed by Shutdown
+	exited   chan struct{} // closed when the export loop returns
+	stopOnce sync.Once
+}
+
+// BatchOptions tune span export
+type BatchOptions struct {
+	// QueueSize is the number of finished spans buffered (default 2048);
+	// spans are dropped while it is full
+	QueueSize int
+	// MaxBatch is the number of spans per export (default 512)
+	MaxBatch int
+	// Interval is how often queued spans are exported (default 5s)
+	Interval time.Duration
+}
+
+// NewTracer creates a tracer for a service; exporter may be nil
+func NewTracer(service string, exporter Exporter) *Tracer {
+	return NewTracerWithOptions(service, exporter, BatchOptions{})
+}
+
+// NewTracerWithOptions creates a tracer with custom batching
+func NewTracerWithOptions(service string, exporter Exporter, opts BatchOptions) *Tracer {
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = 2048
+	}
+	if opts.MaxBatch <= 0 {
+		opts.MaxBatch = 512
+	}
+	if opts.Interval <= 0 {
+		opts.Interval = 5 * time.Second
+	}
+	t := &Tracer{
+		service:  service,
+		exporter: exporter,
+		opts:     opts,
+	}
+	if exporter != nil {
+		t.queue = make(chan SpanData, opts.QueueSize)
+		t.flush = make(chan chan struct{})
+		t.done = make(chan struct{})
+		t.exited = make(chan struct{})
+		go t.run()
+	}
+	return t
+}
+
+// Service returns the service name reported with the spans
+func (t *Tracer) Service() string {
+	if t == nil {
+		return ""
+	}
+	return t.service
+}
+
+// Start begins a span as a child of the span or remote parent in ctx,
+// or as the root of a new trace, and returns a context carrying it
+// A tracer with an exporter records every span, also under an unsampled
+// parent such as a plugin whose tracer has no exporter; one without an
+// exporter passes the parent's sampled flag on.
+func (t *Tracer) Start(ctx context.Context, name string, kind SpanKind, kv ...interface{}) (context.Context, *Span) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	parent := SpanContextFromContext(ctx)
+	exporting := t != nil && t.exporter != nil
+
+	sc := SpanContext{SpanID: newSpanID(), Sampled: exporting}
+	if parent.IsValid() {
+		sc.TraceID = parent.TraceID
+		sc.Sampled = sc.Sampled || parent.Sampled
+	} else {
+		sc.TraceID = newTraceID()
+	}
+
+	// Spans entering the process tag its log records with the trace ID
+	if SpanFromContext(ctx) == nil {
+		ctx = logger.ContextWith(ctx, logger.FieldTraceID, sc.TraceID.String())
+	}
+
+	span := &Span{tracer: t, sc: sc}
+	span.recording = exporting
+	if span.recording {
+		span.data = SpanData{
+			Name:        name,
+			Kind:        kind,
+			SpanContext: sc,
+			Parent:      parent.SpanID,
+			Start:       time.Now(),
+		}
+		span.SetAttributes(kv...)
+	}
+	return contextWithSpan(ctx, span), span
+}
+
+// enqueue hands a finished span to the export loop
+func (t *Tracer) enqueue(data SpanData) {
+	select {
+	case <-t.done:
+		return
+	default:
+	}
+	select {
+	case t.queue <- data:
+	default:
+		logger.Default().Warn("span queue full, dropping span", "name", data.Name)
+	}
+}
+
+// run exports queued spans every Interval or once MaxBatch are queued
+func (t *Tracer) run() {
+	defer close(t.exited)
+	ticker := time.NewTicker(t.opts.Interval)
+	defer ticker.Stop()
+
+	batch := make([]SpanData, 0, t.opts.MaxBatch)
+	export := func() {
+		if len(batch) == 0 {
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		if err := t.exporter.ExportSpans(ctx, t.service, batch); err != nil {
+			logger.Default().Warn("failed to export spans", "count", len(batch), "error", err)
+		}
+		cancel()
+		batch = batch[:0]
+	}
+	drain := func() {
+		for {
+			select {
+			case data := <-t.queue:
+				batch = append(batch, data)
+				if len(batch) >= t.opts.MaxBatch {
+					export()
+				}
+			default:
+				export()
+				return
+			}
+		}
+	}
+
+	for {
+		select {
+		case data := <-t.queue:
+			batch = append(batch, data)
+			if len(batch) >= t.opts.MaxBatch {
+				export()
+			}
+		case <-ticker.C:
+			export()
+		case ack := <-t.flush:
+			drain()
+			close(ack)
+		case <-t.done:
+			drain()
+			return
+		}
+	}
+}
+
+// ForceFlush exports the spans ended so far
+func (t *Tracer) ForceFlush(ctx context.Context) error {
+	if t == nil || t.exporter == nil {
+		return nil
+	}
+	ack := make(chan struct{})
+	select {
+	case t.flush <- ack:
+	case <-t.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case <-ack:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Shutdown exports the remaining spans and closes the exporter
+// Spans ended afterwards are dropped.
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	if t == nil || t.exporter == nil {
+		return nil
+	}
+	err := t.ForceFlush(ctx)
+	t.stopOnce.Do(func() {
+		close(t.done)
+		<-t.exited
+		if shutdownErr := t.exporter.Shutdown(ctx); err == nil {
+			err = shutdownErr
+		}
+	})
+	return err
+}
@@ -0,0 +1,203 @@
+// Package tracing propagates W3C trace context (traceparent) between the
+// core and plugins and records spans in the OpenTelemetry data model.
+// Spans are exported as OTLP/JSON, either to an OTLP/HTTP collector or to
+// a file; without an exporter trace context is still propagated.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// TraceParentHeader is the W3C trace context header, also used as gRPC
+// metadata key and event field
+const TraceParentHeader = "traceparent"
+
+// TraceID identifies a trace
+type TraceID [16]byte
+
+// SpanID identifies a span within a trace
+type SpanID [8]byte
+
+// IsValid reports whether the ID is not all zeros
+func (id TraceID) IsValid() bool {
+	return id != TraceID{}
+}
+
+func (id TraceID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// IsValid reports whether the ID is not all zeros
+func (id SpanID) IsValid() bool {
+	return id != SpanID{}
+}
+
+func (id SpanID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// SpanContext is the part of a span propagated to other processes
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Sampled bool
+}
+
+// IsValid reports whether both IDs are set
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID.IsValid() && sc.SpanID.IsValid()
+}
+
+// TraceParent formats the span context as a traceparent value
+// It returns "" for an invalid span context.
+func (sc SpanContext) TraceParent() string {
+	if !sc.IsValid() {
+		return ""
+	}
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return "00-" + sc.TraceID.String() + "-" + sc.SpanID.String() + "-" + flags
+}
+
+// ErrInvalidTraceParent is returned for malformed traceparent values
+var ErrInvalidTraceParent = errors.New("invalid traceparent")
+
+// ParseTraceParent parses a traceparent value
+// Versions other than 00 are accepted as long as they start with the
+// version 00 fields, as the specification requires.
+func ParseTraceParent(value string) (SpanContext, error) {
+	var sc SpanContext
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return sc, ErrInvalidTraceParent
+	}
+	version, err := decodeHex(parts[0])
+	if err != nil || version[0] == 0xff || (version[0] == 0 && len(parts) != 4) {
+		return sc, ErrInvalidTraceParent
+	}
+	traceID, err := decodeHex(parts[1])
+	if err != nil {
+		return sc, ErrInvalidTraceParent
+	}
+	spanID, err := decodeHex(parts[2])
+	if err != nil {
+		return sc, ErrInvalidTraceParent
+	}
+	flags, err := decodeHex(parts[3])
+	if err != nil {
+		return sc, ErrInvalidTraceParent
+	}
+	copy(sc.TraceID[:], traceID)
+	copy(sc.SpanID[:], spanID)
+	sc.Sampled = flags[0]&1 == 1
+	if !sc.IsValid() {
+		return SpanContext{}, ErrInvalidTraceParent
+	}
+	return sc, nil
+}
+
+// decodeHex decodes lowercase hex, as traceparent requires
+func decodeHex(s string) ([]byte, error) {
+	if strings.ToLower(s) != s {
+		return nil, ErrInvalidTraceParent
+	}
+	return hex.DecodeString(s)
+}
+
+func newTraceID() TraceID {
+	var id TraceID
+	for !id.IsValid() {
+		rand.Read(id[:])
+	}
+	return id
+}
+
+func newSpanID() SpanID {
+	var id SpanID
+	for !id.IsValid() {
+		rand.Read(id[:])
+	}
+	return id
+}
+
+// ============ Context ============
+
+type contextKey struct{}
+
+// contextValue is the current span, or a remote parent without a span
+type contextValue struct {
+	sc   SpanContext
+	span *Span
+}
+
+// ContextWithRemote returns a copy of ctx whose parent is a span of another
+// process; spans started from it continue that trace
+func ContextWithRemote(ctx context.Context, sc SpanContext) context.Context {
+	if !sc.IsValid() {
+		return ctx
+	}
+	return context.WithValue(ctx, contextKey{}, contextValue{sc: sc})
+}
+
+// ContextWithTraceParent is ContextWithRemote for a traceparent value
+// Empty or malformed values return ctx unchanged.
+func ContextWithTraceParent(ctx context.Context, traceParent string) context.Context {
+	if traceParent == "" {
+		return ctx
+	}
+	sc, err := ParseTraceParent(traceParent)
+	if err != nil {
+		return ctx
+	}
+	return ContextWithRemote(ctx, sc)
+}
+
+// contextWithSpan returns a copy of ctx whose current span is span
+func contextWithSpan(ctx context.Context, span *Span) context.Context {
+	return context.WithValue(ctx, contextKey{}, contextValue{sc: span.sc, span: span})
+}
+
+// SpanContextFromContext returns the span context of the current span or
+// remote parent in ctx
+func SpanContextFromContext(ctx context.Context) SpanContext {
+	if ctx == nil {
+		return SpanContext{}
+	}
+	v, _ := ctx.Value(contextKey{}).(contextValue)
+	return v.sc
+}
+
+// SpanFromContext returns the current span in ctx, or nil
+// Span methods are no-ops on nil.
+func SpanFromContext(ctx context.Context) *Span {
+	if ctx == nil {
+		return nil
+	}
+	v, _ := ctx.Value(contextKey{}).(contextValue)
+	return v.span
+}
+
+// TraceParentFromContext returns the traceparent to send on behalf of ctx,
+// or "" when ctx carries no trace
+func TraceParentFromContext(ctx context.Context) string {
+	return SpanContextFromContext(ctx).TraceParent()
+}
+
+// Inject sets the traceparent header of an outgoing request
+func Inject(ctx context.Context, h http.Header) {
+	if tp := TraceParentFromContext(ctx); tp != "" {
+		h.Set(TraceParentHeader, tp)
+	}
+}
+
+// Extract returns a copy of ctx continuing the trace of an incoming request
+func Extract(ctx context.Context, h http.Header) context.Context {
+	return ContextWithTraceParent(ctx, h.Get(TraceParentHeader))
+}
@@ -0,0 +1,78 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"testing"
+)
+
+func TestParseTraceParent(t *testing.T) {
+	const tp = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	sc, err := ParseTraceParent(tp)
+	if err != nil {
+		t.Fatalf("ParseTraceParent: %v", err)
+	}
+	if sc.TraceID.String() != "4bf92f3577b34da6a3ce929d0e0e4736" || sc.SpanID.String() != "00f067aa0ba902b7" || !sc.Sampled {
+		t.Errorf("Unexpected span context %+v", sc)
+	}
+	if got := sc.TraceParent(); got != tp {
+		t.Errorf("TraceParent() = %q, want %q", got, tp)
+	}
+
+	// Future versions may append fields
+	if _, err := ParseTraceParent("01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00-extra"); err != nil {
+		t.Errorf("Expected a future version to parse: %v", err)
+	}
+
+	for _, bad := range []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+		"00-4bf92f3577b34da6a3ce929d0e0e473g-00f067aa0ba902b7-01",
+	} {
+		if _, err := ParseTraceParent(bad); err == nil {
+			t.Errorf("Expected %q to be rejected", bad)
+		}
+	}
+}
+
+func TestStartContinuesTrace(t *testing.T) {
+	tracer := NewTracer("test", nil)
+
+	// Without exporter spans are not recorded but IDs still propagate
+	ctx, root := tracer.Start(context.Background(), "root", SpanKindServer)
+	if root.IsRecording() || !root.SpanContext().IsValid() {
+		t.Fatalf("Expected a valid, non-recording root span, got %+v", root.SpanContext())
+	}
+	_, child := tracer.Start(ctx, "child", SpanKindInternal)
+	if child.SpanContext().TraceID != root.SpanContext().TraceID || child.SpanContext().SpanID == root.SpanContext().SpanID {
+		t.Errorf("Expected child to share the trace with a new span ID")
+	}
+
+	remote, _ := ParseTraceParent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	h := http.Header{}
+	h.Set(TraceParentHeader, remote.TraceParent())
+	_, span := tracer.Start(Extract(context.Background(), h), "server", SpanKindServer)
+	if span.SpanContext().TraceID != remote.TraceID || !span.SpanContext().Sampled {
+		t.Errorf("Expected span to continue the remote trace, got %+v", span.SpanContext())
+	}
+
+	out := http.Header{}
+	Inject(ContextWithRemote(context.Background(), remote), out)
+	if out.Get(TraceParentHeader) != remote.TraceParent() {
+		t.Errorf("Expected injected traceparent, got %q", out.Get(TraceParentHeader))
+	}
+
+	// A nil tracer and nil spans are safe
+	var nilTracer *Tracer
+	_, s := nilTracer.Start(context.Background(), "x", SpanKindInternal)
+	s.SetAttributes("k", "v")
+	s.End()
+	var nilSpan *Span
+	nilSpan.SetError(context.Canceled)
+	nilSpan.End()
+}
@@ -1,6 +1,9 @@
 package types
 
+import "time"
+
 // ============ gRPC Service Types ============
+// Keep in sync with proto/milpa/v1/plugin.proto (see TestStructsMatchProto)
 
 // HandshakeRequest is sent by a plugin when connecting
 type HandshakeRequest struct {
@@ -10,6 +13,33 @@ type HandshakeRequest struct {
 	Capabilities []string          `json:"capabilities"`
 	Metadata     map[string]string `json:"metadata"`
 	Token        string            `json:"token"`
+	// InstanceKey identifies the plugin host across reconnects
+	InstanceKey string `json:"instance_key"`
+	// ResumeSessionId and ResumeAuthToken reattach to a previous session
+	ResumeSessionId string `json:"resume_session_id,omitempty"`
+	ResumeAuthToken string `json:"resume_auth_token,omitempty"`
+	// HealthEndpoint is probed by the core in addition to heartbeats
+	HealthEndpoint *HealthEndpoint `json:"health_endpoint,omitempty"`
+}
+
+// Health endpoint protocols
+const (
+	HealthProtocolHTTP = "http"
+	HealthProtocolGRPC = "grpc"
+)
+
+// HealthEndpoint describes a plugin endpoint the core probes on a schedule
+// HTTP endpoints pass with a 2xx response; gRPC endpoints implement the
+// standard grpc.health.v1 protocol.
+type HealthEndpoint struct {
+	Protocol string `json:"protocol"`
+	// Host defaults to the address the handshake came from
+	Host string `json:"host,omitempty"`
+	Port int    `json:"port"`
+	// Path is the HTTP path (default /healthz)
+	Path string `json:"path,omitempty"`
+	// Service is the gRPC health service name (default "", the whole server)
+	Service string `json:"service,omitempty"`
 }
 
 // HandshakeResponse is sent by the core after validation
@@ -20,6 +50,8 @@ type HandshakeResponse struct {
 	Config      map[string]string   `json:"config"`
 	Error       string              `json:"error"`
 	AuthToken   string              `json:"auth_token"`
+	// Resumed is true when the session continues an existing instance
+	Resumed bool `json:"resumed"`
 }
 
 // HeartbeatRequest is sent periodically by plugins
@@ -27,12 +59,55 @@ type HeartbeatRequest struct {
 	SessionId string            `json:"session_id"`
 	AuthToken string            `json:"auth_token"`
 	Status    map[string]string `json:"status"`
+	// Health is the structured health report; when nil the core falls back
+	// to Status["status"]
+	Health *HealthReport `json:"health,omitempty"`
+	// ShutdownAck acknowledges a HeartbeatResponse with Shutdown set
+	ShutdownAck bool `json:"shutdown_ack,omitempty"`
+	// Metrics are custom plugin metrics the core re-exposes on /metrics
+	Metrics []Metric `json:"metrics,omitempty"`
+}
+
+// Metric types
+const (
+	MetricCounter = "counter"
+	MetricGauge   = "gauge"
+)
+
+// Metric is the current value of a custom plugin metric
+// The core exposes it as milpa_plugin_<name> with plugin_id and
+// instance_id labels added to Labels.
+type Metric struct {
+	Name   string            `json:"name"`
+	Type   string            `json:"type"` // counter, gauge
+	Help   string            `json:"help,omitempty"`
+	Labels map[string]string `json:"labels,omitempty"`
+	Value  float64           `json:"value"`
+}
+
+// HealthReport is the structured health a plugin sends with heartbeats
+// Statuses are "pass", "warn" or "fail". Any failing check, or an overall
+// "fail", marks the instance degraded.
+type HealthReport struct {
+	Status string             `json:"status"`
+	Checks []HealthCheck      `json:"checks,omitempty"`
+	Gauges map[string]float64 `json:"gauges,omitempty"`
+}
+
+// HealthCheck is the result of one named check
+type HealthCheck struct {
+	Name    string `json:"name"`
+	Status  string `json:"status"`
+	Message string `json:"message,omitempty"`
 }
 
 // HeartbeatResponse is sent by the core
 type HeartbeatResponse struct {
 	Ok      bool   `json:"ok"`
 	Message string `json:"message"`
+	// Shutdown is set while the core is shutting down; the plugin should
+	// acknowledge it with ShutdownAck on its next heartbeat
+	Shutdown bool `json:"shutdown,omitempty"`
 }
 
 // ConfigureRequest is sent to update plugin configuration
@@ -48,15 +123,120 @@ type ConfigureResponse struct {
 	Error string `json:"error"`
 }
 
+// DeregisterRequest is sent by a plugin that is shutting down
+type DeregisterRequest struct {
+	SessionId string `json:"session_id"`
+	AuthToken string `json:"auth_token"`
+	// Reason is an optional free-form explanation logged by the core
+	Reason string `json:"reason,omitempty"`
+}
+
+// DeregisterResponse is sent by the core
+type DeregisterResponse struct {
+	Ok    bool   `json:"ok"`
+	Error string `json:"error"`
+}
+
+// Disconnect reasons carried by plugin_disconnected events
+const (
+	DisconnectHeartbeatTimeout = "heartbeat_timeout"
+	DisconnectStreamClosed     = "stream_closed"
+	DisconnectDeregistered     = "deregistered"
+	DisconnectDisabled         = "disabled"
+)
+
+// DisconnectEvent is the data of a plugin_disconnected event (JSON)
+type DisconnectEvent struct {
+	InstanceId string `json:"instance_id"`
+	PluginId   string `json:"plugin_id"`
+	Reason     string `json:"reason"`
+}
+
+// EventPollRequest long-polls the core for events addressed to a plugin
+type EventPollRequest struct {
+	SessionId string `json:"session_id"`
+	AuthToken string `json:"auth_token"`
+	// WaitMs is how long to wait for a first event (default 25s, max 30s)
+	WaitMs int `json:"wait_ms,omitempty"`
+}
+
+// EventPollResponse returns the pending events, possibly none
+type EventPollResponse struct {
+	Ok     bool        `json:"ok"`
+	Error  string      `json:"error,omitempty"`
+	Events []CoreEvent `json:"events"`
+}
+
+// PluginEventRequest sends an event from a plugin to the core over HTTP
+// (on the gRPC stream plugins send a PluginEvent)
+type PluginEventRequest struct {
+	SessionId string `json:"session_id"`
+	AuthToken string `json:"auth_token"`
+	Type      string `json:"type"`
+	Data      string `json:"data"`
+}
+
+// PluginEventResponse is sent by the core
+type PluginEventResponse struct {
+	Ok    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+// LogRecord is a log message written by a plugin
+type LogRecord struct {
+	Time    time.Time         `json:"time"`
+	Level   string            `json:"level"`
+	Logger  string            `json:"logger,omitempty"`
+	Message string            `json:"message"`
+	Fields  map[string]string `json:"fields,omitempty"`
+}
+
+// LogBatchRequest forwards plugin log records to the core
+// The same records can be sent as a logs event (data: JSON array)
+type LogBatchRequest struct {
+	SessionId string      `json:"session_id"`
+	AuthToken string      `json:"auth_token"`
+	Records   []LogRecord `json:"records"`
+}
+
+// LogBatchResponse is sent by the core
+type LogBatchResponse struct {
+	Ok       bool   `json:"ok"`
+	Error    string `json:"error,omitempty"`
+	Accepted int    `json:"accepted"`
+}
+
+// StatusQuery is the data of a status_query event (JSON)
+type StatusQuery struct {
+	QueryId string `json:"query_id"`
+}
+
+// StatusReply is the data of the status_reply event a plugin sends back
+type StatusReply struct {
+	QueryId string            `json:"query_id"`
+	Status  map[string]string `json:"status,omitempty"`
+	Health  *HealthReport     `json:"health,omitempty"`
+	Error   string            `json:"error,omitempty"`
+}
+
 // PluginEvent is sent from plugin to core
 type PluginEvent struct {
 	SessionId string `json:"session_id"`
 	Type      string `json:"type"`
 	Data      string `json:"data"`
+	// TraceParent is the W3C trace context of the operation that sent the event
+	TraceParent string `json:"traceparent,omitempty"`
 }
 
 // CoreEvent is sent from core to plugin
 type CoreEvent struct {
 	Type string `json:"type"`
 	Data string `json:"data"`
+	// TraceParent is the W3C trace context of the operation that sent the event
+	TraceParent string `json:"traceparent,omitempty"`
+}
+
+// LogLevelEvent is the data of a log_level event
+type LogLevelEvent struct {
+	Level string `json:"level"`
 }
@@ -0,0 +1,116 @@
+package types
+
+import (
+	"os"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// protoFile is the gRPC contract the structs mirror
+const protoFile = "../../proto/milpa/v1/plugin.proto"
+
+// protoMessages maps each message of protoFile to its struct
+var protoMessages = map[string]reflect.Type{
+	"HandshakeRequest":   reflect.TypeOf(HandshakeRequest{}),
+	"HealthEndpoint":     reflect.TypeOf(HealthEndpoint{}),
+	"HandshakeResponse":  reflect.TypeOf(HandshakeResponse{}),
+	"HeartbeatRequest":   reflect.TypeOf(HeartbeatRequest{}),
+	"Metric":             reflect.TypeOf(Metric{}),
+	"HealthReport":       reflect.TypeOf(HealthReport{}),
+	"HealthCheck":        reflect.TypeOf(HealthCheck{}),
+	"HeartbeatResponse":  reflect.TypeOf(HeartbeatResponse{}),
+	"ConfigureRequest":   reflect.TypeOf(ConfigureRequest{}),
+	"ConfigureResponse":  reflect.TypeOf(ConfigureResponse{}),
+	"DeregisterRequest":  reflect.TypeOf(DeregisterRequest{}),
+	"DeregisterResponse": reflect.TypeOf(DeregisterResponse{}),
+	"PluginEvent":        reflect.TypeOf(PluginEvent{}),
+	"CoreEvent":          reflect.TypeOf(CoreEvent{}),
+}
+
+var (
+	protoMessage = regexp.MustCompile(`(?s)message (\w+) \{(.*?)\n\}`)
+	protoField   = regexp.MustCompile(`^\s*(repeated )?(map<\w+, ?\w+>|[\w.]+) (\w+) = \d+;`)
+)
+
+// protoKind returns the Go kind a proto field is decoded into
+func protoKind(repeated bool, typ string) reflect.Kind {
+	switch {
+	case repeated:
+		return reflect.Slice
+	case strings.HasPrefix(typ, "map<"):
+		return reflect.Map
+	}
+	switch typ {
+	case "string":
+		return reflect.String
+	case "bool":
+		return reflect.Bool
+	case "int32", "int64":
+		return reflect.Int
+	case "double":
+		return reflect.Float64
+	}
+	// Messages are optional, so they are pointers
+	return reflect.Ptr
+}
+
+// jsonFields returns the kind of each JSON field of a struct
+func jsonFields(t reflect.Type) map[string]reflect.Kind {
+	fields := make(map[string]reflect.Kind)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "" || name == "-" {
+			continue
+		}
+		fields[name] = f.Type.Kind()
+	}
+	return fields
+}
+
+func TestStructsMatchProto(t *testing.T) {
+	data, err := os.ReadFile(protoFile)
+	if err != nil {
+		t.Fatalf("Failed to read %s: %v", protoFile, err)
+	}
+
+	seen := make(map[string]bool)
+	for _, m := range protoMessage.FindAllStringSubmatch(string(data), -1) {
+		name, body := m[1], m[2]
+		typ, ok := protoMessages[name]
+		if !ok {
+			t.Errorf("Message %s has no struct in pkg/types", name)
+			continue
+		}
+		seen[name] = true
+
+		fields := jsonFields(typ)
+		for _, line := range strings.Split(body, "\n") {
+			f := protoField.FindStringSubmatch(line)
+			if f == nil {
+				continue
+			}
+			field, want := f[3], protoKind(f[1] != "", f[2])
+			got, ok := fields[field]
+			if !ok {
+				t.Errorf("%s.%s is missing from the struct", name, field)
+				continue
+			}
+			delete(fields, field)
+			if got != want {
+				t.Errorf("%s.%s is a %s, the proto declares a %s", name, field, got, want)
+			}
+		}
+		for field := range fields {
+			t.Errorf("%s.%s is missing from %s", name, field, protoFile)
+		}
+	}
+
+	for name := range protoMessages {
+		if !seen[name] {
+			t.Errorf("Struct %s has no message in %s", name, protoFile)
+		}
+	}
+}
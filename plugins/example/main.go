@@ -20,7 +20,7 @@ func main() {
 	id := flag.String("id", "example", "Plugin ID")
 	version := flag.String("version", "1.0.0", "Plugin version")
 	apiVersion := flag.String("api-version", "1.0", "API version")
-	coreAddr := flag.String("core", "localhost:8081", "Core gRPC address")
+	coreAddr := flag.String("core", "localhost:8080", "Core HTTP API address (unix:PATH for a socket)")
 	heartbeat := flag.Int("heartbeat", 10, "Heartbeat interval in seconds")
 	flag.Parse()
 
@@ -77,6 +77,10 @@ func main() {
 		EventHandler: eventHandler,
 	})
 
+	// Forward the standard logger to the core through the plugin logger
+	log.SetFlags(0)
+	log.SetOutput(plugin.LogWriter())
+
 	log.Printf("Starting example plugin: ID=%s, Version=%s, Core=%s", *id, *version, *coreAddr)
 
 	// Connect to core